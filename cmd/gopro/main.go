@@ -36,6 +36,10 @@ func main() {
 		log.Warnf("Error with HTTP2 %s", err)
 	}
 
+	if err := s.InitBlobStore(); err != nil {
+		log.Fatalf("Error initializing blob storage %s", err)
+	}
+
 	if *startdb {
 		if err := s.InitDB(); err != nil {
 			log.Fatalf("Error initializing DB %s", err)
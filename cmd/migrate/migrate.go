@@ -1,6 +1,105 @@
-package migrate
+package main
 
 /**
-This will contain all the code necessary to spin up a new DB instance of
-postgres with the required tables for the application to work
+Spins up or tears down the postgres tables required for the application to work.
+
+	migrate [-local] up|down|status|goto N
 */
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/joho/godotenv"
+
+	"github.com/blixenkrone/gopro/internal/storage/postgres"
+	"github.com/blixenkrone/gopro/internal/storage/postgres/migrate"
+	"github.com/blixenkrone/gopro/pkg/logger"
+)
+
+var (
+	local   = flag.Bool("local", false, "Load the .env file in the working directory")
+	timeout = flag.Duration("timeout", 5*time.Minute, "Give up if migrating takes longer than this")
+	log     = logger.NewLogger()
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] up|down|status|goto N\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	if *local {
+		if err := godotenv.Load(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	db, err := postgres.Open()
+	if err != nil {
+		log.Fatalf("POSTGRESQL err: %s", err)
+	}
+	defer db.Close()
+
+	m, err := migrate.New(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	switch cmd := flag.Arg(0); cmd {
+	case "up":
+		err = m.Up(ctx)
+	case "down":
+		err = m.Down(ctx)
+	case "goto":
+		var version int
+		version, err = strconv.Atoi(flag.Arg(1))
+		if err != nil {
+			log.Fatalf("goto needs a version number: %s", err)
+		}
+		err = m.Goto(ctx, version)
+	case "status":
+		err = printStatus(ctx, m)
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func printStatus(ctx context.Context, m *migrate.Migrator) error {
+	status, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT\t")
+	for _, s := range status {
+		applied := "pending"
+		if s.Applied {
+			applied = s.AppliedAt.Format(time.RFC3339)
+		}
+		if s.Modified {
+			applied += " (modified since applied)"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t\n", s.Version, s.Name, applied)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,38 @@
+package mediatest
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Box is an ISO-BMFF box of the type with the payloads joined
+func Box(typ string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	b := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(b, uint32(8+len(body)))
+	copy(b[4:], typ)
+	return append(b, body...)
+}
+
+// FullBox is a box starting with a version and flags
+func FullBox(typ string, version byte, flags uint32, payload ...[]byte) []byte {
+	return Box(typ, append([][]byte{U32(uint32(version)<<24 | flags)}, payload...)...)
+}
+
+// U16 is the values big endian, one after the other
+func U16(v ...uint16) []byte {
+	b := make([]byte, 2*len(v))
+	for i, n := range v {
+		binary.BigEndian.PutUint16(b[2*i:], n)
+	}
+	return b
+}
+
+// U32 is the values big endian, one after the other
+func U32(v ...uint32) []byte {
+	b := make([]byte, 4*len(v))
+	for i, n := range v {
+		binary.BigEndian.PutUint32(b[4*i:], n)
+	}
+	return b
+}
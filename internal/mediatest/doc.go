@@ -0,0 +1,3 @@
+// Package mediatest builds the files media tests decode: TIFF and Exif metadata, JPEG segments,
+// ISO-BMFF boxes of MP4 and HEIF, and images with structure for hashes and crops.
+package mediatest
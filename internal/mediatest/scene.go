@@ -0,0 +1,49 @@
+package mediatest
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// Scene draws a gradient with a checker pattern and a white rectangle, so hashes and JPEG tables have
+// structure to work on
+func Scene(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := uint8(255 * x / w)
+			if (x/max(w/4, 1)+y/max(h/3, 1))%2 == 0 {
+				v = 255 - v
+			}
+			img.SetNRGBA(x, y, color.NRGBA{v, v / 2, 255 - v, 255})
+		}
+	}
+	draw.Draw(img, image.Rect(w/8, h/6, w/3, h/2), image.White, image.Point{}, draw.Src)
+	return img
+}
+
+// Portrait is a plain gray image with a face colored, detailed patch in face
+func Portrait(w, h int, face image.Rectangle) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.NRGBA{120, 120, 120, 255}
+			if (image.Point{x, y}).In(face) {
+				c = color.NRGBA{220, 160, 125, 255}
+				if (x/3+y/3)%2 == 0 {
+					c = color.NRGBA{190, 138, 108, 255}
+				}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,166 @@
+package mediatest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"sort"
+	"testing"
+)
+
+// TIFF field types
+const (
+	TypeByte      = 1
+	TypeASCII     = 2
+	TypeShort     = 3
+	TypeLong      = 4
+	TypeRational  = 5
+	TypeUndefined = 7
+)
+
+// Entry is a field of an IFD, Data is in the byte order of the TIFF it is written to
+type Entry struct {
+	Tag   uint16
+	Type  uint16
+	Count uint32
+	Data  []byte
+}
+
+func ASCII(tag uint16, s string) Entry {
+	return Entry{tag, TypeASCII, uint32(len(s) + 1), append([]byte(s), 0)}
+}
+
+func Byte(tag uint16, b byte) Entry {
+	return Entry{tag, TypeByte, 1, []byte{b}}
+}
+
+func Undefined(tag uint16, b []byte) Entry {
+	return Entry{tag, TypeUndefined, uint32(len(b)), b}
+}
+
+func Short(order binary.ByteOrder, tag uint16, v uint16) Entry {
+	b := make([]byte, 2)
+	order.PutUint16(b, v)
+	return Entry{tag, TypeShort, 1, b}
+}
+
+func Long(order binary.ByteOrder, tag uint16, v uint32) Entry {
+	b := make([]byte, 4)
+	order.PutUint32(b, v)
+	return Entry{tag, TypeLong, 1, b}
+}
+
+// Rational takes numerator and denominator pairs
+func Rational(order binary.ByteOrder, tag uint16, v ...uint32) Entry {
+	b := make([]byte, 4*len(v))
+	for i, n := range v {
+		order.PutUint32(b[4*i:], n)
+	}
+	return Entry{tag, TypeRational, uint32(len(v) / 2), b}
+}
+
+// TIFF describes the metadata of an image. SubIFDs are keyed by the tag of their pointer in IFD0,
+// e.g. 0x8769 for the Exif IFD and 0x8825 for GPS. With a Thumbnail an IFD1 points to it.
+type TIFF struct {
+	// Order is little endian if it isn't set
+	Order     binary.ByteOrder
+	IFD0      []Entry
+	SubIFDs   map[uint16][]Entry
+	Thumbnail []byte
+}
+
+// Bytes lays out IFD0, the sub IFDs in the order of their tags, IFD1 and the thumbnail.
+// Each IFD is followed by its values longer than 4 bytes.
+func (t TIFF) Bytes() []byte {
+	order := t.Order
+	if order == nil {
+		order = binary.LittleEndian
+	}
+	buf := []byte("II*\x00\x08\x00\x00\x00")
+	if order == binary.BigEndian {
+		buf = []byte("MM\x00*\x00\x00\x00\x08")
+	}
+	// write returns where the IFD starts and where the value of each of its tags is
+	write := func(entries []Entry) (start int, values map[uint16]int) {
+		start, values = len(buf), make(map[uint16]int)
+		buf = append(buf, make([]byte, 2+12*len(entries)+4)...)
+		order.PutUint16(buf[start:], uint16(len(entries)))
+		for i, e := range entries {
+			p := start + 2 + 12*i
+			order.PutUint16(buf[p:], e.Tag)
+			order.PutUint16(buf[p+2:], e.Type)
+			order.PutUint32(buf[p+4:], e.Count)
+			values[e.Tag] = p + 8
+			if len(e.Data) <= 4 {
+				copy(buf[p+8:], e.Data)
+				continue
+			}
+			order.PutUint32(buf[p+8:], uint32(len(buf)))
+			buf = append(buf, e.Data...)
+			if len(buf)%2 == 1 {
+				buf = append(buf, 0)
+			}
+		}
+		return start, values
+	}
+
+	var pointers []uint16
+	for tag := range t.SubIFDs {
+		pointers = append(pointers, tag)
+	}
+	sort.Slice(pointers, func(i, j int) bool { return pointers[i] < pointers[j] })
+	ifd0 := t.IFD0[:len(t.IFD0):len(t.IFD0)]
+	for _, tag := range pointers {
+		// patched once the sub IFD is written
+		ifd0 = append(ifd0, Long(order, tag, 0))
+	}
+	ifd0At, ifd0Values := write(ifd0)
+	for _, tag := range pointers {
+		at, _ := write(t.SubIFDs[tag])
+		order.PutUint32(buf[ifd0Values[tag]:], uint32(at))
+	}
+	if t.Thumbnail != nil {
+		ifd1At, ifd1Values := write([]Entry{
+			Short(order, 0x103, 6), Long(order, 0x201, 0), Long(order, 0x202, uint32(len(t.Thumbnail))),
+		})
+		order.PutUint32(buf[ifd0At+2+12*len(ifd0):], uint32(ifd1At))
+		order.PutUint32(buf[ifd1Values[0x201]:], uint32(len(buf)))
+		buf = append(buf, t.Thumbnail...)
+	}
+	return buf
+}
+
+// Segment is a JPEG marker segment with the payloads joined
+func Segment(marker byte, payload ...[]byte) []byte {
+	b := bytes.Join(payload, nil)
+	return append([]byte{0xFF, marker, byte((len(b) + 2) >> 8), byte(len(b) + 2)}, b...)
+}
+
+// ExifSegment is the APP1 segment of a TIFF
+func ExifSegment(tiff []byte) []byte {
+	return Segment(0xE1, []byte("Exif\x00\x00"), tiff)
+}
+
+// WithSegments inserts segments after the SOI marker of a JPEG
+func WithSegments(jpg []byte, segments ...[]byte) []byte {
+	out := append([]byte{}, jpg[:2]...)
+	for _, s := range segments {
+		out = append(out, s...)
+	}
+	return append(out, jpg[2:]...)
+}
+
+// JPEG encodes img at the quality, 0 is the default of image/jpeg
+func JPEG(t testing.TB, img image.Image, quality int) []byte {
+	t.Helper()
+	var opts *jpeg.Options
+	if quality > 0 {
+		opts = &jpeg.Options{Quality: quality}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, opts); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,596 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+
+	"github.com/blixenkrone/gopro/internal/storage"
+	exifimage "github.com/blixenkrone/gopro/pkg/exif/image"
+	exifvideo "github.com/blixenkrone/gopro/pkg/exif/video"
+	exifwriter "github.com/blixenkrone/gopro/pkg/exif/writer"
+	"github.com/blixenkrone/gopro/pkg/image/phash"
+	"github.com/blixenkrone/gopro/pkg/image/thumbnail"
+)
+
+const (
+	// signedURLExpiry is how long a pre-signed deliverable url is valid
+	signedURLExpiry = 15 * time.Minute
+	// maxImageProcessingSize is the largest image read into memory for exif and thumbnails
+	maxImageProcessingSize = 64 << 20
+	// maxVideoProcessingSize is the largest video read in whole, e.g. hashed or from a request body.
+	// The metadata of stored videos is read with ranged reads at any size.
+	maxVideoProcessingSize = 1 << 30
+	// processingTimeout bounds processing a single deliverable
+	processingTimeout = 10 * time.Minute
+)
+
+// deliverableTypes are the content type prefixes accepted as deliverables
+var deliverableTypes = []string{"image/", "video/"}
+
+// processing bounds the deliverables being processed at the same time
+var processing = make(chan struct{}, 2)
+
+type signedURLRequest struct {
+	FileName    string `json:"fileName"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+}
+
+type signedURLResponse struct {
+	Key         string    `json:"key"`
+	URL         string    `json:"url"`
+	Method      string    `json:"method"`
+	ContentType string    `json:"contentType,omitempty"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+type completeUploadRequest struct {
+	Key         string `json:"key"`
+	FileName    string `json:"fileName"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+}
+
+// validDeliverable checks a declared content type and size
+func validDeliverable(contentType string, size int64) error {
+	if size <= 0 || size > maxResumableUploadSize {
+		return errors.Errorf("size must be between 1 and %d bytes", int64(maxResumableUploadSize))
+	}
+	for _, prefix := range deliverableTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return nil
+		}
+	}
+	return errors.Errorf("content type %q is not an image or video", contentType)
+}
+
+// bookingDeliverableKey reports whether key is a deliverable key of the booking
+func bookingDeliverableKey(bookingID, key string) bool {
+	prefix := "booking/" + bookingID + "/"
+	name := strings.TrimPrefix(key, prefix)
+	return strings.HasPrefix(key, prefix) && name != "" && !strings.Contains(name, "/") && path.Clean(key) == key
+}
+
+// requestBooking returns the booking id of the route after checking it exists and the caller has one of roles in it
+func requestBooking(w http.ResponseWriter, r *http.Request, roles ...string) (string, bool) {
+	b, ok := getRequestBooking(w, r)
+	if !ok {
+		return "", false
+	}
+	if _, ok := authorizeBooking(w, r, b, roles...); !ok {
+		return "", false
+	}
+	return b.ID, true
+}
+
+// getRequestBooking returns the booking of the route
+func getRequestBooking(w http.ResponseWriter, r *http.Request) (*storage.Booking, bool) {
+	b, err := pq.GetBooking(r.Context(), mux.Vars(r)["bookingID"])
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			NewResErr(err, "Booking not found", http.StatusNotFound, w)
+			return nil, false
+		}
+		NewResErr(err, "Error getting booking", http.StatusInternalServerError, w)
+		return nil, false
+	}
+	return b, true
+}
+
+// Roles of a caller in a booking
+const (
+	roleProfessional = "professional"
+	roleMedia        = "media"
+	roleAdmin        = "admin"
+)
+
+// bookingRole is how uid takes part in the booking, empty if it doesn't
+func bookingRole(ctx context.Context, uid string, b *storage.Booking) (string, error) {
+	switch {
+	case uid == "":
+		return "", nil
+	case uid == b.UserUID:
+		return roleProfessional, nil
+	case uid == b.MediaUID:
+		return roleMedia, nil
+	}
+	admin, err := fb.IsAdminUID(ctx, uid)
+	if err != nil || !admin {
+		return "", err
+	}
+	return roleAdmin, nil
+}
+
+// authorizeBooking returns the role of the caller in the booking. Unless it is one of roles, or any role
+// without roles, it writes a 403 and returns false.
+func authorizeBooking(w http.ResponseWriter, r *http.Request, b *storage.Booking, roles ...string) (string, bool) {
+	uid := requestUID(r)
+	role, err := bookingRole(r.Context(), uid, b)
+	if err != nil {
+		NewResErr(err, "Error checking access", http.StatusInternalServerError, w)
+		return "", false
+	}
+	if role != "" && len(roles) == 0 {
+		return role, true
+	}
+	for _, allowed := range roles {
+		if role == allowed {
+			return role, true
+		}
+	}
+	err = errors.Errorf("%s as %q requested %s of booking %s", uid, role, r.URL.Path, b.ID)
+	NewResErr(err, "No access to the booking", http.StatusForbidden, w)
+	return "", false
+}
+
+var getDeliverables = func(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		b, ok := getRequestBooking(w, r)
+		if !ok {
+			return
+		}
+		if _, ok := authorizeBooking(w, r, b); !ok {
+			return
+		}
+		deliverables, err := pq.GetDeliverables(r.Context(), b.ID)
+		if err != nil {
+			NewResErr(err, "Error getting deliverables", http.StatusInternalServerError, w)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(deliverables); err != nil {
+			NewResErr(err, "Error encoding deliverables", http.StatusInternalServerError, w)
+			return
+		}
+	}
+}
+
+// createDeliverableUploadURL issues a pre-signed PUT url for a new deliverable to the professional of the booking.
+// The client must PUT with the declared content type and report back to completeDeliverableUpload.
+var createDeliverableUploadURL = func(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		w.Header().Set("Content-Type", "application/json")
+		bookingID, ok := requestBooking(w, r, roleProfessional, roleAdmin)
+		if !ok {
+			return
+		}
+		var req signedURLRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			NewResErr(err, "Error decoding body", http.StatusBadRequest, w)
+			return
+		}
+		if err := validDeliverable(req.ContentType, req.Size); err != nil {
+			NewResErr(err, err.Error(), http.StatusBadRequest, w)
+			return
+		}
+		key, err := deliverableKey(bookingID, req.FileName)
+		if err != nil {
+			NewResErr(err, "Error creating key", http.StatusInternalServerError, w)
+			return
+		}
+		writeSignedURL(w, r, key, storage.SignOptions{
+			Method:      http.MethodPut,
+			Expires:     signedURLExpiry,
+			ContentType: req.ContentType,
+		})
+	}
+}
+
+type consentRequest struct {
+	Consent string `json:"consent"`
+}
+
+// setBookingConsent stores the metadata the professional of the booking lets the media keep in stripped
+// downloads, e.g. {"consent": "gps,names"}. Only the professional or an admin can change it.
+var setBookingConsent = func(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		w.Header().Set("Content-Type", "application/json")
+		b, ok := getRequestBooking(w, r)
+		if !ok {
+			return
+		}
+		if _, ok := authorizeBooking(w, r, b, roleProfessional, roleAdmin); !ok {
+			return
+		}
+		var req consentRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			NewResErr(err, "Error decoding body", http.StatusBadRequest, w)
+			return
+		}
+		policy, err := exifwriter.ParsePolicy(req.Consent)
+		if err != nil {
+			NewResErr(err, err.Error(), http.StatusBadRequest, w)
+			return
+		}
+		b.MetadataConsent = consentOf(policy)
+		if err := pq.SetBookingConsent(r.Context(), b.ID, b.MetadataConsent); err != nil {
+			NewResErr(err, "Error saving consent", http.StatusInternalServerError, w)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(consentRequest{Consent: b.MetadataConsent}); err != nil {
+			NewResErr(err, JSONEncodingError.Error(), http.StatusInternalServerError, w)
+			return
+		}
+	}
+}
+
+// consentOf is the stored consent of a policy, empty without any
+func consentOf(p exifwriter.Policy) string {
+	if s := p.String(); s != "none" {
+		return s
+	}
+	return ""
+}
+
+// createDeliverableDownloadURL issues a pre-signed GET url for ?key= of the booking. The media gets a copy without
+// GPS, serial numbers and names, unless the professional consented to them on the booking. The professional and
+// admins get the original, or the copy with ?stripped=true.
+var createDeliverableDownloadURL = func(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		booking, ok := getRequestBooking(w, r)
+		if !ok {
+			return
+		}
+		role, ok := authorizeBooking(w, r, booking)
+		if !ok {
+			return
+		}
+		key := r.URL.Query().Get("key")
+		if !bookingDeliverableKey(booking.ID, key) {
+			NewResErr(errors.Errorf("invalid key %q", key), "Key is not a deliverable of the booking", http.StatusBadRequest, w)
+			return
+		}
+		info, err := blobs.Stat(r.Context(), key)
+		if err != nil {
+			if errors.Cause(err) == storage.ErrBlobNotFound {
+				NewResErr(err, "Deliverable not found", http.StatusNotFound, w)
+				return
+			}
+			NewResErr(err, "Error getting deliverable", http.StatusInternalServerError, w)
+			return
+		}
+		original := role == roleProfessional || role == roleAdmin
+		if !original || r.URL.Query().Get("stripped") == "true" {
+			policy, err := exifwriter.ParsePolicy(booking.MetadataConsent)
+			if err != nil {
+				NewResErr(err, "Invalid consent on booking", http.StatusInternalServerError, w)
+				return
+			}
+			d, err := bookingDeliverable(r.Context(), booking.ID, key)
+			if err != nil {
+				if errors.Cause(err) == storage.ErrBlobNotFound {
+					NewResErr(err, "Deliverable not found", http.StatusNotFound, w)
+					return
+				}
+				NewResErr(err, "Error getting deliverable", http.StatusInternalServerError, w)
+				return
+			}
+			if key, err = strippedCopy(r.Context(), d.SHA256, info, policy); err != nil {
+				if errors.Cause(err) == exifwriter.ErrNotJPEG {
+					NewResErr(err, "Only JPEGs can be stripped", http.StatusUnsupportedMediaType, w)
+					return
+				}
+				NewResErr(err, "Error stripping deliverable", http.StatusInternalServerError, w)
+				return
+			}
+		}
+		writeSignedURL(w, r, key, storage.SignOptions{Method: http.MethodGet, Expires: signedURLExpiry})
+	}
+}
+
+// strippedCopy returns the key of a copy of the deliverable with its metadata rewritten by the policy.
+// Copies are kept by the SHA256 of the source and the policy below stripped/ and made on the first download,
+// so a deliverable recorded again with other content gets new copies. Without sum the source is hashed.
+func strippedCopy(ctx context.Context, sum string, info *storage.BlobInfo, p exifwriter.Policy) (string, error) {
+	if sum != "" {
+		key, err := existingStrippedCopy(ctx, sum, p)
+		if key != "" || err != nil {
+			return key, err
+		}
+	}
+	if info.Size > maxImageProcessingSize {
+		return "", errors.Errorf("image of %d bytes is too large to strip", info.Size)
+	}
+	body, _, err := blobs.Get(ctx, info.Key)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return "", err
+	}
+	if sum == "" {
+		h := sha256.Sum256(b)
+		sum = hex.EncodeToString(h[:])
+		key, err := existingStrippedCopy(ctx, sum, p)
+		if key != "" || err != nil {
+			return key, err
+		}
+	}
+	key := strippedKey(sum, p)
+	stripped, err := exifwriter.Strip(b, p)
+	if err != nil {
+		return "", err
+	}
+	if _, err := blobs.Put(ctx, key, bytes.NewReader(stripped), storage.PutOptions{ContentType: "image/jpeg"}); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// existingStrippedCopy returns the key of the copy of the source with sum by the policy, empty if it isn't made
+func existingStrippedCopy(ctx context.Context, sum string, p exifwriter.Policy) (string, error) {
+	key := strippedKey(sum, p)
+	if _, err := blobs.Stat(ctx, key); err != nil {
+		if errors.Cause(err) == storage.ErrBlobNotFound {
+			return "", nil
+		}
+		return "", err
+	}
+	return key, nil
+}
+
+func strippedKey(sum string, p exifwriter.Policy) string {
+	return "stripped/" + sum + "/" + p.String() + ".jpg"
+}
+
+func writeSignedURL(w http.ResponseWriter, r *http.Request, key string, opts storage.SignOptions) {
+	url, err := blobs.SignedURL(r.Context(), key, opts)
+	if err != nil {
+		NewResErr(err, "Error signing url", http.StatusInternalServerError, w)
+		return
+	}
+	res := signedURLResponse{
+		Key:         key,
+		URL:         url,
+		Method:      opts.Method,
+		ContentType: opts.ContentType,
+		ExpiresAt:   time.Now().Add(opts.Expires).UTC(),
+	}
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		NewResErr(err, "Error encoding url", http.StatusInternalServerError, w)
+		return
+	}
+}
+
+// completeDeliverableUpload records a file uploaded through a pre-signed url. The stored object must match the
+// declared size and content type, otherwise it is deleted. Exif and thumbnails are extracted in the background.
+var completeDeliverableUpload = func(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		w.Header().Set("Content-Type", "application/json")
+		bookingID, ok := requestBooking(w, r, roleProfessional, roleAdmin)
+		if !ok {
+			return
+		}
+		var req completeUploadRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			NewResErr(err, "Error decoding body", http.StatusBadRequest, w)
+			return
+		}
+		if !bookingDeliverableKey(bookingID, req.Key) {
+			NewResErr(errors.Errorf("invalid key %q", req.Key), "Key is not a deliverable of the booking", http.StatusBadRequest, w)
+			return
+		}
+		info, err := blobs.Stat(r.Context(), req.Key)
+		if err != nil {
+			if errors.Cause(err) == storage.ErrBlobNotFound {
+				NewResErr(err, "Upload not found", http.StatusNotFound, w)
+				return
+			}
+			NewResErr(err, "Error getting upload", http.StatusInternalServerError, w)
+			return
+		}
+
+		err = validDeliverable(info.ContentType, info.Size)
+		if err == nil && (info.Size != req.Size || info.ContentType != req.ContentType) {
+			err = errors.Errorf("stored %d bytes of %s, declared %d bytes of %s", info.Size, info.ContentType, req.Size, req.ContentType)
+		}
+		if err != nil {
+			if delErr := blobs.Delete(r.Context(), req.Key); delErr != nil {
+				log.Errorf("Error deleting rejected upload %s: %s", req.Key, delErr)
+			}
+			NewResErr(err, err.Error(), http.StatusBadRequest, w)
+			return
+		}
+
+		d := &storage.Deliverable{
+			BookingID:   bookingID,
+			Key:         req.Key,
+			FileName:    req.FileName,
+			Size:        info.Size,
+			ContentType: info.ContentType,
+		}
+		if err := pq.CreateDeliverable(r.Context(), d); err != nil {
+			NewResErr(err, "Error recording deliverable", http.StatusInternalServerError, w)
+			return
+		}
+		go processDeliverable(*d)
+
+		w.WriteHeader(http.StatusCreated)
+		if err := json.NewEncoder(w).Encode(d); err != nil {
+			log.Errorf("Error encoding deliverable: %s", err)
+		}
+	}
+}
+
+// processDeliverable hashes a stored deliverable, extracts its exif and creates the derivatives of images
+func processDeliverable(d storage.Deliverable) {
+	processing <- struct{}{}
+	defer func() { <-processing }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), processingTimeout)
+	defer cancel()
+
+	var err error
+	switch {
+	case strings.HasPrefix(d.ContentType, "image/"):
+		err = processImage(ctx, &d)
+	case strings.HasPrefix(d.ContentType, "video/"):
+		err = processVideo(ctx, &d)
+	}
+	if err != nil {
+		log.Errorf("Error processing deliverable %s: %s", d.Key, err)
+		d.ProcessingError = err.Error()
+	}
+	if err := pq.SetDeliverableProcessed(ctx, &d); err != nil {
+		log.Errorf("Error saving processed deliverable %s: %s", d.Key, err)
+		return
+	}
+	duplicates, err := similarDeliverables(ctx, &d)
+	if err != nil {
+		log.Errorf("Error finding duplicates of deliverable %s: %s", d.Key, err)
+	}
+	for _, dup := range duplicates {
+		log.Warnf("Deliverable %s of booking %s is a duplicate of %s of booking %s, %d bits apart",
+			d.Key, d.BookingID, dup.Key, dup.BookingID, dup.Distance)
+	}
+}
+
+// similarDeliverables returns the other deliverables showing the same picture as d
+func similarDeliverables(ctx context.Context, d *storage.Deliverable) ([]*storage.SimilarDeliverable, error) {
+	if d.PHash == nil {
+		return nil, nil
+	}
+	similar, err := pq.GetSimilarDeliverables(ctx, *d.PHash, phash.NearDuplicate)
+	if err != nil {
+		return nil, err
+	}
+	var others []*storage.SimilarDeliverable
+	for _, s := range similar {
+		if s.Key != d.Key {
+			others = append(others, s)
+		}
+	}
+	return others, nil
+}
+
+// getDuplicateDeliverables lists the deliverables of the same picture, at most ?maxDistance= phash bits apart
+var getDuplicateDeliverables = func(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		maxDistance := phash.NearDuplicate
+		if v := r.URL.Query().Get("maxDistance"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 || n > 64 {
+				NewResErr(errors.Errorf("invalid maxDistance %q", v), "maxDistance must be from 0 to 64", http.StatusBadRequest, w)
+				return
+			}
+			maxDistance = n
+		}
+		duplicates, err := pq.GetDuplicateDeliverables(r.Context(), maxDistance)
+		if err != nil {
+			NewResErr(err, "Error getting duplicates", http.StatusInternalServerError, w)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(duplicates); err != nil {
+			NewResErr(err, JSONEncodingError.Error(), http.StatusInternalServerError, w)
+			return
+		}
+	}
+}
+
+func processImage(ctx context.Context, d *storage.Deliverable) error {
+	body, info, err := blobs.Get(ctx, d.Key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	if info.Size > maxImageProcessingSize {
+		return errors.Errorf("image of %d bytes is too large to process", info.Size)
+	}
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(b)
+	d.SHA256 = hex.EncodeToString(sum[:])
+
+	x, exifErr := exifimage.DecodeImageMetadata(b)
+	d.Exif = x
+
+	img, err := thumbnail.New(b)
+	if err != nil {
+		return err
+	}
+	d.PHash = &img.PHash
+	d.BlurHash, d.Palette = img.Placeholder.BlurHash, img.Placeholder.Palette
+	if err := storeDerivatives(ctx, d, img.Image, renditions); err != nil {
+		return errors.Wrap(err, "making derivatives")
+	}
+	thumb, err := img.EncodeThumbnail()
+	if err != nil {
+		return err
+	}
+	thumbKey := "thumbnails/" + d.Key + ".jpg"
+	if _, err := blobs.Put(ctx, thumbKey, bytes.NewReader(thumb.Bytes()), storage.PutOptions{ContentType: "image/jpeg"}); err != nil {
+		return err
+	}
+	d.ThumbnailKey = thumbKey
+	return errors.Wrap(exifErr, "reading exif")
+}
+
+func processVideo(ctx context.Context, d *storage.Deliverable) error {
+	info, err := blobs.Stat(ctx, d.Key)
+	if err != nil {
+		return err
+	}
+	video, err := exifvideo.NewVideo(storage.NewBlobReader(ctx, blobs, d.Key, info.Size), info.Size)
+	if err != nil {
+		return err
+	}
+	d.Exif = video.CreateVideoExifOutput()
+	if d.SHA256 != "" {
+		// hashed while it was uploaded
+		return nil
+	}
+	if info.Size > maxVideoProcessingSize {
+		return errors.Errorf("video of %d bytes is too large to hash", info.Size)
+	}
+	body, _, err := blobs.Get(ctx, d.Key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, body); err != nil {
+		return errors.Wrap(err, "hashing video")
+	}
+	d.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return nil
+}
@@ -0,0 +1,247 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"image"
+	"image/jpeg"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"firebase.google.com/go/auth"
+	"github.com/gorilla/mux"
+
+	"github.com/blixenkrone/gopro/internal/mediatest"
+	"github.com/blixenkrone/gopro/internal/storage"
+	"github.com/blixenkrone/gopro/internal/storage/local"
+)
+
+func TestBookingDeliverableKey(t *testing.T) {
+	cases := map[string]bool{
+		"booking/42/ab12-photo.jpg":         true,
+		"booking/42/":                       false,
+		"booking/421/ab12-photo.jpg":        false,
+		"booking/42/../43/photo.jpg":        false,
+		"booking/42/thumbnails/a.jpg":       false,
+		"thumbnails/booking/42/a.jpg.jpg":   false,
+		"booking/42//photo.jpg":             false,
+		"booking/42/ab12-photo.jpg/../x.jp": false,
+	}
+	for key, expected := range cases {
+		if ok := bookingDeliverableKey("42", key); ok != expected {
+			t.Errorf("%s: expected %v got %v", key, expected, ok)
+		}
+	}
+}
+
+func TestValidDeliverable(t *testing.T) {
+	if err := validDeliverable("video/mp4", 1<<30); err != nil {
+		t.Error(err)
+	}
+	if err := validDeliverable("image/jpeg", 0); err == nil {
+		t.Error("Expected error for an empty file")
+	}
+	if err := validDeliverable("image/jpeg", maxResumableUploadSize+1); err == nil {
+		t.Error("Expected error for a too large file")
+	}
+	if err := validDeliverable("application/zip", 100); err == nil {
+		t.Error("Expected error for a zip")
+	}
+}
+
+type fakeConsent struct {
+	storage.PQService
+	booking     *storage.Booking
+	deliverable *storage.Deliverable
+}
+
+func (f *fakeConsent) GetDeliverables(ctx context.Context, bookingID string) ([]*storage.Deliverable, error) {
+	return []*storage.Deliverable{f.deliverable}, nil
+}
+
+func (f *fakeConsent) GetBooking(ctx context.Context, bookingID string) (*storage.Booking, error) {
+	if bookingID != f.booking.ID {
+		return nil, sql.ErrNoRows
+	}
+	b := *f.booking
+	return &b, nil
+}
+
+func (f *fakeConsent) SetBookingConsent(ctx context.Context, bookingID, consent string) error {
+	f.booking.MetadataConsent = consent
+	return nil
+}
+
+func TestDeliverableAccess(t *testing.T) {
+	store, err := local.New(os.TempDir(), "http://localhost/blobs", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeConsent{booking: &storage.Booking{ID: "42", UserUID: "pro", MediaUID: "media"}, deliverable: &storage.Deliverable{}}
+	blobs, pq, fb = store, fake, fakeAdmins{admins: map[string]bool{"admin": true}}
+	defer func() { blobs, pq, fb = nil, nil, nil }()
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		codes   map[string]int
+	}{
+		{"list", getDeliverables, http.MethodGet,
+			map[string]int{"pro": http.StatusOK, "media": http.StatusOK, "admin": http.StatusOK, "other": http.StatusForbidden, "": http.StatusForbidden}},
+		{"upload url", createDeliverableUploadURL, http.MethodPost,
+			map[string]int{"pro": http.StatusOK, "media": http.StatusForbidden, "admin": http.StatusOK, "other": http.StatusForbidden}},
+		{"complete", completeDeliverableUpload, http.MethodPost,
+			map[string]int{"media": http.StatusForbidden, "other": http.StatusForbidden}},
+		{"derivatives", getDeliverableDerivatives, http.MethodGet,
+			map[string]int{"pro": http.StatusNotFound, "media": http.StatusNotFound, "other": http.StatusForbidden}},
+		{"new derivatives", getDeliverableDerivatives, http.MethodPost,
+			map[string]int{"pro": http.StatusNotFound, "media": http.StatusForbidden, "other": http.StatusForbidden}},
+	}
+	for _, tt := range tests {
+		for caller, code := range tt.codes {
+			body := `{"fileName":"photo.jpg","contentType":"image/jpeg","size":1000}`
+			r := httptest.NewRequest(tt.method, "/booking/42/deliverables?key=booking/42/missing.jpg", strings.NewReader(body))
+			r = mux.SetURLVars(r, map[string]string{"bookingID": "42"})
+			r = r.WithContext(context.WithValue(r.Context(), tokenKey, &auth.Token{UID: caller}))
+			w := httptest.NewRecorder()
+			tt.handler(w, r)
+			if w.Code != code {
+				t.Errorf("%s by %q: got %d, want %d", tt.name, caller, w.Code, code)
+			}
+		}
+	}
+}
+
+func TestBookingConsent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := local.New(dir, "http://localhost/blobs", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := "booking/42/0011-photo.jpg"
+	fake := &fakeConsent{booking: &storage.Booking{ID: "42", UserUID: "pro", MediaUID: "media"}, deliverable: &storage.Deliverable{Key: key}}
+	blobs, pq, fb = store, fake, fakeAdmins{admins: map[string]bool{"admin": true}}
+	defer func() { blobs, pq, fb = nil, nil, nil }()
+
+	// put records a jpeg of the size at key and returns its sum
+	put := func(size int) string {
+		var jpg bytes.Buffer
+		jpeg.Encode(&jpg, image.NewGray(image.Rect(0, 0, size, size)), nil)
+		sum := sha256.Sum256(jpg.Bytes())
+		if _, err := store.Put(context.Background(), key, &jpg, storage.PutOptions{ContentType: "image/jpeg"}); err != nil {
+			t.Fatal(err)
+		}
+		return hex.EncodeToString(sum[:])
+	}
+	sum := put(8)
+
+	request := func(method, target, caller, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r = mux.SetURLVars(r, map[string]string{"bookingID": "42"})
+		r = r.WithContext(context.WithValue(r.Context(), tokenKey, &auth.Token{UID: caller}))
+		w := httptest.NewRecorder()
+		if method == http.MethodPut {
+			setBookingConsent(w, r)
+		} else {
+			createDeliverableDownloadURL(w, r)
+		}
+		return w
+	}
+	download := func(consent string) string {
+		w := request(http.MethodGet, "/booking/42/deliverables/download-url?stripped=true&consent="+consent+"&key="+key, "media", "")
+		var res signedURLResponse
+		if err := json.NewDecoder(w.Body).Decode(&res); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("download: %d %v", w.Code, err)
+		}
+		return res.Key
+	}
+
+	if got := download("gps,names"); got != "stripped/"+sum+"/none.jpg" {
+		t.Errorf("consent of the query was used, got %s", got)
+	}
+	// the media can't opt out of stripping, only the professional and admins get the original
+	for caller, want := range map[string]string{"media": "stripped/" + sum + "/none.jpg", "pro": key, "admin": key} {
+		w := request(http.MethodGet, "/booking/42/deliverables/download-url?key="+key, caller, "")
+		var res signedURLResponse
+		if err := json.NewDecoder(w.Body).Decode(&res); err != nil || res.Key != want {
+			t.Errorf("%s got %s, want %s: %v", caller, res.Key, want, err)
+		}
+	}
+	if w := request(http.MethodGet, "/booking/42/deliverables/download-url?key="+key, "other", ""); w.Code != http.StatusForbidden {
+		t.Errorf("other got %d", w.Code)
+	}
+	if w := request(http.MethodPut, "/booking/42/consent", "media", `{"consent":"gps"}`); w.Code != http.StatusForbidden {
+		t.Errorf("media consented for the professional, got %d", w.Code)
+	}
+	if w := request(http.MethodPut, "/booking/42/consent", "pro", `{"consent":"gps,unknown"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown consent got %d", w.Code)
+	}
+	if w := request(http.MethodPut, "/booking/42/consent", "pro", `{"consent":"names, GPS"}`); w.Code != http.StatusOK || fake.booking.MetadataConsent != "gps,names" {
+		t.Errorf("consent of the professional got %d, stored %q", w.Code, fake.booking.MetadataConsent)
+	}
+	if got := download(""); got != "stripped/"+sum+"/gps,names.jpg" {
+		t.Errorf("consent of the booking was not used, got %s", got)
+	}
+	// a deliverable recorded again with other content isn't served the copy of the old content
+	fake.deliverable.SHA256 = put(16)
+	if got := download(""); got != "stripped/"+fake.deliverable.SHA256+"/gps,names.jpg" || fake.deliverable.SHA256 == sum {
+		t.Errorf("stale copy %s", got)
+	}
+	if _, err := blobs.Stat(context.Background(), "stripped/"+fake.deliverable.SHA256+"/gps,names.jpg"); err != nil {
+		t.Error(err)
+	}
+	if w := request(http.MethodPut, "/booking/42/consent", "admin", `{"consent":""}`); w.Code != http.StatusOK || fake.booking.MetadataConsent != "" {
+		t.Errorf("admin got %d, stored %q", w.Code, fake.booking.MetadataConsent)
+	}
+}
+
+func TestProcessLargeVideo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := local.New(dir, "http://localhost/blobs", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs = store
+	defer func() { blobs = nil }()
+
+	// a sparse file larger than maxVideoProcessingSize, its media data isn't read for the metadata
+	size := int64(maxVideoProcessingSize + 1<<20)
+	mvhd := mediatest.FullBox("mvhd", 0, 0, mediatest.U32(0, 0, 1000, 60000), make([]byte, 80))
+	head := append(mediatest.Box("ftyp", []byte("isom"), mediatest.U32(0)), mediatest.Box("moov", mvhd)...)
+	head = append(head, mediatest.U32(uint32(size-int64(len(head))))...)
+	head = append(head, "mdat"...)
+	key := "booking/42/0001-clip.mp4"
+	if _, err := store.Put(context.Background(), key, bytes.NewReader(head), storage.PutOptions{ContentType: "video/mp4"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(filepath.Join(dir, filepath.FromSlash(key)), size); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &storage.Deliverable{Key: key, SHA256: "hashed while uploading"}
+	if err := processVideo(context.Background(), d); err != nil || d.Exif == nil {
+		t.Fatalf("exif %+v, %v", d.Exif, err)
+	}
+	d = &storage.Deliverable{Key: key}
+	if err := processVideo(context.Background(), d); err == nil || d.Exif == nil {
+		t.Errorf("hashed a video of %d bytes, exif %+v", size, d.Exif)
+	}
+}
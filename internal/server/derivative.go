@@ -0,0 +1,174 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/blixenkrone/gopro/internal/storage"
+	"github.com/blixenkrone/gopro/pkg/image/derivative"
+	"github.com/blixenkrone/gopro/pkg/image/thumbnail"
+)
+
+// renditions are made of every image deliverable, set from IMAGE_RENDITIONS
+var renditions = derivative.DefaultRenditions
+
+// initDerivatives registers the WebP and AVIF encoder commands in WEBP_ENCODER and AVIF_ENCODER, e.g. cwebp
+// and avifenc, and reads the renditions from IMAGE_RENDITIONS as a JSON list
+func initDerivatives() {
+	if path := os.Getenv("WEBP_ENCODER"); path != "" {
+		derivative.RegisterEncoder(derivative.WebP, derivative.CWebP(path))
+	}
+	if path := os.Getenv("AVIF_ENCODER"); path != "" {
+		derivative.RegisterEncoder(derivative.AVIF, derivative.AVIFEnc(path))
+	}
+	if v := os.Getenv("IMAGE_RENDITIONS"); v != "" {
+		r, err := derivative.ParseRenditions([]byte(v))
+		if err != nil {
+			log.Fatalf("IMAGE_RENDITIONS: %s", err)
+		}
+		renditions = r
+	}
+}
+
+// derivativeKey is the storage key of a rendition of a deliverable
+func derivativeKey(key string, d *derivative.Derivative) string {
+	return "derivatives/" + key + "/" + d.Rendition.Name + d.Extension()
+}
+
+// storeDerivatives makes the renditions of the decoded image of d and stores them. The renditions
+// shown before the image is bought carry the watermark of the media of the booking.
+func storeDerivatives(ctx context.Context, d *storage.Deliverable, img image.Image, rs []derivative.Rendition) error {
+	booking, err := pq.GetBooking(ctx, d.BookingID)
+	if err != nil {
+		return err
+	}
+	wm, err := previewWatermark(ctx, booking.MediaUID)
+	if err != nil {
+		return err
+	}
+	derivatives, err := derivative.Generate(img, rs, wm)
+	if err != nil {
+		return err
+	}
+	stored := make([]*storage.Derivative, 0, len(derivatives))
+	for _, dv := range derivatives {
+		key := derivativeKey(d.Key, dv)
+		info, err := blobs.Put(ctx, key, bytes.NewReader(dv.Data), storage.PutOptions{ContentType: dv.ContentType})
+		if err != nil {
+			return err
+		}
+		stored = append(stored, &storage.Derivative{
+			Name:        dv.Rendition.Name,
+			Key:         key,
+			Width:       dv.Width,
+			Height:      dv.Height,
+			ContentType: dv.ContentType,
+			Size:        info.Size,
+		})
+	}
+	d.Derivatives = stored
+	return nil
+}
+
+// getDeliverableDerivatives returns the renditions of the deliverable ?key= with signed urls to the booking's
+// professional, media and admins. POST makes them again with the current renditions, e.g. after they were changed,
+// and ?crop=smart or ?crop=fill crops the square and other fixed aspect renditions by their content or the center.
+// Only the professional and admins can POST.
+var getDeliverableDerivatives = func(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet || r.Method == http.MethodPost {
+		w.Header().Set("Content-Type", "application/json")
+		var roles []string
+		if r.Method == http.MethodPost {
+			roles = []string{roleProfessional, roleAdmin}
+		}
+		bookingID, ok := requestBooking(w, r, roles...)
+		if !ok {
+			return
+		}
+		key := r.URL.Query().Get("key")
+		d, err := bookingDeliverable(r.Context(), bookingID, key)
+		if err != nil {
+			if errors.Cause(err) == storage.ErrBlobNotFound {
+				NewResErr(err, "Deliverable not found", http.StatusNotFound, w)
+				return
+			}
+			NewResErr(err, "Error getting deliverable", http.StatusInternalServerError, w)
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			rs := renditions
+			if crop := r.URL.Query().Get("crop"); crop != "" {
+				if rs, err = derivative.WithCrop(rs, derivative.Mode(crop)); err != nil {
+					NewResErr(err, "Invalid crop", http.StatusBadRequest, w)
+					return
+				}
+			}
+			if err := regenerateDerivatives(r.Context(), d, rs); err != nil {
+				NewResErr(err, "Error making derivatives", http.StatusInternalServerError, w)
+				return
+			}
+		}
+		derivatives := d.Derivatives
+		if derivatives == nil {
+			derivatives = []*storage.Derivative{}
+		}
+		for _, dv := range derivatives {
+			url, err := blobs.SignedURL(r.Context(), dv.Key, storage.SignOptions{Method: http.MethodGet, Expires: signedURLExpiry})
+			if err != nil {
+				NewResErr(err, "Error signing url", http.StatusInternalServerError, w)
+				return
+			}
+			dv.URL = url
+		}
+		if err := json.NewEncoder(w).Encode(derivatives); err != nil {
+			NewResErr(err, JSONEncodingError.Error(), http.StatusInternalServerError, w)
+			return
+		}
+	}
+}
+
+// bookingDeliverable returns the deliverable of the booking with key, or storage.ErrBlobNotFound
+func bookingDeliverable(ctx context.Context, bookingID, key string) (*storage.Deliverable, error) {
+	deliverables, err := pq.GetDeliverables(ctx, bookingID)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range deliverables {
+		if d.Key == key {
+			return d, nil
+		}
+	}
+	return nil, errors.Wrapf(storage.ErrBlobNotFound, "deliverable %s", key)
+}
+
+// regenerateDerivatives decodes a stored image deliverable once to make all renditions, and records them
+func regenerateDerivatives(ctx context.Context, d *storage.Deliverable, rs []derivative.Rendition) error {
+	if d.Size > maxImageProcessingSize {
+		return errors.Errorf("image of %d bytes is too large to process", d.Size)
+	}
+	body, _, err := blobs.Get(ctx, d.Key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	img, err := thumbnail.New(b)
+	if err != nil {
+		return err
+	}
+	if err := storeDerivatives(ctx, d, img.Image, rs); err != nil {
+		return err
+	}
+	return pq.SetDeliverableProcessed(ctx, d)
+}
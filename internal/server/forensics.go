@@ -0,0 +1,53 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/blixenkrone/gopro/pkg/exif/forensics"
+)
+
+// initForensics registers the quantization table fingerprints of camera makes from the JSON file in
+// CAMERA_TABLES. Without it the tables of an image are only compared to those of software.
+func initForensics() {
+	path := os.Getenv("CAMERA_TABLES")
+	if path == "" {
+		return
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatalf("CAMERA_TABLES: %s", err)
+	}
+	if err := forensics.LoadCameraTables(b); err != nil {
+		log.Fatalf("CAMERA_TABLES: %s", err)
+	}
+}
+
+// imageForensics reports signs that the image in the body was edited or its metadata forged
+var imageForensics = func(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		w.Header().Set("Content-Type", "application/json")
+		defer r.Body.Close()
+
+		b, err := ioutil.ReadAll(io.LimitReader(r.Body, maxImageProcessingSize+1))
+		if err != nil {
+			NewResErr(err, "Error reading image", http.StatusBadRequest, w)
+			return
+		}
+		if len(b) > maxImageProcessingSize {
+			err := errors.Errorf("image is larger than %d bytes", maxImageProcessingSize)
+			NewResErr(err, err.Error(), http.StatusRequestEntityTooLarge, w)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(forensics.Analyze(b, time.Now())); err != nil {
+			NewResErr(err, JSONEncodingError.Error(), http.StatusInternalServerError, w)
+			return
+		}
+	}
+}
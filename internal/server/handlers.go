@@ -3,6 +3,7 @@ package server
 import (
 	"bytes"
 	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,6 +11,8 @@ import (
 	"mime/multipart"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -19,12 +22,15 @@ import (
 
 	"github.com/blixenkrone/gopro/internal/mail"
 	"github.com/blixenkrone/gopro/internal/storage"
-	"github.com/blixenkrone/gopro/internal/storage/aws"
-	"github.com/blixenkrone/gopro/pkg/conversion"
 	exif "github.com/blixenkrone/gopro/pkg/exif"
 	exifimage "github.com/blixenkrone/gopro/pkg/exif/image"
+	"github.com/blixenkrone/gopro/pkg/exif/iptc"
 	exifvideo "github.com/blixenkrone/gopro/pkg/exif/video"
+	"github.com/blixenkrone/gopro/pkg/geo"
+	"github.com/blixenkrone/gopro/pkg/ical"
+	"github.com/blixenkrone/gopro/pkg/image/derivative"
 	"github.com/blixenkrone/gopro/pkg/image/thumbnail"
+	"github.com/blixenkrone/gopro/pkg/image/watermark"
 	timeutil "github.com/blixenkrone/gopro/pkg/time"
 )
 
@@ -166,72 +172,10 @@ var getProfiles = func(w http.ResponseWriter, r *http.Request) {
 
 var bookingUpload struct{}
 
-var bookingUploadToStorage = func(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodPost {
-		_, cancel := context.WithDeadline(r.Context(), time.Now().Add(30*time.Second))
-		defer cancel()
-
-		mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
-		if err != nil {
-			NewResErr(err, "Could not parse request body", http.StatusBadRequest, w)
-			return
-		}
-		w.Header().Set("Content-Type", mediaType)
-
-		if strings.HasPrefix(mediaType, "multipart/") {
-			mr := multipart.NewReader(r.Body, params["boundary"])
-			defer log.Error(r.Body.Close())
-
-			var s aws.AWSStorer
-			aws, err := aws.NewSession(s, r.Context(), mediaType)
-			if err != nil {
-				log.Error(err)
-			}
-
-			pr, pw := io.Pipe()
-			defer pw.Close()
-			// nameCH := make(chan string)
-			for {
-				part, err := mr.NextPart()
-				if err != nil {
-					if err == io.EOF {
-						break
-					}
-					NewResErr(err, "error reading file: "+part.FileName(), http.StatusBadRequest, w)
-					break
-				}
-
-				go func() {
-					_, err := io.Copy(pw, part)
-					if err != nil {
-						err := pw.CloseWithError(err)
-						log.Error(errors.Errorf("copy err: %s", err))
-					}
-				}()
-
-				log.Info("Processing: " + part.FileName())
-				go func() {
-					if err := aws.StoreFile(pr, part.FileName()); err != nil {
-						log.Errorf("error storing file %s with err: %s", part.FileName(), err)
-						return
-					}
-				}()
-			}
-
-			// if err := json.NewEncoder(w).Encode("success"); err != nil {
-			//	log.Error(err)
-			// }
-			// if err := json.NewEncoder(w).Encode(&res); err != nil {
-			// 	NewResErr(err, "failed uploading encoding", http.StatusInternalServerError, w)
-			// 	return
-			// }
-		}
-	}
-}
-
 type exifImagesResponse struct {
-	Preview *preview    `json:"preview,omitempty"`
-	Exif    *exifOutput `json:"exif,omitempty"`
+	FileName string      `json:"fileName,omitempty"`
+	Preview  *preview    `json:"preview,omitempty"`
+	Exif     *exifOutput `json:"exif,omitempty"`
 }
 
 type exifOutput struct {
@@ -241,13 +185,17 @@ type exifOutput struct {
 
 type preview struct {
 	Source []byte `json:"source,omitempty"`
-	Error  string `json:"error,omitempty"`
+	// the blurhash and colors to show until the preview is loaded
+	*thumbnail.Placeholder
+	Error string `json:"error,omitempty"`
 }
 
 // getExif receives body with img files
 // it attempts to fetch EXIF data from each image
 // if no exif data, the error message will be added to the response without breaking out of the loop until EOF.
-// endpoint: exif/${type=image/video}/?preview:bool
+// XMP sidecars (photo.xmp next to photo.jpg) are merged into the IPTC of the image with the same name.
+// Previews are watermarked with the profile of the media customer in ?media=, or the default watermark.
+// endpoint: exif/${type=image/video}/?preview:bool&crop=smart&media=uid
 var exifImages = func(w http.ResponseWriter, r *http.Request) {
 	// r.Body = http.MaxBytesReader(w, r.Body, 32<<20+512)
 	if r.Method == "POST" {
@@ -265,9 +213,22 @@ var exifImages = func(w http.ResponseWriter, r *http.Request) {
 			if r.URL.Query().Get("preview") != "" {
 				withPreview = true
 			}
+			encodePreview := (*thumbnail.Image).EncodeThumbnail
+			if r.URL.Query().Get("crop") == string(derivative.Smart) {
+				encodePreview = (*thumbnail.Image).EncodeSmartThumbnail
+			}
+			// previews are shown before an image is bought, so they carry the watermark of ?media=
+			var wm *watermark.Watermark
+			if withPreview {
+				if wm, err = previewWatermark(r.Context(), r.URL.Query().Get("media")); err != nil {
+					NewResErr(err, "Error getting watermark", http.StatusInternalServerError, w)
+					return
+				}
+			}
 			mr := multipart.NewReader(r.Body, params["boundary"])
 			defer r.Body.Close()
 			var res []*exifImagesResponse
+			sidecars := make(map[string]*exif.IPTC)
 
 			for {
 				// (*os.File) for next file
@@ -287,24 +248,39 @@ var exifImages = func(w http.ResponseWriter, r *http.Request) {
 					break
 				}
 
-				log.Infof("copied file: ", part.FileName())
+				log.Infof("copied file: %s", part.FileName())
+
+				if isXMPSidecar(part.FileName()) {
+					meta, err := iptc.DecodeXMP(buf.Bytes())
+					if err != nil {
+						res = append(res, &exifImagesResponse{FileName: part.FileName(), Exif: &exifOutput{Error: err.Error()}})
+						continue
+					}
+					sidecars[sidecarKey(part.FileName())] = meta
+					continue
+				}
 
 				// JSON response struct
-				var data exifImagesResponse
+				data := exifImagesResponse{FileName: part.FileName()}
 
 				if withPreview {
 					var preview preview
+					var thumb *thumbnail.ParsedImage
 					img, err := thumbnail.New(buf.Bytes())
-					if err != nil {
-						preview.Error = err.Error()
-						log.Error(err)
+					if err == nil {
+						preview.Placeholder = &img.Placeholder
+						thumb, err = encodePreview(img)
+					}
+					if err == nil {
+						err = thumb.Watermark(wm)
 					}
-					thumb, err := img.EncodeThumbnail()
+					// a preview that couldn't be watermarked is left out, never sent without it
 					if err != nil {
 						preview.Error = err.Error()
 						log.Error(err)
+					} else {
+						preview.Source = thumb.Bytes()
 					}
-					preview.Source = thumb.Bytes()
 					data.Preview = &preview
 				}
 
@@ -320,6 +296,14 @@ var exifImages = func(w http.ResponseWriter, r *http.Request) {
 
 				res = append(res, &data)
 			}
+			for _, data := range res {
+				if meta, ok := sidecars[sidecarKey(data.FileName)]; ok && !isXMPSidecar(data.FileName) {
+					if data.Exif.Output == nil {
+						data.Exif.Output = &exif.Output{}
+					}
+					data.Exif.Output.AddIPTC(meta)
+				}
+			}
 
 			if err := json.NewEncoder(w).Encode(res); err != nil {
 				NewResErr(err, JSONEncodingError.Error(), http.StatusInternalServerError, w)
@@ -329,6 +313,17 @@ var exifImages = func(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func isXMPSidecar(fileName string) bool {
+	return strings.EqualFold(filepath.Ext(fileName), ".xmp")
+}
+
+// sidecarKey is the file name without extension, shared by an image and its sidecar
+func sidecarKey(fileName string) string {
+	return strings.ToLower(strings.TrimSuffix(fileName, filepath.Ext(fileName)))
+}
+
+// exifVideo returns the exif of the video in the body. With ?preview=true it adds a poster frame from
+// ?at= seconds and a strip of ?frames= frames, watermarked like image previews with the profile of ?media=.
 var exifVideo = func(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
 		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
@@ -337,27 +332,60 @@ var exifVideo = func(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		w.Header().Set("Content-Type", mediaType)
-
-		video, err := exifvideo.ReadVideo(r.Body)
+		withPreview := r.URL.Query().Get("preview") != ""
+		opts, err := parseVideoPreviewOptions(r.URL.Query())
 		if err != nil {
-			NewResErr(err, err.Error(), http.StatusNotFound, w, "err")
+			NewResErr(err, err.Error(), http.StatusBadRequest, w)
 			return
 		}
+		var wm *watermark.Watermark
+		if withPreview {
+			if wm, err = previewWatermark(r.Context(), r.URL.Query().Get("media")); err != nil {
+				NewResErr(err, "Error getting watermark", http.StatusInternalServerError, w)
+				return
+			}
+		}
 
-		out := video.CreateVideoExifOutput()
+		defer r.Body.Close()
+		// frames are extracted from the whole video, for the metadata it is read past
+		read := exifvideo.ReadVideo
+		if withPreview && exifvideo.CanExtractFrames() {
+			read = exifvideo.SpoolVideo
+		}
+		video, err := read(http.MaxBytesReader(w, r.Body, maxVideoProcessingSize))
+		if err != nil {
+			NewResErr(err, err.Error(), http.StatusBadRequest, w, "err")
+			return
+		}
 		defer func() {
-			if err := video.File.Close(); err != nil {
-				log.Errorln(err)
-			}
-			if err := video.File.RemoveFile(); err != nil {
-				log.Error(err)
-			}
-			if err := r.Body.Close(); err != nil {
+			if err := video.Close(); err != nil {
 				log.Error(err)
 			}
 		}()
+		res := exifVideoResponse{Output: video.CreateVideoExifOutput()}
+		if withPreview {
+			res.Preview = makeVideoPreview(r.Context(), video, video.Meta.Duration, opts, wm)
+		}
+
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			NewResErr(err, JSONEncodingError.Error(), http.StatusInternalServerError, w, "trace")
+		}
+	}
+}
+
+// exifVideoDetails returns the format, codecs, frame rate, bitrate and streams of the video in the body
+var exifVideoDetails = func(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		w.Header().Set("Content-Type", "application/json")
 
-		if err := json.NewEncoder(w).Encode(out); err != nil {
+		defer r.Body.Close()
+		video, err := exifvideo.ReadVideo(r.Body)
+		if err != nil {
+			NewResErr(err, err.Error(), http.StatusBadRequest, w, "err")
+			return
+		}
+
+		if err := json.NewEncoder(w).Encode(video.VideoOutput()); err != nil {
 			NewResErr(err, JSONEncodingError.Error(), http.StatusInternalServerError, w, "trace")
 		}
 	}
@@ -430,8 +458,18 @@ var createBooking = func(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if !req.DateEnd.After(*req.DateStart) {
+			err := errors.New("The enddate must be after the startdate")
+			NewResErr(err, err.Error(), http.StatusBadRequest, w)
+			return
+		}
+
 		b, err := pq.CreateBooking(r.Context(), uid, req)
 		if err != nil {
+			if errors.Cause(err) == storage.ErrBookingOverlap {
+				NewResErr(err, err.Error(), http.StatusConflict, w)
+				return
+			}
 			NewResErr(err, err.Error(), http.StatusBadRequest, w, "trace")
 			return
 		}
@@ -448,7 +486,6 @@ var updateBooking = func(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPut {
 		w.Header().Set("Content-Type", "application/json")
 		var b storage.Booking
-		var err error
 		params := mux.Vars(r)
 		bookingID, ok := params["bookingID"]
 		if !ok {
@@ -459,11 +496,6 @@ var updateBooking = func(w http.ResponseWriter, r *http.Request) {
 
 		b.ID = bookingID
 		b.Task = r.FormValue("task")
-		b.IsActive, err = conversion.ParseBool(r.FormValue("isActive"))
-		if err != nil {
-			NewResErr(err, err.Error(), http.StatusBadRequest, w)
-			return
-		}
 
 		if err := pq.UpdateBooking(r.Context(), &b); err != nil {
 			NewResErr(err, "Error inserting record", http.StatusInternalServerError, w, "trace")
@@ -477,6 +509,97 @@ var updateBooking = func(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+type transitionRequest struct {
+	Status storage.BookingStatus `json:"status"`
+	Note   string                `json:"note,omitempty"`
+}
+
+// transitionRoles are the roles in a booking that may move it from a status to another. Admins may make every move.
+func transitionRoles(from, to storage.BookingStatus) []string {
+	switch to {
+	case storage.BookingAccepted, storage.BookingDeclined, storage.BookingDelivered:
+		return []string{roleProfessional, roleAdmin}
+	case storage.BookingInProgress:
+		// the media sends delivered work back, the professional starts accepted work
+		if from == storage.BookingDelivered {
+			return []string{roleMedia, roleAdmin}
+		}
+		return []string{roleProfessional, roleAdmin}
+	case storage.BookingApproved, storage.BookingCancelled:
+		return []string{roleMedia, roleAdmin}
+	default:
+		return []string{roleAdmin}
+	}
+}
+
+// POST /booking/task/{bookingID}/transition
+var transitionBooking = func(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		w.Header().Set("Content-Type", "application/json")
+		params := mux.Vars(r)
+		bookingID := params["bookingID"]
+
+		var req transitionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			NewResErr(err, "Error reading body", http.StatusBadRequest, w)
+			return
+		}
+		defer r.Body.Close()
+
+		b, ok := getRequestBooking(w, r)
+		if !ok {
+			return
+		}
+		if _, ok := authorizeBooking(w, r, b, transitionRoles(b.Status, req.Status)...); !ok {
+			return
+		}
+
+		t, err := pq.TransitionBooking(r.Context(), bookingID, storage.BookingTransition{
+			From:     b.Status,
+			To:       req.Status,
+			ActorUID: requestUID(r),
+			Note:     req.Note,
+		})
+		if err != nil {
+			switch errors.Cause(err) {
+			case storage.ErrIllegalTransition:
+				NewResErr(err, err.Error(), http.StatusConflict, w)
+			case storage.ErrUnknownBookingStatus:
+				NewResErr(err, err.Error(), http.StatusBadRequest, w)
+			case sql.ErrNoRows:
+				NewResErr(err, "No booking found with id "+bookingID, http.StatusNotFound, w)
+			default:
+				NewResErr(err, "Error changing booking status", http.StatusInternalServerError, w, "trace")
+			}
+			return
+		}
+
+		if err := json.NewEncoder(w).Encode(t); err != nil {
+			NewResErr(err, "Error returning response", http.StatusInternalServerError, w)
+			return
+		}
+	}
+}
+
+// GET /booking/task/{bookingID}/transitions
+var getBookingTransitions = func(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		params := mux.Vars(r)
+
+		transitions, err := pq.GetBookingTransitions(r.Context(), params["bookingID"])
+		if err != nil {
+			NewResErr(err, "Error getting booking history", http.StatusInternalServerError, w, "trace")
+			return
+		}
+
+		if err := json.NewEncoder(w).Encode(transitions); err != nil {
+			NewResErr(err, "Error returning response", http.StatusInternalServerError, w)
+			return
+		}
+	}
+}
+
 // DELETE /bookings/{bookingID}
 var deleteBooking = func(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodDelete {
@@ -494,6 +617,377 @@ var deleteBooking = func(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+/**
+ * Professional availability
+ */
+
+const maxAvailabilityWindow = 62 * 24 * time.Hour
+
+// GET /professional/{uid}/availability?from=&to=
+// from and to are RFC3339 timestamps and default to the coming week.
+var getAvailability = func(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		params := mux.Vars(r)
+		var err error
+
+		from := time.Now().UTC()
+		if v := r.URL.Query().Get("from"); v != "" {
+			if from, err = time.Parse(time.RFC3339, v); err != nil {
+				NewResErr(err, "from must be an RFC3339 timestamp", http.StatusBadRequest, w)
+				return
+			}
+		}
+		to := from.Add(7 * 24 * time.Hour)
+		if v := r.URL.Query().Get("to"); v != "" {
+			if to, err = time.Parse(time.RFC3339, v); err != nil {
+				NewResErr(err, "to must be an RFC3339 timestamp", http.StatusBadRequest, w)
+				return
+			}
+		}
+		if !to.After(from) || to.Sub(from) > maxAvailabilityWindow {
+			err := errors.Errorf("to must be after from and at most %s later", maxAvailabilityWindow)
+			NewResErr(err, err.Error(), http.StatusBadRequest, w)
+			return
+		}
+
+		availability, err := pq.GetAvailability(r.Context(), params["uid"], from, to)
+		if err != nil {
+			NewResErr(err, "Error getting availability", http.StatusInternalServerError, w, "trace")
+			return
+		}
+
+		if err := json.NewEncoder(w).Encode(availability); err != nil {
+			NewResErr(err, "Error returning response", http.StatusInternalServerError, w)
+			return
+		}
+	}
+}
+
+// authorizeProfessional writes a 403 and returns false unless the caller is the professional uid or an admin
+func authorizeProfessional(w http.ResponseWriter, r *http.Request, uid string) bool {
+	caller := requestUID(r)
+	if caller == uid {
+		return true
+	}
+	if ok, err := fb.IsAdminUID(r.Context(), caller); err != nil || !ok {
+		err := errors.Errorf("%s requested %s of %s", caller, r.URL.Path, uid)
+		NewResErr(err, "Belongs to another professional", http.StatusForbidden, w)
+		return false
+	}
+	return true
+}
+
+// PUT /professional/{uid}/hours
+var setWorkingHours = func(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		w.Header().Set("Content-Type", "application/json")
+		params := mux.Vars(r)
+		if !authorizeProfessional(w, r, params["uid"]) {
+			return
+		}
+
+		var hours []*storage.WorkingHours
+		if err := json.NewDecoder(r.Body).Decode(&hours); err != nil {
+			NewResErr(err, "Error reading body", http.StatusBadRequest, w)
+			return
+		}
+		defer r.Body.Close()
+
+		if err := pq.SetWorkingHours(r.Context(), params["uid"], hours); err != nil {
+			NewResErr(err, err.Error(), http.StatusBadRequest, w, "trace")
+			return
+		}
+
+		if err := json.NewEncoder(w).Encode(hours); err != nil {
+			NewResErr(err, "Error returning response", http.StatusInternalServerError, w)
+			return
+		}
+	}
+}
+
+// POST /professional/{uid}/blocked
+var createBlockedPeriod = func(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		w.Header().Set("Content-Type", "application/json")
+		params := mux.Vars(r)
+		if !authorizeProfessional(w, r, params["uid"]) {
+			return
+		}
+
+		var req storage.BlockedPeriod
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			NewResErr(err, "Error reading body", http.StatusBadRequest, w)
+			return
+		}
+		defer r.Body.Close()
+
+		if req.DateStart == nil || req.DateEnd == nil || !req.DateEnd.After(*req.DateStart) {
+			err := errors.New("The start and enddate must be set and the enddate must be after the startdate")
+			NewResErr(err, err.Error(), http.StatusBadRequest, w)
+			return
+		}
+
+		id, err := pq.CreateBlockedPeriod(r.Context(), params["uid"], req)
+		if err != nil {
+			NewResErr(err, "Error inserting record", http.StatusInternalServerError, w, "trace")
+			return
+		}
+		req.ID = id
+		req.UserUID = params["uid"]
+
+		if err := json.NewEncoder(w).Encode(&req); err != nil {
+			NewResErr(err, "Error returning response", http.StatusInternalServerError, w)
+			return
+		}
+	}
+}
+
+/**
+ * Booking calendar feed
+ */
+
+const calendarProdID = "-//Byrd//Pro bookings//EN"
+
+var calendarEventStatus = map[storage.BookingStatus]string{
+	storage.BookingRequested:  ical.StatusTentative,
+	storage.BookingAccepted:   ical.StatusConfirmed,
+	storage.BookingInProgress: ical.StatusConfirmed,
+	storage.BookingDelivered:  ical.StatusConfirmed,
+	storage.BookingApproved:   ical.StatusConfirmed,
+	storage.BookingInvoiced:   ical.StatusConfirmed,
+	storage.BookingCancelled:  ical.StatusCancelled,
+	storage.BookingDeclined:   ical.StatusCancelled,
+	storage.BookingExpired:    ical.StatusCancelled,
+}
+
+// bookingEvent maps a booking to a calendar event. The UID is stable per booking id,
+// so calendar apps update the event instead of duplicating it.
+func bookingEvent(b *storage.Booking) *ical.Event {
+	ev := &ical.Event{
+		UID:     "booking-" + b.ID + "@pro.byrd.news",
+		Start:   *b.DateStart,
+		End:     *b.DateEnd,
+		Summary: b.Task,
+		Status:  calendarEventStatus[b.Status],
+	}
+	if b.CreatedAt != nil {
+		ev.Stamp = *b.CreatedAt
+	}
+	if b.Lat != 0 || b.Lng != 0 {
+		ev.Geo = &ical.Geo{Lat: float64(b.Lat), Lng: float64(b.Lng)}
+	}
+	return ev
+}
+
+type calendarTokenResponse struct {
+	Token string `json:"token"`
+	URL   string `json:"url"`
+}
+
+// GET /professional/{uid}/calendar returns the subscription url for the calendar feed.
+// POST rotates the token, breaking existing subscriptions. Only the professional and admins have access.
+var getCalendarToken = func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	params := mux.Vars(r)
+	if !authorizeProfessional(w, r, params["uid"]) {
+		return
+	}
+	var token string
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		token, err = pq.GetCalendarToken(r.Context(), params["uid"])
+	case http.MethodPost:
+		token, err = pq.RotateCalendarToken(r.Context(), params["uid"])
+	default:
+		return
+	}
+	if err != nil {
+		NewResErr(err, "Error getting calendar token", http.StatusInternalServerError, w, "trace")
+		return
+	}
+
+	res := calendarTokenResponse{
+		Token: token,
+		URL:   "https://" + r.Host + "/calendar/" + token + ".ics",
+	}
+	if err := json.NewEncoder(w).Encode(&res); err != nil {
+		NewResErr(err, "Error returning response", http.StatusInternalServerError, w)
+		return
+	}
+}
+
+// GET /calendar/{token}.ics
+// Not behind isAuth, since calendar apps can't send a firebase token. The token itself is the credential.
+var getBookingCalendar = func(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		params := mux.Vars(r)
+		uid, err := pq.GetUIDByCalendarToken(r.Context(), params["token"])
+		if err != nil {
+			if err == sql.ErrNoRows {
+				NewResErr(err, "Calendar not found", http.StatusNotFound, w)
+				return
+			}
+			NewResErr(err, "Error getting calendar", http.StatusInternalServerError, w, "trace")
+			return
+		}
+
+		bookings, err := pq.GetBookingsByUID(r.Context(), uid)
+		if err != nil {
+			NewResErr(err, "Error getting bookings", http.StatusInternalServerError, w, "trace")
+			return
+		}
+
+		cal := &ical.Calendar{
+			ProdID: calendarProdID,
+			Name:   "Byrd bookings",
+		}
+		for _, b := range bookings {
+			if b.DateStart == nil || b.DateEnd == nil {
+				continue
+			}
+			cal.Events = append(cal.Events, bookingEvent(b))
+		}
+
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		w.Header().Set("Content-Disposition", `inline; filename="bookings.ics"`)
+		if err := cal.Encode(w); err != nil {
+			log.Errorf("Error encoding calendar: %s", err)
+		}
+	}
+}
+
+/**
+ * Geo search
+ */
+
+const (
+	defaultRadiusKm = 25
+	maxRadiusKm     = 500
+)
+
+// parseNearQuery reads ?near=lat,lng&radius_km=
+func parseNearQuery(r *http.Request) (geo.Point, float64, error) {
+	point, err := geo.ParsePoint(r.URL.Query().Get("near"))
+	if err != nil {
+		return point, 0, err
+	}
+	radius := float64(defaultRadiusKm)
+	if v := r.URL.Query().Get("radius_km"); v != "" {
+		if radius, err = strconv.ParseFloat(v, 64); err != nil {
+			return point, 0, errors.Wrap(err, "parsing radius_km")
+		}
+	}
+	if radius <= 0 || radius > maxRadiusKm {
+		return point, 0, errors.Errorf("radius_km must be between 0 and %d", maxRadiusKm)
+	}
+	return point, radius, nil
+}
+
+// GET /booking/task?near=lat,lng&radius_km=
+var getBookingsNear = func(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		point, radius, err := parseNearQuery(r)
+		if err != nil {
+			NewResErr(err, err.Error(), http.StatusBadRequest, w)
+			return
+		}
+
+		bookings, err := pq.GetBookingsNear(r.Context(), point, radius)
+		if err != nil {
+			NewResErr(err, "Error getting bookings", http.StatusInternalServerError, w, "trace")
+			return
+		}
+
+		if err := json.NewEncoder(w).Encode(bookings); err != nil {
+			NewResErr(err, "Error returning response", http.StatusInternalServerError, w)
+			return
+		}
+	}
+}
+
+// GET /professionals/nearby?near=lat,lng&radius_km=&from=&to=
+// When from and to (RFC3339) are given, only professionals without bookings in the period are returned.
+var getProfessionalsNear = func(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		point, radius, err := parseNearQuery(r)
+		if err != nil {
+			NewResErr(err, err.Error(), http.StatusBadRequest, w)
+			return
+		}
+
+		var available *timeutil.Period
+		if from, to := r.URL.Query().Get("from"), r.URL.Query().Get("to"); from != "" || to != "" {
+			var period timeutil.Period
+			if period.Start, err = time.Parse(time.RFC3339, from); err != nil {
+				NewResErr(err, "from must be an RFC3339 timestamp", http.StatusBadRequest, w)
+				return
+			}
+			if period.End, err = time.Parse(time.RFC3339, to); err != nil {
+				NewResErr(err, "to must be an RFC3339 timestamp", http.StatusBadRequest, w)
+				return
+			}
+			if !period.End.After(period.Start) {
+				err := errors.New("to must be after from")
+				NewResErr(err, err.Error(), http.StatusBadRequest, w)
+				return
+			}
+			available = &period
+		}
+
+		pros, err := pq.GetProfessionalsNear(r.Context(), point, radius, available)
+		if err != nil {
+			NewResErr(err, "Error getting professionals", http.StatusInternalServerError, w, "trace")
+			return
+		}
+
+		if err := json.NewEncoder(w).Encode(pros); err != nil {
+			NewResErr(err, "Error returning response", http.StatusInternalServerError, w)
+			return
+		}
+	}
+}
+
+// PUT /professional/{uid}/location
+var updateProfessionalLocation = func(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		w.Header().Set("Content-Type", "application/json")
+		params := mux.Vars(r)
+		if !authorizeProfessional(w, r, params["uid"]) {
+			return
+		}
+
+		var point geo.Point
+		if err := json.NewDecoder(r.Body).Decode(&point); err != nil {
+			NewResErr(err, "Error reading body", http.StatusBadRequest, w)
+			return
+		}
+		defer r.Body.Close()
+		if !point.Valid() {
+			err := errors.Errorf("coordinates out of range: %v,%v", point.Lat, point.Lng)
+			NewResErr(err, err.Error(), http.StatusBadRequest, w)
+			return
+		}
+
+		if err := pq.UpdateProfessionalLocation(r.Context(), params["uid"], point); err != nil {
+			if err == sql.ErrNoRows {
+				NewResErr(err, "No professional found with uid "+params["uid"], http.StatusNotFound, w)
+				return
+			}
+			NewResErr(err, "Error updating location", http.StatusInternalServerError, w, "trace")
+			return
+		}
+
+		if err := json.NewEncoder(w).Encode(&point); err != nil {
+			NewResErr(err, "Error returning response", http.StatusInternalServerError, w)
+			return
+		}
+	}
+}
+
 // Gets the firebase profile, with postgres profile and booking
 var getProfileWithBookings = func(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"firebase.google.com/go/auth"
+	"github.com/gorilla/mux"
+
+	"github.com/blixenkrone/gopro/internal/storage"
+	"github.com/blixenkrone/gopro/pkg/geo"
+)
+
+type fakeAdmins struct {
+	storage.FBService
+	admins map[string]bool
+}
+
+func (f fakeAdmins) IsAdminUID(ctx context.Context, uid string) (bool, error) {
+	return f.admins[uid], nil
+}
+
+type fakeCalendars struct {
+	storage.PQService
+}
+
+func (fakeCalendars) GetCalendarToken(ctx context.Context, proUID string) (string, error) {
+	return "token-of-" + proUID, nil
+}
+
+func (fakeCalendars) RotateCalendarToken(ctx context.Context, proUID string) (string, error) {
+	return "rotated-" + proUID, nil
+}
+
+func TestGetCalendarTokenOwner(t *testing.T) {
+	fb, pq = fakeAdmins{admins: map[string]bool{"admin": true}}, fakeCalendars{}
+	defer func() { fb, pq = nil, nil }()
+
+	tests := []struct {
+		caller, method string
+		code           int
+	}{
+		{"pro", http.MethodGet, http.StatusOK},
+		{"pro", http.MethodPost, http.StatusOK},
+		{"other", http.MethodGet, http.StatusForbidden},
+		{"other", http.MethodPost, http.StatusForbidden},
+		{"admin", http.MethodGet, http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "/professional/pro/calendar", nil)
+		r = mux.SetURLVars(r, map[string]string{"uid": "pro"})
+		r = r.WithContext(context.WithValue(r.Context(), tokenKey, &auth.Token{UID: tt.caller}))
+		w := httptest.NewRecorder()
+		getCalendarToken(w, r)
+		if w.Code != tt.code {
+			t.Errorf("%s %s: got %d, want %d", tt.caller, tt.method, w.Code, tt.code)
+		}
+	}
+}
+
+type fakeLocations struct {
+	storage.PQService
+	moved map[string]geo.Point
+}
+
+func (f fakeLocations) UpdateProfessionalLocation(ctx context.Context, proUID string, p geo.Point) error {
+	f.moved[proUID] = p
+	return nil
+}
+
+func TestUpdateProfessionalLocationOwner(t *testing.T) {
+	locations := fakeLocations{moved: make(map[string]geo.Point)}
+	fb, pq = fakeAdmins{admins: map[string]bool{"admin": true}}, locations
+	defer func() { fb, pq = nil, nil }()
+
+	for caller, code := range map[string]int{"pro": http.StatusOK, "admin": http.StatusOK, "other": http.StatusForbidden} {
+		delete(locations.moved, "pro")
+		r := httptest.NewRequest(http.MethodPut, "/professional/pro/location", strings.NewReader(`{"lat":56.15,"lng":10.2}`))
+		r = mux.SetURLVars(r, map[string]string{"uid": "pro"})
+		r = r.WithContext(context.WithValue(r.Context(), tokenKey, &auth.Token{UID: caller}))
+		w := httptest.NewRecorder()
+		updateProfessionalLocation(w, r)
+		if w.Code != code {
+			t.Errorf("%s: got %d, want %d", caller, w.Code, code)
+		}
+		if _, moved := locations.moved["pro"]; moved != (code == http.StatusOK) {
+			t.Errorf("%s: moved %v", caller, moved)
+		}
+	}
+}
+
+type fakeSchedules struct {
+	storage.PQService
+}
+
+func (fakeSchedules) SetWorkingHours(ctx context.Context, proUID string, hours []*storage.WorkingHours) error {
+	return nil
+}
+
+func (fakeSchedules) CreateBlockedPeriod(ctx context.Context, proUID string, b storage.BlockedPeriod) (string, error) {
+	return "blocked", nil
+}
+
+func TestScheduleOwner(t *testing.T) {
+	fb, pq = fakeAdmins{admins: map[string]bool{"admin": true}}, fakeSchedules{}
+	defer func() { fb, pq = nil, nil }()
+
+	tests := []struct {
+		handler      http.HandlerFunc
+		method, body string
+	}{
+		{setWorkingHours, http.MethodPut, `[]`},
+		{createBlockedPeriod, http.MethodPost, `{"dateStart":"2021-06-01T08:00:00Z","dateEnd":"2021-06-02T08:00:00Z"}`},
+	}
+	for _, tt := range tests {
+		for caller, code := range map[string]int{"pro": http.StatusOK, "admin": http.StatusOK, "other": http.StatusForbidden} {
+			r := httptest.NewRequest(tt.method, "/professional/pro", strings.NewReader(tt.body))
+			r = mux.SetURLVars(r, map[string]string{"uid": "pro"})
+			r = r.WithContext(context.WithValue(r.Context(), tokenKey, &auth.Token{UID: caller}))
+			w := httptest.NewRecorder()
+			tt.handler(w, r)
+			if w.Code != code {
+				t.Errorf("%s %s: got %d, want %d: %s", caller, tt.method, w.Code, code, w.Body)
+			}
+		}
+	}
+}
+
+type fakeTransitions struct {
+	storage.PQService
+	booking *storage.Booking
+}
+
+func (f *fakeTransitions) GetBooking(ctx context.Context, bookingID string) (*storage.Booking, error) {
+	b := *f.booking
+	return &b, nil
+}
+
+func (f *fakeTransitions) TransitionBooking(ctx context.Context, bookingID string, t storage.BookingTransition) (*storage.BookingTransition, error) {
+	if t.From != f.booking.Status {
+		return nil, storage.ErrIllegalTransition
+	}
+	f.booking.Status = t.To
+	return &t, nil
+}
+
+func TestTransitionBookingRoles(t *testing.T) {
+	fake := &fakeTransitions{}
+	fb, pq = fakeAdmins{admins: map[string]bool{"admin": true}}, fake
+	defer func() { fb, pq = nil, nil }()
+
+	tests := []struct {
+		from, to storage.BookingStatus
+		caller   string
+		code     int
+	}{
+		{storage.BookingRequested, storage.BookingAccepted, "pro", http.StatusOK},
+		{storage.BookingRequested, storage.BookingAccepted, "media", http.StatusForbidden},
+		{storage.BookingRequested, storage.BookingDeclined, "other", http.StatusForbidden},
+		{storage.BookingAccepted, storage.BookingInProgress, "pro", http.StatusOK},
+		{storage.BookingInProgress, storage.BookingDelivered, "media", http.StatusForbidden},
+		{storage.BookingDelivered, storage.BookingInProgress, "media", http.StatusOK},
+		{storage.BookingDelivered, storage.BookingInProgress, "pro", http.StatusForbidden},
+		{storage.BookingDelivered, storage.BookingApproved, "pro", http.StatusForbidden},
+		{storage.BookingDelivered, storage.BookingApproved, "media", http.StatusOK},
+		{storage.BookingAccepted, storage.BookingCancelled, "media", http.StatusOK},
+		{storage.BookingApproved, storage.BookingInvoiced, "media", http.StatusForbidden},
+		{storage.BookingApproved, storage.BookingInvoiced, "pro", http.StatusForbidden},
+		{storage.BookingApproved, storage.BookingInvoiced, "admin", http.StatusOK},
+	}
+	for _, tt := range tests {
+		fake.booking = &storage.Booking{ID: "42", UserUID: "pro", MediaUID: "media", Status: tt.from}
+		body := `{"status":"` + string(tt.to) + `"}`
+		r := httptest.NewRequest(http.MethodPost, "/booking/task/42/transition", strings.NewReader(body))
+		r = mux.SetURLVars(r, map[string]string{"bookingID": "42"})
+		r = r.WithContext(context.WithValue(r.Context(), tokenKey, &auth.Token{UID: tt.caller}))
+		w := httptest.NewRecorder()
+		transitionBooking(w, r)
+		if w.Code != tt.code {
+			t.Errorf("%s %s -> %s: got %d, want %d", tt.caller, tt.from, tt.to, w.Code, tt.code)
+		}
+	}
+}
@@ -1,9 +1,14 @@
 package server
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"time"
 
+	"firebase.google.com/go/auth"
 	"github.com/pkg/errors"
 )
 
@@ -12,6 +17,70 @@ const (
 	// isAdminClaim = "is_admin"
 )
 
+type contextKey string
+
+// tokenKey holds the verified token of the request, set by isAuth and isAdmin
+const tokenKey contextKey = "token"
+
+// connKey holds the connection of the request, set by the ConnContext of the server
+const connKey contextKey = "conn"
+
+const (
+	// readTimeout and writeTimeout bound reading and answering a request
+	readTimeout  = 5 * time.Second
+	writeTimeout = 10 * time.Second
+	// uploadIdleTimeout is how long the body of an upload may go without a byte arriving
+	uploadIdleTimeout = time.Minute
+)
+
+// withDeadline sets the deadlines of the connection for each request. Unlike the Read- and WriteTimeout
+// of the server, they can be extended for the uploads of large files by withUploadDeadline.
+func withDeadline(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c, ok := r.Context().Value(connKey).(net.Conn); ok {
+			now := time.Now()
+			c.SetReadDeadline(now.Add(readTimeout))
+			c.SetWriteDeadline(now.Add(writeTimeout))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withUploadDeadline lets the body of an upload take as long as its bytes keep arriving
+var withUploadDeadline = func(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if c, ok := r.Context().Value(connKey).(net.Conn); ok {
+			c.SetWriteDeadline(time.Time{})
+			r.Body = &idleBody{ReadCloser: r.Body, conn: c}
+		}
+		next(w, r)
+	}
+}
+
+// idleBody moves the read deadline of the connection on each read of the body
+type idleBody struct {
+	io.ReadCloser
+	conn net.Conn
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	b.conn.SetReadDeadline(time.Now().Add(uploadIdleTimeout))
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		// the handler may still be storing the upload, which the server would cancel on a read timeout
+		b.conn.SetReadDeadline(time.Time{})
+	}
+	return n, err
+}
+
+// requestUID is the uid of the verified token of the request, empty outside isAuth and isAdmin
+func requestUID(r *http.Request) string {
+	if token, ok := r.Context().Value(tokenKey).(*auth.Token); ok {
+		return token.UID
+	}
+	return ""
+}
+
 var isAdmin = func(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -29,7 +98,7 @@ var isAdmin = func(next http.HandlerFunc) http.HandlerFunc {
 		}
 
 		if ok, err := fb.IsAdminUID(r.Context(), token.UID); ok && err == nil {
-			next(w, r)
+			next(w, r.WithContext(context.WithValue(r.Context(), tokenKey, token)))
 			return
 		}
 		err = errors.New("No admin rights found:")
@@ -67,6 +136,6 @@ var isAuth = func(next http.HandlerFunc) http.HandlerFunc {
 			http.RedirectHandler("/login", http.StatusFound)
 			return
 		}
-		next(w, r)
+		next(w, r.WithContext(context.WithValue(r.Context(), tokenKey, token)))
 	}
 }
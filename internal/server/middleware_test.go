@@ -0,0 +1,23 @@
+package server
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestIdleBody(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	// the deadline of withDeadline has passed, but the upload is still sending
+	server.SetReadDeadline(time.Now().Add(-time.Second))
+	go client.Write([]byte("chunk"))
+
+	b := &idleBody{ReadCloser: ioutil.NopCloser(server), conn: server}
+	buf := make([]byte, 16)
+	if n, err := b.Read(buf); err != nil || string(buf[:n]) != "chunk" {
+		t.Errorf("got %q, %v", buf[:n], err)
+	}
+}
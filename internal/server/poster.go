@@ -0,0 +1,125 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/pkg/errors"
+
+	exif "github.com/blixenkrone/gopro/pkg/exif"
+	exifvideo "github.com/blixenkrone/gopro/pkg/exif/video"
+	"github.com/blixenkrone/gopro/pkg/image/watermark"
+)
+
+const (
+	// posterSize is the longest side of a poster frame
+	posterSize = 1280
+	// stripFrameWidth is the width of each frame of a strip
+	stripFrameWidth                    = 160
+	defaultStripFrames, maxStripFrames = 10, 50
+)
+
+type exifVideoResponse struct {
+	*exif.Output
+	Preview *videoPreview `json:"preview,omitempty"`
+}
+
+// videoPreview is the poster frame and the strip of a video as JPEGs
+type videoPreview struct {
+	Poster []byte `json:"poster,omitempty"`
+	// PosterTime is the time of the poster frame in seconds
+	PosterTime float64 `json:"posterTime"`
+	// Strip has StripFrames frames of FrameWidth x FrameHeight side by side, spread evenly over the video
+	Strip       []byte `json:"strip,omitempty"`
+	StripFrames int    `json:"stripFrames,omitempty"`
+	FrameWidth  int    `json:"frameWidth,omitempty"`
+	FrameHeight int    `json:"frameHeight,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// videoFrames extracts the frames of a video read with exifvideo.SpoolVideo
+type videoFrames interface {
+	Poster(ctx context.Context, at time.Duration) (image.Image, time.Duration, error)
+	Frames(ctx context.Context, n int) ([]image.Image, error)
+}
+
+// videoPreviewOptions are the time of the poster frame in ?at= seconds, -1 for a tenth into the video,
+// and the number of strip frames in ?frames=
+type videoPreviewOptions struct {
+	at     time.Duration
+	frames int
+}
+
+func parseVideoPreviewOptions(q url.Values) (videoPreviewOptions, error) {
+	opts := videoPreviewOptions{at: -1, frames: defaultStripFrames}
+	if v := q.Get("at"); v != "" {
+		seconds, err := strconv.ParseFloat(v, 64)
+		if err != nil || seconds < 0 {
+			return opts, errors.Errorf("at must be the seconds into the video, not %q", v)
+		}
+		opts.at = time.Duration(seconds * float64(time.Second))
+	}
+	if v := q.Get("frames"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxStripFrames {
+			return opts, errors.Errorf("frames must be from 1 to %d, not %q", maxStripFrames, v)
+		}
+		opts.frames = n
+	}
+	return opts, nil
+}
+
+// makeVideoPreview extracts the poster frame and the strip of a video, with the watermark on the poster
+// and every frame of the strip. Failures are reported in the preview like those of image previews.
+func makeVideoPreview(ctx context.Context, video videoFrames, duration time.Duration, opts videoPreviewOptions, wm *watermark.Watermark) *videoPreview {
+	var preview videoPreview
+	at := opts.at
+	if at < 0 {
+		// past the fade in most videos start with
+		at = duration / 10
+	}
+	poster, at, err := video.Poster(ctx, at)
+	if err == nil {
+		// the time of the frame found, a time past the end is moved before it
+		preview.PosterTime = at.Seconds()
+		poster, err = wm.Apply(imaging.Fit(poster, posterSize, posterSize, imaging.Lanczos))
+	}
+	if err == nil {
+		preview.Poster, err = encodeJPEG(poster)
+	}
+	if err != nil {
+		log.Errorf("error making poster frame: %s", err)
+		preview.Error = err.Error()
+		return &preview
+	}
+
+	frames, err := video.Frames(ctx, opts.frames)
+	for i := 0; err == nil && i < len(frames); i++ {
+		// marked one by one, a watermark scaled to the whole strip is taller than it
+		frames[i], err = wm.Apply(imaging.Resize(frames[i], stripFrameWidth, 0, imaging.Lanczos))
+	}
+	if err == nil {
+		strip := exifvideo.ContactSheet(frames, len(frames), stripFrameWidth)
+		preview.StripFrames, preview.FrameWidth, preview.FrameHeight = len(frames), stripFrameWidth, strip.Bounds().Dy()
+		preview.Strip, err = encodeJPEG(strip)
+	}
+	if err != nil {
+		log.Errorf("error making strip: %s", err)
+		preview.Error = err.Error()
+	}
+	return &preview
+}
+
+func encodeJPEG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		return nil, errors.Wrap(err, "encoding jpeg")
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"net/url"
+	"testing"
+	"time"
+
+	exifvideo "github.com/blixenkrone/gopro/pkg/exif/video"
+	"github.com/blixenkrone/gopro/pkg/image/watermark"
+)
+
+// fakeVideo takes its frames from a FakeFrameExtractor the way a video of duration would
+type fakeVideo struct {
+	frames   *exifvideo.FakeFrameExtractor
+	duration time.Duration
+}
+
+func (v fakeVideo) Poster(ctx context.Context, at time.Duration) (image.Image, time.Duration, error) {
+	if at >= v.duration {
+		at = v.duration - v.duration/100
+	}
+	frame, err := v.frames.Frame(ctx, "in.mp4", at)
+	return frame, at, err
+}
+
+func (v fakeVideo) Frames(ctx context.Context, n int) ([]image.Image, error) {
+	frames := make([]image.Image, n)
+	for i := range frames {
+		frames[i], _ = v.frames.Frame(ctx, "in.mp4", v.duration*time.Duration(i)/time.Duration(n))
+	}
+	return frames, nil
+}
+
+func TestVideoPreview(t *testing.T) {
+	fake := &exifvideo.FakeFrameExtractor{Width: 1920, Height: 1080}
+	opts, err := parseVideoPreviewOptions(url.Values{"frames": {"4"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	preview := makeVideoPreview(context.Background(), fakeVideo{fake, 20 * time.Second}, 20*time.Second, opts, &watermark.Default)
+	if preview.Error != "" || preview.PosterTime != 2 || fake.Times[0] != 2*time.Second {
+		t.Fatalf("got %+v", preview)
+	}
+	poster, err := jpeg.DecodeConfig(bytes.NewReader(preview.Poster))
+	if err != nil || poster.Width != posterSize || poster.Height != 720 {
+		t.Errorf("poster %+v, %v", poster, err)
+	}
+	strip, err := jpeg.DecodeConfig(bytes.NewReader(preview.Strip))
+	if err != nil || strip.Width != 4*stripFrameWidth || strip.Height != 90 || preview.StripFrames != 4 || preview.FrameHeight != 90 {
+		t.Errorf("strip %+v of %+v, %v", strip, preview, err)
+	}
+
+	// past the end the poster is the frame before it
+	preview = makeVideoPreview(context.Background(), fakeVideo{fake, 20 * time.Second}, 20*time.Second, videoPreviewOptions{at: time.Minute, frames: 1}, &watermark.Default)
+	if preview.Error != "" || preview.PosterTime != 19.8 {
+		t.Errorf("got poster time %v, %s", preview.PosterTime, preview.Error)
+	}
+
+	for _, q := range []url.Values{{"at": {"-1"}}, {"at": {"soon"}}, {"frames": {"0"}}, {"frames": {"51"}}} {
+		if _, err := parseVideoPreviewOptions(q); err == nil {
+			t.Errorf("%v accepted", q)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -16,15 +17,20 @@ import (
 	"golang.org/x/net/http2"
 
 	storage "github.com/blixenkrone/gopro/internal/storage"
+	"github.com/blixenkrone/gopro/internal/storage/aws"
 	firebase "github.com/blixenkrone/gopro/internal/storage/firebase"
+	"github.com/blixenkrone/gopro/internal/storage/local"
 	"github.com/blixenkrone/gopro/internal/storage/postgres"
+	exifvideo "github.com/blixenkrone/gopro/pkg/exif/video"
+	"github.com/blixenkrone/gopro/pkg/image/heif"
 	"github.com/blixenkrone/gopro/pkg/logger"
 )
 
 var (
-	log = logger.NewLogger()
-	pq  storage.PQService
-	fb  storage.FBService
+	log   = logger.NewLogger()
+	pq    storage.PQService
+	fb    storage.FBService
+	blobs storage.BlobStore
 )
 
 // Server is used in main.go
@@ -68,6 +74,15 @@ func NewServer() *Server {
 	mux.HandleFunc("/mail/send", isAuth(sendMail)).Methods("POST")
 	mux.HandleFunc("/exif/image", isAuth(exifImages)).Methods("POST")
 	mux.HandleFunc("/exif/video", isAuth(exifVideo)).Methods("POST")
+	mux.HandleFunc("/exif/video/details", isAuth(exifVideoDetails)).Methods("POST")
+	mux.HandleFunc("/exif/image/forensics", isAuth(imageForensics)).Methods("POST")
+	mux.HandleFunc("/exif/validate", isAuth(validateMedia)).Methods("POST").Queries("media", "{media}", "spec", "{spec}")
+	mux.HandleFunc("/media/{mediaUID}/specs", isAuth(getMediaSpecs)).Methods("GET")
+	mux.HandleFunc("/media/{mediaUID}/specs/{name}", isAdmin(putMediaSpec)).Methods("PUT")
+	mux.HandleFunc("/media/{mediaUID}/specs/{name}", isAdmin(deleteMediaSpec)).Methods("DELETE")
+	mux.HandleFunc("/media/{mediaUID}/watermark", isAuth(getMediaWatermark)).Methods("GET")
+	mux.HandleFunc("/media/{mediaUID}/watermark", isAdmin(putMediaWatermark)).Methods("PUT")
+	mux.HandleFunc("/media/{mediaUID}/watermark", isAdmin(deleteMediaWatermark)).Methods("DELETE")
 
 	mux.HandleFunc("/profiles", isAuth(getProfiles)).Methods("GET")
 	mux.HandleFunc("/profile/{id}", isAuth(getProfileByID)).Methods("GET")
@@ -75,17 +90,42 @@ func NewServer() *Server {
 	mux.HandleFunc("/auth/profile/token", isAuth(decodeTokenGetProfile)).Methods("GET")
 	mux.HandleFunc("/profile/{id}", isAuth(getProProfile)).Methods("GET")
 
-	mux.HandleFunc("/booking/upload", isAuth(bookingUploadToStorage)).Methods("POST")
+	mux.HandleFunc("/booking/upload", withUploadDeadline(isAuth(bookingUploadToStorage))).Methods("POST")
+	mux.PathPrefix(tusBasePath).HandlerFunc(tusUpload).Methods("OPTIONS")
+	mux.PathPrefix(tusBasePath).HandlerFunc(withUploadDeadline(isAuth(tusUpload))).Methods("POST", "HEAD", "PATCH", "DELETE")
+	mux.HandleFunc("/booking/{bookingID}/deliverables", isAuth(getDeliverables)).Methods("GET")
+	mux.HandleFunc("/booking/{bookingID}/deliverables/upload-url", isAuth(createDeliverableUploadURL)).Methods("POST")
+	mux.HandleFunc("/booking/{bookingID}/deliverables/download-url", isAuth(createDeliverableDownloadURL)).Methods("GET")
+	mux.HandleFunc("/booking/{bookingID}/consent", isAuth(setBookingConsent)).Methods("PUT")
+	mux.HandleFunc("/booking/{bookingID}/deliverables/complete", isAuth(completeDeliverableUpload)).Methods("POST")
+	mux.HandleFunc("/booking/{bookingID}/deliverables/derivatives", isAuth(getDeliverableDerivatives)).Methods("GET", "POST").Queries("key", "{key}")
+	mux.HandleFunc("/admin/deliverables/duplicates", isAdmin(getDuplicateDeliverables)).Methods("GET")
 	mux.HandleFunc("/booking/task/{uid}", isAuth(getBookingsByUID)).Methods("GET")
 	mux.HandleFunc("/booking/task/{proUID}", isAuth(createBooking)).Methods("POST")
 	mux.HandleFunc("/booking/task/{bookingID}", isAuth(updateBooking)).Methods("PUT")
 	mux.HandleFunc("/booking/task/{bookingID}", isAuth(deleteBooking)).Methods("DELETE")
+	mux.HandleFunc("/booking/task/{bookingID}/transition", isAuth(transitionBooking)).Methods("POST")
+	mux.HandleFunc("/booking/task/{bookingID}/transitions", isAuth(getBookingTransitions)).Methods("GET")
+	mux.HandleFunc("/professional/{uid}/availability", isAuth(getAvailability)).Methods("GET")
+	mux.HandleFunc("/professional/{uid}/hours", isAuth(setWorkingHours)).Methods("PUT")
+	mux.HandleFunc("/professional/{uid}/blocked", isAuth(createBlockedPeriod)).Methods("POST")
+	mux.HandleFunc("/professional/{uid}/calendar", isAuth(getCalendarToken)).Methods("GET", "POST")
+	mux.HandleFunc("/calendar/{token}.ics", getBookingCalendar).Methods("GET")
+	mux.HandleFunc("/professional/{uid}/location", isAuth(updateProfessionalLocation)).Methods("PUT")
+	mux.HandleFunc("/professionals/nearby", isAuth(getProfessionalsNear)).Methods("GET")
+	mux.HandleFunc("/booking/task", isAuth(getBookingsNear)).Methods("GET").Queries("near", "{near}")
 	mux.HandleFunc("/booking/task" /** isAdmin() middleware? */, isAuth(getProfileWithBookings)).Methods("GET")
 
+	// Signed urls of the local blob store point here
+	mux.PathPrefix("/blobs/").Handler(http.StripPrefix("/blobs/", http.HandlerFunc(serveBlobs)))
+
 	c := cors.New(cors.Options{
 		AllowedOrigins: []string{"http://localhost:4200", "http://localhost:4201", "http://localhost", "https://pro.development.byrd.news", "https://pro.dev.byrd.news", "https://pro.byrd.news"},
-		AllowedMethods: []string{"GET", "PUT", "POST", "DELETE", "OPTIONS"},
-		AllowedHeaders: []string{"Content-Type", "Accept", "Content-Length", "X-Requested-By", "user_token", "preview"},
+		AllowedMethods: []string{"GET", "PUT", "POST", "DELETE", "OPTIONS", "HEAD", "PATCH"},
+		AllowedHeaders: []string{"Content-Type", "Accept", "Content-Length", "X-Requested-By", "user_token", "preview",
+			"Tus-Resumable", "Upload-Length", "Upload-Offset", "Upload-Metadata", "Upload-Defer-Length"},
+		ExposedHeaders: []string{"Location", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size",
+			"Upload-Offset", "Upload-Length", "Upload-Metadata", "Upload-Expires"},
 		// AllowCredentials: true,
 	})
 
@@ -96,9 +136,8 @@ func NewServer() *Server {
 	// 	Cache:      autocert.DirCache("certs"),
 	// }
 
+	// Read and write deadlines are set per request by withDeadline, so uploads can take longer
 	httpsSrv := &http.Server{
-		ReadTimeout:       5 * time.Second,
-		WriteTimeout:      10 * time.Second,
 		ReadHeaderTimeout: 5 * time.Second,
 		IdleTimeout:       120 * time.Second,
 		MaxHeaderBytes:    1 << 20,
@@ -110,7 +149,10 @@ func NewServer() *Server {
 				tls.X25519,
 			},
 		},
-		Handler: c.Handler(mux),
+		Handler: c.Handler(withDeadline(mux)),
+		ConnContext: func(ctx context.Context, conn net.Conn) context.Context {
+			return context.WithValue(ctx, connKey, conn)
+		},
 	}
 
 	// Create server for redirecting HTTP to HTTPS
@@ -124,6 +166,10 @@ func NewServer() *Server {
 	// 	// Handler:        m.HTTPHandler(nil),
 	// }
 
+	initImageDecoders()
+	initDerivatives()
+	initForensics()
+
 	return &Server{
 		HttpListenServer: httpsSrv,
 		// HttpRedirectServer: httpSrv,
@@ -148,6 +194,45 @@ func (s *Server) InitDB() error {
 	return nil
 }
 
+// InitBlobStore selects the file storage from BLOB_STORE: "s3" (default) or "local"
+func (s *Server) InitBlobStore() error {
+	var err error
+	switch store := os.Getenv("BLOB_STORE"); store {
+	case "", "s3":
+		blobs, err = aws.NewS3Store()
+	case "local":
+		blobs, err = local.NewFromEnv()
+	default:
+		err = fmt.Errorf("unknown BLOB_STORE %q", store)
+	}
+	if err != nil {
+		return err
+	}
+	initUploads()
+	return nil
+}
+
+// initImageDecoders registers the HEIF decoder command in HEIF_DECODER, e.g. heif-convert of libheif.
+// Without it HEIC previews fail, their EXIF is still read.
+func initImageDecoders() {
+	if path := os.Getenv("HEIF_DECODER"); path != "" {
+		heif.RegisterDecoder(heif.CommandDecoder{Path: path})
+	}
+	if path := os.Getenv("FFMPEG"); path != "" {
+		exifvideo.RegisterFrameExtractor(exifvideo.FFmpeg{Path: path})
+	}
+}
+
+// serveBlobs serves signed urls for blob stores that handle them themselves
+func serveBlobs(w http.ResponseWriter, r *http.Request) {
+	h, ok := blobs.(http.Handler)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	h.ServeHTTP(w, r)
+}
+
 func (s *Server) UseHTTP2() error {
 	http2Srv := http2.Server{}
 	err := http2.ConfigureServer(s.HttpListenServer, &http2Srv)
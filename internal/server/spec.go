@@ -0,0 +1,150 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+
+	exif "github.com/blixenkrone/gopro/pkg/exif"
+	exifimage "github.com/blixenkrone/gopro/pkg/exif/image"
+	"github.com/blixenkrone/gopro/pkg/exif/spec"
+	exifvideo "github.com/blixenkrone/gopro/pkg/exif/video"
+)
+
+type validateResponse struct {
+	*spec.Report
+	Exif *exif.Output `json:"exif"`
+}
+
+var getMediaSpecs = func(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		specs, err := pq.GetMediaSpecs(r.Context(), mux.Vars(r)["mediaUID"])
+		if err != nil {
+			NewResErr(err, "Error getting specs", http.StatusInternalServerError, w)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(specs); err != nil {
+			NewResErr(err, "Error encoding specs", http.StatusInternalServerError, w)
+			return
+		}
+	}
+}
+
+// putMediaSpec creates or replaces a named spec of a media customer from the rules in the body
+var putMediaSpec = func(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		w.Header().Set("Content-Type", "application/json")
+		vars := mux.Vars(r)
+		s := &spec.Spec{Name: vars["name"], MediaUID: vars["mediaUID"]}
+		if err := json.NewDecoder(r.Body).Decode(&s.Rules); err != nil {
+			NewResErr(err, "Error decoding rules", http.StatusBadRequest, w)
+			return
+		}
+		if err := s.Validate(); err != nil {
+			NewResErr(err, err.Error(), http.StatusBadRequest, w)
+			return
+		}
+		if err := pq.UpsertMediaSpec(r.Context(), s); err != nil {
+			NewResErr(err, "Error saving spec", http.StatusInternalServerError, w)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(s); err != nil {
+			NewResErr(err, "Error encoding spec", http.StatusInternalServerError, w)
+			return
+		}
+	}
+}
+
+var deleteMediaSpec = func(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodDelete {
+		w.Header().Set("Content-Type", "application/json")
+		vars := mux.Vars(r)
+		if err := pq.DeleteMediaSpec(r.Context(), vars["mediaUID"], vars["name"]); err != nil {
+			if errors.Cause(err) == sql.ErrNoRows {
+				NewResErr(err, "Spec not found", http.StatusNotFound, w)
+				return
+			}
+			NewResErr(err, "Error deleting spec", http.StatusInternalServerError, w)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// validateMedia checks the media in the body against the spec ?spec= of the media customer ?media=.
+// The body is an image or video, or an exif output as JSON when it was extracted before.
+var validateMedia = func(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		w.Header().Set("Content-Type", "application/json")
+		defer r.Body.Close()
+
+		q := r.URL.Query()
+		s, err := pq.GetMediaSpec(r.Context(), q.Get("media"), q.Get("spec"))
+		if err != nil {
+			if errors.Cause(err) == sql.ErrNoRows {
+				NewResErr(err, "Spec not found", http.StatusNotFound, w)
+				return
+			}
+			NewResErr(err, "Error getting spec", http.StatusInternalServerError, w)
+			return
+		}
+
+		out, err := readExifOutput(w, r)
+		if err != nil {
+			NewResErr(err, err.Error(), http.StatusBadRequest, w, "trace")
+			return
+		}
+		res := validateResponse{Report: s.Check(out, time.Now()), Exif: out}
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			NewResErr(err, JSONEncodingError.Error(), http.StatusInternalServerError, w)
+			return
+		}
+	}
+}
+
+// readExifOutput reads the exif of the request body by its content type
+func readExifOutput(w http.ResponseWriter, r *http.Request) (*exif.Output, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing content type")
+	}
+	switch {
+	case mediaType == "application/json":
+		var out exif.Output
+		if err := json.NewDecoder(r.Body).Decode(&out); err != nil {
+			return nil, errors.Wrap(err, "decoding exif output")
+		}
+		return &out, nil
+	case strings.HasPrefix(mediaType, "image/"):
+		b, err := ioutil.ReadAll(io.LimitReader(r.Body, maxImageProcessingSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(b) > maxImageProcessingSize {
+			return nil, errors.Errorf("image is larger than %d bytes", maxImageProcessingSize)
+		}
+		out, err := exifimage.DecodeImageMetadata(b)
+		if out != nil {
+			// missing exif is reported in the output and fails the rules needing it
+			return out, nil
+		}
+		return nil, err
+	case strings.HasPrefix(mediaType, "video/"):
+		video, err := exifvideo.ReadVideo(http.MaxBytesReader(w, r.Body, maxVideoProcessingSize))
+		if err != nil {
+			return nil, err
+		}
+		return video.CreateVideoExifOutput(), nil
+	default:
+		return nil, errors.Errorf("cannot validate %s", mediaType)
+	}
+}
@@ -0,0 +1,342 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/blixenkrone/gopro/internal/storage"
+	"github.com/blixenkrone/gopro/internal/tus"
+	"github.com/blixenkrone/gopro/pkg/image/palette"
+	"github.com/blixenkrone/gopro/pkg/image/phash"
+	"github.com/blixenkrone/gopro/pkg/image/thumbnail"
+)
+
+const (
+	// maxConcurrentUploads bounds the files of one request being written to storage at the same time
+	maxConcurrentUploads = 4
+
+	tusBasePath = "/booking/upload/tus/"
+	// maxResumableUploadSize is the largest video accepted through tus
+	maxResumableUploadSize = 20 << 30
+)
+
+// uploads serves resumable uploads, it is set up with the blob store
+var uploads *tus.Handler
+
+// uploadResult is the outcome of storing a single file of a multipart upload
+type uploadResult struct {
+	FileName    string `json:"fileName"`
+	Key         string `json:"key,omitempty"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+	// PHash, BlurHash, Palette and Duplicates are set for images, Duplicates are earlier deliverables of
+	// the same picture
+	PHash      *phash.Hash                   `json:"phash,omitempty"`
+	BlurHash   string                        `json:"blurHash,omitempty"`
+	Palette    []palette.Color               `json:"palette,omitempty"`
+	Duplicates []*storage.SimilarDeliverable `json:"duplicates,omitempty"`
+	Error      string                        `json:"error,omitempty"`
+}
+
+// bookingUploadToStorage stores every file part of a multipart body as a deliverable of the booking in ?bookingID=,
+// for the professional of the booking or an admin.
+// Parts are read in order, but the storage writes of up to maxConcurrentUploads files overlap.
+// It responds with one result per file once all writes have finished.
+var bookingUploadToStorage = func(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		w.Header().Set("Content-Type", "application/json")
+		ctx := r.Context()
+
+		bookingID := r.URL.Query().Get("bookingID")
+		if bookingID == "" {
+			NewResErr(errors.New("missing bookingID"), "bookingID query parameter is required", http.StatusBadRequest, w)
+			return
+		}
+		b, err := pq.GetBooking(ctx, bookingID)
+		if err != nil {
+			if errors.Cause(err) == sql.ErrNoRows {
+				NewResErr(err, "Booking not found", http.StatusNotFound, w)
+				return
+			}
+			NewResErr(err, "Error getting booking", http.StatusInternalServerError, w)
+			return
+		}
+		if _, ok := authorizeBooking(w, r, b, roleProfessional, roleAdmin); !ok {
+			return
+		}
+
+		mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+			NewResErr(errors.Errorf("unexpected content type %q", mediaType), "Body must be multipart", http.StatusBadRequest, w)
+			return
+		}
+		defer r.Body.Close()
+
+		results, err := uploadParts(ctx, multipart.NewReader(r.Body, params["boundary"]), bookingID)
+		if err != nil {
+			NewResErr(err, "error reading multipart body", http.StatusBadRequest, w, "trace")
+			return
+		}
+		if err := json.NewEncoder(w).Encode(results); err != nil {
+			NewResErr(err, "Error encoding upload results", http.StatusInternalServerError, w)
+			return
+		}
+	}
+}
+
+// uploadParts streams the file parts of mr to storage. The returned error is only set when the body itself
+// can't be read, failed files are reported in their result.
+func uploadParts(ctx context.Context, mr *multipart.Reader, bookingID string) ([]*uploadResult, error) {
+	var (
+		results []*uploadResult
+		wg      sync.WaitGroup
+		sem     = make(chan struct{}, maxConcurrentUploads)
+		readErr error
+	)
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			readErr = err
+			break
+		}
+		if part.FileName() == "" {
+			continue
+		}
+
+		res := &uploadResult{FileName: part.FileName()}
+		results = append(results, res)
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			res.Error = ctx.Err().Error()
+			continue
+		}
+
+		key, err := deliverableKey(bookingID, part.FileName())
+		if err != nil {
+			res.Error = err.Error()
+			<-sem
+			continue
+		}
+		res.Key = key
+
+		// The part is only valid until the next call to NextPart, so it is piped to the storage write
+		// and fully consumed here. Only the tail of the write, e.g. finishing an S3 multipart upload,
+		// overlaps with reading the next part.
+		pr, pw := io.Pipe()
+		wg.Add(1)
+		go func(contentType string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			err := storeDeliverable(ctx, pr, bookingID, contentType, res)
+			if err != nil {
+				res.Error = err.Error()
+				log.Errorf("error storing file %s with err: %s", res.FileName, err)
+			}
+			// Unblocks the copy below if the write gave up early
+			pr.CloseWithError(errors.New("upload aborted"))
+		}(part.Header.Get("Content-Type"))
+
+		_, err = io.Copy(pw, part)
+		pw.CloseWithError(err)
+	}
+
+	wg.Wait()
+	// A body cut off by a cancelled request is reported through the results of the affected files
+	if readErr != nil && ctx.Err() == nil {
+		return nil, readErr
+	}
+	return results, nil
+}
+
+// storeDeliverable writes r to storage under res.Key and records it on the booking, filling in res.
+// Images are hashed on the way to flag duplicates of earlier deliverables.
+func storeDeliverable(ctx context.Context, r io.Reader, bookingID, contentType string, res *uploadResult) error {
+	br := bufio.NewReaderSize(r, 512)
+	if contentType == "" || contentType == "application/octet-stream" {
+		head, _ := br.Peek(512)
+		contentType = http.DetectContentType(head)
+	}
+
+	h := sha256.New()
+	var w io.Writer = h
+	img := &limitedBuffer{max: maxImageProcessingSize}
+	if strings.HasPrefix(contentType, "image/") {
+		w = io.MultiWriter(h, img)
+	}
+	info, err := blobs.Put(ctx, res.Key, io.TeeReader(br, w), storage.PutOptions{ContentType: contentType})
+	if err != nil {
+		return err
+	}
+	res.Size = info.Size
+	res.SHA256 = hex.EncodeToString(h.Sum(nil))
+	res.ContentType = contentType
+
+	d := &storage.Deliverable{
+		BookingID:   bookingID,
+		Key:         res.Key,
+		FileName:    res.FileName,
+		Size:        res.Size,
+		SHA256:      res.SHA256,
+		ContentType: res.ContentType,
+	}
+	if img.Len() > 0 && !img.truncated {
+		if decoded, err := thumbnail.New(img.Bytes()); err != nil {
+			log.Errorf("error hashing image %s: %s", res.FileName, err)
+		} else {
+			d.PHash = &decoded.PHash
+			d.BlurHash, d.Palette = decoded.Placeholder.BlurHash, decoded.Placeholder.Palette
+		}
+	}
+	if err := pq.CreateDeliverable(ctx, d); err != nil {
+		return err
+	}
+	res.PHash, res.BlurHash, res.Palette = d.PHash, d.BlurHash, d.Palette
+	duplicates, err := similarDeliverables(ctx, d)
+	if err != nil {
+		log.Errorf("error finding duplicates of %s: %s", res.Key, err)
+	}
+	res.Duplicates = duplicates
+	return nil
+}
+
+// limitedBuffer buffers up to max bytes, past that it drops them and is truncated
+type limitedBuffer struct {
+	bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.truncated || b.Len()+len(p) > b.max {
+		b.truncated = true
+		b.Reset()
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// deliverableKey returns a unique storage key below booking/{id}/ keeping a sanitised file name
+func deliverableKey(bookingID, fileName string) (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "booking/" + bookingID + "/" + hex.EncodeToString(b) + "-" + sanitiseFileName(fileName), nil
+}
+
+func sanitiseFileName(name string) string {
+	name = path.Base(strings.Replace(name, "\\", "/", -1))
+	clean := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, name)
+	if clean == "" || clean == "." || clean == ".." || clean == "/" {
+		return "file"
+	}
+	return clean
+}
+
+// initUploads sets up resumable uploads on the blob store and removes expired ones every hour
+func initUploads() {
+	uploads = tus.New(blobs, tus.Config{
+		BasePath:   tusBasePath,
+		MaxSize:    maxResumableUploadSize,
+		Expiration: 24 * time.Hour,
+		Create:     createResumableUpload,
+		Complete:   completeResumableUpload,
+	})
+	go func() {
+		for range time.Tick(time.Hour) {
+			n, err := uploads.Cleanup(context.Background())
+			if err != nil {
+				log.Errorf("Error removing expired uploads: %s", err)
+			}
+			if n > 0 {
+				log.Infof("Removed %d expired uploads", n)
+			}
+		}
+	}()
+}
+
+// tusUpload serves the tus protocol below /booking/upload/tus/.
+// Uploads are created with the metadata bookingID, filename and optionally filetype.
+var tusUpload = func(w http.ResponseWriter, r *http.Request) {
+	if uploads == nil {
+		http.Error(w, "uploads not available", http.StatusServiceUnavailable)
+		return
+	}
+	uploads.ServeHTTP(w, r)
+}
+
+// createResumableUpload checks the caller is the professional of the booking of a new upload or an admin,
+// and chooses its deliverable key
+func createResumableUpload(r *http.Request, u *tus.Upload) error {
+	bookingID := u.Metadata["bookingID"]
+	if bookingID == "" {
+		return errors.New("Upload-Metadata must contain bookingID")
+	}
+	b, err := pq.GetBooking(r.Context(), bookingID)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return errors.Errorf("booking %s not found", bookingID)
+		}
+		return err
+	}
+	role, err := bookingRole(r.Context(), requestUID(r), b)
+	if err != nil {
+		return err
+	}
+	if role != roleProfessional && role != roleAdmin {
+		return errors.Wrapf(tus.ErrForbidden, "%s can't upload to booking %s", requestUID(r), bookingID)
+	}
+	key, err := deliverableKey(bookingID, u.Metadata["filename"])
+	if err != nil {
+		return err
+	}
+	u.Key = key
+	return nil
+}
+
+// completeResumableUpload records a finished upload as a deliverable of its booking. Like uploads through
+// a pre-signed url, its exif and thumbnails are extracted in the background.
+func completeResumableUpload(ctx context.Context, u *tus.Upload, info *storage.BlobInfo) error {
+	d := &storage.Deliverable{
+		BookingID:   u.Metadata["bookingID"],
+		Key:         u.Key,
+		FileName:    u.Metadata["filename"],
+		Size:        info.Size,
+		SHA256:      u.SHA256,
+		ContentType: info.ContentType,
+	}
+	if err := pq.CreateDeliverable(ctx, d); err != nil {
+		return err
+	}
+	go processDeliverable(*d)
+	return nil
+}
@@ -0,0 +1,305 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"firebase.google.com/go/auth"
+	"github.com/pkg/errors"
+
+	"github.com/blixenkrone/gopro/internal/mediatest"
+	"github.com/blixenkrone/gopro/internal/storage"
+	"github.com/blixenkrone/gopro/internal/storage/local"
+	"github.com/blixenkrone/gopro/internal/tus"
+	"github.com/blixenkrone/gopro/pkg/image/phash"
+	"github.com/blixenkrone/gopro/pkg/image/watermark"
+)
+
+type fakeDeliverables struct {
+	storage.PQService
+	mu      sync.Mutex
+	created []*storage.Deliverable
+}
+
+func (f *fakeDeliverables) CreateDeliverable(ctx context.Context, d *storage.Deliverable) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.created = append(f.created, d)
+	return nil
+}
+
+func (f *fakeDeliverables) GetBooking(ctx context.Context, bookingID string) (*storage.Booking, error) {
+	if bookingID != "42" {
+		return nil, sql.ErrNoRows
+	}
+	return &storage.Booking{ID: bookingID, UserUID: "pro", MediaUID: "media"}, nil
+}
+
+func TestUploadAccess(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := local.New(dir, "http://localhost/blobs", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeDeliverables{}
+	blobs, pq, fb = store, fake, fakeAdmins{admins: map[string]bool{"admin": true}}
+	defer func() { blobs, pq, fb = nil, nil, nil }()
+
+	for _, caller := range []string{"media", "other", "pro", "admin"} {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		fw, _ := mw.CreateFormFile("files", caller+".txt")
+		fw.Write([]byte("deliverable of " + caller))
+		mw.Close()
+		r := httptest.NewRequest(http.MethodPost, "/booking/upload?bookingID=42", &body)
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+		r = r.WithContext(context.WithValue(r.Context(), tokenKey, &auth.Token{UID: caller}))
+		w := httptest.NewRecorder()
+		bookingUploadToStorage(w, r)
+		want := http.StatusOK
+		if caller == "media" || caller == "other" {
+			want = http.StatusForbidden
+		}
+		if w.Code != want {
+			t.Errorf("%s: got %d, want %d", caller, w.Code, want)
+		}
+	}
+	if len(fake.created) != 2 {
+		t.Errorf("created %d deliverables, want those of pro and admin", len(fake.created))
+	}
+}
+
+func TestCreateResumableUpload(t *testing.T) {
+	pq, fb = &fakeDeliverables{}, fakeAdmins{admins: map[string]bool{"admin": true}}
+	defer func() { pq, fb = nil, nil }()
+
+	for caller, forbidden := range map[string]bool{"pro": false, "admin": false, "media": true, "other": true} {
+		r := httptest.NewRequest(http.MethodPost, tusBasePath, nil)
+		r = r.WithContext(context.WithValue(r.Context(), tokenKey, &auth.Token{UID: caller}))
+		u := &tus.Upload{Metadata: map[string]string{"bookingID": "42", "filename": "clip.mp4"}}
+		err := createResumableUpload(r, u)
+		if got := errors.Cause(err) == tus.ErrForbidden; got != forbidden || (!forbidden && err != nil) {
+			t.Errorf("%s: %v", caller, err)
+		}
+		if !forbidden && !bookingDeliverableKey("42", u.Key) {
+			t.Errorf("%s: key %s", caller, u.Key)
+		}
+	}
+}
+
+type fakeProcessed struct {
+	*fakeDeliverables
+	processed chan *storage.Deliverable
+}
+
+func (f fakeProcessed) SetDeliverableProcessed(ctx context.Context, d *storage.Deliverable) error {
+	f.processed <- d
+	return nil
+}
+
+func (f fakeProcessed) GetMediaWatermark(ctx context.Context, mediaUID string) (*watermark.Watermark, error) {
+	return nil, sql.ErrNoRows
+}
+
+func TestCompleteResumableUpload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := local.New(dir, "http://localhost/blobs", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := fakeProcessed{&fakeDeliverables{}, make(chan *storage.Deliverable, 1)}
+	blobs, pq = store, fake
+	defer func() { blobs, pq = nil, nil }()
+
+	key := "booking/42/0001-photo.jpg"
+	jpg := mediatest.JPEG(t, mediatest.Scene(960, 800), 0)
+	info, err := store.Put(context.Background(), key, bytes.NewReader(jpg), storage.PutOptions{ContentType: "image/jpeg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &tus.Upload{Key: key, Metadata: map[string]string{"bookingID": "42", "filename": "photo.jpg"}}
+	if err := completeResumableUpload(context.Background(), u, info); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.created) != 1 || fake.created[0].Key != key {
+		t.Fatalf("created %+v", fake.created)
+	}
+	select {
+	case d := <-fake.processed:
+		if d.Key != key || d.PHash == nil || d.ThumbnailKey == "" {
+			t.Errorf("processed %+v", d)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("upload was not processed")
+	}
+	// duplicates are looked up after saving, wait for that before the fakes are reset
+	for i := 0; i < cap(processing); i++ {
+		processing <- struct{}{}
+	}
+	for i := 0; i < cap(processing); i++ {
+		<-processing
+	}
+}
+
+func TestUploadParts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := local.New(dir, "http://localhost/blobs", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeDeliverables{}
+	blobs, pq = store, fake
+	defer func() { blobs, pq = nil, nil }()
+
+	files := map[string]string{}
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("note", "not a file")
+	for i := 0; i < maxConcurrentUploads*2; i++ {
+		name := "photo " + string(rune('a'+i)) + ".txt"
+		content := strings.Repeat(string(rune('a'+i)), 100000)
+		files[name] = content
+		fw, _ := mw.CreateFormFile("files", name)
+		fw.Write([]byte(content))
+	}
+	mw.Close()
+
+	results, err := uploadParts(context.Background(), multipart.NewReader(&body, mw.Boundary()), "42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(files) || len(fake.created) != len(files) {
+		t.Fatalf("Expected %d results and deliverables got %d and %d", len(files), len(results), len(fake.created))
+	}
+	for _, res := range results {
+		if res.Error != "" {
+			t.Fatalf("%s: %s", res.FileName, res.Error)
+		}
+		content := files[res.FileName]
+		sum := sha256.Sum256([]byte(content))
+		if res.SHA256 != hex.EncodeToString(sum[:]) || res.Size != int64(len(content)) {
+			t.Errorf("%s: wrong size or hash %+v", res.FileName, res)
+		}
+		if !strings.HasPrefix(res.Key, "booking/42/") || strings.Contains(res.Key, "..") || strings.Contains(res.Key, " ") {
+			t.Errorf("Unexpected key %s", res.Key)
+		}
+		if !strings.HasPrefix(res.ContentType, "text/plain") {
+			t.Errorf("Expected sniffed text content type got %s", res.ContentType)
+		}
+		stored, err := ioutil.ReadFile(dir + "/" + res.Key)
+		if err != nil || string(stored) != content {
+			t.Errorf("%s: stored content differs: %v", res.Key, err)
+		}
+	}
+}
+
+// GetSimilarDeliverables finds the created deliverables by their phash
+func (f *fakeDeliverables) GetSimilarDeliverables(ctx context.Context, h phash.Hash, maxDistance int) ([]*storage.SimilarDeliverable, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var similar []*storage.SimilarDeliverable
+	for _, d := range f.created {
+		if d.PHash != nil && d.PHash.Distance(h) <= maxDistance {
+			similar = append(similar, &storage.SimilarDeliverable{Deliverable: d, Distance: d.PHash.Distance(h)})
+		}
+	}
+	return similar, nil
+}
+
+func TestUploadDuplicates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := local.New(dir, "http://localhost/blobs", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeDeliverables{}
+	blobs, pq = store, fake
+	defer func() { blobs, pq = nil, nil }()
+
+	img := image.NewNRGBA(image.Rect(0, 0, 320, 240))
+	for y := 0; y < 240; y++ {
+		for x := 0; x < 320; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 255 / 320), uint8(y * 255 / 240), 100, 255})
+		}
+	}
+	upload := func(name string, quality int) *uploadResult {
+		var b, body bytes.Buffer
+		jpeg.Encode(&b, img, &jpeg.Options{Quality: quality})
+		mw := multipart.NewWriter(&body)
+		fw, _ := mw.CreateFormFile("files", name)
+		fw.Write(b.Bytes())
+		mw.Close()
+		results, err := uploadParts(context.Background(), multipart.NewReader(&body, mw.Boundary()), "42")
+		if err != nil || len(results) != 1 || results[0].Error != "" {
+			t.Fatalf("upload of %s: %v %+v", name, err, results)
+		}
+		return results[0]
+	}
+
+	first := upload("first.jpg", 90)
+	if first.PHash == nil || first.BlurHash == "" || len(first.Palette) == 0 || len(first.Duplicates) != 0 {
+		t.Fatalf("first upload %+v", first)
+	}
+	second := upload("second.jpg", 50)
+	if len(second.Duplicates) != 1 || second.Duplicates[0].Key != first.Key {
+		t.Errorf("recompressed upload not flagged as a duplicate: %+v", second)
+	}
+}
+
+func TestLimitedBuffer(t *testing.T) {
+	b := &limitedBuffer{max: 4}
+	b.Write([]byte("abc"))
+	if b.truncated || b.String() != "abc" {
+		t.Errorf("got %q, truncated %v", b.String(), b.truncated)
+	}
+	if n, err := b.Write([]byte("de")); n != 2 || err != nil || !b.truncated || b.Len() != 0 {
+		t.Errorf("wrote %d, %v, truncated %v with %d bytes", n, err, b.truncated, b.Len())
+	}
+}
+
+func TestSanitiseFileName(t *testing.T) {
+	cases := map[string]string{
+		"photo.jpg":           "photo.jpg",
+		"../../etc/passwd":    "passwd",
+		`C:\Users\me\a b.mov`: "a_b.mov",
+		"":                    "file",
+		"..":                  "file",
+		"æble.jpg":            "_ble.jpg",
+	}
+	for in, expected := range cases {
+		if out := sanitiseFileName(in); out != expected {
+			t.Errorf("%q: expected %q got %q", in, expected, out)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+
+	"github.com/blixenkrone/gopro/pkg/image/watermark"
+)
+
+// maxWatermarkSize is the largest watermark profile body, a base64 encoded logo and its settings
+const maxWatermarkSize = 2 << 20
+
+var getMediaWatermark = func(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		wm, err := pq.GetMediaWatermark(r.Context(), mux.Vars(r)["mediaUID"])
+		if err != nil {
+			if errors.Cause(err) == sql.ErrNoRows {
+				NewResErr(err, "Watermark not found", http.StatusNotFound, w)
+				return
+			}
+			NewResErr(err, "Error getting watermark", http.StatusInternalServerError, w)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(wm); err != nil {
+			NewResErr(err, JSONEncodingError.Error(), http.StatusInternalServerError, w)
+			return
+		}
+	}
+}
+
+// putMediaWatermark creates or replaces the watermark put on previews for a media customer
+var putMediaWatermark = func(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		w.Header().Set("Content-Type", "application/json")
+		var wm watermark.Watermark
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxWatermarkSize)).Decode(&wm); err != nil {
+			NewResErr(err, "Error decoding watermark", http.StatusBadRequest, w)
+			return
+		}
+		if err := wm.Validate(); err != nil {
+			NewResErr(err, err.Error(), http.StatusBadRequest, w)
+			return
+		}
+		if err := pq.UpsertMediaWatermark(r.Context(), mux.Vars(r)["mediaUID"], &wm); err != nil {
+			NewResErr(err, "Error saving watermark", http.StatusInternalServerError, w)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(wm); err != nil {
+			NewResErr(err, JSONEncodingError.Error(), http.StatusInternalServerError, w)
+			return
+		}
+	}
+}
+
+var deleteMediaWatermark = func(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodDelete {
+		w.Header().Set("Content-Type", "application/json")
+		if err := pq.DeleteMediaWatermark(r.Context(), mux.Vars(r)["mediaUID"]); err != nil {
+			if errors.Cause(err) == sql.ErrNoRows {
+				NewResErr(err, "Watermark not found", http.StatusNotFound, w)
+				return
+			}
+			NewResErr(err, "Error deleting watermark", http.StatusInternalServerError, w)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// previewWatermark is the watermark profile of the media customer, or the default watermark
+// when there is no customer or it has no profile
+func previewWatermark(ctx context.Context, mediaUID string) (*watermark.Watermark, error) {
+	if mediaUID == "" {
+		return &watermark.Default, nil
+	}
+	wm, err := pq.GetMediaWatermark(ctx, mediaUID)
+	if errors.Cause(err) == sql.ErrNoRows {
+		return &watermark.Default, nil
+	}
+	return wm, err
+}
@@ -2,52 +2,200 @@ package aws
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"net/http"
 	"os"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/pkg/errors"
+
+	"github.com/blixenkrone/gopro/internal/storage"
 	"github.com/blixenkrone/gopro/pkg/logger"
 )
 
 var log = logger.NewLogger()
 
-type s3Storage struct {
-	session     *session.Session
-	ctx         context.Context
-	contentType string
-}
+const defaultBookingBucket = "byrd-bookings"
 
-type AWSStorer interface {
-	StoreFile(io.Reader) error
+// S3Store is a storage.BlobStore backed by an S3 bucket
+type S3Store struct {
+	client   *s3.S3
+	uploader *s3manager.Uploader
+	bucket   string
 }
 
-func NewSession(s AWSStorer, ctx context.Context, contentType string) (*s3Storage, error) {
-	session, err := session.NewSession(&aws.Config{
+// NewS3Store returns a store for the bucket in S3_BUCKET, or the bookings bucket if unset
+func NewS3Store() (storage.BlobStore, error) {
+	sess, err := session.NewSession(&aws.Config{
 		Region:      aws.String(s3NorthRegion),
 		Credentials: credentials.NewStaticCredentials(os.Getenv("AWS_ACCESS"), os.Getenv("AWS_SECRET"), ""),
 	})
 	if err != nil {
 		return nil, err
 	}
-	return &s3Storage{session, ctx, contentType}, nil
+	bucket, ok := os.LookupEnv("S3_BUCKET")
+	if !ok {
+		bucket = defaultBookingBucket
+	}
+	return &S3Store{
+		client:   s3.New(sess),
+		uploader: s3manager.NewUploader(sess),
+		bucket:   bucket,
+	}, nil
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
 }
 
-func (s *s3Storage) StoreFile(file io.Reader, name string) error {
-	uploader := s3manager.NewUploader(s.session)
-	_, err := uploader.UploadWithContext(s.ctx, &s3manager.UploadInput{
-		Body:                 file,
-		Bucket:               aws.String("byrd-bookings"),
-		Key:                  aws.String("/simontestdir/" + name),
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Put streams r to the bucket
+func (s *S3Store) Put(ctx context.Context, key string, r io.Reader, opts storage.PutOptions) (*storage.BlobInfo, error) {
+	body := &countingReader{r: r}
+	input := &s3manager.UploadInput{
+		Body:                 body,
+		Bucket:               aws.String(s.bucket),
+		Key:                  aws.String(key),
 		ServerSideEncryption: aws.String("AES256"),
-		ContentType:          aws.String(s.contentType),
+	}
+	if opts.ContentType != "" {
+		input.ContentType = aws.String(opts.ContentType)
+	}
+	if _, err := s.uploader.UploadWithContext(ctx, input); err != nil {
+		return nil, errors.Wrapf(err, "uploading %s", key)
+	}
+	log.Infof("storage upload complete: %s", key)
+	return &storage.BlobInfo{
+		Key:         key,
+		Size:        body.n,
+		ContentType: opts.ContentType,
+	}, nil
+}
+
+// Get returns the object body, which the caller must close
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, *storage.BlobInfo, error) {
+	out, err := s.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
 	})
 	if err != nil {
-		log.Error(err)
-		return err
+		return nil, nil, notFound(err, key)
+	}
+	return out.Body, &storage.BlobInfo{
+		Key:          key,
+		Size:         aws.Int64Value(out.ContentLength),
+		ContentType:  aws.StringValue(out.ContentType),
+		LastModified: aws.TimeValue(out.LastModified),
+	}, nil
+}
+
+// GetRange returns a range of the object body, which the caller must close
+func (s *S3Store) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	out, err := s.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	})
+	if err != nil {
+		return nil, notFound(err, key)
+	}
+	return out.Body, nil
+}
+
+// Stat returns the object metadata without the body
+func (s *S3Store) Stat(ctx context.Context, key string) (*storage.BlobInfo, error) {
+	out, err := s.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, notFound(err, key)
+	}
+	return &storage.BlobInfo{
+		Key:          key,
+		Size:         aws.Int64Value(out.ContentLength),
+		ContentType:  aws.StringValue(out.ContentType),
+		LastModified: aws.TimeValue(out.LastModified),
+	}, nil
+}
+
+// Delete removes the object. Deleting a missing key is not an error.
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+// List returns all objects with keys starting with prefix
+func (s *S3Store) List(ctx context.Context, prefix string) ([]*storage.BlobInfo, error) {
+	var res []*storage.BlobInfo
+	err := s.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, o := range page.Contents {
+			res = append(res, &storage.BlobInfo{
+				Key:          aws.StringValue(o.Key),
+				Size:         aws.Int64Value(o.Size),
+				LastModified: aws.TimeValue(o.LastModified),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// SignedURL returns a presigned url that lets the holder GET or PUT the object without credentials
+func (s *S3Store) SignedURL(ctx context.Context, key string, opts storage.SignOptions) (string, error) {
+	var req *request.Request
+	switch opts.Method {
+	case http.MethodGet:
+		req, _ = s.client.GetObjectRequest(&s3.GetObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(key),
+		})
+	case http.MethodPut:
+		input := &s3.PutObjectInput{
+			Bucket:               aws.String(s.bucket),
+			Key:                  aws.String(key),
+			ServerSideEncryption: aws.String("AES256"),
+		}
+		if opts.ContentType != "" {
+			input.ContentType = aws.String(opts.ContentType)
+		}
+		req, _ = s.client.PutObjectRequest(input)
+	default:
+		return "", errors.Errorf("cannot sign %s requests", opts.Method)
+	}
+	req.SetContext(ctx)
+	return req.Presign(opts.Expires)
+}
+
+func notFound(err error, key string) error {
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case s3.ErrCodeNoSuchKey, "NotFound":
+			return errors.Wrap(storage.ErrBlobNotFound, key)
+		}
 	}
-	log.Info("storage upload complete")
-	return nil
+	return err
 }
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+const (
+	// blobBlockSize is the size of the ranges a BlobReader gets, parsers make many small reads close together
+	blobBlockSize = 256 << 10
+	// blobCachedBlocks is the number of blocks a BlobReader keeps
+	blobCachedBlocks = 16
+)
+
+// BlobReader reads a stored object at offsets with ranged gets, so parsers needing only parts of a large
+// object, like the metadata boxes of a video, don't download all of it. The last blocks read are kept.
+type BlobReader struct {
+	ctx   context.Context
+	store BlobStore
+	key   string
+	size  int64
+
+	mu     sync.Mutex
+	blocks map[int64][]byte
+	// order is the blocks from the oldest read
+	order []int64
+}
+
+// NewBlobReader returns a reader of the object key of size bytes
+func NewBlobReader(ctx context.Context, store BlobStore, key string, size int64) *BlobReader {
+	return &BlobReader{ctx: ctx, store: store, key: key, size: size, blocks: make(map[int64][]byte)}
+}
+
+// ReadAt implements io.ReaderAt
+func (b *BlobReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	for n < len(p) {
+		if off >= b.size {
+			return n, io.EOF
+		}
+		index := off / blobBlockSize
+		block, err := b.block(index)
+		if err != nil {
+			return n, err
+		}
+		c := copy(p[n:], block[off-index*blobBlockSize:])
+		n += c
+		off += int64(c)
+	}
+	return n, nil
+}
+
+func (b *BlobReader) block(index int64) ([]byte, error) {
+	if block, ok := b.blocks[index]; ok {
+		return block, nil
+	}
+	start := index * blobBlockSize
+	length := b.size - start
+	if length > blobBlockSize {
+		length = blobBlockSize
+	}
+	body, err := b.store.GetRange(b.ctx, b.key, start, length)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	block := make([]byte, length)
+	if _, err := io.ReadFull(body, block); err != nil {
+		return nil, err
+	}
+
+	if len(b.order) == blobCachedBlocks {
+		delete(b.blocks, b.order[0])
+		b.order = b.order[1:]
+	}
+	b.blocks[index] = block
+	b.order = append(b.order, index)
+	return block, nil
+}
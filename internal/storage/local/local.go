@@ -0,0 +1,345 @@
+package local
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/blixenkrone/gopro/internal/storage"
+	"github.com/blixenkrone/gopro/pkg/logger"
+)
+
+var log = logger.NewLogger()
+
+// metaDir holds the content types of the stored files
+const metaDir = ".meta"
+
+// Store is a storage.BlobStore keeping files under a root directory, meant for development and tests.
+// Signed urls point back at the store itself and are served through ServeHTTP.
+type Store struct {
+	root    string
+	baseURL string
+	secret  []byte
+}
+
+type meta struct {
+	ContentType string `json:"contentType,omitempty"`
+}
+
+// New returns a store rooted at root. Signed urls are issued below baseURL and signed with secret.
+func New(root, baseURL string, secret []byte) (*Store, error) {
+	if err := os.MkdirAll(root, 0750); err != nil {
+		return nil, errors.Wrap(err, "creating blob root")
+	}
+	return &Store{
+		root:    root,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		secret:  secret,
+	}, nil
+}
+
+// NewFromEnv returns a store configured by BLOB_DIR, BLOB_URL and BLOB_SECRET.
+// Without a secret a random one is used, so signed urls don't survive a restart.
+func NewFromEnv() (storage.BlobStore, error) {
+	root, ok := os.LookupEnv("BLOB_DIR")
+	if !ok {
+		root = "blobs"
+	}
+	baseURL, ok := os.LookupEnv("BLOB_URL")
+	if !ok {
+		baseURL = "http://localhost:3000/blobs"
+	}
+	secret := []byte(os.Getenv("BLOB_SECRET"))
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+	}
+	log.Infof("Using local blob storage in %s", root)
+	return New(root, baseURL, secret)
+}
+
+// path returns the file path of key, refusing keys that escape the root
+func (s *Store) path(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") || path.Clean(key) != key ||
+		key == ".." || strings.HasPrefix(key, "../") || strings.HasPrefix(key, metaDir+"/") {
+		return "", errors.Errorf("invalid key %q", key)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
+
+func (s *Store) metaPath(key string) string {
+	return filepath.Join(s.root, metaDir, filepath.FromSlash(key)+".json")
+}
+
+// Put writes r to the file of key. The file is replaced atomically.
+func (s *Store) Put(ctx context.Context, key string, r io.Reader, opts storage.PutOptions) (*storage.BlobInfo, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0750); err != nil {
+		return nil, err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "writing %s", key)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := s.writeMeta(key, meta{ContentType: opts.ContentType}); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return nil, err
+	}
+	return &storage.BlobInfo{
+		Key:          key,
+		Size:         n,
+		ContentType:  opts.ContentType,
+		LastModified: time.Now(),
+	}, nil
+}
+
+func (s *Store) writeMeta(key string, m meta) error {
+	mp := s.metaPath(key)
+	if err := os.MkdirAll(filepath.Dir(mp), 0750); err != nil {
+		return err
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(mp, b, 0640)
+}
+
+func (s *Store) readMeta(key string) meta {
+	var m meta
+	b, err := ioutil.ReadFile(s.metaPath(key))
+	if err != nil {
+		return m
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		log.Errorf("Invalid blob meta for %s: %s", key, err)
+	}
+	return m
+}
+
+// Get opens the file of key, which the caller must close
+func (s *Store) Get(ctx context.Context, key string) (io.ReadCloser, *storage.BlobInfo, error) {
+	info, err := s.Stat(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	p, _ := s.path(key)
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, nil, notFound(err, key)
+	}
+	return f, info, nil
+}
+
+// GetRange opens the file of key at offset, limited to length bytes. The caller must close it.
+func (s *Store) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	body, _, err := s.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	f := body.(*os.File)
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}, nil
+}
+
+// Stat returns the size, modification time and content type of key
+func (s *Store) Stat(ctx context.Context, key string) (*storage.BlobInfo, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(p)
+	if err != nil {
+		return nil, notFound(err, key)
+	}
+	if fi.IsDir() {
+		return nil, errors.Wrap(storage.ErrBlobNotFound, key)
+	}
+	return &storage.BlobInfo{
+		Key:          key,
+		Size:         fi.Size(),
+		ContentType:  s.readMeta(key).ContentType,
+		LastModified: fi.ModTime(),
+	}, nil
+}
+
+// Delete removes the file of key. Deleting a missing key is not an error.
+func (s *Store) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(s.metaPath(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// List returns the blobs with keys starting with prefix, sorted by key
+func (s *Store) List(ctx context.Context, prefix string) ([]*storage.BlobInfo, error) {
+	var res []*storage.BlobInfo
+	err := filepath.Walk(s.root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if fi.IsDir() {
+			if key == metaDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(fi.Name(), ".upload-") || !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		res = append(res, &storage.BlobInfo{
+			Key:          key,
+			Size:         fi.Size(),
+			ContentType:  s.readMeta(key).ContentType,
+			LastModified: fi.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Key < res[j].Key })
+	return res, nil
+}
+
+func (s *Store) sign(method, key, expires, contentType string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(method + "\n" + key + "\n" + expires + "\n" + contentType))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignedURL returns a url to ServeHTTP granting opts.Method on key until it expires
+func (s *Store) SignedURL(ctx context.Context, key string, opts storage.SignOptions) (string, error) {
+	if _, err := s.path(key); err != nil {
+		return "", err
+	}
+	if opts.Method != http.MethodGet && opts.Method != http.MethodPut {
+		return "", errors.Errorf("cannot sign %s requests", opts.Method)
+	}
+	expires := strconv.FormatInt(time.Now().Add(opts.Expires).Unix(), 10)
+
+	q := url.Values{}
+	q.Set("method", opts.Method)
+	q.Set("expires", expires)
+	if opts.ContentType != "" {
+		q.Set("contentType", opts.ContentType)
+	}
+	q.Set("signature", s.sign(opts.Method, key, expires, opts.ContentType))
+
+	segments := strings.Split(key, "/")
+	for i := range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+	return s.baseURL + "/" + strings.Join(segments, "/") + "?" + q.Encode(), nil
+}
+
+// ServeHTTP serves signed GET and PUT urls. It expects the url path to be the key,
+// so mount it with http.StripPrefix.
+func (s *Store) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	q := r.URL.Query()
+	contentType := q.Get("contentType")
+
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		http.Error(w, "url expired", http.StatusForbidden)
+		return
+	}
+	expected := s.sign(r.Method, key, q.Get("expires"), contentType)
+	if q.Get("method") != r.Method || !hmac.Equal([]byte(expected), []byte(q.Get("signature"))) {
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		body, info, err := s.Get(r.Context(), key)
+		if err != nil {
+			if errors.Cause(err) == storage.ErrBlobNotFound {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer body.Close()
+		if info.ContentType != "" {
+			w.Header().Set("Content-Type", info.ContentType)
+		}
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+		if _, err := io.Copy(w, body); err != nil {
+			log.Errorf("Error serving blob %s: %s", key, err)
+		}
+	case http.MethodPut:
+		if contentType != "" && r.Header.Get("Content-Type") != contentType {
+			http.Error(w, "content type must be "+contentType, http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+		if _, err := s.Put(r.Context(), key, r.Body, storage.PutOptions{ContentType: r.Header.Get("Content-Type")}); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func notFound(err error, key string) error {
+	if os.IsNotExist(err) {
+		return errors.Wrap(storage.ErrBlobNotFound, key)
+	}
+	return err
+}
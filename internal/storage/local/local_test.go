@@ -0,0 +1,168 @@
+package local
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/blixenkrone/gopro/internal/storage"
+)
+
+func newTestStore(t *testing.T) (*Store, func()) {
+	dir, err := ioutil.TempDir("", "blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(dir, "http://localhost/blobs", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, func() { os.RemoveAll(dir) }
+}
+
+func TestStore(t *testing.T) {
+	s, cleanup := newTestStore(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	info, err := s.Put(ctx, "booking/1/a.jpg", strings.NewReader("jpeg"), storage.PutOptions{ContentType: "image/jpeg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != 4 {
+		t.Errorf("Expected size 4 got %d", info.Size)
+	}
+	if _, err := s.Put(ctx, "booking/2/b.mp4", strings.NewReader("video"), storage.PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	body, info, err := s.Get(ctx, "booking/1/a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(body)
+	body.Close()
+	if string(b) != "jpeg" || info.ContentType != "image/jpeg" {
+		t.Errorf("Expected jpeg image/jpeg got %s %s", b, info.ContentType)
+	}
+
+	list, err := s.List(ctx, "booking/1/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Key != "booking/1/a.jpg" {
+		t.Errorf("Expected only booking/1/a.jpg got %v", list)
+	}
+
+	if err := s.Delete(ctx, "booking/1/a.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Stat(ctx, "booking/1/a.jpg"); errors.Cause(err) != storage.ErrBlobNotFound {
+		t.Errorf("Expected ErrBlobNotFound got %v", err)
+	}
+
+	for _, key := range []string{"", "/abs", "../escape", "a/../../b", ".meta/x"} {
+		if _, err := s.Put(ctx, key, strings.NewReader(""), storage.PutOptions{}); err == nil {
+			t.Errorf("Expected invalid key error for %q", key)
+		}
+	}
+}
+
+func TestSignedURL(t *testing.T) {
+	s, cleanup := newTestStore(t)
+	defer cleanup()
+	ctx := context.Background()
+	srv := httptest.NewServer(http.StripPrefix("/blobs/", s))
+	defer srv.Close()
+	s.baseURL = srv.URL + "/blobs"
+
+	putURL, err := s.SignedURL(ctx, "booking/1/a b.jpg", storage.SignOptions{Method: http.MethodPut, Expires: time.Minute, ContentType: "image/jpeg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest(http.MethodPut, putURL, strings.NewReader("jpeg"))
+	req.Header.Set("Content-Type", "image/png")
+	if res, err := http.DefaultClient.Do(req); err != nil || res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected wrong content type to be rejected got %v %v", res, err)
+	}
+
+	req, _ = http.NewRequest(http.MethodPut, putURL, strings.NewReader("jpeg"))
+	req.Header.Set("Content-Type", "image/jpeg")
+	if res, err := http.DefaultClient.Do(req); err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("Expected upload to succeed got %v %v", res, err)
+	}
+
+	if res, err := http.Get(putURL); err != nil || res.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected PUT url to be refused for GET got %v %v", res, err)
+	}
+
+	getURL, err := s.SignedURL(ctx, "booking/1/a b.jpg", storage.SignOptions{Method: http.MethodGet, Expires: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.Get(getURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || string(b) != "jpeg" {
+		t.Errorf("Expected jpeg got %d %s", res.StatusCode, b)
+	}
+
+	expired, _ := s.SignedURL(ctx, "booking/1/a b.jpg", storage.SignOptions{Method: http.MethodGet, Expires: -time.Minute})
+	if res, err := http.Get(expired); err != nil || res.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected expired url to be refused got %v %v", res, err)
+	}
+}
+
+func TestBlobReader(t *testing.T) {
+	s, cleanup := newTestStore(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	data := make([]byte, 600<<10)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	info, err := s.Put(ctx, "booking/1/v.mp4", bytes.NewReader(data), storage.PutOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := s.GetRange(ctx, "booking/1/v.mp4", 10, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(body)
+	body.Close()
+	if !bytes.Equal(b, data[10:15]) {
+		t.Errorf("Expected range 10-15 got %v", b)
+	}
+
+	r := storage.NewBlobReader(ctx, s, "booking/1/v.mp4", info.Size)
+	// across the first and second block, then the end
+	for _, off := range []int64{250 << 10, 0, int64(len(data)) - 100} {
+		p := make([]byte, 10<<10)
+		n, err := r.ReadAt(p, off)
+		want := data[off:]
+		if len(want) > len(p) {
+			want = want[:len(p)]
+		}
+		if n != len(want) || !bytes.Equal(p[:n], want) || (n < len(p) && err != io.EOF) {
+			t.Errorf("Unexpected read at %d: %d bytes %v", off, n, err)
+		}
+	}
+	if _, err := storage.NewBlobReader(ctx, s, "missing", 10).ReadAt(make([]byte, 1), 0); errors.Cause(err) != storage.ErrBlobNotFound {
+		t.Errorf("Expected ErrBlobNotFound got %v", err)
+	}
+}
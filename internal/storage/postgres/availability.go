@@ -0,0 +1,214 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	squirrel "github.com/Masterminds/squirrel"
+	"github.com/pkg/errors"
+
+	"github.com/blixenkrone/gopro/internal/storage"
+	timeutil "github.com/blixenkrone/gopro/pkg/time"
+)
+
+// inactiveBookingStatuses do not occupy the professional's calendar
+var inactiveBookingStatuses = []string{
+	string(storage.BookingCancelled),
+	string(storage.BookingDeclined),
+	string(storage.BookingExpired),
+}
+
+// lockProfessional serialises booking writes for a professional until tx ends,
+// so two requests cannot both pass the overlap check for the same period.
+func lockProfessional(ctx context.Context, tx *sql.Tx, proUID string) error {
+	_, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", proUID)
+	return err
+}
+
+// busyPeriods returns the active bookings and blocked periods of a professional overlapping window
+func busyPeriods(ctx context.Context, runner squirrel.BaseRunner, proUID string, window timeutil.Period) ([]timeutil.Period, error) {
+	sb := qb.RunWith(runner)
+	overlaps := squirrel.And{
+		squirrel.Eq{"user_uid": proUID},
+		squirrel.Lt{"date_start": window.End},
+		squirrel.Gt{"date_end": window.Start},
+	}
+	queries := []squirrel.SelectBuilder{
+		sb.Select("date_start", "date_end").From("booking").
+			Where(overlaps).
+			Where(squirrel.NotEq{"status": inactiveBookingStatuses}),
+		sb.Select("date_start", "date_end").From("blocked_period").
+			Where(overlaps),
+	}
+
+	var busy []timeutil.Period
+	for _, q := range queries {
+		rows, err := q.QueryContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var p timeutil.Period
+			if err := rows.Scan(&p.Start, &p.End); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			busy = append(busy, p)
+		}
+		if err := rows.Err(); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if err := rows.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return busy, nil
+}
+
+// workingPeriods expands the weekly working hours into concrete periods within window.
+// A professional without working hours is considered open for the whole window.
+func workingPeriods(hours []*storage.WorkingHours, window timeutil.Period) ([]timeutil.Period, error) {
+	if len(hours) == 0 {
+		return []timeutil.Period{window}, nil
+	}
+
+	var periods []timeutil.Period
+	for _, h := range hours {
+		loc, err := time.LoadLocation(h.TimeZone)
+		if err != nil {
+			return nil, errors.Wrapf(err, "loading time zone %q", h.TimeZone)
+		}
+		// start a day early so windows crossing midnight are not missed
+		from := window.Start.In(loc).AddDate(0, 0, -1)
+		day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, loc)
+		for ; day.Before(window.End); day = day.AddDate(0, 0, 1) {
+			if day.Weekday() != h.Weekday {
+				continue
+			}
+			p := timeutil.Period{
+				Start: time.Date(day.Year(), day.Month(), day.Day(), 0, h.StartMinute, 0, 0, loc),
+				End:   time.Date(day.Year(), day.Month(), day.Day(), 0, h.EndMinute, 0, 0, loc),
+			}
+			if p.Start.Before(window.Start) {
+				p.Start = window.Start
+			}
+			if p.End.After(window.End) {
+				p.End = window.End
+			}
+			if p.End.After(p.Start) {
+				periods = append(periods, p)
+			}
+		}
+	}
+	return periods, nil
+}
+
+// GetAvailability returns the free periods of a professional between from and to,
+// i.e. the working hours minus active bookings and blocked periods.
+func (p *Postgres) GetAvailability(ctx context.Context, proUID string, from, to time.Time) (*storage.Availability, error) {
+	window := timeutil.Period{Start: from, End: to}
+
+	hours, err := p.getWorkingHours(ctx, proUID)
+	if err != nil {
+		return nil, err
+	}
+	open, err := workingPeriods(hours, window)
+	if err != nil {
+		return nil, err
+	}
+	busy, err := busyPeriods(ctx, p.DB, proUID, window)
+	if err != nil {
+		return nil, err
+	}
+
+	return &storage.Availability{
+		UserUID: proUID,
+		From:    from,
+		To:      to,
+		Free:    timeutil.Subtract(open, busy),
+	}, nil
+}
+
+func (p *Postgres) getWorkingHours(ctx context.Context, proUID string) ([]*storage.WorkingHours, error) {
+	var hours []*storage.WorkingHours
+	sb := qb.RunWith(p.DB)
+	rows, err := sb.Select("user_uid", "weekday", "start_minute", "end_minute", "time_zone").
+		From("professional_hours").
+		Where("user_uid = ?", proUID).
+		OrderBy("weekday", "start_minute").QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var h storage.WorkingHours
+		if err := rows.Scan(&h.UserUID, &h.Weekday, &h.StartMinute, &h.EndMinute, &h.TimeZone); err != nil {
+			return nil, err
+		}
+		hours = append(hours, &h)
+	}
+	if err := p.HandleRowError(rows.Err()); err != nil {
+		return nil, err
+	}
+	return hours, nil
+}
+
+// SetWorkingHours replaces the weekly working hours of a professional
+func (p *Postgres) SetWorkingHours(ctx context.Context, proUID string, hours []*storage.WorkingHours) error {
+	for _, h := range hours {
+		if h.Weekday < time.Sunday || h.Weekday > time.Saturday {
+			return errors.Errorf("invalid weekday %d", h.Weekday)
+		}
+		if h.StartMinute < 0 || h.EndMinute > 24*60 || h.StartMinute >= h.EndMinute {
+			return errors.Errorf("invalid working hours %d-%d on %s", h.StartMinute, h.EndMinute, h.Weekday)
+		}
+		if h.TimeZone == "" {
+			h.TimeZone = "UTC"
+		}
+		if _, err := time.LoadLocation(h.TimeZone); err != nil {
+			return errors.Wrapf(err, "loading time zone %q", h.TimeZone)
+		}
+	}
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Errorf("Rollback error: %s", err)
+		}
+	}()
+
+	sb := qb.RunWith(tx)
+	if _, err := sb.Delete("professional_hours").Where("user_uid = ?", proUID).ExecContext(ctx); err != nil {
+		return err
+	}
+	if len(hours) > 0 {
+		insert := sb.Insert("professional_hours").Columns("user_uid", "weekday", "start_minute", "end_minute", "time_zone")
+		for _, h := range hours {
+			insert = insert.Values(proUID, h.Weekday, h.StartMinute, h.EndMinute, h.TimeZone)
+		}
+		if _, err := insert.ExecContext(ctx); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// CreateBlockedPeriod blocks a period in the professional's calendar
+func (p *Postgres) CreateBlockedPeriod(ctx context.Context, proUID string, b storage.BlockedPeriod) (id string, err error) {
+	sb := qb.RunWith(p.DB)
+	err = sb.Insert("blocked_period").Columns(
+		"user_uid", "date_start", "date_end", "reason").Values(
+		proUID, b.DateStart, b.DateEnd, b.Reason,
+	).Suffix("RETURNING id").QueryRowContext(ctx).Scan(&id)
+	if err != nil {
+		log.Errorf("Insert error: %s", err)
+		return "", err
+	}
+	return id, nil
+}
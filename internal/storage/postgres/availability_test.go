@@ -0,0 +1,51 @@
+package postgres
+
+import (
+	"testing"
+	"time"
+
+	"github.com/blixenkrone/gopro/internal/storage"
+	timeutil "github.com/blixenkrone/gopro/pkg/time"
+)
+
+func TestWorkingPeriods(t *testing.T) {
+	// monday 6th to wednesday 8th of january 2020
+	window := timeutil.Period{
+		Start: time.Date(2020, 1, 6, 0, 0, 0, 0, time.UTC),
+		End:   time.Date(2020, 1, 8, 0, 0, 0, 0, time.UTC),
+	}
+
+	t.Run("no working hours", func(t *testing.T) {
+		periods, err := workingPeriods(nil, window)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(periods) != 1 || periods[0] != window {
+			t.Errorf("Expected the whole window got %v", periods)
+		}
+	})
+
+	t.Run("weekdays in time zone", func(t *testing.T) {
+		hours := []*storage.WorkingHours{
+			{Weekday: time.Monday, StartMinute: 8 * 60, EndMinute: 16 * 60, TimeZone: "Europe/Copenhagen"},
+			{Weekday: time.Tuesday, StartMinute: 10 * 60, EndMinute: 12 * 60, TimeZone: "UTC"},
+			{Weekday: time.Friday, StartMinute: 8 * 60, EndMinute: 16 * 60, TimeZone: "UTC"},
+		}
+		periods, err := workingPeriods(hours, window)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []timeutil.Period{
+			{Start: time.Date(2020, 1, 6, 7, 0, 0, 0, time.UTC), End: time.Date(2020, 1, 6, 15, 0, 0, 0, time.UTC)},
+			{Start: time.Date(2020, 1, 7, 10, 0, 0, 0, time.UTC), End: time.Date(2020, 1, 7, 12, 0, 0, 0, time.UTC)},
+		}
+		if len(periods) != len(expected) {
+			t.Fatalf("Expected %v got %v", expected, periods)
+		}
+		for i := range periods {
+			if !periods[i].Start.Equal(expected[i].Start) || !periods[i].End.Equal(expected[i].End) {
+				t.Errorf("Expected %v got %v", expected[i], periods[i])
+			}
+		}
+	})
+}
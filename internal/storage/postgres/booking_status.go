@@ -0,0 +1,143 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pkg/errors"
+
+	"github.com/blixenkrone/gopro/internal/storage"
+)
+
+// bookingTransitions maps a booking status to the statuses it may move to.
+// Statuses without an entry are terminal.
+var bookingTransitions = map[storage.BookingStatus][]storage.BookingStatus{
+	storage.BookingRequested:  {storage.BookingAccepted, storage.BookingDeclined, storage.BookingCancelled, storage.BookingExpired},
+	storage.BookingAccepted:   {storage.BookingInProgress, storage.BookingCancelled, storage.BookingExpired},
+	storage.BookingInProgress: {storage.BookingDelivered, storage.BookingCancelled},
+	// a media can reject the deliverables and send the booking back to the professional
+	storage.BookingDelivered: {storage.BookingApproved, storage.BookingInProgress},
+	storage.BookingApproved:  {storage.BookingInvoiced},
+}
+
+var bookingStatuses = map[storage.BookingStatus]bool{
+	storage.BookingRequested:  true,
+	storage.BookingAccepted:   true,
+	storage.BookingInProgress: true,
+	storage.BookingDelivered:  true,
+	storage.BookingApproved:   true,
+	storage.BookingInvoiced:   true,
+	storage.BookingCancelled:  true,
+	storage.BookingDeclined:   true,
+	storage.BookingExpired:    true,
+}
+
+// ValidBookingStatus reports whether s is part of the booking lifecycle
+func ValidBookingStatus(s storage.BookingStatus) bool {
+	return bookingStatuses[s]
+}
+
+// CanTransition reports whether a booking in status from may be moved to status to
+func CanTransition(from, to storage.BookingStatus) bool {
+	for _, s := range bookingTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// isActiveStatus and isCompletedStatus keep the legacy is_active/is_completed columns in line with the status
+func isActiveStatus(s storage.BookingStatus) bool {
+	return s == storage.BookingAccepted || s == storage.BookingInProgress
+}
+
+func isCompletedStatus(s storage.BookingStatus) bool {
+	return s == storage.BookingDelivered || s == storage.BookingApproved || s == storage.BookingInvoiced
+}
+
+// TransitionBooking moves a booking to t.To if the current status allows it. When t.From is set the booking
+// must still be in that status, e.g. the one the actor was authorized for.
+// The booking row is locked for the duration of the transaction and the change is recorded in booking_transition.
+func (p *Postgres) TransitionBooking(ctx context.Context, bookingID string, t storage.BookingTransition) (*storage.BookingTransition, error) {
+	if !ValidBookingStatus(t.To) {
+		return nil, errors.Wrapf(storage.ErrUnknownBookingStatus, "status %q", t.To)
+	}
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Errorf("Rollback error: %s", err)
+		}
+	}()
+
+	sb := qb.RunWith(tx)
+	var from storage.BookingStatus
+	err = sb.Select("status").From("booking").
+		Where("id = ?", bookingID).
+		Suffix("FOR UPDATE").QueryRowContext(ctx).Scan(&from)
+	if err != nil {
+		return nil, p.HandleRowError(err)
+	}
+
+	if t.From != "" && t.From != from {
+		return nil, errors.Wrapf(storage.ErrIllegalTransition, "booking moved from %s to %s", t.From, from)
+	}
+	if !CanTransition(from, t.To) {
+		return nil, errors.Wrapf(storage.ErrIllegalTransition, "%s -> %s", from, t.To)
+	}
+
+	_, err = sb.Update("booking").
+		Set("status", t.To).
+		Set("is_active", isActiveStatus(t.To)).
+		Set("is_completed", isCompletedStatus(t.To)).
+		Where("id = ?", bookingID).ExecContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	t.BookingID = bookingID
+	t.From = from
+	err = sb.Insert("booking_transition").Columns(
+		"booking_id", "from_status", "to_status", "actor_uid", "note").Values(
+		t.BookingID, t.From, t.To, t.ActorUID, t.Note,
+	).Suffix("RETURNING id, created_at").QueryRowContext(ctx).Scan(&t.ID, &t.CreatedAt)
+	if err != nil {
+		log.Errorf("Insert error: %s", err)
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// GetBookingTransitions returns the status history of a booking, oldest first
+func (p *Postgres) GetBookingTransitions(ctx context.Context, bookingID string) ([]*storage.BookingTransition, error) {
+	var transitions []*storage.BookingTransition
+	sb := qb.RunWith(p.DB)
+	rows, err := sb.Select("id", "booking_id", "from_status", "to_status", "actor_uid", "note", "created_at").
+		From("booking_transition").
+		Where("booking_id = ?", bookingID).
+		OrderBy("created_at ASC").QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t storage.BookingTransition
+		if err := rows.Scan(&t.ID, &t.BookingID, &t.From, &t.To, &t.ActorUID, &t.Note, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		transitions = append(transitions, &t)
+	}
+	if err := p.HandleRowError(rows.Err()); err != nil {
+		return nil, err
+	}
+	return transitions, nil
+}
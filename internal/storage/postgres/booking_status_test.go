@@ -0,0 +1,44 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/blixenkrone/gopro/internal/storage"
+)
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from, to storage.BookingStatus
+		ok       bool
+	}{
+		{storage.BookingRequested, storage.BookingAccepted, true},
+		{storage.BookingRequested, storage.BookingDeclined, true},
+		{storage.BookingAccepted, storage.BookingInProgress, true},
+		{storage.BookingInProgress, storage.BookingDelivered, true},
+		{storage.BookingDelivered, storage.BookingInProgress, true},
+		{storage.BookingDelivered, storage.BookingApproved, true},
+		{storage.BookingApproved, storage.BookingInvoiced, true},
+		{storage.BookingRequested, storage.BookingDelivered, false},
+		{storage.BookingAccepted, storage.BookingDeclined, false},
+		{storage.BookingInvoiced, storage.BookingCancelled, false},
+		{storage.BookingCancelled, storage.BookingRequested, false},
+		{storage.BookingApproved, storage.BookingCancelled, false},
+	}
+
+	for _, test := range tests {
+		if ok := CanTransition(test.from, test.to); ok != test.ok {
+			t.Errorf("%s -> %s: expected %v got %v", test.from, test.to, test.ok, ok)
+		}
+	}
+}
+
+func TestTerminalStatuses(t *testing.T) {
+	terminal := []storage.BookingStatus{storage.BookingInvoiced, storage.BookingCancelled, storage.BookingDeclined, storage.BookingExpired}
+	for _, from := range terminal {
+		for to := range bookingStatuses {
+			if CanTransition(from, to) {
+				t.Errorf("terminal status %s must not move to %s", from, to)
+			}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package postgres
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+)
+
+// calendarTokenBytes is the amount of random bytes in a calendar feed token
+const calendarTokenBytes = 32
+
+func newCalendarToken() (string, error) {
+	b := make([]byte, calendarTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// GetCalendarToken returns the calendar feed token of a professional, creating one on first use
+func (p *Postgres) GetCalendarToken(ctx context.Context, proUID string) (token string, err error) {
+	newToken, err := newCalendarToken()
+	if err != nil {
+		return "", err
+	}
+	// the no-op update makes RETURNING yield the existing token on conflict
+	sb := qb.RunWith(p.DB)
+	err = sb.Insert("calendar_token").Columns("user_uid", "token").Values(proUID, newToken).
+		Suffix("ON CONFLICT (user_uid) DO UPDATE SET user_uid = EXCLUDED.user_uid RETURNING token").
+		QueryRowContext(ctx).Scan(&token)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// RotateCalendarToken replaces the calendar feed token, invalidating subscriptions to the old one
+func (p *Postgres) RotateCalendarToken(ctx context.Context, proUID string) (token string, err error) {
+	newToken, err := newCalendarToken()
+	if err != nil {
+		return "", err
+	}
+	sb := qb.RunWith(p.DB)
+	err = sb.Insert("calendar_token").Columns("user_uid", "token").Values(proUID, newToken).
+		Suffix("ON CONFLICT (user_uid) DO UPDATE SET token = EXCLUDED.token, created_at = now() RETURNING token").
+		QueryRowContext(ctx).Scan(&token)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// GetUIDByCalendarToken returns the professional owning a calendar feed token
+func (p *Postgres) GetUIDByCalendarToken(ctx context.Context, token string) (proUID string, err error) {
+	sb := qb.RunWith(p.DB)
+	err = sb.Select("user_uid").From("calendar_token").
+		Where("token = ?", token).QueryRowContext(ctx).Scan(&proUID)
+	if err := p.HandleRowError(err); err != nil {
+		return "", err
+	}
+	return proUID, nil
+}
@@ -0,0 +1,258 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	squirrel "github.com/Masterminds/squirrel"
+
+	"github.com/blixenkrone/gopro/internal/storage"
+	"github.com/blixenkrone/gopro/pkg/image/phash"
+)
+
+var deliverableColumns = []string{
+	"id", "booking_id", "key", "file_name", "size", "sha256", "content_type", "created_at",
+	"exif", "thumbnail_key", "processing_error", "processed_at", "phash", "derivatives", "blurhash", "palette",
+}
+
+// deliverableScan holds a row of deliverableColumns until its exif, phash, derivatives and placeholder are converted
+type deliverableScan struct {
+	d           storage.Deliverable
+	exif        []byte
+	phash       sql.NullInt64
+	derivatives []byte
+	blurHash    sql.NullString
+	palette     []byte
+}
+
+func (s *deliverableScan) dest() []interface{} {
+	d := &s.d
+	return []interface{}{&d.ID, &d.BookingID, &d.Key, &d.FileName, &d.Size, &d.SHA256, &d.ContentType, &d.CreatedAt,
+		&s.exif, &d.ThumbnailKey, &d.ProcessingError, &d.ProcessedAt, &s.phash, &s.derivatives, &s.blurHash, &s.palette}
+}
+
+func (s *deliverableScan) deliverable() (*storage.Deliverable, error) {
+	d := s.d
+	if len(s.exif) > 0 {
+		if err := json.Unmarshal(s.exif, &d.Exif); err != nil {
+			return nil, err
+		}
+	}
+	if s.phash.Valid {
+		h := phash.Hash(s.phash.Int64)
+		d.PHash = &h
+	}
+	if len(s.derivatives) > 0 {
+		if err := json.Unmarshal(s.derivatives, &d.Derivatives); err != nil {
+			return nil, err
+		}
+	}
+	d.BlurHash = s.blurHash.String
+	if len(s.palette) > 0 {
+		if err := json.Unmarshal(s.palette, &d.Palette); err != nil {
+			return nil, err
+		}
+	}
+	return &d, nil
+}
+
+// jsonValue is v as a JSON string, or nil if it isn't set
+func jsonValue(v interface{}, set bool) (interface{}, error) {
+	if !set {
+		return nil, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// phashValue is the bigint a phash is stored as
+func phashValue(h *phash.Hash) interface{} {
+	if h == nil {
+		return nil
+	}
+	return int64(*h)
+}
+
+// nullString is s, or nil if it is empty
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// hammingDistance is the SQL for the number of differing bits of two bigint hashes.
+// bit_count would do from Postgres 14.
+func hammingDistance(a, b string) string {
+	return "length(replace((" + a + " # " + b + ")::bit(64)::text, '0', ''))"
+}
+
+// prefixColumns qualifies columns with a table alias
+func prefixColumns(alias string, columns []string) []string {
+	prefixed := make([]string, len(columns))
+	for i, c := range columns {
+		prefixed[i] = alias + "." + c
+	}
+	return prefixed
+}
+
+// CreateDeliverable records a stored file for a booking and sets its id and creation time.
+// Recording a key again updates it, so completing an upload can be retried.
+func (p *Postgres) CreateDeliverable(ctx context.Context, d *storage.Deliverable) error {
+	colors, err := jsonValue(d.Palette, d.Palette != nil)
+	if err != nil {
+		return err
+	}
+	sb := qb.RunWith(p.DB)
+	err = sb.Insert("booking_deliverable").Columns(
+		"booking_id", "key", "file_name", "size", "sha256", "content_type", "phash", "blurhash", "palette").Values(
+		d.BookingID, d.Key, d.FileName, d.Size, d.SHA256, d.ContentType, phashValue(d.PHash), nullString(d.BlurHash), colors,
+	).Suffix(`ON CONFLICT (key) DO UPDATE SET size = EXCLUDED.size, sha256 = EXCLUDED.sha256, content_type = EXCLUDED.content_type,
+		phash = COALESCE(EXCLUDED.phash, booking_deliverable.phash),
+		blurhash = COALESCE(EXCLUDED.blurhash, booking_deliverable.blurhash),
+		palette = COALESCE(EXCLUDED.palette, booking_deliverable.palette)
+		RETURNING id, created_at`).QueryRowContext(ctx).Scan(&d.ID, &d.CreatedAt)
+	if err != nil {
+		log.Errorf("Insert error: %s", err)
+		return err
+	}
+	return nil
+}
+
+// GetDeliverables returns the files delivered for a booking, oldest first
+func (p *Postgres) GetDeliverables(ctx context.Context, bookingID string) ([]*storage.Deliverable, error) {
+	var deliverables []*storage.Deliverable
+	sb := qb.RunWith(p.DB)
+	rows, err := sb.Select(deliverableColumns...).
+		From("booking_deliverable").
+		Where("booking_id = ?", bookingID).
+		OrderBy("created_at ASC").QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ds deliverableScan
+		if err := rows.Scan(ds.dest()...); err != nil {
+			return nil, err
+		}
+		d, err := ds.deliverable()
+		if err != nil {
+			return nil, err
+		}
+		deliverables = append(deliverables, d)
+	}
+	if err := p.HandleRowError(rows.Err()); err != nil {
+		return nil, err
+	}
+	return deliverables, nil
+}
+
+// SetDeliverableProcessed stores the exif, thumbnail, phash, derivatives, placeholder and processing error of
+// a deliverable, and its hash if it wasn't known when it was recorded
+func (p *Postgres) SetDeliverableProcessed(ctx context.Context, d *storage.Deliverable) error {
+	x, err := jsonValue(d.Exif, d.Exif != nil)
+	if err != nil {
+		return err
+	}
+	derivatives, err := jsonValue(d.Derivatives, d.Derivatives != nil)
+	if err != nil {
+		return err
+	}
+	colors, err := jsonValue(d.Palette, d.Palette != nil)
+	if err != nil {
+		return err
+	}
+	sb := qb.RunWith(p.DB)
+	err = sb.Update("booking_deliverable").
+		Set("sha256", squirrel.Expr("COALESCE(NULLIF(?, ''), sha256)", d.SHA256)).
+		Set("exif", x).
+		Set("thumbnail_key", d.ThumbnailKey).
+		Set("phash", squirrel.Expr("COALESCE(?, phash)", phashValue(d.PHash))).
+		Set("derivatives", derivatives).
+		Set("blurhash", squirrel.Expr("COALESCE(?, blurhash)", nullString(d.BlurHash))).
+		Set("palette", squirrel.Expr("COALESCE(?::jsonb, palette)", colors)).
+		Set("processing_error", d.ProcessingError).
+		Set("processed_at", squirrel.Expr("now()")).
+		Where("id = ?", d.ID).
+		Suffix("RETURNING processed_at").QueryRowContext(ctx).Scan(&d.ProcessedAt)
+	return p.HandleRowError(err)
+}
+
+// GetSimilarDeliverables returns the deliverables with a phash at most maxDistance bits from h, closest first
+func (p *Postgres) GetSimilarDeliverables(ctx context.Context, h phash.Hash, maxDistance int) ([]*storage.SimilarDeliverable, error) {
+	distance := hammingDistance("phash", "?")
+	sb := qb.RunWith(p.DB)
+	rows, err := sb.Select(deliverableColumns...).
+		Column(squirrel.Alias(squirrel.Expr(distance, int64(h)), "distance")).
+		From("booking_deliverable").
+		Where("phash IS NOT NULL").
+		Where(squirrel.Expr(distance+" <= ?", int64(h), maxDistance)).
+		OrderBy("distance ASC", "created_at ASC").QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var similar []*storage.SimilarDeliverable
+	for rows.Next() {
+		var ds deliverableScan
+		var n int
+		if err := rows.Scan(append(ds.dest(), &n)...); err != nil {
+			return nil, err
+		}
+		d, err := ds.deliverable()
+		if err != nil {
+			return nil, err
+		}
+		similar = append(similar, &storage.SimilarDeliverable{Deliverable: d, Distance: n})
+	}
+	if err := p.HandleRowError(rows.Err()); err != nil {
+		return nil, err
+	}
+	return similar, nil
+}
+
+// GetDuplicateDeliverables returns every pair of deliverables with phashes at most maxDistance bits apart,
+// the latest duplicates first. Each pair is compared, which is fine for the deliverables of a few years.
+func (p *Postgres) GetDuplicateDeliverables(ctx context.Context, maxDistance int) ([]*storage.DuplicateDeliverables, error) {
+	distance := hammingDistance("o.phash", "d.phash")
+	columns := append(prefixColumns("o", deliverableColumns), prefixColumns("d", deliverableColumns)...)
+	sb := qb.RunWith(p.DB)
+	rows, err := sb.Select(append(columns, distance)...).
+		From("booking_deliverable o").
+		Join("booking_deliverable d ON (o.created_at, o.id) < (d.created_at, d.id)").
+		Where("o.phash IS NOT NULL AND d.phash IS NOT NULL").
+		Where(distance+" <= ?", maxDistance).
+		OrderBy("d.created_at DESC", "o.created_at ASC").QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var duplicates []*storage.DuplicateDeliverables
+	for rows.Next() {
+		var o, d deliverableScan
+		dup := &storage.DuplicateDeliverables{}
+		if err := rows.Scan(append(append(o.dest(), d.dest()...), &dup.Distance)...); err != nil {
+			return nil, err
+		}
+		var err error
+		if dup.Original, err = o.deliverable(); err != nil {
+			return nil, err
+		}
+		if dup.Duplicate, err = d.deliverable(); err != nil {
+			return nil, err
+		}
+		duplicates = append(duplicates, dup)
+	}
+	if err := p.HandleRowError(rows.Err()); err != nil {
+		return nil, err
+	}
+	return duplicates, nil
+}
@@ -0,0 +1,137 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+
+	squirrel "github.com/Masterminds/squirrel"
+
+	"github.com/blixenkrone/gopro/internal/storage"
+	"github.com/blixenkrone/gopro/pkg/geo"
+	timeutil "github.com/blixenkrone/gopro/pkg/time"
+)
+
+// withinBox is the index friendly prefilter for a radius search. The exact haversine check is done after scanning.
+func withinBox(table string, box geo.BoundingBox) squirrel.And {
+	cond := squirrel.And{
+		squirrel.GtOrEq{table + ".lat": box.MinLat},
+		squirrel.LtOrEq{table + ".lat": box.MaxLat},
+	}
+	if !box.AllLng {
+		cond = append(cond,
+			squirrel.GtOrEq{table + ".lng": box.MinLng},
+			squirrel.LtOrEq{table + ".lng": box.MaxLng},
+		)
+	}
+	return cond
+}
+
+// GetBookingsNear returns the bookings that are not cancelled, declined or expired within radiusKm of p, closest first
+func (p *Postgres) GetBookingsNear(ctx context.Context, point geo.Point, radiusKm float64) ([]*storage.NearbyBooking, error) {
+	sb := qb.RunWith(p.DB)
+	rows, err := sb.Select(bookingColumns...).From("booking").
+		Where(withinBox("booking", point.BoundingBox(radiusKm))).
+		Where(squirrel.NotEq{"status": inactiveBookingStatuses}).
+		QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []*storage.NearbyBooking
+	for rows.Next() {
+		b, err := scanBooking(rows)
+		if err != nil {
+			return nil, err
+		}
+		d := geo.Distance(point, geo.Point{Lat: float64(b.Lat), Lng: float64(b.Lng)})
+		if d > radiusKm {
+			continue
+		}
+		res = append(res, &storage.NearbyBooking{Booking: b, DistanceKm: d})
+	}
+	if err := p.HandleRowError(rows.Err()); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].DistanceKm < res[j].DistanceKm })
+	return res, nil
+}
+
+// GetProfessionalsNear returns the professionals whose last known location is within radiusKm of p, closest first.
+// If available is set, professionals with active bookings or blocked periods overlapping it are left out.
+func (p *Postgres) GetProfessionalsNear(ctx context.Context, point geo.Point, radiusKm float64, available *timeutil.Period) ([]*storage.NearbyProfessional, error) {
+	query := qb.RunWith(p.DB).
+		Select("professional.id", "professional.user_uid", "professional.pro_level", "professional.lat", "professional.lng", "professional.location_updated_at").
+		From("professional").
+		Where(withinBox("professional", point.BoundingBox(radiusKm)))
+
+	if available != nil {
+		overlaps := squirrel.And{
+			squirrel.Expr("user_uid = professional.user_uid"),
+			squirrel.Lt{"date_start": available.End},
+			squirrel.Gt{"date_end": available.Start},
+		}
+		booked, bookedArgs, err := squirrel.Select("1").From("booking").
+			Where(overlaps).
+			Where(squirrel.NotEq{"status": inactiveBookingStatuses}).ToSql()
+		if err != nil {
+			return nil, err
+		}
+		blocked, blockedArgs, err := squirrel.Select("1").From("blocked_period").
+			Where(overlaps).ToSql()
+		if err != nil {
+			return nil, err
+		}
+		query = query.
+			Where(squirrel.Expr("NOT EXISTS ("+booked+")", bookedArgs...)).
+			Where(squirrel.Expr("NOT EXISTS ("+blocked+")", blockedArgs...))
+	}
+
+	rows, err := query.QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []*storage.NearbyProfessional
+	for rows.Next() {
+		var pro storage.Professional
+		if err := rows.Scan(&pro.ID, &pro.UserUID, &pro.ProLevel, &pro.Lat, &pro.Lng, &pro.LocationUpdatedAt); err != nil {
+			return nil, err
+		}
+		d := geo.Distance(point, geo.Point{Lat: float64(pro.Lat), Lng: float64(pro.Lng)})
+		if d > radiusKm {
+			continue
+		}
+		res = append(res, &storage.NearbyProfessional{Professional: &pro, DistanceKm: d})
+	}
+	if err := p.HandleRowError(rows.Err()); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].DistanceKm < res[j].DistanceKm })
+	return res, nil
+}
+
+// UpdateProfessionalLocation sets the last known location of a professional
+func (p *Postgres) UpdateProfessionalLocation(ctx context.Context, proUID string, point geo.Point) error {
+	sb := qb.RunWith(p.DB)
+	res, err := sb.Update("professional").
+		Set("lat", point.Lat).
+		Set("lng", point.Lng).
+		Set("location_updated_at", squirrel.Expr("now()")).
+		Where("user_uid = ?", proUID).ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
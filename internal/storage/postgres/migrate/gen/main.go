@@ -0,0 +1,47 @@
+// gen embeds the sql migration files into the migrate package, since go 1.13 has no embed support.
+// Run through go generate in the migrate package after adding or changing a migration.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	sqlDir  = "sql"
+	outFile = "sql.go"
+)
+
+func main() {
+	paths, err := filepath.Glob(filepath.Join(sqlDir, "*.sql"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	sort.Strings(paths)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by gen/main.go from the %s directory. DO NOT EDIT.\n\n", sqlDir)
+	fmt.Fprintf(&buf, "package migrate\n\n")
+	fmt.Fprintf(&buf, "var files = map[string]string{\n")
+	for _, p := range paths {
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Fprintf(&buf, "%q: %q,\n", filepath.Base(p), b)
+	}
+	fmt.Fprintf(&buf, "}\n")
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(outFile, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
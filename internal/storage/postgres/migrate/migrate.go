@@ -0,0 +1,295 @@
+package migrate
+
+//go:generate go run ./gen
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/blixenkrone/gopro/pkg/logger"
+)
+
+var log = logger.NewLogger()
+
+// lockKey is the postgres advisory lock held while migrating, so two deploying containers can't migrate concurrently
+const lockKey int64 = 7301604913
+
+const createTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version    INTEGER PRIMARY KEY,
+    name       TEXT NOT NULL,
+    checksum   TEXT NOT NULL,
+    applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+)`
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is a numbered schema change with the sql to apply and revert it
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Checksum identifies the applied sql, to detect migrations changed after they ran
+func (m *Migration) Checksum() string {
+	sum := sha256.Sum256([]byte(m.Up))
+	return hex.EncodeToString(sum[:])
+}
+
+// Status is the state of a single migration in a database
+type Status struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"appliedAt,omitempty"`
+	// Modified is set when the migration file no longer matches what was applied
+	Modified bool `json:"modified,omitempty"`
+}
+
+// Parse builds the migrations from file names like 0001_baseline.up.sql and 0001_baseline.down.sql.
+// Every version needs both files, and versions must start at 1 without gaps.
+func Parse(files map[string]string) ([]*Migration, error) {
+	byVersion := make(map[int]*Migration)
+	for name, content := range files {
+		match := fileName.FindStringSubmatch(name)
+		if match == nil {
+			return nil, errors.Errorf("invalid migration file name %s", name)
+		}
+		version, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing version of %s", name)
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, errors.Errorf("migration %d has two names: %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = content
+		} else {
+			m.Down = content
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, errors.Errorf("expected migration %d got %d", i+1, m.Version)
+		}
+		if m.Up == "" || m.Down == "" {
+			return nil, errors.Errorf("migration %d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+	}
+	return migrations, nil
+}
+
+// Migrator applies migrations to a database
+type Migrator struct {
+	db         *sql.DB
+	migrations []*Migration
+}
+
+// New returns a Migrator for the sql files embedded in this package
+func New(db *sql.DB) (*Migrator, error) {
+	migrations, err := Parse(files)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db, migrations}, nil
+}
+
+// Latest is the highest known migration version
+func (m *Migrator) Latest() int {
+	return len(m.migrations)
+}
+
+// Up applies all pending migrations
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.Goto(ctx, m.Latest())
+}
+
+// Down reverts the latest applied migration
+func (m *Migrator) Down(ctx context.Context) error {
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		current, err := m.verify(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if current == 0 {
+			log.Infoln("No migrations to revert")
+			return nil
+		}
+		return m.revert(ctx, conn, m.migrations[current-1])
+	})
+}
+
+// Goto migrates up or down until version is the latest applied migration
+func (m *Migrator) Goto(ctx context.Context, version int) error {
+	if version < 0 || version > m.Latest() {
+		return errors.Errorf("unknown migration version %d, latest is %d", version, m.Latest())
+	}
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		current, err := m.verify(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for v := current + 1; v <= version; v++ {
+			if err := m.apply(ctx, conn, m.migrations[v-1]); err != nil {
+				return err
+			}
+		}
+		for v := current; v > version; v-- {
+			if err := m.revert(ctx, conn, m.migrations[v-1]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Status returns the state of every known migration
+func (m *Migrator) Status(ctx context.Context) ([]*Status, error) {
+	var res []*Status
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			s := &Status{Version: mig.Version, Name: mig.Name}
+			if a, ok := applied[mig.Version]; ok {
+				s.Applied = true
+				s.AppliedAt = &a.appliedAt
+				s.Modified = a.checksum != mig.Checksum()
+			}
+			res = append(res, s)
+		}
+		return nil
+	})
+	return res, err
+}
+
+// withLock runs fn on a single connection holding the migration advisory lock
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	log.Infoln("Waiting for migration lock")
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockKey); err != nil {
+		return errors.Wrap(err, "acquiring migration lock")
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", lockKey); err != nil {
+			log.Errorf("Error releasing migration lock: %s", err)
+		}
+	}()
+
+	if _, err := conn.ExecContext(ctx, createTable); err != nil {
+		return errors.Wrap(err, "creating schema_migrations")
+	}
+	return fn(conn)
+}
+
+type appliedMigration struct {
+	checksum  string
+	appliedAt time.Time
+}
+
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int]appliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, checksum, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]appliedMigration)
+	for rows.Next() {
+		var version int
+		var a appliedMigration
+		if err := rows.Scan(&version, &a.checksum, &a.appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = a
+	}
+	return applied, rows.Err()
+}
+
+// verify checks that the applied migrations are a known, unmodified prefix and returns the latest applied version
+func (m *Migrator) verify(ctx context.Context, conn *sql.Conn) (int, error) {
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+	current := 0
+	for _, mig := range m.migrations {
+		a, ok := applied[mig.Version]
+		if !ok {
+			break
+		}
+		if a.checksum != mig.Checksum() {
+			return 0, errors.Errorf("migration %d_%s was changed after it was applied", mig.Version, mig.Name)
+		}
+		current = mig.Version
+	}
+	if len(applied) != current {
+		return 0, errors.Errorf("database has %d applied migrations but only 1-%d are applied in order or known", len(applied), current)
+	}
+	return current, nil
+}
+
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, mig *Migration) error {
+	log.Infof("Applying migration %d_%s", mig.Version, mig.Name)
+	return inTx(ctx, conn, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, mig.Up); err != nil {
+			return errors.Wrapf(err, "applying migration %d_%s", mig.Version, mig.Name)
+		}
+		_, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)",
+			mig.Version, mig.Name, mig.Checksum())
+		return err
+	})
+}
+
+func (m *Migrator) revert(ctx context.Context, conn *sql.Conn, mig *Migration) error {
+	log.Infof("Reverting migration %d_%s", mig.Version, mig.Name)
+	return inTx(ctx, conn, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, mig.Down); err != nil {
+			return errors.Wrapf(err, "reverting migration %d_%s", mig.Version, mig.Name)
+		}
+		_, err := tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = $1", mig.Version)
+		return err
+	})
+}
+
+func inTx(ctx context.Context, conn *sql.Conn, fn func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			log.Errorf("Rollback error: %s", rbErr)
+		}
+		return err
+	}
+	return tx.Commit()
+}
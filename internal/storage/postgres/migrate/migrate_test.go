@@ -0,0 +1,60 @@
+package migrate
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := Parse(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+
+	paths, err := filepath.Glob(filepath.Join("sql", "*.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != len(files) {
+		t.Fatalf("sql.go is out of date, run go generate: %d files in sql/ but %d embedded", len(paths), len(files))
+	}
+	for _, p := range paths {
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if files[filepath.Base(p)] != string(b) {
+			t.Errorf("sql.go is out of date for %s, run go generate", p)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		ok    bool
+	}{
+		{"valid", map[string]string{"0001_a.up.sql": "up", "0001_a.down.sql": "down", "0002_b.up.sql": "up", "0002_b.down.sql": "down"}, true},
+		{"missing down", map[string]string{"0001_a.up.sql": "up"}, false},
+		{"gap", map[string]string{"0001_a.up.sql": "up", "0001_a.down.sql": "down", "0003_c.up.sql": "up", "0003_c.down.sql": "down"}, false},
+		{"bad name", map[string]string{"a.sql": "up"}, false},
+		{"two names", map[string]string{"0001_a.up.sql": "up", "0001_b.down.sql": "down"}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			migrations, err := Parse(test.files)
+			if test.ok && err != nil {
+				t.Fatal(err)
+			}
+			if !test.ok && err == nil {
+				t.Fatalf("Expected error got %v", migrations)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+// Code generated by gen/main.go from the sql directory. DO NOT EDIT.
+
+package migrate
+
+var files = map[string]string{
+	"0001_baseline.down.sql":                 "DROP TABLE IF EXISTS booking;\nDROP TABLE IF EXISTS professional;\n",
+	"0001_baseline.up.sql":                   "-- Tables the service was running on before migrations were tracked.\n-- IF NOT EXISTS lets existing databases adopt the migration history.\nCREATE TABLE IF NOT EXISTS professional (\n    id         SERIAL PRIMARY KEY,\n    user_uid   TEXT NOT NULL UNIQUE,\n    pro_level  INTEGER NOT NULL DEFAULT 0,\n    email      TEXT\n);\n\nCREATE TABLE IF NOT EXISTS booking (\n    id           SERIAL PRIMARY KEY,\n    user_uid     TEXT NOT NULL,\n    media_uid    TEXT,\n    media_booker TEXT,\n    task         TEXT,\n    price        INTEGER NOT NULL DEFAULT 0,\n    credits      INTEGER NOT NULL DEFAULT 0,\n    is_active    BOOLEAN NOT NULL DEFAULT false,\n    is_completed BOOLEAN NOT NULL DEFAULT false,\n    date_start   TIMESTAMPTZ,\n    date_end     TIMESTAMPTZ,\n    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),\n    lat          TEXT,\n    lng          TEXT\n);\n\nCREATE INDEX IF NOT EXISTS booking_user_uid_idx ON booking (user_uid);\n",
+	"0002_booking_status.down.sql":           "DROP TABLE booking_transition;\nALTER TABLE booking DROP COLUMN status;\n",
+	"0002_booking_status.up.sql":             "ALTER TABLE booking ADD COLUMN status TEXT NOT NULL DEFAULT 'requested'\n    CHECK (status IN ('requested', 'accepted', 'in_progress', 'delivered', 'approved', 'invoiced', 'cancelled', 'declined', 'expired'));\n\nUPDATE booking SET status = CASE\n    WHEN is_completed THEN 'delivered'\n    WHEN is_active THEN 'accepted'\n    ELSE 'requested'\nEND;\n\nCREATE TABLE booking_transition (\n    id          SERIAL PRIMARY KEY,\n    booking_id  INTEGER NOT NULL REFERENCES booking (id) ON DELETE CASCADE,\n    from_status TEXT NOT NULL,\n    to_status   TEXT NOT NULL,\n    actor_uid   TEXT NOT NULL DEFAULT '',\n    note        TEXT NOT NULL DEFAULT '',\n    created_at  TIMESTAMPTZ NOT NULL DEFAULT now()\n);\n\nCREATE INDEX booking_transition_booking_id_idx ON booking_transition (booking_id, created_at);\n",
+	"0003_availability.down.sql":             "DROP INDEX booking_user_uid_period_idx;\nDROP TABLE blocked_period;\nDROP TABLE professional_hours;\n",
+	"0003_availability.up.sql":               "CREATE TABLE professional_hours (\n    user_uid     TEXT NOT NULL,\n    weekday      SMALLINT NOT NULL CHECK (weekday BETWEEN 0 AND 6),\n    start_minute SMALLINT NOT NULL CHECK (start_minute >= 0),\n    end_minute   SMALLINT NOT NULL CHECK (end_minute <= 1440),\n    time_zone    TEXT NOT NULL DEFAULT 'UTC',\n    CHECK (start_minute < end_minute)\n);\n\nCREATE INDEX professional_hours_user_uid_idx ON professional_hours (user_uid);\n\nCREATE TABLE blocked_period (\n    id         SERIAL PRIMARY KEY,\n    user_uid   TEXT NOT NULL,\n    date_start TIMESTAMPTZ NOT NULL,\n    date_end   TIMESTAMPTZ NOT NULL,\n    reason     TEXT NOT NULL DEFAULT '',\n    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),\n    CHECK (date_start < date_end)\n);\n\nCREATE INDEX blocked_period_user_uid_idx ON blocked_period (user_uid, date_start, date_end);\nCREATE INDEX booking_user_uid_period_idx ON booking (user_uid, date_start, date_end);\n",
+	"0004_calendar_token.down.sql":           "DROP TABLE calendar_token;\n",
+	"0004_calendar_token.up.sql":             "CREATE TABLE calendar_token (\n    user_uid   TEXT PRIMARY KEY,\n    token      TEXT NOT NULL UNIQUE,\n    created_at TIMESTAMPTZ NOT NULL DEFAULT now()\n);\n",
+	"0005_geo.down.sql":                      "DROP INDEX professional_lat_lng_idx;\nALTER TABLE professional\n    DROP COLUMN lat,\n    DROP COLUMN lng,\n    DROP COLUMN location_updated_at;\n\nDROP INDEX booking_lat_lng_idx;\nALTER TABLE booking\n    ALTER COLUMN lat TYPE TEXT USING lat::text,\n    ALTER COLUMN lng TYPE TEXT USING lng::text;\n",
+	"0005_geo.up.sql":                        "ALTER TABLE booking\n    ALTER COLUMN lat TYPE DOUBLE PRECISION USING NULLIF(trim(lat::text), '')::double precision,\n    ALTER COLUMN lng TYPE DOUBLE PRECISION USING NULLIF(trim(lng::text), '')::double precision;\n\nCREATE INDEX booking_lat_lng_idx ON booking (lat, lng);\n\nALTER TABLE professional\n    ADD COLUMN lat DOUBLE PRECISION,\n    ADD COLUMN lng DOUBLE PRECISION,\n    ADD COLUMN location_updated_at TIMESTAMPTZ;\n\nCREATE INDEX professional_lat_lng_idx ON professional (lat, lng);\n",
+	"0006_booking_deliverable.down.sql":      "DROP TABLE booking_deliverable;\n",
+	"0006_booking_deliverable.up.sql":        "CREATE TABLE booking_deliverable (\n    id           SERIAL PRIMARY KEY,\n    booking_id   INTEGER NOT NULL REFERENCES booking (id) ON DELETE CASCADE,\n    key          TEXT NOT NULL UNIQUE,\n    file_name    TEXT NOT NULL DEFAULT '',\n    size         BIGINT NOT NULL DEFAULT 0,\n    sha256       TEXT NOT NULL DEFAULT '',\n    content_type TEXT NOT NULL DEFAULT '',\n    created_at   TIMESTAMPTZ NOT NULL DEFAULT now()\n);\n\nCREATE INDEX booking_deliverable_booking_id_idx ON booking_deliverable (booking_id);\n",
+	"0007_deliverable_processing.down.sql":   "ALTER TABLE booking_deliverable\n    DROP COLUMN exif,\n    DROP COLUMN thumbnail_key,\n    DROP COLUMN processing_error,\n    DROP COLUMN processed_at;\n",
+	"0007_deliverable_processing.up.sql":     "ALTER TABLE booking_deliverable\n    ADD COLUMN exif             JSONB,\n    ADD COLUMN thumbnail_key    TEXT NOT NULL DEFAULT '',\n    ADD COLUMN processing_error TEXT NOT NULL DEFAULT '',\n    ADD COLUMN processed_at     TIMESTAMPTZ;\n",
+	"0008_media_spec.down.sql":               "DROP TABLE media_spec;\n",
+	"0008_media_spec.up.sql":                 "CREATE TABLE media_spec (\n    name       TEXT PRIMARY KEY,\n    media_uid  TEXT NOT NULL,\n    rules      JSONB NOT NULL DEFAULT '{}',\n    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),\n    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()\n);\n\nCREATE INDEX media_spec_media_uid_idx ON media_spec (media_uid);\n",
+	"0009_deliverable_phash.down.sql":        "ALTER TABLE booking_deliverable DROP COLUMN phash;\n",
+	"0009_deliverable_phash.up.sql":          "-- dHash of images, compared by hamming distance to find the same picture in other uploads\nALTER TABLE booking_deliverable ADD COLUMN phash BIGINT;\n",
+	"0010_deliverable_derivatives.down.sql":  "ALTER TABLE booking_deliverable DROP COLUMN derivatives;\n",
+	"0010_deliverable_derivatives.up.sql":    "ALTER TABLE booking_deliverable ADD COLUMN derivatives JSONB;\n",
+	"0011_media_watermark.down.sql":          "DROP TABLE media_watermark;\n",
+	"0011_media_watermark.up.sql":            "CREATE TABLE media_watermark (\n    media_uid  TEXT PRIMARY KEY,\n    watermark  JSONB NOT NULL,\n    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),\n    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()\n);\n",
+	"0012_deliverable_placeholder.down.sql":  "ALTER TABLE booking_deliverable DROP COLUMN blurhash, DROP COLUMN palette;\n",
+	"0012_deliverable_placeholder.up.sql":    "ALTER TABLE booking_deliverable ADD COLUMN blurhash TEXT, ADD COLUMN palette JSONB;\n",
+	"0013_media_spec_scope.down.sql":         "-- Specs are only unique per media, so names shared by two media can't go back to being the key.\nDO $$\nBEGIN\n    IF EXISTS (SELECT 1 FROM media_spec GROUP BY name HAVING count(*) > 1) THEN\n        RAISE EXCEPTION 'media_spec has names used by more than one media, rename them before reverting';\n    END IF;\nEND\n$$;\nCREATE INDEX media_spec_media_uid_idx ON media_spec (media_uid);\nALTER TABLE media_spec DROP CONSTRAINT media_spec_pkey;\nALTER TABLE media_spec ADD PRIMARY KEY (name);\n",
+	"0013_media_spec_scope.up.sql":           "ALTER TABLE media_spec DROP CONSTRAINT media_spec_pkey;\nALTER TABLE media_spec ADD PRIMARY KEY (media_uid, name);\nDROP INDEX media_spec_media_uid_idx;\n",
+	"0014_booking_metadata_consent.down.sql": "ALTER TABLE booking DROP COLUMN metadata_consent;\n",
+	"0014_booking_metadata_consent.up.sql":   "ALTER TABLE booking ADD COLUMN metadata_consent TEXT NOT NULL DEFAULT '';\n",
+}
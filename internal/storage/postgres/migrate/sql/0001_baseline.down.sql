@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS booking;
+DROP TABLE IF EXISTS professional;
@@ -0,0 +1,27 @@
+-- Tables the service was running on before migrations were tracked.
+-- IF NOT EXISTS lets existing databases adopt the migration history.
+CREATE TABLE IF NOT EXISTS professional (
+    id         SERIAL PRIMARY KEY,
+    user_uid   TEXT NOT NULL UNIQUE,
+    pro_level  INTEGER NOT NULL DEFAULT 0,
+    email      TEXT
+);
+
+CREATE TABLE IF NOT EXISTS booking (
+    id           SERIAL PRIMARY KEY,
+    user_uid     TEXT NOT NULL,
+    media_uid    TEXT,
+    media_booker TEXT,
+    task         TEXT,
+    price        INTEGER NOT NULL DEFAULT 0,
+    credits      INTEGER NOT NULL DEFAULT 0,
+    is_active    BOOLEAN NOT NULL DEFAULT false,
+    is_completed BOOLEAN NOT NULL DEFAULT false,
+    date_start   TIMESTAMPTZ,
+    date_end     TIMESTAMPTZ,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    lat          TEXT,
+    lng          TEXT
+);
+
+CREATE INDEX IF NOT EXISTS booking_user_uid_idx ON booking (user_uid);
@@ -0,0 +1,2 @@
+DROP TABLE booking_transition;
+ALTER TABLE booking DROP COLUMN status;
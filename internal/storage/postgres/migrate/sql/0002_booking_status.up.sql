@@ -0,0 +1,20 @@
+ALTER TABLE booking ADD COLUMN status TEXT NOT NULL DEFAULT 'requested'
+    CHECK (status IN ('requested', 'accepted', 'in_progress', 'delivered', 'approved', 'invoiced', 'cancelled', 'declined', 'expired'));
+
+UPDATE booking SET status = CASE
+    WHEN is_completed THEN 'delivered'
+    WHEN is_active THEN 'accepted'
+    ELSE 'requested'
+END;
+
+CREATE TABLE booking_transition (
+    id          SERIAL PRIMARY KEY,
+    booking_id  INTEGER NOT NULL REFERENCES booking (id) ON DELETE CASCADE,
+    from_status TEXT NOT NULL,
+    to_status   TEXT NOT NULL,
+    actor_uid   TEXT NOT NULL DEFAULT '',
+    note        TEXT NOT NULL DEFAULT '',
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX booking_transition_booking_id_idx ON booking_transition (booking_id, created_at);
@@ -0,0 +1,3 @@
+DROP INDEX booking_user_uid_period_idx;
+DROP TABLE blocked_period;
+DROP TABLE professional_hours;
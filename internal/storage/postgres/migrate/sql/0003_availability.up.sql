@@ -0,0 +1,23 @@
+CREATE TABLE professional_hours (
+    user_uid     TEXT NOT NULL,
+    weekday      SMALLINT NOT NULL CHECK (weekday BETWEEN 0 AND 6),
+    start_minute SMALLINT NOT NULL CHECK (start_minute >= 0),
+    end_minute   SMALLINT NOT NULL CHECK (end_minute <= 1440),
+    time_zone    TEXT NOT NULL DEFAULT 'UTC',
+    CHECK (start_minute < end_minute)
+);
+
+CREATE INDEX professional_hours_user_uid_idx ON professional_hours (user_uid);
+
+CREATE TABLE blocked_period (
+    id         SERIAL PRIMARY KEY,
+    user_uid   TEXT NOT NULL,
+    date_start TIMESTAMPTZ NOT NULL,
+    date_end   TIMESTAMPTZ NOT NULL,
+    reason     TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CHECK (date_start < date_end)
+);
+
+CREATE INDEX blocked_period_user_uid_idx ON blocked_period (user_uid, date_start, date_end);
+CREATE INDEX booking_user_uid_period_idx ON booking (user_uid, date_start, date_end);
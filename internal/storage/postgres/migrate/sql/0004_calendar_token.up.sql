@@ -0,0 +1,5 @@
+CREATE TABLE calendar_token (
+    user_uid   TEXT PRIMARY KEY,
+    token      TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,10 @@
+DROP INDEX professional_lat_lng_idx;
+ALTER TABLE professional
+    DROP COLUMN lat,
+    DROP COLUMN lng,
+    DROP COLUMN location_updated_at;
+
+DROP INDEX booking_lat_lng_idx;
+ALTER TABLE booking
+    ALTER COLUMN lat TYPE TEXT USING lat::text,
+    ALTER COLUMN lng TYPE TEXT USING lng::text;
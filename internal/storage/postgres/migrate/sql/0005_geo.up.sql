@@ -0,0 +1,12 @@
+ALTER TABLE booking
+    ALTER COLUMN lat TYPE DOUBLE PRECISION USING NULLIF(trim(lat::text), '')::double precision,
+    ALTER COLUMN lng TYPE DOUBLE PRECISION USING NULLIF(trim(lng::text), '')::double precision;
+
+CREATE INDEX booking_lat_lng_idx ON booking (lat, lng);
+
+ALTER TABLE professional
+    ADD COLUMN lat DOUBLE PRECISION,
+    ADD COLUMN lng DOUBLE PRECISION,
+    ADD COLUMN location_updated_at TIMESTAMPTZ;
+
+CREATE INDEX professional_lat_lng_idx ON professional (lat, lng);
@@ -0,0 +1,12 @@
+CREATE TABLE booking_deliverable (
+    id           SERIAL PRIMARY KEY,
+    booking_id   INTEGER NOT NULL REFERENCES booking (id) ON DELETE CASCADE,
+    key          TEXT NOT NULL UNIQUE,
+    file_name    TEXT NOT NULL DEFAULT '',
+    size         BIGINT NOT NULL DEFAULT 0,
+    sha256       TEXT NOT NULL DEFAULT '',
+    content_type TEXT NOT NULL DEFAULT '',
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX booking_deliverable_booking_id_idx ON booking_deliverable (booking_id);
@@ -0,0 +1,5 @@
+ALTER TABLE booking_deliverable
+    DROP COLUMN exif,
+    DROP COLUMN thumbnail_key,
+    DROP COLUMN processing_error,
+    DROP COLUMN processed_at;
@@ -0,0 +1,5 @@
+ALTER TABLE booking_deliverable
+    ADD COLUMN exif             JSONB,
+    ADD COLUMN thumbnail_key    TEXT NOT NULL DEFAULT '',
+    ADD COLUMN processing_error TEXT NOT NULL DEFAULT '',
+    ADD COLUMN processed_at     TIMESTAMPTZ;
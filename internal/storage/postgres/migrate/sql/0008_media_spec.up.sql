@@ -0,0 +1,9 @@
+CREATE TABLE media_spec (
+    name       TEXT PRIMARY KEY,
+    media_uid  TEXT NOT NULL,
+    rules      JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX media_spec_media_uid_idx ON media_spec (media_uid);
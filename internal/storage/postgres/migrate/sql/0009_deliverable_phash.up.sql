@@ -0,0 +1,2 @@
+-- dHash of images, compared by hamming distance to find the same picture in other uploads
+ALTER TABLE booking_deliverable ADD COLUMN phash BIGINT;
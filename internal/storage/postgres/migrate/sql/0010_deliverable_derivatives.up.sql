@@ -0,0 +1 @@
+ALTER TABLE booking_deliverable ADD COLUMN derivatives JSONB;
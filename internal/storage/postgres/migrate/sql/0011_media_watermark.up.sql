@@ -0,0 +1,6 @@
+CREATE TABLE media_watermark (
+    media_uid  TEXT PRIMARY KEY,
+    watermark  JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
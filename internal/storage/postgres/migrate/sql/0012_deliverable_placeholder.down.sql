@@ -0,0 +1 @@
+ALTER TABLE booking_deliverable DROP COLUMN blurhash, DROP COLUMN palette;
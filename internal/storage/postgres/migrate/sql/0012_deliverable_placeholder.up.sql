@@ -0,0 +1 @@
+ALTER TABLE booking_deliverable ADD COLUMN blurhash TEXT, ADD COLUMN palette JSONB;
@@ -0,0 +1,11 @@
+-- Specs are only unique per media, so names shared by two media can't go back to being the key.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM media_spec GROUP BY name HAVING count(*) > 1) THEN
+        RAISE EXCEPTION 'media_spec has names used by more than one media, rename them before reverting';
+    END IF;
+END
+$$;
+CREATE INDEX media_spec_media_uid_idx ON media_spec (media_uid);
+ALTER TABLE media_spec DROP CONSTRAINT media_spec_pkey;
+ALTER TABLE media_spec ADD PRIMARY KEY (name);
@@ -0,0 +1,3 @@
+ALTER TABLE media_spec DROP CONSTRAINT media_spec_pkey;
+ALTER TABLE media_spec ADD PRIMARY KEY (media_uid, name);
+DROP INDEX media_spec_media_uid_idx;
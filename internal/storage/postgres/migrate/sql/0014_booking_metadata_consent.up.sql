@@ -0,0 +1 @@
+ALTER TABLE booking ADD COLUMN metadata_consent TEXT NOT NULL DEFAULT '';
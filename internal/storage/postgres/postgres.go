@@ -13,6 +13,7 @@ import (
 
 	"github.com/blixenkrone/gopro/internal/storage"
 	"github.com/blixenkrone/gopro/pkg/logger"
+	timeutil "github.com/blixenkrone/gopro/pkg/time"
 	_ "github.com/lib/pq"
 )
 
@@ -28,6 +29,16 @@ type Postgres struct {
 
 // NewPQ Starts ORM
 func NewPQ() (storage.PQService, error) {
+	db, err := Open()
+	if err != nil {
+		return nil, err
+	}
+	log.Infoln("Started psql DB")
+	return &Postgres{db}, nil
+}
+
+// Open connects to the database in the POSTGRES_CONNSTR environment variable
+func Open() (*sql.DB, error) {
 	connStr, ok := os.LookupEnv("POSTGRES_CONNSTR")
 	if !ok {
 		return nil, errors.New("Error opening postgress connstr from environment variable")
@@ -40,23 +51,59 @@ func NewPQ() (storage.PQService, error) {
 	if err != nil {
 		return nil, err
 	}
-	log.Infoln("Started psql DB")
-	return &Postgres{db}, nil
+	return db, nil
 }
 
 /** BOOKING ENDPOINTS */
 
-// CreateBooking is being made from the media client
+// bookingColumns are the booking columns in the order they are scanned into storage.Booking
+var bookingColumns = []string{"id", "user_uid", "media_uid", "media_booker", "task", "price", "credits", "is_active", "is_completed", "date_start", "date_end", "created_at", "lat", "lng", "status", "metadata_consent"}
+
+func scanBooking(row squirrel.RowScanner) (*storage.Booking, error) {
+	var b storage.Booking
+	err := row.Scan(&b.ID, &b.UserUID, &b.MediaUID, &b.MediaBooker, &b.Task, &b.Price, &b.Credits, &b.IsActive, &b.IsCompleted, &b.DateStart, &b.DateEnd, &b.CreatedAt, &b.Lat, &b.Lng, &b.Status, &b.MetadataConsent)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// CreateBooking is being made from the media client.
+// It fails with storage.ErrBookingOverlap if the professional is already booked or blocked in the period.
 func (p *Postgres) CreateBooking(ctx context.Context, proUID string, b storage.Booking) (bookingID string, err error) {
-	sb := qb.RunWith(p.DB)
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Errorf("Rollback error: %s", err)
+		}
+	}()
+
+	if err := lockProfessional(ctx, tx, proUID); err != nil {
+		return "", err
+	}
+	busy, err := busyPeriods(ctx, tx, proUID, timeutil.Period{Start: *b.DateStart, End: *b.DateEnd})
+	if err != nil {
+		return "", err
+	}
+	if len(busy) > 0 {
+		return "", storage.ErrBookingOverlap
+	}
+
+	sb := qb.RunWith(tx)
 	err = sb.Insert("booking").Columns(
-		"user_uid", "media_uid", "media_booker", "task", "price", "credits", "date_start", "date_end", "lat", "lng").Values(
-		proUID, &b.MediaUID, &b.MediaBooker, &b.Task, &b.Price, &b.Credits, &b.DateStart, &b.DateEnd, &b.Lat, &b.Lng,
+		"user_uid", "media_uid", "media_booker", "task", "price", "credits", "date_start", "date_end", "lat", "lng", "status").Values(
+		proUID, &b.MediaUID, &b.MediaBooker, &b.Task, &b.Price, &b.Credits, &b.DateStart, &b.DateEnd, &b.Lat, &b.Lng, storage.BookingRequested,
 	).Suffix("RETURNING id").QueryRowContext(ctx).Scan(&bookingID)
 	if err != nil {
 		log.Errorf("Insert error: %s", err)
 		return "", err
 	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
 	return bookingID, nil
 }
 
@@ -64,32 +111,41 @@ func (p *Postgres) CreateBooking(ctx context.Context, proUID string, b storage.B
 func (p *Postgres) GetBookingsByUID(ctx context.Context, proID string) ([]*storage.Booking, error) {
 	var bookings []*storage.Booking
 	sb := qb.RunWith(p.DB)
-	rows, err := sb.Select("*").From("booking").Where("user_uid = ?", proID).OrderBy("created_at DESC").QueryContext(ctx)
+	rows, err := sb.Select(bookingColumns...).From("booking").Where("user_uid = ?", proID).OrderBy("created_at DESC").QueryContext(ctx)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var b storage.Booking
-		err := rows.Scan(&b.ID, &b.UserUID, &b.MediaUID, &b.MediaBooker, &b.Task, &b.Price, &b.Credits, &b.IsActive, &b.IsCompleted, &b.DateStart, &b.DateEnd, &b.CreatedAt, &b.Lat, &b.Lng)
+		b, err := scanBooking(rows)
 		if err != nil {
 			return nil, err
 		}
-		if err := p.HandleRowError(err); err != nil {
-			return nil, err
-		}
-		bookings = append(bookings, &b)
+		bookings = append(bookings, b)
+	}
+	if err := p.HandleRowError(rows.Err()); err != nil {
+		return nil, err
 	}
 	return bookings, nil
 }
 
-// UpdateBooking -
+// GetBooking returns a single booking, or sql.ErrNoRows
+func (p *Postgres) GetBooking(ctx context.Context, bookingID string) (*storage.Booking, error) {
+	sb := qb.RunWith(p.DB)
+	row := sb.Select(bookingColumns...).From("booking").Where("id = ?", bookingID).QueryRowContext(ctx)
+	b, err := scanBooking(row)
+	if err := p.HandleRowError(err); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// UpdateBooking updates the editable fields of a booking.
+// The status is changed through TransitionBooking only.
 func (p *Postgres) UpdateBooking(ctx context.Context, b *storage.Booking) error {
 	sb := qb.RunWith(p.DB)
 	_, err := sb.Update("booking").
-		Set("is_active", &b.IsActive).
-		Set("is_completed", &b.IsCompleted).
 		Set("task", &b.Task).
 		Where("id = ?", &b.ID).ExecContext(ctx)
 	if err != nil {
@@ -98,6 +154,26 @@ func (p *Postgres) UpdateBooking(ctx context.Context, b *storage.Booking) error
 	return nil
 }
 
+// SetBookingConsent stores the metadata the professional consents to keep in downloads of the booking,
+// or returns sql.ErrNoRows
+func (p *Postgres) SetBookingConsent(ctx context.Context, bookingID, consent string) error {
+	sb := qb.RunWith(p.DB)
+	res, err := sb.Update("booking").
+		Set("metadata_consent", consent).
+		Where("id = ?", bookingID).ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
 // DeleteBooking -
 func (p *Postgres) DeleteBooking(ctx context.Context, bookingID string) error {
 	sb := qb.RunWith(p.DB)
@@ -158,6 +234,47 @@ func (p *Postgres) GetProfile(ctx context.Context, id string) (*storage.Professi
 	return &pro, nil
 }
 
+// GetProfessionals returns all professionals
+func (p *Postgres) GetProfessionals(ctx context.Context) ([]*storage.Professional, error) {
+	var pros []*storage.Professional
+	sb := qb.RunWith(p.DB)
+	rows, err := sb.Select("id", "user_uid", "pro_level", "COALESCE(email, '')").From("professional").
+		OrderBy("id").QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var pro storage.Professional
+		if err := rows.Scan(&pro.ID, &pro.UserUID, &pro.ProLevel, &pro.Email); err != nil {
+			return nil, err
+		}
+		pros = append(pros, &pro)
+	}
+	if err := p.HandleRowError(rows.Err()); err != nil {
+		return nil, err
+	}
+	return pros, nil
+}
+
+// UpsertProfessional creates the professional or updates the email and pro level of an existing one.
+// A zero pro level never overwrites a level that is already set.
+func (p *Postgres) UpsertProfessional(ctx context.Context, pro *storage.Professional) (created bool, err error) {
+	sb := qb.RunWith(p.DB)
+	err = sb.Insert("professional").Columns("user_uid", "pro_level", "email").
+		Values(pro.UserUID, pro.ProLevel, pro.Email).
+		Suffix(`ON CONFLICT (user_uid) DO UPDATE SET
+			email = EXCLUDED.email,
+			pro_level = CASE WHEN EXCLUDED.pro_level > 0 THEN EXCLUDED.pro_level ELSE professional.pro_level END
+		RETURNING id, (xmax = 0)`).
+		QueryRowContext(ctx).Scan(&pro.ID, &created)
+	if err != nil {
+		return false, err
+	}
+	return created, nil
+}
+
 // GetProProfileByEmail -
 func (p *Postgres) GetProProfileByEmail(ctx context.Context, email string) (*storage.Professional, error) {
 	var pro storage.Professional
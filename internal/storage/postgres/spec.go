@@ -0,0 +1,93 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	squirrel "github.com/Masterminds/squirrel"
+
+	"github.com/blixenkrone/gopro/pkg/exif/spec"
+)
+
+func scanSpec(row squirrel.RowScanner) (*spec.Spec, error) {
+	var s spec.Spec
+	var rules []byte
+	if err := row.Scan(&s.Name, &s.MediaUID, &rules, &s.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(rules, &s.Rules); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// GetMediaSpec returns the spec of a media customer with name, or sql.ErrNoRows
+func (p *Postgres) GetMediaSpec(ctx context.Context, mediaUID, name string) (*spec.Spec, error) {
+	sb := qb.RunWith(p.DB)
+	row := sb.Select("name", "media_uid", "rules", "updated_at").
+		From("media_spec").
+		Where("media_uid = ? AND name = ?", mediaUID, name).QueryRowContext(ctx)
+	s, err := scanSpec(row)
+	if err := p.HandleRowError(err); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// GetMediaSpecs returns the specs of a media customer by name
+func (p *Postgres) GetMediaSpecs(ctx context.Context, mediaUID string) ([]*spec.Spec, error) {
+	var specs []*spec.Spec
+	sb := qb.RunWith(p.DB)
+	rows, err := sb.Select("name", "media_uid", "rules", "updated_at").
+		From("media_spec").
+		Where("media_uid = ?", mediaUID).
+		OrderBy("name ASC").QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		s, err := scanSpec(rows)
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, s)
+	}
+	if err := p.HandleRowError(rows.Err()); err != nil {
+		return nil, err
+	}
+	return specs, nil
+}
+
+// UpsertMediaSpec creates or replaces the rules of a spec of a media customer
+func (p *Postgres) UpsertMediaSpec(ctx context.Context, s *spec.Spec) error {
+	rules, err := json.Marshal(s.Rules)
+	if err != nil {
+		return err
+	}
+	sb := qb.RunWith(p.DB)
+	return sb.Insert("media_spec").Columns("name", "media_uid", "rules").
+		Values(s.Name, s.MediaUID, string(rules)).
+		Suffix(`ON CONFLICT (media_uid, name) DO UPDATE SET rules = EXCLUDED.rules, updated_at = now()
+		RETURNING updated_at`).QueryRowContext(ctx).Scan(&s.UpdatedAt)
+}
+
+// DeleteMediaSpec removes a spec of a media customer, or returns sql.ErrNoRows
+func (p *Postgres) DeleteMediaSpec(ctx context.Context, mediaUID, name string) error {
+	sb := qb.RunWith(p.DB)
+	res, err := sb.Delete("media_spec").
+		Where("name = ? AND media_uid = ?", name, mediaUID).ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
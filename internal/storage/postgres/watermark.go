@@ -0,0 +1,58 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/blixenkrone/gopro/pkg/image/watermark"
+)
+
+// GetMediaWatermark returns the watermark profile of a media customer, or sql.ErrNoRows
+func (p *Postgres) GetMediaWatermark(ctx context.Context, mediaUID string) (*watermark.Watermark, error) {
+	var b []byte
+	sb := qb.RunWith(p.DB)
+	err := sb.Select("watermark").
+		From("media_watermark").
+		Where("media_uid = ?", mediaUID).QueryRowContext(ctx).Scan(&b)
+	if err := p.HandleRowError(err); err != nil {
+		return nil, err
+	}
+	var wm watermark.Watermark
+	if err := json.Unmarshal(b, &wm); err != nil {
+		return nil, err
+	}
+	return &wm, nil
+}
+
+// UpsertMediaWatermark creates or replaces the watermark profile of a media customer
+func (p *Postgres) UpsertMediaWatermark(ctx context.Context, mediaUID string, wm *watermark.Watermark) error {
+	b, err := json.Marshal(wm)
+	if err != nil {
+		return err
+	}
+	sb := qb.RunWith(p.DB)
+	_, err = sb.Insert("media_watermark").Columns("media_uid", "watermark").
+		Values(mediaUID, string(b)).
+		Suffix("ON CONFLICT (media_uid) DO UPDATE SET watermark = EXCLUDED.watermark, updated_at = now()").
+		ExecContext(ctx)
+	return err
+}
+
+// DeleteMediaWatermark removes the watermark profile of a media customer, or returns sql.ErrNoRows
+func (p *Postgres) DeleteMediaWatermark(ctx context.Context, mediaUID string) error {
+	sb := qb.RunWith(p.DB)
+	res, err := sb.Delete("media_watermark").
+		Where("media_uid = ?", mediaUID).ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -3,24 +3,107 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"io"
 	"time"
 
 	"firebase.google.com/go/auth"
+
+	"github.com/blixenkrone/gopro/pkg/exif"
+	"github.com/blixenkrone/gopro/pkg/exif/spec"
+	"github.com/blixenkrone/gopro/pkg/geo"
+	"github.com/blixenkrone/gopro/pkg/image/palette"
+	"github.com/blixenkrone/gopro/pkg/image/phash"
+	"github.com/blixenkrone/gopro/pkg/image/watermark"
+	timeutil "github.com/blixenkrone/gopro/pkg/time"
+)
+
+var (
+	// ErrIllegalTransition is returned when a booking is moved to a status its current status does not allow
+	ErrIllegalTransition = errors.New("illegal booking status transition")
+	// ErrUnknownBookingStatus is returned when a status is not part of the booking lifecycle
+	ErrUnknownBookingStatus = errors.New("unknown booking status")
+	// ErrBookingOverlap is returned when a professional is already booked or blocked in the requested period
+	ErrBookingOverlap = errors.New("professional is not available in the requested period")
+	// ErrBlobNotFound is returned by a BlobStore when no object exists for a key
+	ErrBlobNotFound = errors.New("blob not found")
 )
 
 type PQService interface {
 	GetBookingsByUID(ctx context.Context, proID string) ([]*Booking, error)
+	GetBooking(ctx context.Context, bookingID string) (*Booking, error)
 	CreateBooking(ctx context.Context, uid string, b Booking) (string, error)
 	UpdateBooking(ctx context.Context, b *Booking) error
 	DeleteBooking(ctx context.Context, bookingID string) error
+	TransitionBooking(ctx context.Context, bookingID string, t BookingTransition) (*BookingTransition, error)
+	GetBookingTransitions(ctx context.Context, bookingID string) ([]*BookingTransition, error)
+	SetBookingConsent(ctx context.Context, bookingID, consent string) error
 	GetBookingsAdmin(ctx context.Context) ([]*AdminBookings, error)
 	GetProfile(ctx context.Context, id string) (*Professional, error)
+	GetAvailability(ctx context.Context, proUID string, from, to time.Time) (*Availability, error)
+	SetWorkingHours(ctx context.Context, proUID string, hours []*WorkingHours) error
+	CreateBlockedPeriod(ctx context.Context, proUID string, b BlockedPeriod) (string, error)
+	GetCalendarToken(ctx context.Context, proUID string) (string, error)
+	RotateCalendarToken(ctx context.Context, proUID string) (string, error)
+	GetUIDByCalendarToken(ctx context.Context, token string) (string, error)
+	GetBookingsNear(ctx context.Context, p geo.Point, radiusKm float64) ([]*NearbyBooking, error)
+	GetProfessionalsNear(ctx context.Context, p geo.Point, radiusKm float64, available *timeutil.Period) ([]*NearbyProfessional, error)
+	UpdateProfessionalLocation(ctx context.Context, proUID string, p geo.Point) error
+	GetProfessionals(ctx context.Context) ([]*Professional, error)
+	UpsertProfessional(ctx context.Context, pro *Professional) (created bool, err error)
+	CreateDeliverable(ctx context.Context, d *Deliverable) error
+	GetDeliverables(ctx context.Context, bookingID string) ([]*Deliverable, error)
+	SetDeliverableProcessed(ctx context.Context, d *Deliverable) error
+	GetSimilarDeliverables(ctx context.Context, h phash.Hash, maxDistance int) ([]*SimilarDeliverable, error)
+	GetDuplicateDeliverables(ctx context.Context, maxDistance int) ([]*DuplicateDeliverables, error)
+	GetMediaSpec(ctx context.Context, mediaUID, name string) (*spec.Spec, error)
+	GetMediaSpecs(ctx context.Context, mediaUID string) ([]*spec.Spec, error)
+	UpsertMediaSpec(ctx context.Context, s *spec.Spec) error
+	DeleteMediaSpec(ctx context.Context, mediaUID, name string) error
+	GetMediaWatermark(ctx context.Context, mediaUID string) (*watermark.Watermark, error)
+	UpsertMediaWatermark(ctx context.Context, mediaUID string, wm *watermark.Watermark) error
+	DeleteMediaWatermark(ctx context.Context, mediaUID string) error
 	Close() error
 	Ping() error
 	HandleRowError(error) error
 	CancelRowsError(*sql.Rows) error
 }
 
+// BlobStore stores files such as booking deliverables by key.
+// Keys are slash separated paths without a leading slash, i.e. booking/42/photo.jpg
+type BlobStore interface {
+	Put(ctx context.Context, key string, r io.Reader, opts PutOptions) (*BlobInfo, error)
+	Get(ctx context.Context, key string) (io.ReadCloser, *BlobInfo, error)
+	// GetRange returns length bytes of the object from offset, which the caller must close
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	Stat(ctx context.Context, key string) (*BlobInfo, error)
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context, prefix string) ([]*BlobInfo, error)
+	SignedURL(ctx context.Context, key string, opts SignOptions) (string, error)
+}
+
+// BlobInfo describes a stored object
+type BlobInfo struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ContentType  string    `json:"contentType,omitempty"`
+	LastModified time.Time `json:"lastModified,omitempty"`
+}
+
+// PutOptions are optional properties of a stored object
+type PutOptions struct {
+	ContentType string
+}
+
+// SignOptions describe what a signed url grants access to
+type SignOptions struct {
+	// Method is http.MethodGet or http.MethodPut
+	Method  string
+	Expires time.Duration
+	// ContentType the client must send when uploading with a PUT url
+	ContentType string
+}
+
 // FBService contains firebase methods
 type FBService interface {
 	GetTransactions() ([]*Transaction, error)
@@ -41,27 +124,151 @@ type FBService interface {
 
 // Professional user class
 type Professional struct {
-	ID       string `json:"id" sql:"id"`
-	UserUID  string `json:"userUID" sql:"user_uid"`
-	ProLevel int    `json:"proLevel" sql:"pro_level"`
+	ID                string         `json:"id" sql:"id"`
+	UserUID           string         `json:"userUID" sql:"user_uid"`
+	ProLevel          int            `json:"proLevel" sql:"pro_level"`
+	Email             string         `json:"email,omitempty" sql:"email"`
+	Lat               geo.Coordinate `json:"lat,omitempty" sql:"lat"`
+	Lng               geo.Coordinate `json:"lng,omitempty" sql:"lng"`
+	LocationUpdatedAt *time.Time     `json:"locationUpdatedAt,omitempty" sql:"location_updated_at"`
+}
+
+// NearbyProfessional is a professional with the distance to a searched point
+type NearbyProfessional struct {
+	*Professional
+	DistanceKm float64 `json:"distanceKm"`
 }
 
+// BookingStatus is the lifecycle state of a booking
+type BookingStatus string
+
+// The booking lifecycle: requested -> accepted -> in_progress -> delivered -> approved -> invoiced.
+// Cancelled, declined and expired are terminal side exits.
+const (
+	BookingRequested  BookingStatus = "requested"
+	BookingAccepted   BookingStatus = "accepted"
+	BookingInProgress BookingStatus = "in_progress"
+	BookingDelivered  BookingStatus = "delivered"
+	BookingApproved   BookingStatus = "approved"
+	BookingInvoiced   BookingStatus = "invoiced"
+	BookingCancelled  BookingStatus = "cancelled"
+	BookingDeclined   BookingStatus = "declined"
+	BookingExpired    BookingStatus = "expired"
+)
+
 // Booking repræsents a professional user appointment from a media
 type Booking struct {
+	ID          string         `json:"id,omitempty" sql:"id"`
+	MediaUID    string         `json:"mediaUID,omitempty" sql:"media_uid"`
+	MediaBooker string         `json:"mediaBooker,omitempty" sql:"media_booker"`
+	UserUID     string         `json:"userUID,omitempty" sql:"user_uid"`
+	Task        string         `json:"task,omitempty"`
+	Price       int            `json:"price,omitempty"`
+	Credits     int            `json:"credits,omitempty"`
+	IsActive    bool           `json:"isActive,omitempty" sql:"is_active"`
+	IsCompleted bool           `json:"isCompleted,omitempty" sql:"is_completed"`
+	Status      BookingStatus  `json:"status,omitempty" sql:"status"`
+	DateStart   *time.Time     `json:"dateStart,omitempty" sql:"date_start"`
+	DateEnd     *time.Time     `json:"dateEnd,omitempty" sql:"date_end"`
+	CreatedAt   *time.Time     `json:"createdAt,omitempty" sql:"created_at"`
+	Lng         geo.Coordinate `json:"lng,omitempty" sql:"lng"`
+	Lat         geo.Coordinate `json:"lat,omitempty" sql:"lat"`
+	// MetadataConsent is the comma separated metadata the professional lets the media keep in downloads,
+	// e.g. gps,names
+	MetadataConsent string `json:"metadataConsent,omitempty" sql:"metadata_consent"`
+}
+
+// NearbyBooking is a booking with the distance to a searched point
+type NearbyBooking struct {
+	*Booking
+	DistanceKm float64 `json:"distanceKm"`
+}
+
+// BookingTransition is a recorded change of status on a booking
+type BookingTransition struct {
+	ID        string        `json:"id,omitempty" sql:"id"`
+	BookingID string        `json:"bookingID,omitempty" sql:"booking_id"`
+	From      BookingStatus `json:"from,omitempty" sql:"from_status"`
+	To        BookingStatus `json:"to" sql:"to_status"`
+	ActorUID  string        `json:"actorUID,omitempty" sql:"actor_uid"`
+	Note      string        `json:"note,omitempty" sql:"note"`
+	CreatedAt *time.Time    `json:"createdAt,omitempty" sql:"created_at"`
+}
+
+// WorkingHours is a weekly recurring window in which a professional can be booked.
+// Start and end are minutes after midnight in TimeZone.
+type WorkingHours struct {
+	UserUID     string       `json:"userUID,omitempty" sql:"user_uid"`
+	Weekday     time.Weekday `json:"weekday" sql:"weekday"`
+	StartMinute int          `json:"startMinute" sql:"start_minute"`
+	EndMinute   int          `json:"endMinute" sql:"end_minute"`
+	TimeZone    string       `json:"timeZone,omitempty" sql:"time_zone"`
+}
+
+// BlockedPeriod is a period where a professional cannot be booked, i.e. vacation
+type BlockedPeriod struct {
+	ID        string     `json:"id,omitempty" sql:"id"`
+	UserUID   string     `json:"userUID,omitempty" sql:"user_uid"`
+	DateStart *time.Time `json:"dateStart,omitempty" sql:"date_start"`
+	DateEnd   *time.Time `json:"dateEnd,omitempty" sql:"date_end"`
+	Reason    string     `json:"reason,omitempty" sql:"reason"`
+}
+
+// Availability is the free time of a professional within a window
+type Availability struct {
+	UserUID string            `json:"userUID"`
+	From    time.Time         `json:"from"`
+	To      time.Time         `json:"to"`
+	Free    []timeutil.Period `json:"free"`
+}
+
+// Deliverable is a file a professional delivered for a booking
+type Deliverable struct {
 	ID          string     `json:"id,omitempty" sql:"id"`
-	MediaUID    string     `json:"mediaUID,omitempty" sql:"media_uid"`
-	MediaBooker string     `json:"mediaBooker,omitempty" sql:"media_booker"`
-	UserUID     string     `json:"userUID,omitempty" sql:"user_uid"`
-	Task        string     `json:"task,omitempty"`
-	Price       int        `json:"price,omitempty"`
-	Credits     int        `json:"credits,omitempty"`
-	IsActive    bool       `json:"isActive,omitempty" sql:"is_active"`
-	IsCompleted bool       `json:"isCompleted,omitempty" sql:"is_completed"`
-	DateStart   *time.Time `json:"dateStart,omitempty" sql:"date_start"`
-	DateEnd     *time.Time `json:"dateEnd,omitempty" sql:"date_end"`
+	BookingID   string     `json:"bookingID" sql:"booking_id"`
+	Key         string     `json:"key" sql:"key"`
+	FileName    string     `json:"fileName,omitempty" sql:"file_name"`
+	Size        int64      `json:"size" sql:"size"`
+	SHA256      string     `json:"sha256,omitempty" sql:"sha256"`
+	ContentType string     `json:"contentType,omitempty" sql:"content_type"`
 	CreatedAt   *time.Time `json:"createdAt,omitempty" sql:"created_at"`
-	Lng         string     `json:"lng,omitempty" sql:"lng"`
-	Lat         string     `json:"lat,omitempty" sql:"lat"`
+	// Set once the file was processed after upload
+	Exif            *exif.Output `json:"exif,omitempty" sql:"exif"`
+	ThumbnailKey    string       `json:"thumbnailKey,omitempty" sql:"thumbnail_key"`
+	ProcessingError string       `json:"processingError,omitempty" sql:"processing_error"`
+	ProcessedAt     *time.Time   `json:"processedAt,omitempty" sql:"processed_at"`
+	// PHash is the perceptual hash of images
+	PHash *phash.Hash `json:"phash,omitempty" sql:"phash"`
+	// Derivatives are the renditions of images, e.g. thumbnails and previews
+	Derivatives []*Derivative `json:"derivatives,omitempty" sql:"derivatives"`
+	// BlurHash and Palette of images are shown by lists while the image loads
+	BlurHash string          `json:"blurHash,omitempty" sql:"blurhash"`
+	Palette  []palette.Color `json:"palette,omitempty" sql:"palette"`
+}
+
+// Derivative is a stored rendition of a deliverable image
+type Derivative struct {
+	Name        string `json:"name"`
+	Key         string `json:"key"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+	// URL is a signed url to download the derivative, set when it is served
+	URL string `json:"url,omitempty"`
+}
+
+// SimilarDeliverable is a deliverable showing the same picture as another, Distance bits of their phash apart
+type SimilarDeliverable struct {
+	*Deliverable
+	Distance int `json:"distance"`
+}
+
+// DuplicateDeliverables are two deliverables of the same picture, Duplicate was recorded after Original
+type DuplicateDeliverables struct {
+	Original  *Deliverable `json:"original"`
+	Duplicate *Deliverable `json:"duplicate"`
+	Distance  int          `json:"distance"`
 }
 
 // AdminBookings is a joined response for a booking attached to a pro user
@@ -81,6 +288,7 @@ type FirebaseProfile struct {
 	Email               string `json:"email,omitempty"`
 	IsMedia             bool   `json:"isMedia,omitempty"`
 	IsProfessional      bool   `json:"isProfessional,omitempty"`
+	ProLevel            int    `json:"proLevel,omitempty"`
 	IsPress             bool   `json:"isPress,omitempty"`
 	SalesQuantity       int64  `json:"salesQuantity,omitempty"`
 	SalesAmount         int64  `json:"salesAmount,omitempty"`
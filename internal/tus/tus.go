@@ -0,0 +1,648 @@
+// Package tus implements a tus 1.0 resumable upload server on top of a storage.BlobStore.
+//
+// Supported extensions are creation, termination and expiration. Every PATCH is stored as its own
+// chunk blob, so an interrupted request keeps the bytes it received. The first chunk is stored at the
+// final key, so an upload sent in a single PATCH is never copied. When the last byte arrives the chunks
+// are joined into the final key and the Complete hook is called in the background, retried until it succeeds.
+//
+// See https://tus.io/protocols/resumable-upload.html
+package tus
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/blixenkrone/gopro/internal/storage"
+	"github.com/blixenkrone/gopro/pkg/logger"
+)
+
+var log = logger.NewLogger()
+
+const (
+	// Version is the only protocol version spoken
+	Version = "1.0.0"
+
+	offsetContentType = "application/offset+octet-stream"
+	// prefix is where upload state and chunks are kept in the blob store
+	prefix = "tus/"
+	// chunkTimeout bounds storing a single PATCH, which isn't tied to the request so a dropped connection keeps its bytes
+	chunkTimeout = 30 * time.Minute
+	// completeTimeout bounds joining the chunks and calling the Complete hook
+	completeTimeout = 30 * time.Minute
+	// completeAttempts is how often completing is tried after the last PATCH, after that Cleanup retries it
+	completeAttempts = 5
+)
+
+var (
+	// ErrNotFound is returned for unknown or terminated uploads
+	ErrNotFound = errors.New("upload not found")
+	// ErrExpired is returned for uploads that weren't completed in time
+	ErrExpired = errors.New("upload expired")
+	// ErrForbidden is returned by the Create hook to reject an upload with 403
+	ErrForbidden = errors.New("upload not allowed")
+)
+
+// Upload is the state of a single resumable upload
+type Upload struct {
+	ID       string            `json:"id"`
+	Length   int64             `json:"length"`
+	Offset   int64             `json:"offset"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Key is the blob the chunks are joined into. It is set by the Create hook.
+	Key       string    `json:"key"`
+	Chunks    []Chunk   `json:"chunks,omitempty"`
+	Expires   time.Time `json:"expires"`
+	Completed bool      `json:"completed,omitempty"`
+	// SHA256 of the joined upload, set once completed
+	SHA256 string `json:"sha256,omitempty"`
+	// Hash is the state of the SHA256 of the chunks so far, so they aren't read again to hash them
+	Hash []byte `json:"hash,omitempty"`
+}
+
+// Chunk is the blob holding the bytes of a single PATCH
+type Chunk struct {
+	Key    string `json:"key"`
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+}
+
+// expired reports whether the upload was left unfinished for too long. An upload with all its bytes
+// doesn't expire before it is completed, its bytes are kept until the Complete hook succeeds.
+func (u *Upload) expired(now time.Time) bool {
+	return !u.Completed && u.Offset < u.Length && now.After(u.Expires)
+}
+
+// hash returns the SHA256 of the chunks stored so far
+func (u *Upload) hash() (hash.Hash, error) {
+	h := sha256.New()
+	if u.Hash != nil {
+		if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(u.Hash); err != nil {
+			return nil, errors.Wrap(err, "restoring hash")
+		}
+	}
+	return h, nil
+}
+
+// pending reports whether all bytes arrived but completing the upload hasn't succeeded yet
+func (u *Upload) pending() bool {
+	return !u.Completed && u.Offset == u.Length
+}
+
+// Config configures a Handler
+type Config struct {
+	// BasePath is the path the handler is mounted at, upload urls are BasePath + id
+	BasePath string
+	// MaxSize is the largest accepted upload length, 0 means no limit
+	MaxSize int64
+	// Expiration is how long an upload may be idle before it expires
+	Expiration time.Duration
+	// Create validates a new upload and sets its Key. Returning an error rejects the upload with 400,
+	// or 403 when its cause is ErrForbidden.
+	Create func(r *http.Request, u *Upload) error
+	// Complete is called in the background once the upload is joined into u.Key. The final PATCH has
+	// already reported the whole upload as received, so on error it is retried, a few times with a backoff
+	// and then by Cleanup, and it must be safe to call again.
+	Complete func(ctx context.Context, u *Upload, info *storage.BlobInfo) error
+}
+
+// Handler serves the tus protocol
+type Handler struct {
+	store storage.BlobStore
+	cfg   Config
+	now   func() time.Time
+	// retryDelay is the wait before the second attempt to complete, doubled for each attempt after that
+	retryDelay time.Duration
+	// completing tracks background completions
+	completing sync.WaitGroup
+
+	mu    sync.Mutex
+	locks map[string]*uploadLock
+}
+
+type uploadLock struct {
+	sync.Mutex
+	refs int
+}
+
+// New returns a tus handler storing uploads in store
+func New(store storage.BlobStore, cfg Config) *Handler {
+	if cfg.Expiration == 0 {
+		cfg.Expiration = 24 * time.Hour
+	}
+	if !strings.HasSuffix(cfg.BasePath, "/") {
+		cfg.BasePath += "/"
+	}
+	return &Handler{
+		store:      store,
+		cfg:        cfg,
+		now:        time.Now,
+		retryDelay: 10 * time.Second,
+		locks:      make(map[string]*uploadLock),
+	}
+}
+
+// lock serialises requests for a single upload and returns the unlock func
+func (h *Handler) lock(id string) func() {
+	h.mu.Lock()
+	l, ok := h.locks[id]
+	if !ok {
+		l = &uploadLock{}
+		h.locks[id] = l
+	}
+	l.refs++
+	h.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		h.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(h.locks, id)
+		}
+		h.mu.Unlock()
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", Version)
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Tus-Version", Version)
+		w.Header().Set("Tus-Extension", "creation,termination,expiration")
+		if h.cfg.MaxSize > 0 {
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(h.cfg.MaxSize, 10))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Header.Get("Tus-Resumable") != Version {
+		w.Header().Set("Tus-Version", Version)
+		http.Error(w, "unsupported tus version", http.StatusPreconditionFailed)
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, h.cfg.BasePath), "/")
+	switch {
+	case id == "" && r.Method == http.MethodPost:
+		h.create(w, r)
+	case id != "" && strings.Contains(id, "/"):
+		http.NotFound(w, r)
+	case id != "" && r.Method == http.MethodHead:
+		h.head(w, r, id)
+	case id != "" && r.Method == http.MethodPatch:
+		h.patch(w, r, id)
+	case id != "" && r.Method == http.MethodDelete:
+		h.terminate(w, r, id)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) create(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Upload-Defer-Length") != "" {
+		http.Error(w, "deferred upload length is not supported", http.StatusBadRequest)
+		return
+	}
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+	if h.cfg.MaxSize > 0 && length > h.cfg.MaxSize {
+		http.Error(w, "upload exceeds Tus-Max-Size", http.StatusRequestEntityTooLarge)
+		return
+	}
+	metadata, err := ParseMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id, err := newID()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	u := &Upload{
+		ID:       id,
+		Length:   length,
+		Metadata: metadata,
+		Key:      prefix + id + "/data",
+		Expires:  h.now().Add(h.cfg.Expiration).UTC(),
+	}
+	if h.cfg.Create != nil {
+		if err := h.cfg.Create(r, u); err != nil {
+			code := http.StatusBadRequest
+			if errors.Cause(err) == ErrForbidden {
+				code = http.StatusForbidden
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+	}
+	if err := h.save(r.Context(), u); err != nil {
+		log.Errorf("Error creating upload %s: %s", id, err)
+		http.Error(w, "error creating upload", http.StatusInternalServerError)
+		return
+	}
+	// An empty upload is complete on creation
+	if length == 0 {
+		h.completeLater(id)
+	}
+
+	w.Header().Set("Location", h.cfg.BasePath+id)
+	w.Header().Set("Upload-Expires", u.Expires.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *Handler) head(w http.ResponseWriter, r *http.Request, id string) {
+	u, err := h.Get(r.Context(), id)
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(u.Length, 10))
+	if len(u.Metadata) > 0 {
+		w.Header().Set("Upload-Metadata", EncodeMetadata(u.Metadata))
+	}
+	if !u.Completed {
+		w.Header().Set("Upload-Expires", u.Expires.Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) patch(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Header.Get("Content-Type") != offsetContentType {
+		http.Error(w, "Content-Type must be "+offsetContentType, http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+
+	unlock := h.lock(id)
+	defer unlock()
+
+	u, err := h.Get(r.Context(), id)
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	if offset != u.Offset {
+		http.Error(w, fmt.Sprintf("offset is %d", u.Offset), http.StatusConflict)
+		return
+	}
+
+	if u.Offset < u.Length {
+		if err := h.appendChunk(u, r.Body); err != nil {
+			log.Errorf("Error appending to upload %s: %s", id, err)
+			http.Error(w, "error storing chunk", http.StatusInternalServerError)
+			return
+		}
+	}
+	if u.pending() {
+		h.completeLater(id)
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	if !u.Completed {
+		w.Header().Set("Upload-Expires", u.Expires.Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// appendChunk stores body up to the remaining length as a new chunk. Bytes received before
+// the body broke off are kept, so the client can resume from there. The first chunk is stored at
+// the upload key, which is all there is to join for an upload sent in one PATCH.
+func (h *Handler) appendChunk(u *Upload, body io.Reader) error {
+	ctx, cancel := context.WithTimeout(context.Background(), chunkTimeout)
+	defer cancel()
+
+	sum, err := u.hash()
+	if err != nil {
+		return err
+	}
+	chunk := Chunk{Key: fmt.Sprintf("%s%s/chunk-%020d", prefix, u.ID, u.Offset), Offset: u.Offset}
+	opts := storage.PutOptions{ContentType: offsetContentType}
+	if u.Offset == 0 {
+		chunk.Key, opts.ContentType = u.Key, u.Metadata["filetype"]
+	}
+	pr := &partialReader{r: io.LimitReader(body, u.Length-u.Offset)}
+	info, err := h.store.Put(ctx, chunk.Key, io.TeeReader(pr, sum), opts)
+	if err != nil {
+		return err
+	}
+	if pr.err != nil {
+		log.Infof("Upload %s broke off after %d bytes: %s", u.ID, info.Size, pr.err)
+	}
+	if info.Size == 0 {
+		return h.store.Delete(ctx, chunk.Key)
+	}
+
+	if u.Hash, err = sum.(encoding.BinaryMarshaler).MarshalBinary(); err != nil {
+		return errors.Wrap(err, "saving hash")
+	}
+	chunk.Size = info.Size
+	u.Chunks = append(u.Chunks, chunk)
+	u.Offset += info.Size
+	u.Expires = h.now().Add(h.cfg.Expiration).UTC()
+	return h.save(ctx, u)
+}
+
+// completeLater completes the upload in the background, trying again with a growing delay when it fails.
+// Uploads still not completed after that are retried by Cleanup.
+func (h *Handler) completeLater(id string) {
+	h.completing.Add(1)
+	go func() {
+		defer h.completing.Done()
+		delay := h.retryDelay
+		for attempt := 1; ; attempt++ {
+			err := h.completeID(id)
+			if err == nil {
+				return
+			}
+			log.Errorf("Error completing upload %s, attempt %d: %s", id, attempt, err)
+			if attempt == completeAttempts {
+				return
+			}
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}()
+}
+
+// completeID completes the upload id unless that was done already
+func (h *Handler) completeID(id string) error {
+	unlock := h.lock(id)
+	defer unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), completeTimeout)
+	defer cancel()
+	u, err := h.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !u.pending() {
+		return nil
+	}
+	return h.complete(ctx, u)
+}
+
+// complete joins the chunks into the upload key and calls the Complete hook. The first chunk already
+// is the upload key, so only uploads sent in more than one PATCH are copied.
+func (h *Handler) complete(ctx context.Context, u *Upload) error {
+	info, err := h.join(ctx, u)
+	if err != nil {
+		return errors.Wrap(err, "joining chunks")
+	}
+	if info.Size != u.Length {
+		return errors.Errorf("joined %d bytes, expected %d", info.Size, u.Length)
+	}
+	sum, err := u.hash()
+	if err != nil {
+		return err
+	}
+	u.SHA256 = hex.EncodeToString(sum.Sum(nil))
+
+	if h.cfg.Complete != nil {
+		if err := h.cfg.Complete(ctx, u, info); err != nil {
+			return err
+		}
+	}
+
+	chunks := u.Chunks
+	u.Completed, u.Chunks, u.Hash = true, nil, nil
+	if err := h.save(ctx, u); err != nil {
+		return err
+	}
+	for _, c := range chunks {
+		if c.Key == u.Key {
+			continue
+		}
+		if err := h.store.Delete(ctx, c.Key); err != nil {
+			log.Errorf("Error deleting chunk %s: %s", c.Key, err)
+		}
+	}
+	return nil
+}
+
+// join writes the chunks after the first into the upload key, after the first chunk stored there.
+// The store replaces the key atomically, so the first chunk is read while it is written over.
+func (h *Handler) join(ctx context.Context, u *Upload) (*storage.BlobInfo, error) {
+	if len(u.Chunks) == 0 {
+		return h.store.Put(ctx, u.Key, strings.NewReader(""), storage.PutOptions{ContentType: u.Metadata["filetype"]})
+	}
+	if len(u.Chunks) == 1 {
+		return h.store.Stat(ctx, u.Key)
+	}
+	readers := make([]io.Reader, 0, len(u.Chunks))
+	var closers []io.Closer
+	defer func() {
+		for _, c := range closers {
+			c.Close()
+		}
+	}()
+	for _, c := range u.Chunks {
+		body, _, err := h.store.Get(ctx, c.Key)
+		if err != nil {
+			return nil, err
+		}
+		closers = append(closers, body)
+		readers = append(readers, body)
+	}
+	return h.store.Put(ctx, u.Key, io.MultiReader(readers...), storage.PutOptions{ContentType: u.Metadata["filetype"]})
+}
+
+func (h *Handler) terminate(w http.ResponseWriter, r *http.Request, id string) {
+	unlock := h.lock(id)
+	defer unlock()
+
+	u, err := h.Get(r.Context(), id)
+	if err != nil && errors.Cause(err) != ErrExpired {
+		h.error(w, err)
+		return
+	}
+	if err := h.remove(r.Context(), u); err != nil {
+		log.Errorf("Error terminating upload %s: %s", id, err)
+		http.Error(w, "error terminating upload", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// remove deletes the chunks and state of an upload. The joined upload of a completed upload is kept.
+func (h *Handler) remove(ctx context.Context, u *Upload) error {
+	for _, c := range u.Chunks {
+		if err := h.store.Delete(ctx, c.Key); err != nil {
+			return err
+		}
+	}
+	return h.store.Delete(ctx, infoKey(u.ID))
+}
+
+// Get returns the state of an upload. Expired uploads are returned together with ErrExpired.
+func (h *Handler) Get(ctx context.Context, id string) (*Upload, error) {
+	body, _, err := h.store.Get(ctx, infoKey(id))
+	if err != nil {
+		if errors.Cause(err) == storage.ErrBlobNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	defer body.Close()
+
+	var u Upload
+	if err := json.NewDecoder(body).Decode(&u); err != nil {
+		return nil, errors.Wrapf(err, "decoding upload %s", id)
+	}
+	if u.expired(h.now()) {
+		return &u, ErrExpired
+	}
+	return &u, nil
+}
+
+// Cleanup completes uploads whose completion failed so far, removes the chunks and state of expired
+// uploads and returns how many were removed
+func (h *Handler) Cleanup(ctx context.Context) (int, error) {
+	infos, err := h.store.List(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+	var ids []string
+	for _, info := range infos {
+		if strings.HasSuffix(info.Key, "/info") {
+			ids = append(ids, strings.TrimSuffix(strings.TrimPrefix(info.Key, prefix), "/info"))
+		}
+	}
+	sort.Strings(ids)
+
+	removed := 0
+	for _, id := range ids {
+		unlock := h.lock(id)
+		u, err := h.Get(ctx, id)
+		if err == nil && u.pending() {
+			if err := h.complete(ctx, u); err != nil {
+				log.Errorf("Error completing upload %s: %s", id, err)
+			}
+			unlock()
+			continue
+		}
+		// Completed uploads keep their state until they expire, so clients can still ask for the offset
+		if errors.Cause(err) == ErrExpired || (err == nil && u.Completed && h.now().After(u.Expires)) {
+			err = h.remove(ctx, u)
+			if err == nil {
+				removed++
+			}
+		}
+		unlock()
+		if err != nil && errors.Cause(err) != ErrNotFound {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+func (h *Handler) save(ctx context.Context, u *Upload) error {
+	b, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	_, err = h.store.Put(ctx, infoKey(u.ID), strings.NewReader(string(b)), storage.PutOptions{ContentType: "application/json"})
+	return err
+}
+
+func (h *Handler) error(w http.ResponseWriter, err error) {
+	switch errors.Cause(err) {
+	case ErrNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case ErrExpired:
+		http.Error(w, err.Error(), http.StatusGone)
+	default:
+		log.Errorf("tus error: %s", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	}
+}
+
+func infoKey(id string) string {
+	return prefix + id + "/info"
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// partialReader ends with io.EOF instead of a read error, keeping the error for the caller
+type partialReader struct {
+	r   io.Reader
+	err error
+}
+
+func (p *partialReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if err != nil && err != io.EOF {
+		p.err = err
+		err = io.EOF
+	}
+	return n, err
+}
+
+// ParseMetadata decodes an Upload-Metadata header: comma separated keys with optional base64 values
+func ParseMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 || len(fields) > 2 {
+			return nil, errors.Errorf("invalid Upload-Metadata pair %q", pair)
+		}
+		var value []byte
+		if len(fields) == 2 {
+			var err error
+			if value, err = base64.StdEncoding.DecodeString(fields[1]); err != nil {
+				return nil, errors.Wrapf(err, "invalid Upload-Metadata value for %s", fields[0])
+			}
+		}
+		metadata[fields[0]] = string(value)
+	}
+	return metadata, nil
+}
+
+// EncodeMetadata encodes metadata as an Upload-Metadata header
+func EncodeMetadata(metadata map[string]string) string {
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + " " + base64.StdEncoding.EncodeToString([]byte(metadata[k]))
+	}
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,293 @@
+package tus
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/blixenkrone/gopro/internal/storage"
+	"github.com/blixenkrone/gopro/internal/storage/local"
+)
+
+type testServer struct {
+	t         *testing.T
+	h         *Handler
+	store     storage.BlobStore
+	completed []*Upload
+	// fail is the number of times completing fails before it succeeds
+	fail int
+}
+
+func newTestServer(t *testing.T) (*testServer, func()) {
+	dir, err := ioutil.TempDir("", "tus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := local.New(dir, "http://localhost/blobs", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := &testServer{t: t, store: store}
+	ts.h = New(store, Config{
+		BasePath: "/files",
+		MaxSize:  1000,
+		Complete: func(ctx context.Context, u *Upload, info *storage.BlobInfo) error {
+			if ts.fail > 0 {
+				ts.fail--
+				return errors.New("database is down")
+			}
+			ts.completed = append(ts.completed, u)
+			return nil
+		},
+	})
+	ts.h.retryDelay = time.Millisecond
+	return ts, func() { os.RemoveAll(dir) }
+}
+
+func (ts *testServer) do(method, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Tus-Resumable", Version)
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	ts.h.ServeHTTP(w, r)
+	return w
+}
+
+func (ts *testServer) patch(location string, offset int, body string) *httptest.ResponseRecorder {
+	return ts.do(http.MethodPatch, location, body, map[string]string{
+		"Content-Type":  offsetContentType,
+		"Upload-Offset": strconv.Itoa(offset),
+	})
+}
+
+func (ts *testServer) create(length int) string {
+	w := ts.do(http.MethodPost, "/files/", "", map[string]string{
+		"Upload-Length":   strconv.Itoa(length),
+		"Upload-Metadata": "filename dmlkZW8ubXA0,filetype dmlkZW8vbXA0,empty",
+	})
+	if w.Code != http.StatusCreated {
+		ts.t.Fatalf("Expected 201 got %d: %s", w.Code, w.Body)
+	}
+	return w.Header().Get("Location")
+}
+
+func TestUpload(t *testing.T) {
+	ts, cleanup := newTestServer(t)
+	defer cleanup()
+
+	location := ts.create(11)
+	if !strings.HasPrefix(location, "/files/") {
+		t.Fatalf("Unexpected location %s", location)
+	}
+
+	if w := ts.patch(location, 0, "hello "); w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != "6" {
+		t.Fatalf("Expected 204 at offset 6 got %d %s: %s", w.Code, w.Header().Get("Upload-Offset"), w.Body)
+	}
+	if w := ts.patch(location, 3, "world"); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for a wrong offset got %d", w.Code)
+	}
+
+	w := ts.do(http.MethodHead, location, "", nil)
+	if w.Code != http.StatusOK || w.Header().Get("Upload-Offset") != "6" || w.Header().Get("Upload-Length") != "11" {
+		t.Errorf("Unexpected HEAD %d %v", w.Code, w.Header())
+	}
+	if m, _ := ParseMetadata(w.Header().Get("Upload-Metadata")); m["filename"] != "video.mp4" {
+		t.Errorf("Expected metadata to round trip got %v", m)
+	}
+	if len(ts.completed) != 0 {
+		t.Fatal("Upload completed too early")
+	}
+
+	// Bytes past the upload length are ignored
+	if w := ts.patch(location, 6, "world and more"); w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != "11" {
+		t.Fatalf("Expected 204 at offset 11 got %d %s: %s", w.Code, w.Header().Get("Upload-Offset"), w.Body)
+	}
+	ts.h.completing.Wait()
+	if len(ts.completed) != 1 {
+		t.Fatalf("Expected the upload to complete once got %d", len(ts.completed))
+	}
+
+	u := ts.completed[0]
+	sum := sha256.Sum256([]byte("hello world"))
+	if u.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("Unexpected hash %s", u.SHA256)
+	}
+	body, info, err := ts.store.Get(context.Background(), u.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	if b, _ := ioutil.ReadAll(body); string(b) != "hello world" || info.ContentType != "video/mp4" {
+		t.Errorf("Unexpected joined upload %q %s", b, info.ContentType)
+	}
+	if chunks, _ := ts.store.List(context.Background(), prefix+u.ID+"/chunk-"); len(chunks) != 0 {
+		t.Errorf("Expected chunks to be removed got %d", len(chunks))
+	}
+}
+
+func TestCompleteRetries(t *testing.T) {
+	ts, cleanup := newTestServer(t)
+	defer cleanup()
+
+	// the hook keeps failing in the background, the bytes are kept and the upload isn't lost
+	ts.fail = completeAttempts + 1
+	location := ts.create(5)
+	if w := ts.patch(location, 0, "hello"); w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != "5" {
+		t.Fatalf("Expected 204 at offset 5 got %d %s: %s", w.Code, w.Header().Get("Upload-Offset"), w.Body)
+	}
+	ts.h.completing.Wait()
+	if len(ts.completed) != 0 {
+		t.Fatal("Expected completing to fail")
+	}
+	ts.h.now = func() time.Time { return time.Now().Add(25 * time.Hour) }
+	if w := ts.do(http.MethodHead, location, "", nil); w.Code != http.StatusOK || w.Header().Get("Upload-Offset") != "5" {
+		t.Errorf("Expected a pending upload not to expire got %d %v", w.Code, w.Header())
+	}
+
+	// Cleanup retries it
+	if _, err := ts.h.Cleanup(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(ts.completed) != 0 {
+		t.Fatal("Expected completing to fail again")
+	}
+	if _, err := ts.h.Cleanup(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(ts.completed) != 1 {
+		t.Fatalf("Expected the upload to complete once got %d", len(ts.completed))
+	}
+	u := ts.completed[0]
+	sum := sha256.Sum256([]byte("hello"))
+	if u.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("Unexpected hash %s", u.SHA256)
+	}
+	// sent in one PATCH, the upload was stored at its key without chunks to join
+	if chunks, _ := ts.store.List(context.Background(), prefix+u.ID+"/chunk-"); len(chunks) != 0 {
+		t.Errorf("Expected no chunks got %d", len(chunks))
+	}
+	if info, err := ts.store.Stat(context.Background(), u.Key); err != nil || info.Size != 5 || info.ContentType != "video/mp4" {
+		t.Errorf("Unexpected upload %+v %v", info, err)
+	}
+}
+
+func TestCreateValidation(t *testing.T) {
+	ts, cleanup := newTestServer(t)
+	defer cleanup()
+
+	if w := ts.do(http.MethodPost, "/files/", "", map[string]string{"Upload-Length": "1001"}); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 got %d", w.Code)
+	}
+	if w := ts.do(http.MethodPost, "/files/", "", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without length got %d", w.Code)
+	}
+	ts.h.cfg.Create = func(r *http.Request, u *Upload) error {
+		if u.Metadata["filename"] == "" {
+			return errors.New("filename is required")
+		}
+		return errors.Wrap(ErrForbidden, "not your booking")
+	}
+	if w := ts.do(http.MethodPost, "/files/", "", map[string]string{"Upload-Length": "10"}); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 from the hook got %d", w.Code)
+	}
+	if w := ts.do(http.MethodPost, "/files/", "", map[string]string{"Upload-Length": "10", "Upload-Metadata": "filename dmlkZW8ubXA0"}); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 from the hook got %d", w.Code)
+	}
+	ts.h.cfg.Create = nil
+	r := httptest.NewRequest(http.MethodPost, "/files/", nil)
+	r.Header.Set("Upload-Length", "10")
+	w := httptest.NewRecorder()
+	ts.h.ServeHTTP(w, r)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected 412 without Tus-Resumable got %d", w.Code)
+	}
+	if w := ts.patch("/files/unknown", 0, "x"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 got %d", w.Code)
+	}
+}
+
+func TestTerminate(t *testing.T) {
+	ts, cleanup := newTestServer(t)
+	defer cleanup()
+
+	location := ts.create(10)
+	ts.patch(location, 0, "abc")
+	if w := ts.do(http.MethodDelete, location, "", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204 got %d", w.Code)
+	}
+	if w := ts.do(http.MethodHead, location, "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 after termination got %d", w.Code)
+	}
+	if blobs, _ := ts.store.List(context.Background(), prefix); len(blobs) != 0 {
+		t.Errorf("Expected no blobs left got %d", len(blobs))
+	}
+}
+
+func TestExpiration(t *testing.T) {
+	ts, cleanup := newTestServer(t)
+	defer cleanup()
+
+	location := ts.create(10)
+	ts.patch(location, 0, "abc")
+	if w := ts.do(http.MethodHead, location, "", nil); w.Header().Get("Upload-Expires") == "" {
+		t.Error("Expected Upload-Expires")
+	}
+
+	ts.h.now = func() time.Time { return time.Now().Add(25 * time.Hour) }
+	if w := ts.patch(location, 3, "def"); w.Code != http.StatusGone {
+		t.Errorf("Expected 410 got %d", w.Code)
+	}
+	n, err := ts.h.Cleanup(context.Background())
+	if err != nil || n != 1 {
+		t.Fatalf("Expected 1 removed upload got %d %v", n, err)
+	}
+	if blobs, _ := ts.store.List(context.Background(), prefix); len(blobs) != 0 {
+		t.Errorf("Expected no blobs left got %d", len(blobs))
+	}
+}
+
+func TestParseMetadata(t *testing.T) {
+	m, err := ParseMetadata("bookingID NDI=, filename YS5tb3Y=,flag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m["bookingID"] != "42" || m["filename"] != "a.mov" || m["flag"] != "" || len(m) != 3 {
+		t.Errorf("Unexpected metadata %v", m)
+	}
+	if _, err := ParseMetadata("key not-base64!"); err == nil {
+		t.Error("Expected error for invalid base64")
+	}
+}
+
+type brokenReader struct{}
+
+func (brokenReader) Read(p []byte) (int, error) { return 0, errors.New("connection reset") }
+
+func TestInterruptedPatchKeepsBytes(t *testing.T) {
+	ts, cleanup := newTestServer(t)
+	defer cleanup()
+
+	location := ts.create(10)
+	r := httptest.NewRequest(http.MethodPatch, location, io.MultiReader(strings.NewReader("abcd"), brokenReader{}))
+	r.Header.Set("Tus-Resumable", Version)
+	r.Header.Set("Content-Type", offsetContentType)
+	r.Header.Set("Upload-Offset", "0")
+	ts.h.ServeHTTP(httptest.NewRecorder(), r)
+
+	if w := ts.do(http.MethodHead, location, "", nil); w.Header().Get("Upload-Offset") != "4" {
+		t.Errorf("Expected to resume at 4 got %s", w.Header().Get("Upload-Offset"))
+	}
+}
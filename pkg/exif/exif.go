@@ -1,6 +1,8 @@
 package exif
 
 import (
+	"time"
+
 	"github.com/pkg/errors"
 	goexif "github.com/rwcarlsen/goexif/exif"
 
@@ -12,20 +14,143 @@ import (
 // Output represents the final decoded EXIF data from an image
 type Output struct {
 	// File            file.FileGenerator
-	Date            int64             `json:"date,omitempty"`
-	Lat             float64           `json:"lat,omitempty"`
-	Lng             float64           `json:"lng,omitempty"`
-	Copyright       string            `json:"copyright,omitempty"`
-	Model           string            `json:"model,omitempty"`
-	PixelXDimension int               `json:"pixelXDimension,omitempty"`
-	PixelYDimension int               `json:"pixelYDimension,omitempty"`
-	MediaSize       float64           `json:"mediaSize,omitempty"`
-	MissingExif     map[string]string `json:"missingExif,omitempty"`
+	// Date is the capture time in milliseconds since the unix epoch
+	Date int64   `json:"date,omitempty"`
+	Lat  float64 `json:"lat,omitempty"`
+	Lng  float64 `json:"lng,omitempty"`
+	// Altitude is in meters, negative below sea level
+	Altitude *float64 `json:"altitude,omitempty"`
+	// GPSTime is the UTC time of the GPS fix in milliseconds since the unix epoch
+	GPSTime int64 `json:"gpsTime,omitempty"`
+	// ImgDirection is the compass bearing the camera pointed in, in degrees.
+	// ImgDirectionRef is T for true north or M for magnetic north.
+	ImgDirection    *float64 `json:"imgDirection,omitempty"`
+	ImgDirectionRef string   `json:"imgDirectionRef,omitempty"`
+	// HPositioningError is the horizontal accuracy of Lat and Lng in meters
+	HPositioningError *float64          `json:"hPositioningError,omitempty"`
+	Copyright         string            `json:"copyright,omitempty"`
+	Model             string            `json:"model,omitempty"`
+	PixelXDimension   int               `json:"pixelXDimension,omitempty"`
+	PixelYDimension   int               `json:"pixelYDimension,omitempty"`
+	MediaSize         float64           `json:"mediaSize,omitempty"`
+	MissingExif       map[string]string `json:"missingExif,omitempty"`
+	// Video is set for the output of a video, Codec is the common name of its codec
+	Video bool   `json:"video,omitempty"`
+	Codec string `json:"codec,omitempty"`
+	// Camera is the camera and exposure of an image
+	Camera *Camera `json:"camera,omitempty"`
+	// IPTC is the caption, credit and rights agencies embed in IPTC and XMP
+	IPTC *IPTC `json:"iptc,omitempty"`
 	// MediaFormat     string  `json:"mediaFormat,omitempty"`
 }
 
+// Camera describes the camera, lens and exposure an image was taken with
+type Camera struct {
+	Make         string `json:"make,omitempty"`
+	Model        string `json:"model,omitempty"`
+	LensMake     string `json:"lensMake,omitempty"`
+	LensModel    string `json:"lensModel,omitempty"`
+	SerialNumber string `json:"serialNumber,omitempty"`
+	// Software is the firmware or the editor that last saved the file
+	Software string `json:"software,omitempty"`
+
+	FNumber float64 `json:"fNumber,omitempty"`
+	// ExposureTime is in seconds, ShutterSpeed is the same as written on a camera, e.g. 1/250
+	ExposureTime float64 `json:"exposureTime,omitempty"`
+	ShutterSpeed string  `json:"shutterSpeed,omitempty"`
+	ISO          int     `json:"iso,omitempty"`
+	// FocalLength is in mm, FocalLength35mm is the equivalent on a full frame sensor
+	FocalLength     float64 `json:"focalLength,omitempty"`
+	FocalLength35mm int     `json:"focalLength35mm,omitempty"`
+	// Orientation is the EXIF orientation from 1 to 8, 1 being upright
+	Orientation int `json:"orientation,omitempty"`
+
+	// The times are RFC 3339, without an offset when the file has no time zone
+	DateTimeOriginal  string `json:"dateTimeOriginal,omitempty"`
+	DateTimeDigitized string `json:"dateTimeDigitized,omitempty"`
+	DateTimeModified  string `json:"dateTimeModified,omitempty"`
+}
+
+// IPTC is the descriptive metadata of an image from its IPTC-IIM block and XMP packet
+type IPTC struct {
+	Title       string   `json:"title,omitempty"`
+	Headline    string   `json:"headline,omitempty"`
+	Caption     string   `json:"caption,omitempty"`
+	Byline      []string `json:"byline,omitempty"`
+	BylineTitle string   `json:"bylineTitle,omitempty"`
+	Credit      string   `json:"credit,omitempty"`
+	Source      string   `json:"source,omitempty"`
+	Copyright   string   `json:"copyright,omitempty"`
+	UsageTerms  string   `json:"usageTerms,omitempty"`
+	Keywords    []string `json:"keywords,omitempty"`
+	Sublocation string   `json:"sublocation,omitempty"`
+	City        string   `json:"city,omitempty"`
+	State       string   `json:"state,omitempty"`
+	Country     string   `json:"country,omitempty"`
+	CountryCode string   `json:"countryCode,omitempty"`
+}
+
+// Merge overwrites the fields of i with those set in o
+func (i *IPTC) Merge(o *IPTC) {
+	if o == nil {
+		return
+	}
+	set := func(dst *string, src string) {
+		if src != "" {
+			*dst = src
+		}
+	}
+	set(&i.Title, o.Title)
+	set(&i.Headline, o.Headline)
+	set(&i.Caption, o.Caption)
+	set(&i.BylineTitle, o.BylineTitle)
+	set(&i.Credit, o.Credit)
+	set(&i.Source, o.Source)
+	set(&i.Copyright, o.Copyright)
+	set(&i.UsageTerms, o.UsageTerms)
+	set(&i.Sublocation, o.Sublocation)
+	set(&i.City, o.City)
+	set(&i.State, o.State)
+	set(&i.Country, o.Country)
+	set(&i.CountryCode, o.CountryCode)
+	if len(o.Byline) > 0 {
+		i.Byline = o.Byline
+	}
+	if len(o.Keywords) > 0 {
+		i.Keywords = o.Keywords
+	}
+}
+
 var log = logger.NewLogger()
 
+// AddIPTC merges m into the IPTC of the output, and uses its copyright if the EXIF has none
+func (o *Output) AddIPTC(m *IPTC) {
+	if m == nil {
+		return
+	}
+	if o.IPTC == nil {
+		o.IPTC = &IPTC{}
+	}
+	o.IPTC.Merge(m)
+	if o.Copyright == "" && o.IPTC.Copyright != "" {
+		o.Copyright = o.IPTC.Copyright
+		delete(o.MissingExif, "copyright")
+	}
+}
+
+// CaptureTime returns Date as a time, or the zero time if it is unknown
+func (o *Output) CaptureTime() time.Time {
+	if o.Date == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, o.Date*int64(time.Millisecond))
+}
+
+// HasLocation reports whether the media has a GPS position
+func (o *Output) HasLocation() bool {
+	return o.Lat != 0 || o.Lng != 0
+}
+
 // adds an object to the output JSON that displays missing exif data
 func (o *Output) AddMissingExif(errType string, originError error) {
 	var returnError error
@@ -0,0 +1,291 @@
+// Package forensics looks for signs that an image was edited or its metadata forged. Each signal found is
+// a weighted Finding, and the weights add up to a tamper likelihood. No single signal proves tampering:
+// the report is for an editor to decide what to look at closer.
+package forensics
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"math"
+	"regexp"
+	"time"
+
+	goexif "github.com/rwcarlsen/goexif/exif"
+
+	"github.com/blixenkrone/gopro/pkg/exif"
+	exifimage "github.com/blixenkrone/gopro/pkg/exif/image"
+	"github.com/blixenkrone/gopro/pkg/image/heif"
+	"github.com/blixenkrone/gopro/pkg/image/phash"
+	"github.com/blixenkrone/gopro/pkg/image/pixels"
+)
+
+// Signals reported in a Finding
+const (
+	SignalNoExif            = "noExif"
+	SignalEditingSoftware   = "editingSoftware"
+	SignalDateModified      = "dateModified"
+	SignalDateDigitized     = "dateDigitized"
+	SignalFutureDate        = "futureDate"
+	SignalMissingThumbnail  = "missingThumbnail"
+	SignalMissingMakerNote  = "missingMakerNote"
+	SignalThumbnailMismatch = "thumbnailMismatch"
+	SignalQuantization      = "quantization"
+	SignalGPSTime           = "gpsTime"
+	SignalTimeZone          = "timeZone"
+	SignalLocation          = "location"
+)
+
+// Likelihoods of a Report
+const (
+	Low    = "low"
+	Medium = "medium"
+	High   = "high"
+)
+
+const (
+	// maxThumbnailDistance is the dHash distance above which the EXIF thumbnail shows another picture
+	maxThumbnailDistance = 12
+	// dateTolerance allows for cameras that write the times a moment apart
+	dateTolerance = time.Minute
+	// gpsTolerance allows for a GPS fix taken a while before the picture
+	gpsTolerance = 30 * time.Minute
+)
+
+var editingSoftware = regexp.MustCompile(`(?i)photoshop|lightroom|gimp|snapseed|picsart|facetune|affinity|pixelmator|` +
+	`canva|vsco|afterlight|luminar|paint\.net|capture one|darktable|rawtherapee|photoscape|fotor|meitu|airbrush|faceapp|remini`)
+
+// Finding is a signal of tampering. Weight is from 0 to 1, how strongly it suggests tampering on its own.
+type Finding struct {
+	Signal string  `json:"signal"`
+	Weight float64 `json:"weight"`
+	Detail string  `json:"detail"`
+}
+
+// Report is the outcome of Analyze
+type Report struct {
+	// Score is the combined weight of the findings from 0 to 1
+	Score      float64   `json:"score"`
+	Likelihood string    `json:"likelihood"`
+	Findings   []Finding `json:"findings"`
+	// Unchecked are the signals that couldn't be checked and why
+	Unchecked    map[string]string `json:"unchecked,omitempty"`
+	Quantization *Quantization     `json:"quantization,omitempty"`
+	Exif         *exif.Output      `json:"exif,omitempty"`
+}
+
+func (r *Report) add(signal string, weight float64, format string, args ...interface{}) {
+	r.Findings = append(r.Findings, Finding{Signal: signal, Weight: weight, Detail: fmt.Sprintf(format, args...)})
+}
+
+func (r *Report) unchecked(signal, format string, args ...interface{}) {
+	r.Unchecked[signal] = fmt.Sprintf(format, args...)
+}
+
+// Analyze checks a JPEG or HEIF image at time now
+func Analyze(data []byte, now time.Time) *Report {
+	r := &Report{Findings: []Finding{}, Unchecked: make(map[string]string)}
+
+	out, err := exifimage.DecodeImageMetadata(data)
+	if err != nil {
+		r.add(SignalNoExif, 0.3, "no readable EXIF: %s", err)
+	} else {
+		r.Exif = out
+		checkSoftware(r, out)
+		checkDates(r, out, now)
+		checkGPS(r, out)
+	}
+	if x, err := exifimage.LoadExif(data); err == nil {
+		checkCameraStructure(r, x, out, data)
+	} else {
+		r.unchecked(SignalThumbnailMismatch, "no readable EXIF")
+	}
+	checkQuantization(r, out, data)
+
+	r.score()
+	return r
+}
+
+func (r *Report) score() {
+	// the findings are taken as independent
+	clean := 1.0
+	for _, f := range r.Findings {
+		clean *= 1 - f.Weight
+	}
+	r.Score = math.Round((1-clean)*100) / 100
+	switch {
+	case r.Score < 0.25:
+		r.Likelihood = Low
+	case r.Score < 0.6:
+		r.Likelihood = Medium
+	default:
+		r.Likelihood = High
+	}
+}
+
+func camera(o *exif.Output) *exif.Camera {
+	if o == nil || o.Camera == nil {
+		return &exif.Camera{}
+	}
+	return o.Camera
+}
+
+func checkSoftware(r *Report, o *exif.Output) {
+	if sw := camera(o).Software; editingSoftware.MatchString(sw) {
+		r.add(SignalEditingSoftware, 0.45, "saved by %s", sw)
+	}
+}
+
+// parseCameraTime parses the times of exif.Camera, hasZone is false if they have no offset
+func parseCameraTime(s string) (t time.Time, hasZone bool, ok bool) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, true, true
+	}
+	if t, err := time.Parse("2006-01-02T15:04:05", s); err == nil {
+		return t, false, true
+	}
+	return time.Time{}, false, false
+}
+
+func checkDates(r *Report, o *exif.Output, now time.Time) {
+	c := camera(o)
+	original, _, hasOriginal := parseCameraTime(c.DateTimeOriginal)
+	if modified, _, ok := parseCameraTime(c.DateTimeModified); ok && hasOriginal {
+		// both are compared as written, without their offsets
+		if d := wallClock(modified).Sub(wallClock(original)); d > dateTolerance || d < -dateTolerance {
+			r.add(SignalDateModified, 0.3, "modified %s, %s after it was taken", c.DateTimeModified, d)
+		}
+	}
+	if digitized, _, ok := parseCameraTime(c.DateTimeDigitized); ok && hasOriginal {
+		if d := wallClock(digitized).Sub(wallClock(original)); d > dateTolerance || d < -dateTolerance {
+			r.add(SignalDateDigitized, 0.15, "digitized %s, %s from when it was taken", c.DateTimeDigitized, d)
+		}
+	}
+	// a day of slack for times without a zone
+	if captured := o.CaptureTime(); !captured.IsZero() && captured.After(now.Add(24*time.Hour)) {
+		r.add(SignalFutureDate, 0.5, "taken %s, in the future", captured.UTC().Format(time.RFC3339))
+	}
+}
+
+// wallClock drops the zone of a time, keeping the date and clock as written
+func wallClock(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+func checkGPS(r *Report, o *exif.Output) {
+	c := camera(o)
+	original, hasZone, hasOriginal := parseCameraTime(c.DateTimeOriginal)
+
+	if o.GPSTime != 0 && hasOriginal {
+		fix := time.Unix(0, o.GPSTime*int64(time.Millisecond)).UTC()
+		if hasZone {
+			if d := absDuration(original.Sub(fix)); d > gpsTolerance {
+				r.add(SignalGPSTime, 0.35, "GPS fix at %s is %s from the capture time %s", fix.Format(time.RFC3339), d, c.DateTimeOriginal)
+			}
+		} else if d := absDuration(wallClock(original).Sub(fix)); d > 14*time.Hour+gpsTolerance {
+			// without a zone the difference is the time zone, which is at most 14 hours
+			r.add(SignalGPSTime, 0.35, "GPS fix at %s is %s from the capture time %s", fix.Format(time.RFC3339), d, c.DateTimeOriginal)
+		}
+	}
+
+	if !o.HasLocation() {
+		if o.MissingExif["lat"] == "" && o.MissingExif["lng"] == "" {
+			r.add(SignalLocation, 0.3, "GPS position is 0,0")
+		}
+		return
+	}
+	if math.Abs(o.Lat) > 90 || math.Abs(o.Lng) > 180 {
+		r.add(SignalLocation, 0.5, "GPS position %f,%f is out of range", o.Lat, o.Lng)
+		return
+	}
+	if o.Altitude != nil && (*o.Altitude < -450 || *o.Altitude > 9000) {
+		r.add(SignalLocation, 0.3, "altitude of %.0f m is not on the ground", *o.Altitude)
+	}
+	if hasOriginal && hasZone {
+		_, offset := original.Zone()
+		expected := o.Lng / 15
+		if d := math.Abs(float64(offset)/3600 - expected); d > 3.5 {
+			r.add(SignalTimeZone, 0.2, "time zone %s is %.1f hours off the longitude %.4f", original.Format("-07:00"), d, o.Lng)
+		}
+	}
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// checkCameraStructure looks for the thumbnail and maker note cameras write, and compares the thumbnail
+// with the image
+func checkCameraStructure(r *Report, x *goexif.Exif, o *exif.Output, data []byte) {
+	cameraMake := camera(o).Make
+	if cameraMake != "" {
+		if _, err := x.Get(goexif.MakerNote); err != nil {
+			r.add(SignalMissingMakerNote, 0.2, "%s cameras write a maker note, it is missing", cameraMake)
+		}
+	}
+	if heif.IsHEIF(data) {
+		r.unchecked(SignalThumbnailMismatch, "heif thumbnails are separate images")
+		return
+	}
+	thumbData, err := x.JpegThumbnail()
+	if err != nil {
+		if cameraMake != "" {
+			r.add(SignalMissingThumbnail, 0.15, "%s cameras embed a thumbnail, it is missing", cameraMake)
+		} else {
+			r.unchecked(SignalThumbnailMismatch, "no thumbnail")
+		}
+		return
+	}
+	thumb, err := decode(thumbData)
+	if err != nil {
+		r.unchecked(SignalThumbnailMismatch, "thumbnail can't be decoded: %s", err)
+		return
+	}
+	img, err := decode(data)
+	if err != nil {
+		r.unchecked(SignalThumbnailMismatch, "image can't be decoded: %s", err)
+		return
+	}
+	thumb = phash.TrimBorders(thumb)
+	if d := phash.DHash(thumb).Distance(phash.DHash(img)); d > maxThumbnailDistance {
+		r.add(SignalThumbnailMismatch, 0.6, "the EXIF thumbnail shows another picture, %d of 64 bits differ", d)
+		return
+	}
+	ib, tb := img.Bounds(), thumb.Bounds()
+	imgRatio, thumbRatio := float64(ib.Dx())/float64(ib.Dy()), float64(tb.Dx())/float64(tb.Dy())
+	if math.Abs(imgRatio-thumbRatio)/imgRatio > 0.05 {
+		r.add(SignalThumbnailMismatch, 0.3, "the EXIF thumbnail is %.2f:1 and the image %.2f:1, it was cropped", thumbRatio, imgRatio)
+	}
+}
+
+// decode decodes an image unless it declares more pixels than can be decoded
+func decode(data []byte) (image.Image, error) {
+	if _, _, err := pixels.Check(data); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+func checkQuantization(r *Report, o *exif.Output, data []byte) {
+	tables, err := readQuantization(data)
+	if err != nil {
+		r.unchecked(SignalQuantization, "%s", err)
+		return
+	}
+	q := analyzeQuantization(tables)
+	r.Quantization = q
+	cameraMake := camera(o).Make
+	if registered, match := knownTables(cameraMake, q.Fingerprint); registered && !match {
+		r.add(SignalQuantization, 0.4, "tables %s aren't known from %s cameras", q.Fingerprint, cameraMake)
+		return
+	}
+	if q.Standard && cameraMake != "" {
+		r.add(SignalQuantization, 0.25, "standard libjpeg tables at quality %d are typical of software, not %s cameras", q.Quality, cameraMake)
+	}
+}
@@ -0,0 +1,201 @@
+package forensics
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/disintegration/imaging"
+
+	"github.com/blixenkrone/gopro/internal/mediatest"
+	"github.com/blixenkrone/gopro/pkg/exif"
+)
+
+// exifSegment builds an APP1 Exif segment with IFD0, an Exif IFD and an IFD1 with the thumbnail
+func exifSegment(ifd0, exifIFD []mediatest.Entry, thumb []byte) []byte {
+	return mediatest.ExifSegment(mediatest.TIFF{
+		IFD0:      ifd0,
+		SubIFDs:   map[uint16][]mediatest.Entry{0x8769: exifIFD},
+		Thumbnail: thumb,
+	}.Bytes())
+}
+
+func signals(r *Report) map[string]bool {
+	s := make(map[string]bool)
+	for _, f := range r.Findings {
+		s[f.Signal] = true
+	}
+	return s
+}
+
+func cameraIFDs(software string) ([]mediatest.Entry, []mediatest.Entry) {
+	ifd0 := []mediatest.Entry{
+		mediatest.ASCII(0x10F, "Canon"), mediatest.ASCII(0x110, "Canon EOS R5"), mediatest.ASCII(0x132, "2021:06:01 18:30:15"),
+	}
+	if software != "" {
+		ifd0 = append(ifd0, mediatest.ASCII(0x131, software))
+	}
+	exifIFD := []mediatest.Entry{
+		mediatest.ASCII(0x9003, "2021:06:01 18:30:15"),
+		mediatest.ASCII(0x9004, "2021:06:01 18:30:15"),
+		mediatest.Undefined(0x927C, []byte("Canon\x00\x00\x00")),
+	}
+	return ifd0, exifIFD
+}
+
+func TestAnalyzeThumbnail(t *testing.T) {
+	now := time.Date(2021, 6, 2, 0, 0, 0, 0, time.UTC)
+	main := mediatest.Scene(480, 320)
+	ifd0, exifIFD := cameraIFDs("")
+
+	thumb := mediatest.JPEG(t, imaging.Resize(main, 160, 0, imaging.Lanczos), 80)
+	r := Analyze(mediatest.WithSegments(mediatest.JPEG(t, main, 90), exifSegment(ifd0, exifIFD, thumb)), now)
+	if s := signals(r); s[SignalThumbnailMismatch] || s[SignalMissingMakerNote] || s[SignalNoExif] {
+		t.Errorf("matching thumbnail flagged: %+v", r.Findings)
+	}
+
+	swapped := mediatest.JPEG(t, imaging.Resize(imaging.Invert(main), 160, 0, imaging.Lanczos), 80)
+	r = Analyze(mediatest.WithSegments(mediatest.JPEG(t, main, 90), exifSegment(ifd0, exifIFD, swapped)), now)
+	if !signals(r)[SignalThumbnailMismatch] {
+		t.Errorf("other thumbnail not flagged: %+v", r.Findings)
+	}
+
+	cropped := mediatest.JPEG(t, imaging.Resize(imaging.CropCenter(main, 240, 320), 80, 0, imaging.Lanczos), 80)
+	r = Analyze(mediatest.WithSegments(mediatest.JPEG(t, main, 90), exifSegment(ifd0, exifIFD, cropped)), now)
+	if !signals(r)[SignalThumbnailMismatch] {
+		t.Errorf("crop not flagged: %+v", r.Findings)
+	}
+}
+
+func TestAnalyzeTooManyPixels(t *testing.T) {
+	ifd0, exifIFD := cameraIFDs("")
+	main := mediatest.JPEG(t, mediatest.Scene(64, 48), 90)
+	// the frame header declares 65535x65535, which must not be decoded
+	sof := bytes.Index(main, []byte{0xFF, 0xC0})
+	binary.BigEndian.PutUint16(main[sof+5:], 0xFFFF)
+	binary.BigEndian.PutUint16(main[sof+7:], 0xFFFF)
+	r := Analyze(mediatest.WithSegments(main, exifSegment(ifd0, exifIFD, mediatest.JPEG(t, mediatest.Scene(64, 48), 80))), time.Now())
+	if !strings.Contains(r.Unchecked[SignalThumbnailMismatch], "too many pixels") {
+		t.Errorf("got %+v %v", r.Findings, r.Unchecked)
+	}
+}
+
+func TestAnalyzeSoftware(t *testing.T) {
+	ifd0, exifIFD := cameraIFDs("Adobe Photoshop 24.0 (Windows)")
+	main := mediatest.Scene(480, 320)
+	thumb := mediatest.JPEG(t, imaging.Resize(main, 160, 0, imaging.Lanczos), 80)
+	r := Analyze(mediatest.WithSegments(mediatest.JPEG(t, main, 90), exifSegment(ifd0, exifIFD[:2], thumb)), time.Now())
+	s := signals(r)
+	if !s[SignalEditingSoftware] || !s[SignalMissingMakerNote] || !s[SignalQuantization] {
+		t.Errorf("got %+v", r.Findings)
+	}
+	if r.Quantization == nil || !r.Quantization.Standard || r.Quantization.Quality != 90 {
+		t.Errorf("quantization %+v, want standard quality 90", r.Quantization)
+	}
+	if r.Likelihood != Medium && r.Likelihood != High {
+		t.Errorf("likelihood %s at score %.2f", r.Likelihood, r.Score)
+	}
+}
+
+func TestAnalyzeNoExif(t *testing.T) {
+	r := Analyze(mediatest.JPEG(t, mediatest.Scene(64, 48), 75), time.Now())
+	if !signals(r)[SignalNoExif] || r.Unchecked[SignalThumbnailMismatch] == "" {
+		t.Errorf("got %+v %v", r.Findings, r.Unchecked)
+	}
+	// without a make standard tables aren't suspicious
+	if signals(r)[SignalQuantization] || r.Quantization.Quality != 75 {
+		t.Errorf("quantization %+v", r.Quantization)
+	}
+}
+
+func TestCameraTables(t *testing.T) {
+	ifd0, exifIFD := cameraIFDs("")
+	main := mediatest.Scene(64, 48)
+	data := mediatest.WithSegments(mediatest.JPEG(t, main, 75), exifSegment(ifd0, exifIFD, mediatest.JPEG(t, main, 75)))
+	registered := cameraTables
+	cameraTables = make(map[string]map[string]bool)
+	defer func() { cameraTables = registered }()
+
+	fingerprint := Analyze(data, time.Now()).Quantization.Fingerprint
+	RegisterCameraTables("Canon", "0000000000000000")
+	if f := Analyze(data, time.Now()).Findings; len(f) == 0 || f[len(f)-1].Weight != 0.4 {
+		t.Errorf("unknown tables not flagged: %+v", f)
+	}
+	if err := LoadCameraTables([]byte(`{"Nikon": ["` + fingerprint + `"], "canon ": ["not hex"]}`)); err == nil {
+		t.Error("loaded an invalid fingerprint")
+	}
+	if registered, _ := knownTables("nikon", fingerprint); registered {
+		t.Error("registered tables of a file with an invalid fingerprint")
+	}
+	if err := LoadCameraTables([]byte(`{"canon ": ["` + fingerprint + `"]}`)); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range Analyze(data, time.Now()).Findings {
+		if f.Signal == SignalQuantization && f.Weight == 0.4 {
+			t.Errorf("known tables flagged: %+v", f)
+		}
+	}
+}
+
+func TestCheckDates(t *testing.T) {
+	now := time.Date(2021, 6, 2, 0, 0, 0, 0, time.UTC)
+	o := &exif.Output{
+		Date: time.Date(2021, 6, 1, 16, 30, 15, 0, time.UTC).UnixNano() / 1e6,
+		Camera: &exif.Camera{
+			DateTimeOriginal:  "2021-06-01T18:30:15+02:00",
+			DateTimeDigitized: "2021-06-01T18:30:15+02:00",
+			DateTimeModified:  "2021-06-01T18:30:15",
+		},
+	}
+	r := &Report{}
+	checkDates(r, o, now)
+	if len(r.Findings) != 0 {
+		t.Errorf("consistent dates flagged: %+v", r.Findings)
+	}
+
+	o.Camera.DateTimeModified = "2021-06-01T21:02:00"
+	o.Camera.DateTimeDigitized = "2019-01-01T10:00:00+02:00"
+	o.Date = now.Add(72*time.Hour).UnixNano() / 1e6
+	checkDates(r, o, now)
+	if s := signals(r); !s[SignalDateModified] || !s[SignalDateDigitized] || !s[SignalFutureDate] {
+		t.Errorf("got %+v", r.Findings)
+	}
+}
+
+func TestCheckGPS(t *testing.T) {
+	fix := time.Date(2021, 6, 1, 16, 29, 0, 0, time.UTC).UnixNano() / 1e6
+	alt := 12.0
+	tests := []struct {
+		name    string
+		o       exif.Output
+		signals []string
+	}{
+		{"consistent", exif.Output{Lat: 56.15, Lng: 10.2, Altitude: &alt, GPSTime: fix,
+			Camera: &exif.Camera{DateTimeOriginal: "2021-06-01T18:30:15+02:00"}}, nil},
+		{"no zone", exif.Output{Lat: 56.15, Lng: 10.2, GPSTime: fix,
+			Camera: &exif.Camera{DateTimeOriginal: "2021-06-01T18:30:15"}}, nil},
+		{"stale fix", exif.Output{Lat: 56.15, Lng: 10.2, GPSTime: fix,
+			Camera: &exif.Camera{DateTimeOriginal: "2021-06-01T22:30:15+02:00"}}, []string{SignalGPSTime}},
+		{"far fix", exif.Output{Lat: 56.15, Lng: 10.2, GPSTime: fix,
+			Camera: &exif.Camera{DateTimeOriginal: "2021-06-03T18:30:15"}}, []string{SignalGPSTime}},
+		{"zone off longitude", exif.Output{Lat: 40.7, Lng: -74, GPSTime: fix,
+			Camera: &exif.Camera{DateTimeOriginal: "2021-06-01T18:30:15+02:00"}}, []string{SignalTimeZone}},
+		{"null island", exif.Output{MissingExif: map[string]string{}}, []string{SignalLocation}},
+		{"no gps", exif.Output{MissingExif: map[string]string{"lat": "missing", "lng": "missing"}}, nil},
+	}
+	for _, tt := range tests {
+		r := &Report{}
+		checkGPS(r, &tt.o)
+		s := signals(r)
+		if len(s) != len(tt.signals) {
+			t.Errorf("%s: got %+v, want %v", tt.name, r.Findings, tt.signals)
+		}
+		for _, sig := range tt.signals {
+			if !s[sig] {
+				t.Errorf("%s: %s not found in %+v", tt.name, sig, r.Findings)
+			}
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package forensics
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+var errNotJPEG = errors.New("not a jpeg")
+
+var validFingerprint = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+// zigzag maps the order of a DQT table to the natural order of the 8x8 block
+var zigzag = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10, 17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34, 27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36, 29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46, 53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// ijgLuminance and ijgChrominance are the example tables of the JPEG standard, annex K, that libjpeg
+// and most software scale by a quality from 1 to 100. Natural order.
+var (
+	ijgLuminance = [64]int{
+		16, 11, 10, 16, 24, 40, 51, 61,
+		12, 12, 14, 19, 26, 58, 60, 55,
+		14, 13, 16, 24, 40, 57, 69, 56,
+		14, 17, 22, 29, 51, 87, 80, 62,
+		18, 22, 37, 56, 68, 109, 103, 77,
+		24, 35, 55, 64, 81, 104, 113, 92,
+		49, 64, 78, 87, 103, 121, 120, 101,
+		72, 92, 95, 98, 112, 100, 103, 99,
+	}
+	ijgChrominance = [64]int{
+		17, 18, 24, 47, 99, 99, 99, 99,
+		18, 21, 26, 66, 99, 99, 99, 99,
+		24, 26, 56, 99, 99, 99, 99, 99,
+		47, 66, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+	}
+)
+
+// Quantization describes the quantization tables of a JPEG
+type Quantization struct {
+	// Fingerprint identifies the set of tables
+	Fingerprint string `json:"fingerprint"`
+	// Standard is set when the tables are the scaled IJG tables, Quality is their libjpeg quality
+	Standard bool `json:"standard"`
+	Quality  int  `json:"quality,omitempty"`
+}
+
+var (
+	cameraTablesMu sync.RWMutex
+	// cameraTables holds the known fingerprints of each camera make
+	cameraTables = make(map[string]map[string]bool)
+)
+
+// RegisterCameraTables adds fingerprints of the quantization tables a camera make is known to write.
+// Images of a make with registered fingerprints are flagged when they have other tables.
+func RegisterCameraTables(cameraMake string, fingerprints ...string) {
+	cameraTablesMu.Lock()
+	defer cameraTablesMu.Unlock()
+	key := strings.ToLower(strings.TrimSpace(cameraMake))
+	if cameraTables[key] == nil {
+		cameraTables[key] = make(map[string]bool)
+	}
+	for _, f := range fingerprints {
+		cameraTables[key][f] = true
+	}
+}
+
+// LoadCameraTables registers the fingerprints of a JSON object of camera makes and their fingerprint lists,
+// e.g. {"Canon": ["1f0c4a7e9b2d3c55"]}. Fingerprints are those reported in Quantization for original images
+// of the make. Nothing is registered if any of them is invalid.
+func LoadCameraTables(b []byte) error {
+	var tables map[string][]string
+	if err := json.Unmarshal(b, &tables); err != nil {
+		return errors.Wrap(err, "decoding camera tables")
+	}
+	for cameraMake, fingerprints := range tables {
+		if strings.TrimSpace(cameraMake) == "" {
+			return errors.New("camera tables: empty make")
+		}
+		for _, f := range fingerprints {
+			if !validFingerprint.MatchString(f) {
+				return errors.Errorf("camera tables: invalid fingerprint %q of %s", f, cameraMake)
+			}
+		}
+	}
+	for cameraMake, fingerprints := range tables {
+		RegisterCameraTables(cameraMake, fingerprints...)
+	}
+	return nil
+}
+
+// knownTables reports whether the camera make has registered fingerprints and whether fingerprint is one of them
+func knownTables(cameraMake, fingerprint string) (registered, match bool) {
+	cameraTablesMu.RLock()
+	defer cameraTablesMu.RUnlock()
+	tables, ok := cameraTables[strings.ToLower(strings.TrimSpace(cameraMake))]
+	return ok, tables[fingerprint]
+}
+
+// readQuantization reads the DQT tables of a JPEG, indexed by table id, in natural order
+func readQuantization(data []byte) (map[int][64]int, error) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errNotJPEG
+	}
+	tables := make(map[int][64]int)
+	for p := 2; p+4 <= len(data); {
+		if data[p] != 0xFF {
+			return nil, errors.Errorf("jpeg: no marker at %d", p)
+		}
+		marker := data[p+1]
+		if marker == 0xFF {
+			p++
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		n := int(binary.BigEndian.Uint16(data[p+2:]))
+		if n < 2 || p+2+n > len(data) {
+			return nil, errors.Errorf("jpeg: segment %#x overflows the file", marker)
+		}
+		if marker == 0xDB {
+			seg := data[p+4 : p+2+n]
+			for len(seg) > 0 {
+				precision, id := int(seg[0]>>4), int(seg[0]&0xF)
+				size := 64 * (precision + 1)
+				if len(seg) < 1+size {
+					return nil, errors.New("jpeg: short DQT")
+				}
+				var t [64]int
+				for i := 0; i < 64; i++ {
+					if precision == 0 {
+						t[zigzag[i]] = int(seg[1+i])
+					} else {
+						t[zigzag[i]] = int(binary.BigEndian.Uint16(seg[1+2*i:]))
+					}
+				}
+				tables[id] = t
+				seg = seg[1+size:]
+			}
+		}
+		p += 2 + n
+	}
+	if len(tables) == 0 {
+		return nil, errors.New("jpeg: no quantization tables")
+	}
+	return tables, nil
+}
+
+// analyzeQuantization fingerprints the tables and finds the IJG quality they were scaled with
+func analyzeQuantization(tables map[int][64]int) *Quantization {
+	h := sha1.New()
+	for id := 0; id < 4; id++ {
+		t, ok := tables[id]
+		if !ok {
+			continue
+		}
+		for _, v := range t {
+			h.Write([]byte{byte(v >> 8), byte(v)})
+		}
+	}
+	q := &Quantization{Fingerprint: hex.EncodeToString(h.Sum(nil))[:16]}
+
+	lum, ok := tables[0]
+	if !ok {
+		return q
+	}
+	chrom, hasChrom := tables[1]
+	for quality := 1; quality <= 100; quality++ {
+		if lum != scaleTable(ijgLuminance, quality) {
+			continue
+		}
+		if hasChrom && chrom != scaleTable(ijgChrominance, quality) {
+			continue
+		}
+		q.Standard, q.Quality = true, quality
+		break
+	}
+	return q
+}
+
+// scaleTable scales a table to a quality like libjpeg's jpeg_quality_scaling with baseline values
+func scaleTable(t [64]int, quality int) [64]int {
+	scale := 200 - 2*quality
+	if quality < 50 {
+		scale = 5000 / quality
+	}
+	var out [64]int
+	for i, v := range t {
+		v = (v*scale + 50) / 100
+		switch {
+		case v < 1:
+			v = 1
+		case v > 255:
+			v = 255
+		}
+		out[i] = v
+	}
+	return out
+}
@@ -0,0 +1,99 @@
+package image
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	goexif "github.com/rwcarlsen/goexif/exif"
+
+	"github.com/blixenkrone/gopro/pkg/exif"
+)
+
+const (
+	exifTimeLayout = "2006:01:02 15:04:05"
+	localLayout    = "2006-01-02T15:04:05"
+)
+
+// getCamera returns the camera and exposure fields that are present, or nil if there are none
+func (e *ExifMetadata) getCamera() *exif.Camera {
+	c := &exif.Camera{}
+	c.Make, _ = e.getString(goexif.Make)
+	c.Model, _ = e.getString(goexif.Model)
+	c.LensMake, _ = e.getString(goexif.LensMake)
+	c.LensModel, _ = e.getString(goexif.LensModel)
+	c.SerialNumber, _ = e.getString(BodySerialNumber)
+	c.Software, _ = e.getString(goexif.Software)
+
+	c.FNumber, _ = e.getRat(goexif.FNumber)
+	if tag, err := e.x.Get(goexif.ExposureTime); err == nil {
+		if num, den, err := tag.Rat2(0); err == nil && num > 0 && den > 0 {
+			c.ExposureTime = float64(num) / float64(den)
+			c.ShutterSpeed = shutterSpeed(num, den)
+		}
+	}
+	c.ISO, _ = e.getInt(goexif.ISOSpeedRatings)
+	c.FocalLength, _ = e.getRat(goexif.FocalLength)
+	c.FocalLength35mm, _ = e.getInt(goexif.FocalLengthIn35mmFilm)
+	c.Orientation, _ = e.getInt(goexif.Orientation)
+
+	c.DateTimeOriginal = e.formatTime(goexif.DateTimeOriginal, OffsetTimeOriginal)
+	c.DateTimeDigitized = e.formatTime(goexif.DateTimeDigitized, OffsetTimeDigitized)
+	c.DateTimeModified = e.formatTime(goexif.DateTime, OffsetTime)
+
+	if *c == (exif.Camera{}) {
+		return nil
+	}
+	return c
+}
+
+// getTime parses a date field in the time zone of its offset field. ok is false if the offset is missing,
+// the time is then in UTC.
+func (e *ExifMetadata) getTime(dateName, offsetName goexif.FieldName) (t time.Time, ok bool, err error) {
+	date, err := e.getString(dateName)
+	if err != nil {
+		return t, false, err
+	}
+	date = strings.TrimSpace(date)
+	if offset, err := e.getString(offsetName); err == nil {
+		if t, err := time.Parse(exifTimeLayout+"-07:00", date+strings.TrimSpace(offset)); err == nil {
+			return t, true, nil
+		}
+	}
+	t, err = time.Parse(exifTimeLayout, date)
+	if err != nil {
+		return t, false, errors.Wrapf(err, "parsing %s", dateName)
+	}
+	return t, false, nil
+}
+
+// formatTime returns a date field as RFC 3339, without an offset if it is unknown
+func (e *ExifMetadata) formatTime(dateName, offsetName goexif.FieldName) string {
+	t, ok, err := e.getTime(dateName, offsetName)
+	switch {
+	case err != nil:
+		return ""
+	case ok:
+		return t.Format(time.RFC3339)
+	default:
+		return t.Format(localLayout)
+	}
+}
+
+// shutterSpeed writes an exposure time like a camera does, 1/250 or 2.5
+func shutterSpeed(num, den int64) string {
+	if num < den {
+		return "1/" + strconv.FormatFloat(math.Round(float64(den)/float64(num)), 'f', -1, 64)
+	}
+	return strconv.FormatFloat(float64(num)/float64(den), 'f', -1, 64)
+}
+
+func (e *ExifMetadata) getInt(fieldName goexif.FieldName) (int, error) {
+	tag, err := e.x.Get(fieldName)
+	if err != nil {
+		return 0, err
+	}
+	return tag.Int(exifIntVal)
+}
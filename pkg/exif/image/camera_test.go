@@ -0,0 +1,88 @@
+package image
+
+import (
+	"testing"
+	"time"
+
+	"github.com/blixenkrone/gopro/internal/mediatest"
+	"github.com/blixenkrone/gopro/pkg/exif"
+)
+
+func TestCamera(t *testing.T) {
+	ifd0 := []mediatest.Entry{
+		mediatest.ASCII(0x10F, "Canon"),
+		mediatest.ASCII(0x110, "Canon EOS R5"),
+		mediatest.Short(le, 0x112, 6),
+		mediatest.ASCII(0x131, "Adobe Photoshop 24.0"),
+		mediatest.ASCII(0x132, "2021:06:02 09:00:00"),
+	}
+	exifIFD := []mediatest.Entry{
+		mediatest.Rational(le, 0x829A, 1, 250),
+		mediatest.Rational(le, 0x829D, 28, 10),
+		mediatest.Short(le, 0x8827, 400),
+		mediatest.ASCII(0x9003, "2021:06:01 18:30:15"),
+		mediatest.ASCII(0x9004, "2021:06:01 18:30:15"),
+		mediatest.ASCII(0x9011, "+02:00"),
+		mediatest.Rational(le, 0x920A, 50, 1),
+		mediatest.Short(le, 0xA405, 50),
+		mediatest.ASCII(0xA431, "012345678"),
+		mediatest.ASCII(0xA433, "Canon"),
+		mediatest.ASCII(0xA434, "RF50mm F1.2 L USM"),
+	}
+	out, err := DecodeImageMetadata(mediatest.TIFF{IFD0: ifd0, SubIFDs: map[uint16][]mediatest.Entry{0x8769: exifIFD}}.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := exif.Camera{
+		Make:              "Canon",
+		Model:             "Canon EOS R5",
+		LensMake:          "Canon",
+		LensModel:         "RF50mm F1.2 L USM",
+		SerialNumber:      "012345678",
+		Software:          "Adobe Photoshop 24.0",
+		FNumber:           2.8,
+		ExposureTime:      0.004,
+		ShutterSpeed:      "1/250",
+		ISO:               400,
+		FocalLength:       50,
+		FocalLength35mm:   50,
+		Orientation:       6,
+		DateTimeOriginal:  "2021-06-01T18:30:15+02:00",
+		DateTimeDigitized: "2021-06-01T18:30:15",
+		DateTimeModified:  "2021-06-02T09:00:00",
+	}
+	if out.Camera == nil || *out.Camera != want {
+		t.Fatalf("got camera %+v\nwant %+v", out.Camera, want)
+	}
+	if captured := time.Date(2021, 6, 1, 16, 30, 15, 0, time.UTC); out.Date != captured.UnixNano()/1e6 {
+		t.Errorf("date %d, want %d from the original offset", out.Date, captured.UnixNano()/1e6)
+	}
+}
+
+func TestShutterSpeed(t *testing.T) {
+	tests := []struct {
+		num, den int64
+		want     string
+	}{
+		{1, 250, "1/250"},
+		{10, 600, "1/60"},
+		{1, 1, "1"},
+		{5, 2, "2.5"},
+		{30, 1, "30"},
+	}
+	for _, tt := range tests {
+		if got := shutterSpeed(tt.num, tt.den); got != tt.want {
+			t.Errorf("shutterSpeed(%d, %d) = %s, want %s", tt.num, tt.den, got, tt.want)
+		}
+	}
+}
+
+func TestCameraMissing(t *testing.T) {
+	out, err := DecodeImageMetadata(gpsTIFF("N", "E"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Camera == nil || out.Camera.Model != "Test Camera" || out.Camera.ISO != 0 {
+		t.Errorf("got camera %+v, want only the model", out.Camera)
+	}
+}
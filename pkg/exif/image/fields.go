@@ -0,0 +1,62 @@
+package image
+
+import (
+	"bytes"
+	"io"
+
+	goexif "github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+)
+
+// Fields of EXIF 2.31 that goexif doesn't know, loaded by fieldParser
+const (
+	OffsetTime           goexif.FieldName = "OffsetTime"
+	OffsetTimeOriginal   goexif.FieldName = "OffsetTimeOriginal"
+	OffsetTimeDigitized  goexif.FieldName = "OffsetTimeDigitized"
+	BodySerialNumber     goexif.FieldName = "BodySerialNumber"
+	GPSHPositioningError goexif.FieldName = "GPSHPositioningError"
+)
+
+// extraFields are keyed by the pointer to the sub IFD they are in
+var extraFields = map[goexif.FieldName]map[uint16]goexif.FieldName{
+	goexif.ExifIFDPointer: {
+		0x9010: OffsetTime,
+		0x9011: OffsetTimeOriginal,
+		0x9012: OffsetTimeDigitized,
+		0xA431: BodySerialNumber,
+	},
+	goexif.GPSInfoIFDPointer: {
+		0x1F: GPSHPositioningError,
+	},
+}
+
+func init() {
+	goexif.RegisterParsers(fieldParser{})
+}
+
+// fieldParser loads extraFields after the goexif parser
+type fieldParser struct{}
+
+func (fieldParser) Parse(x *goexif.Exif) error {
+	for ptr, fields := range extraFields {
+		tag, err := x.Get(ptr)
+		if err != nil {
+			continue
+		}
+		offset, err := tag.Int64(0)
+		if err != nil {
+			continue
+		}
+		r := bytes.NewReader(x.Raw)
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			continue
+		}
+		// the goexif parser already reported a broken sub IFD
+		dir, _, err := tiff.DecodeDir(r, x.Tiff.Order)
+		if err != nil {
+			continue
+		}
+		x.LoadTags(dir, fields, false)
+	}
+	return nil
+}
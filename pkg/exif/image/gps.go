@@ -0,0 +1,131 @@
+package image
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	goexif "github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+
+	"github.com/blixenkrone/gopro/pkg/conversion"
+)
+
+// calcGeoCoordinate returns the degrees of a latitude or longitude, negative in the southern
+// and western hemisphere as given by refName
+func (e *ExifMetadata) calcGeoCoordinate(fieldName, refName goexif.FieldName) (float64, error) {
+	tag, err := e.x.Get(fieldName)
+	if err != nil {
+		return 0.0, errors.WithMessagef(err, "error getting location coordinates from %s", fieldName)
+	}
+	var dms [3]float64
+	for i := range dms {
+		if dms[i], err = ratFloat(tag, i); err != nil {
+			return 0.0, err
+		}
+	}
+	res := dms[0] + (dms[1] / 60) + (dms[2] / 3600)
+
+	// without a ref there's nothing better than assuming north and east
+	ref, err := e.getString(refName)
+	if err != nil {
+		return res, nil
+	}
+	switch strings.ToUpper(strings.TrimSpace(ref)) {
+	case "N", "E":
+	case "S", "W":
+		res = -res
+	default:
+		return 0.0, errors.Errorf("unknown %s %q", refName, ref)
+	}
+	return res, nil
+}
+
+// getAltitude returns the altitude in meters, negative below sea level
+func (e *ExifMetadata) getAltitude() (*float64, error) {
+	alt, err := e.getRat(goexif.GPSAltitude)
+	if err != nil {
+		return nil, err
+	}
+	// 0 is above and 1 below sea level, a missing ref means above
+	if tag, err := e.x.Get(goexif.GPSAltitudeRef); err == nil {
+		if ref, err := tag.Int(0); err == nil && ref == 1 {
+			alt = -alt
+		}
+	}
+	return &alt, nil
+}
+
+// getGPSTime returns the UTC time of the GPS fix from GPSDateStamp and GPSTimeStamp in milliseconds
+func (e *ExifMetadata) getGPSTime() (int64, error) {
+	date, err := e.getString(goexif.GPSDateStamp)
+	if err != nil {
+		return 0, err
+	}
+	day, err := time.Parse("2006:01:02", strings.TrimSpace(date))
+	if err != nil {
+		return 0, errors.Wrap(err, "parsing GPSDateStamp")
+	}
+	tag, err := e.x.Get(goexif.GPSTimeStamp)
+	if err != nil {
+		return 0, err
+	}
+	var hms [3]float64
+	for i := range hms {
+		if hms[i], err = ratFloat(tag, i); err != nil {
+			return 0, err
+		}
+	}
+	secs := hms[0]*3600 + hms[1]*60 + hms[2]
+	t := day.Add(time.Duration(secs * float64(time.Second)))
+	return conversion.UnixNanoToMillis(t), nil
+}
+
+// getImgDirection returns the bearing of the camera in degrees and whether it is relative to true or magnetic north
+func (e *ExifMetadata) getImgDirection() (*float64, string, error) {
+	dir, err := e.getRat(goexif.GPSImgDirection)
+	if err != nil {
+		return nil, "", err
+	}
+	ref, err := e.getString(goexif.GPSImgDirectionRef)
+	if err != nil {
+		ref = ""
+	}
+	return &dir, strings.ToUpper(strings.TrimSpace(ref)), nil
+}
+
+// getHPositioningError returns the horizontal accuracy in meters
+func (e *ExifMetadata) getHPositioningError() (*float64, error) {
+	v, err := e.getRat(GPSHPositioningError)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func (e *ExifMetadata) getRat(fieldName goexif.FieldName) (float64, error) {
+	tag, err := e.x.Get(fieldName)
+	if err != nil {
+		return 0, err
+	}
+	return ratFloat(tag, 0)
+}
+
+func (e *ExifMetadata) getString(fieldName goexif.FieldName) (string, error) {
+	tag, err := e.x.Get(fieldName)
+	if err != nil {
+		return "", err
+	}
+	return tag.StringVal()
+}
+
+func ratFloat(tag *tiff.Tag, i int) (float64, error) {
+	num, den, err := tag.Rat2(i)
+	if err != nil {
+		return 0, err
+	}
+	if den == 0 {
+		return 0, errors.Errorf("%d/0 in tag %#x", num, tag.Id)
+	}
+	return float64(num) / float64(den), nil
+}
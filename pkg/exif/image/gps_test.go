@@ -0,0 +1,96 @@
+package image
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/blixenkrone/gopro/internal/mediatest"
+)
+
+var le = binary.LittleEndian
+
+func gpsTIFF(latRef, lngRef string, extra ...mediatest.Entry) []byte {
+	gps := append([]mediatest.Entry{
+		mediatest.ASCII(0x1, latRef),
+		mediatest.Rational(le, 0x2, 33, 1, 51, 1, 3596, 100),
+		mediatest.ASCII(0x3, lngRef),
+		mediatest.Rational(le, 0x4, 151, 1, 12, 1, 4080, 100),
+	}, extra...)
+	return mediatest.TIFF{
+		IFD0:    []mediatest.Entry{mediatest.ASCII(0x110, "Test Camera")},
+		SubIFDs: map[uint16][]mediatest.Entry{0x8825: gps},
+	}.Bytes()
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestGPSHemisphere(t *testing.T) {
+	const lat, lng = 33 + 51.0/60 + 35.96/3600, 151 + 12.0/60 + 40.8/3600
+	tests := []struct {
+		latRef, lngRef string
+		lat, lng       float64
+	}{
+		{"N", "E", lat, lng},
+		{"S", "E", -lat, lng},
+		{"N", "W", lat, -lng},
+		{"S", "W", -lat, -lng},
+	}
+	for _, tt := range tests {
+		out, err := DecodeImageMetadata(gpsTIFF(tt.latRef, tt.lngRef))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !near(out.Lat, tt.lat) || !near(out.Lng, tt.lng) {
+			t.Errorf("%s %s: got %f,%f want %f,%f", tt.latRef, tt.lngRef, out.Lat, out.Lng, tt.lat, tt.lng)
+		}
+		if _, ok := out.MissingExif["lat"]; ok {
+			t.Errorf("%s %s: lat reported missing", tt.latRef, tt.lngRef)
+		}
+	}
+}
+
+func TestGPSExtended(t *testing.T) {
+	out, err := DecodeImageMetadata(gpsTIFF("S", "E",
+		mediatest.Byte(0x5, 1),
+		mediatest.Rational(le, 0x6, 125, 10),
+		mediatest.Rational(le, 0x7, 14, 1, 3, 1, 2550, 100),
+		mediatest.ASCII(0x10, "M"),
+		mediatest.Rational(le, 0x11, 27150, 100),
+		mediatest.ASCII(0x1D, "2019:04:01"),
+		mediatest.Rational(le, 0x1F, 65, 10),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Altitude == nil || !near(*out.Altitude, -12.5) {
+		t.Errorf("altitude %v, want -12.5", out.Altitude)
+	}
+	if want := time.Date(2019, 4, 1, 14, 3, 25, 500e6, time.UTC).UnixNano() / 1e6; out.GPSTime != want {
+		t.Errorf("gps time %d, want %d", out.GPSTime, want)
+	}
+	if out.ImgDirection == nil || !near(*out.ImgDirection, 271.5) || out.ImgDirectionRef != "M" {
+		t.Errorf("direction %v %q, want 271.5 M", out.ImgDirection, out.ImgDirectionRef)
+	}
+	if out.HPositioningError == nil || !near(*out.HPositioningError, 6.5) {
+		t.Errorf("positioning error %v, want 6.5", out.HPositioningError)
+	}
+}
+
+func TestGPSOptional(t *testing.T) {
+	out, err := DecodeImageMetadata(gpsTIFF("N", "E", mediatest.Short(le, 0x0, 2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Altitude != nil || out.GPSTime != 0 || out.ImgDirection != nil || out.HPositioningError != nil {
+		t.Errorf("got extended GPS fields from a file without them: %+v", out)
+	}
+	for _, k := range []string{"altitude", "gpsTime", "imgDirection"} {
+		if _, ok := out.MissingExif[k]; ok {
+			t.Errorf("%s reported missing", k)
+		}
+	}
+}
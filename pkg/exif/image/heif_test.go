@@ -0,0 +1,36 @@
+package image
+
+import (
+	"testing"
+
+	"github.com/blixenkrone/gopro/internal/mediatest"
+)
+
+// heicWithExif builds a HEIC whose only stored item is the Exif, at the end of the file
+func heicWithExif(tiff []byte) []byte {
+	exifItem := append(mediatest.U32(0), tiff...)
+	ftyp := mediatest.Box("ftyp", []byte("heic"), mediatest.U32(0), []byte("mif1"))
+	meta := func(offset uint32) []byte {
+		return mediatest.FullBox("meta", 0, 0,
+			mediatest.FullBox("pitm", 0, 0, []byte{0, 1}),
+			mediatest.FullBox("iinf", 0, 0, []byte{0, 2},
+				mediatest.FullBox("infe", 2, 0, []byte{0, 1, 0, 0}, []byte("hvc1\x00")),
+				mediatest.FullBox("infe", 2, 0, []byte{0, 2, 0, 0}, []byte("Exif\x00")),
+			),
+			// version 0, 4 byte offsets and lengths, one item with one extent
+			mediatest.FullBox("iloc", 0, 0, []byte{0x44, 0, 0, 1, 0, 2, 0, 0, 0, 1}, mediatest.U32(offset, uint32(len(exifItem)))),
+		)
+	}
+	offset := len(ftyp) + len(meta(0)) + 8
+	return append(append(ftyp, meta(uint32(offset))...), mediatest.Box("mdat", exifItem)...)
+}
+
+func TestDecodeHEIC(t *testing.T) {
+	out, err := DecodeImageMetadata(heicWithExif(gpsTIFF("S", "W")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Model != "Test Camera" || out.Lat >= 0 || out.Lng >= 0 {
+		t.Errorf("got %+v", out)
+	}
+}
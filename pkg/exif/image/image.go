@@ -11,6 +11,8 @@ import (
 
 	"github.com/blixenkrone/gopro/pkg/conversion"
 	"github.com/blixenkrone/gopro/pkg/exif"
+	"github.com/blixenkrone/gopro/pkg/exif/iptc"
+	"github.com/blixenkrone/gopro/pkg/image/heif"
 	"github.com/blixenkrone/gopro/pkg/logger"
 
 	goexif "github.com/rwcarlsen/goexif/exif"
@@ -47,31 +49,47 @@ func (r *ExifReader) Read(b []byte) error {
 
 // DecodeImageMetadata returns the struct *Output containing img data.
 // This will include the errors from missing/broken exif will follow.
-// If an error is != nil, its a panic
+// Without readable exif the error is returned along with an output of the IPTC and XMP.
+// HEIF images are read from their Exif item.
 func DecodeImageMetadata(data []byte) (*exif.Output, error) {
-	r := bytes.NewReader(data)
 	xErr := &exif.Output{MissingExif: make(map[string]string)}
 
-	x, err := loadExifData(r)
+	r, hf, err := exifSource(data)
+	if err != nil && hf == nil && heif.IsHEIF(data) {
+		return nil, err
+	}
+	var x *ExifMetadata
+	if err == nil {
+		x, err = loadExifData(r)
+	}
 	if err != nil {
-		err = errors.Cause(err)
-		if err == io.ErrUnexpectedEOF || err == io.EOF {
-			return nil, errors.New(EOFError)
+		cause := errors.Cause(err)
+		if cause == io.ErrUnexpectedEOF || cause == io.EOF {
+			err = errors.New(EOFError)
 		}
-		// Missing exif should probably not happen
-		xErr.AddMissingExif("decode", err)
-		return nil, err
+		xErr.AddMissingExif("decode", cause)
+		meta, iptcErr := readIPTC(data, hf)
+		if iptcErr != nil {
+			log.Errorf("reading iptc: %v", iptcErr)
+		}
+		xErr.AddIPTC(meta)
+		return xErr, err
 	}
-	lat, err := x.calcGeoCoordinate(goexif.GPSLatitude)
+	lat, err := x.calcGeoCoordinate(goexif.GPSLatitude, goexif.GPSLatitudeRef)
 	if err != nil {
 		err = errors.Cause(err)
 		xErr.AddMissingExif("lat", err)
 	}
-	lng, err := x.calcGeoCoordinate(goexif.GPSLongitude)
+	lng, err := x.calcGeoCoordinate(goexif.GPSLongitude, goexif.GPSLongitudeRef)
 	if err != nil {
 		err = errors.Cause(err)
 		xErr.AddMissingExif("lng", err)
 	}
+	// the extended GPS tags are optional and aren't reported as missing
+	altitude, _ := x.getAltitude()
+	gpsTime, _ := x.getGPSTime()
+	direction, directionRef, _ := x.getImgDirection()
+	posErr, _ := x.getHPositioningError()
 	date, err := x.getDateTime()
 	if err != nil {
 		err = errors.Cause(err)
@@ -88,65 +106,118 @@ func DecodeImageMetadata(data []byte) (*exif.Output, error) {
 		xErr.AddMissingExif("model", err)
 	}
 	dimensions, err := x.getImageDimensions()
+	if err != nil && hf != nil {
+		w, h := hf.Size()
+		dimensions, err = map[goexif.FieldName]int{goexif.PixelXDimension: w, goexif.PixelYDimension: h}, nil
+	}
 	if err != nil {
 		err = errors.Cause(err)
 		xErr.AddMissingExif("dimension", err)
 	}
-	size, err := x.getFileSize(r)
-	if err != nil {
+	var size float64
+	if hf != nil {
+		size = conversion.FileSizeBytesToFloat(len(data))
+	} else if size, err = x.getFileSize(r); err != nil {
 		err = errors.Cause(err)
 		xErr.AddMissingExif("fileSize", err)
 	}
 
-	return &exif.Output{
-		Lat:             lat,
-		Lng:             lng,
-		Date:            date,
-		Model:           model,
-		PixelXDimension: dimensions[goexif.PixelXDimension],
-		PixelYDimension: dimensions[goexif.PixelYDimension],
-		Copyright:       author,
-		MediaSize:       size,
-		MissingExif:     xErr.MissingExif,
+	out := &exif.Output{
+		Lat:               lat,
+		Lng:               lng,
+		Altitude:          altitude,
+		GPSTime:           gpsTime,
+		ImgDirection:      direction,
+		ImgDirectionRef:   directionRef,
+		HPositioningError: posErr,
+		Date:              date,
+		Model:             model,
+		PixelXDimension:   dimensions[goexif.PixelXDimension],
+		PixelYDimension:   dimensions[goexif.PixelYDimension],
+		Copyright:         author,
+		Camera:            x.getCamera(),
+		MediaSize:         size,
+		MissingExif:       xErr.MissingExif,
 		// ? do this MediaFormat:     mediaFmt,
-	}, nil
+	}
+	meta, err := readIPTC(data, hf)
+	if err != nil {
+		log.Errorf("reading iptc: %v", err)
+	}
+	out.AddIPTC(meta)
+	return out, nil
 }
 
-// loadExifData request exif data for image
-func loadExifData(r io.Reader) (*ExifMetadata, error) {
-	x, err := goexif.Decode(r)
+// readIPTC reads the IPTC and XMP of a JPEG, or the XMP item of a HEIF
+func readIPTC(data []byte, hf *heif.File) (*exif.IPTC, error) {
+	if hf == nil {
+		meta, err := iptc.Decode(data)
+		if err == iptc.ErrNotJPEG {
+			return nil, nil
+		}
+		return meta, err
+	}
+	xmp, err := hf.XMP()
+	if err == heif.ErrNoXMP {
+		return nil, nil
+	}
 	if err != nil {
-		err := errors.Wrap(err, "loading exif error")
 		return nil, err
 	}
-	return &ExifMetadata{x}, nil
+	return iptc.DecodeXMP(xmp)
 }
 
-func (e *ExifMetadata) calcGeoCoordinate(fieldName goexif.FieldName) (float64, error) {
-	tag, err := e.x.Get(fieldName)
+// LoadExif decodes the EXIF of a JPEG, TIFF or HEIF image, including the fields goexif doesn't know
+func LoadExif(data []byte) (*goexif.Exif, error) {
+	r, _, err := exifSource(data)
 	if err != nil {
-		return 0.0, errors.WithMessagef(err, "error getting location coordinates from %s", fieldName)
+		return nil, err
 	}
-	ratValues := map[string]int{"deg": 0, "min": 1, "sec": 2}
-	fValues := make(map[string]float64, len(ratValues))
+	x, err := loadExifData(r)
+	if err != nil {
+		return nil, err
+	}
+	return x.x, nil
+}
 
-	for key, val := range ratValues {
-		v, err := tag.Rat(val)
-		if err != nil {
-			return 0.0, err
-		}
-		f, _ := v.Float64()
-		fValues[key] = f
+// exifSource returns a reader of the EXIF of the image, the Exif item of a HEIF or else the image itself
+func exifSource(data []byte) (*bytes.Reader, *heif.File, error) {
+	if !heif.IsHEIF(data) {
+		return bytes.NewReader(data), nil, nil
 	}
+	hf, err := heif.Parse(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	tiff, err := hf.Exif()
+	if err != nil {
+		// the file is still read for its XMP
+		return nil, hf, err
+	}
+	return bytes.NewReader(tiff), hf, nil
+}
 
-	res := fValues["deg"] + (fValues["min"] / 60) + (fValues["sec"] / 3600)
-	return res, nil
+// loadExifData request exif data for image
+func loadExifData(r io.Reader) (*ExifMetadata, error) {
+	x, err := goexif.Decode(r)
+	if err != nil {
+		err := errors.Wrap(err, "loading exif error")
+		return nil, err
+	}
+	return &ExifMetadata{x}, nil
 }
 
+// getDateTime returns the capture time in the time zone of OffsetTimeOriginal, or OffsetTime for the
+// modification date. Without an offset the goexif guess of the time zone is used.
 func (e *ExifMetadata) getDateTime() (d int64, err error) {
-	t, err := e.x.DateTime()
+	t, ok, err := e.getTime(goexif.DateTimeOriginal, OffsetTimeOriginal)
 	if err != nil {
-		return d, err
+		t, ok, err = e.getTime(goexif.DateTime, OffsetTime)
+	}
+	if err != nil || !ok {
+		if t, err = e.x.DateTime(); err != nil {
+			return d, err
+		}
 	}
 	d = conversion.UnixNanoToMillis(t)
 	return d, nil
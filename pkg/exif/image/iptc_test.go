@@ -0,0 +1,24 @@
+package image
+
+import (
+	"testing"
+
+	"github.com/blixenkrone/gopro/internal/mediatest"
+)
+
+func TestDecodeWithoutExif(t *testing.T) {
+	xmp := []byte("http://ns.adobe.com/xap/1.0/\x00" + `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description xmlns:photoshop="http://ns.adobe.com/photoshop/1.0/" photoshop:Credit="Byrd"/>
+ </rdf:RDF>
+</x:xmpmeta>`)
+	jpg := append(append([]byte{0xFF, 0xD8}, mediatest.Segment(0xE1, xmp)...), 0xFF, 0xDA, 0, 2, 0xFF, 0xD9)
+
+	out, err := DecodeImageMetadata(jpg)
+	if err == nil {
+		t.Error("expected an error without exif")
+	}
+	if out == nil || out.IPTC == nil || out.IPTC.Credit != "Byrd" || out.MissingExif["decode"] == "" {
+		t.Errorf("got %+v", out)
+	}
+}
@@ -0,0 +1,236 @@
+// Package iptc reads the descriptive metadata agencies embed in images: the IPTC-IIM block of
+// the Photoshop APP13 segment and the XMP packet of the APP1 segment of JPEGs, and XMP sidecars.
+package iptc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+
+	"github.com/blixenkrone/gopro/pkg/exif"
+)
+
+var (
+	ErrNotJPEG = errors.New("not a jpeg")
+
+	xmpHeader       = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	photoshopHeader = []byte("Photoshop 3.0\x00")
+)
+
+const (
+	markerSOI   = 0xD8
+	markerEOI   = 0xD9
+	markerSOS   = 0xDA
+	markerAPP1  = 0xE1
+	markerAPP13 = 0xED
+
+	// iimResource is the Photoshop image resource holding the IPTC-IIM records
+	iimResource = 0x0404
+)
+
+// Decode reads the IPTC-IIM and XMP of a JPEG. XMP takes precedence over IIM where both are set.
+// It returns nil if the JPEG has neither.
+func Decode(data []byte) (*exif.IPTC, error) {
+	var photoshop, xmp []byte
+	err := appSegments(data, func(marker byte, payload []byte) {
+		switch {
+		case marker == markerAPP13 && bytes.HasPrefix(payload, photoshopHeader):
+			// large resources continue in the next APP13 segment
+			photoshop = append(photoshop, payload[len(photoshopHeader):]...)
+		case marker == markerAPP1 && bytes.HasPrefix(payload, xmpHeader) && xmp == nil:
+			xmp = payload[len(xmpHeader):]
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var out *exif.IPTC
+	if iim := photoshopResource(photoshop, iimResource); iim != nil {
+		if out, err = DecodeIIM(iim); err != nil {
+			return nil, err
+		}
+	}
+	if xmp != nil {
+		x, err := DecodeXMP(xmp)
+		if err != nil {
+			return nil, err
+		}
+		if out == nil {
+			out = x
+		} else {
+			out.Merge(x)
+		}
+	}
+	return out, nil
+}
+
+// appSegments calls fn with the APPn segments of a JPEG up to the image data
+func appSegments(data []byte, fn func(marker byte, payload []byte)) error {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != markerSOI {
+		return ErrNotJPEG
+	}
+	for p := 2; p+4 <= len(data); {
+		if data[p] != 0xFF {
+			return errors.Errorf("jpeg: no marker at %d", p)
+		}
+		marker := data[p+1]
+		switch {
+		case marker == 0xFF:
+			// fill byte
+			p++
+			continue
+		case marker == markerSOS || marker == markerEOI:
+			return nil
+		case marker == 0x01 || marker >= 0xD0 && marker <= 0xD7:
+			p += 2
+			continue
+		}
+		n := int(binary.BigEndian.Uint16(data[p+2:]))
+		if n < 2 || p+2+n > len(data) {
+			return errors.Errorf("jpeg: segment %#x at %d overflows the file", marker, p)
+		}
+		if marker >= 0xE0 && marker <= 0xEF {
+			fn(marker, data[p+4:p+2+n])
+		}
+		p += 2 + n
+	}
+	return nil
+}
+
+// photoshopResource returns the data of the 8BIM image resource with the id, or nil
+func photoshopResource(b []byte, id uint16) []byte {
+	for len(b) >= 12 && bytes.HasPrefix(b, []byte("8BIM")) {
+		resID := binary.BigEndian.Uint16(b[4:])
+		// the pascal name is padded to an even length
+		nameLen := int(b[6]) + 1
+		nameLen += nameLen % 2
+		p := 6 + nameLen
+		if p+4 > len(b) {
+			return nil
+		}
+		size := int(binary.BigEndian.Uint32(b[p:]))
+		p += 4
+		if size < 0 || p+size > len(b) {
+			return nil
+		}
+		if resID == id {
+			return b[p : p+size]
+		}
+		p += size + size%2
+		if p > len(b) {
+			return nil
+		}
+		b = b[p:]
+	}
+	return nil
+}
+
+// IIM datasets of the application record
+const (
+	iimObjectName  = 5
+	iimKeywords    = 25
+	iimByline      = 80
+	iimBylineTitle = 85
+	iimCity        = 90
+	iimSublocation = 92
+	iimState       = 95
+	iimCountryCode = 100
+	iimCountry     = 101
+	iimHeadline    = 105
+	iimCredit      = 110
+	iimSource      = 115
+	iimCopyright   = 116
+	iimCaption     = 120
+)
+
+// DecodeIIM reads IPTC-IIM records. Text is UTF-8 when the envelope says so, else ISO 8859-1.
+func DecodeIIM(b []byte) (*exif.IPTC, error) {
+	out := &exif.IPTC{}
+	utf8Charset := false
+	for p := 0; p < len(b); {
+		if b[p] != 0x1C {
+			// trailing padding
+			break
+		}
+		if p+5 > len(b) {
+			return nil, errors.New("iptc: truncated dataset")
+		}
+		record, dataset := b[p+1], b[p+2]
+		n := int(binary.BigEndian.Uint16(b[p+3:]))
+		p += 5
+		if n&0x8000 != 0 {
+			// extended dataset, the length is in the next n bytes
+			lenBytes := n & 0x7FFF
+			if lenBytes > 4 || p+lenBytes > len(b) {
+				return nil, errors.New("iptc: invalid extended dataset")
+			}
+			n = 0
+			for _, c := range b[p : p+lenBytes] {
+				n = n<<8 | int(c)
+			}
+			p += lenBytes
+		}
+		if n < 0 || p+n > len(b) {
+			return nil, errors.New("iptc: dataset overflows the block")
+		}
+		value := b[p : p+n]
+		p += n
+
+		if record == 1 && dataset == 90 {
+			// coded character set, ESC % G is UTF-8
+			utf8Charset = bytes.Equal(value, []byte("\x1b%G"))
+			continue
+		}
+		if record != 2 {
+			continue
+		}
+		s := iimString(value, utf8Charset)
+		switch dataset {
+		case iimObjectName:
+			out.Title = s
+		case iimKeywords:
+			out.Keywords = append(out.Keywords, s)
+		case iimByline:
+			out.Byline = append(out.Byline, s)
+		case iimBylineTitle:
+			out.BylineTitle = s
+		case iimCity:
+			out.City = s
+		case iimSublocation:
+			out.Sublocation = s
+		case iimState:
+			out.State = s
+		case iimCountryCode:
+			out.CountryCode = s
+		case iimCountry:
+			out.Country = s
+		case iimHeadline:
+			out.Headline = s
+		case iimCredit:
+			out.Credit = s
+		case iimSource:
+			out.Source = s
+		case iimCopyright:
+			out.Copyright = s
+		case iimCaption:
+			out.Caption = s
+		}
+	}
+	return out, nil
+}
+
+// iimString decodes a value as UTF-8 if declared or valid, else as ISO 8859-1
+func iimString(b []byte, isUTF8 bool) string {
+	if isUTF8 || utf8.Valid(b) {
+		return strings.TrimSpace(string(b))
+	}
+	r := make([]rune, len(b))
+	for i, c := range b {
+		r[i] = rune(c)
+	}
+	return strings.TrimSpace(string(r))
+}
@@ -0,0 +1,174 @@
+package iptc
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/blixenkrone/gopro/pkg/exif"
+)
+
+func dataset(record, num byte, value string) []byte {
+	b := []byte{0x1C, record, num, 0, 0}
+	binary.BigEndian.PutUint16(b[3:], uint16(len(value)))
+	return append(b, value...)
+}
+
+func resource(id uint16, data []byte) []byte {
+	b := []byte("8BIM")
+	b = append(b, byte(id>>8), byte(id), 0, 0)
+	size := make([]byte, 4)
+	binary.BigEndian.PutUint32(size, uint32(len(data)))
+	b = append(b, size...)
+	b = append(b, data...)
+	if len(data)%2 == 1 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func segment(marker byte, payload []byte) []byte {
+	b := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(b[2:], uint16(len(payload)+2))
+	return append(b, payload...)
+}
+
+func jpeg(segments ...[]byte) []byte {
+	b := []byte{0xFF, markerSOI}
+	for _, s := range segments {
+		b = append(b, s...)
+	}
+	// the scan isn't read
+	return append(b, 0xFF, markerSOS, 0, 2, 0xFF, markerEOI)
+}
+
+func iimBlock(latin1 bool) []byte {
+	var iim []byte
+	if !latin1 {
+		iim = append(iim, dataset(1, 90, "\x1b%G")...)
+	}
+	caption := "Protesters gather in Århus"
+	if latin1 {
+		caption = "Protesters gather in \xc5rhus"
+	}
+	for _, d := range [][]byte{
+		dataset(2, iimObjectName, "protest-01"),
+		dataset(2, iimKeywords, "protest"),
+		dataset(2, iimKeywords, "denmark"),
+		dataset(2, iimByline, "Jane Doe"),
+		dataset(2, iimCity, "Aarhus"),
+		dataset(2, iimCountry, "Denmark"),
+		dataset(2, iimCountryCode, "DNK"),
+		dataset(2, iimCredit, "Byrd"),
+		dataset(2, iimCopyright, "© 2020 Jane Doe"),
+		dataset(2, iimCaption, caption),
+	} {
+		iim = append(iim, d...)
+	}
+	return iim
+}
+
+const xmpPacket = `<?xpacket begin="" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmlns:photoshop="http://ns.adobe.com/photoshop/1.0/"
+    xmlns:Iptc4xmpCore="http://iptc.org/std/Iptc4xmpCore/1.0/xmlns/"
+    xmlns:xmpRights="http://ns.adobe.com/xap/1.0/rights/"
+    photoshop:City="Aarhus C"
+    photoshop:Headline="Climate march">
+   <dc:description>
+    <rdf:Alt>
+     <rdf:li xml:lang="da">Demonstranter samles</rdf:li>
+     <rdf:li xml:lang="x-default">Protesters gather</rdf:li>
+    </rdf:Alt>
+   </dc:description>
+   <dc:subject>
+    <rdf:Bag><rdf:li>climate</rdf:li><rdf:li>march</rdf:li></rdf:Bag>
+   </dc:subject>
+   <Iptc4xmpCore:Location>Rådhuspladsen</Iptc4xmpCore:Location>
+   <xmpRights:UsageTerms><rdf:Alt><rdf:li xml:lang="x-default">Editorial use only</rdf:li></rdf:Alt></xmpRights:UsageTerms>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`
+
+func TestDecodeIIM(t *testing.T) {
+	want := &exif.IPTC{
+		Title:       "protest-01",
+		Caption:     "Protesters gather in Århus",
+		Byline:      []string{"Jane Doe"},
+		Credit:      "Byrd",
+		Copyright:   "© 2020 Jane Doe",
+		Keywords:    []string{"protest", "denmark"},
+		City:        "Aarhus",
+		Country:     "Denmark",
+		CountryCode: "DNK",
+	}
+	for _, latin1 := range []bool{false, true} {
+		got, err := DecodeIIM(iimBlock(latin1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("latin1 %v: got %+v\nwant %+v", latin1, got, want)
+		}
+	}
+}
+
+func TestDecodeXMP(t *testing.T) {
+	got, err := DecodeXMP([]byte(xmpPacket))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &exif.IPTC{
+		Headline:    "Climate march",
+		Caption:     "Protesters gather",
+		Keywords:    []string{"climate", "march"},
+		UsageTerms:  "Editorial use only",
+		Sublocation: "Rådhuspladsen",
+		City:        "Aarhus C",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	data := jpeg(
+		segment(0xE0, []byte("JFIF\x00")),
+		segment(markerAPP1, append(append([]byte{}, xmpHeader...), xmpPacket...)),
+		segment(markerAPP13, append(append([]byte{}, photoshopHeader...), append(resource(0x03ED, []byte{1, 2, 3}), resource(iimResource, iimBlock(false))...)...)),
+	)
+	got, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// XMP wins over IIM
+	if got.City != "Aarhus C" || got.Caption != "Protesters gather" || got.Keywords[0] != "climate" {
+		t.Errorf("xmp not merged over iim: %+v", got)
+	}
+	if got.Credit != "Byrd" || got.Copyright != "© 2020 Jane Doe" || got.Byline[0] != "Jane Doe" {
+		t.Errorf("iim fields missing: %+v", got)
+	}
+
+	if got, err := Decode(jpeg(segment(0xE0, []byte("JFIF\x00")))); err != nil || got != nil {
+		t.Errorf("got %+v, %v from a jpeg without metadata", got, err)
+	}
+	if _, err := Decode([]byte("\x89PNG")); err != ErrNotJPEG {
+		t.Errorf("got %v, want ErrNotJPEG", err)
+	}
+}
+
+func TestAddIPTC(t *testing.T) {
+	out := &exif.Output{MissingExif: map[string]string{"copyright": "error parsing from type copyright"}}
+	out.AddIPTC(&exif.IPTC{Copyright: "© Byrd", City: "Aarhus"})
+	out.AddIPTC(&exif.IPTC{City: "Odense"})
+	if out.Copyright != "© Byrd" || out.IPTC.City != "Odense" {
+		t.Errorf("got %+v %+v", out, out.IPTC)
+	}
+	if _, ok := out.MissingExif["copyright"]; ok {
+		t.Error("copyright still missing")
+	}
+}
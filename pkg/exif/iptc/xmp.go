@@ -0,0 +1,135 @@
+package iptc
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/blixenkrone/gopro/pkg/exif"
+)
+
+// XMP namespaces
+const (
+	nsRDF       = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	nsXML       = "http://www.w3.org/XML/1998/namespace"
+	nsDC        = "http://purl.org/dc/elements/1.1/"
+	nsPhotoshop = "http://ns.adobe.com/photoshop/1.0/"
+	nsIptcCore  = "http://iptc.org/std/Iptc4xmpCore/1.0/xmlns/"
+	nsRights    = "http://ns.adobe.com/xap/1.0/rights/"
+)
+
+// xmpFields maps the XMP properties to the fields they are read into
+var xmpFields = map[xml.Name]func(o *exif.IPTC, values []string){
+	{Space: nsDC, Local: "title"}:                  func(o *exif.IPTC, v []string) { o.Title = v[0] },
+	{Space: nsDC, Local: "description"}:            func(o *exif.IPTC, v []string) { o.Caption = v[0] },
+	{Space: nsDC, Local: "creator"}:                func(o *exif.IPTC, v []string) { o.Byline = v },
+	{Space: nsDC, Local: "rights"}:                 func(o *exif.IPTC, v []string) { o.Copyright = v[0] },
+	{Space: nsDC, Local: "subject"}:                func(o *exif.IPTC, v []string) { o.Keywords = v },
+	{Space: nsPhotoshop, Local: "Headline"}:        func(o *exif.IPTC, v []string) { o.Headline = v[0] },
+	{Space: nsPhotoshop, Local: "AuthorsPosition"}: func(o *exif.IPTC, v []string) { o.BylineTitle = v[0] },
+	{Space: nsPhotoshop, Local: "Credit"}:          func(o *exif.IPTC, v []string) { o.Credit = v[0] },
+	{Space: nsPhotoshop, Local: "Source"}:          func(o *exif.IPTC, v []string) { o.Source = v[0] },
+	{Space: nsPhotoshop, Local: "City"}:            func(o *exif.IPTC, v []string) { o.City = v[0] },
+	{Space: nsPhotoshop, Local: "State"}:           func(o *exif.IPTC, v []string) { o.State = v[0] },
+	{Space: nsPhotoshop, Local: "Country"}:         func(o *exif.IPTC, v []string) { o.Country = v[0] },
+	{Space: nsIptcCore, Local: "CountryCode"}:      func(o *exif.IPTC, v []string) { o.CountryCode = v[0] },
+	{Space: nsIptcCore, Local: "Location"}:         func(o *exif.IPTC, v []string) { o.Sublocation = v[0] },
+	{Space: nsRights, Local: "UsageTerms"}:         func(o *exif.IPTC, v []string) { o.UsageTerms = v[0] },
+}
+
+// DecodeXMP reads an XMP packet or sidecar. Properties may be attributes or elements of rdf:Description,
+// of language alternatives the x-default value is used.
+func DecodeXMP(b []byte) (*exif.IPTC, error) {
+	values := make(map[xml.Name][]string)
+	d := xml.NewDecoder(bytes.NewReader(b))
+	d.Strict = false
+
+	var (
+		stack []xml.Name
+		// prop is the property element being read, lis its rdf:li values
+		prop     *xml.Name
+		lis      []string
+		text     strings.Builder
+		isXDeflt bool
+	)
+	parent := func() xml.Name {
+		if len(stack) < 2 {
+			return xml.Name{}
+		}
+		return stack[len(stack)-2]
+	}
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "xmp")
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name)
+			switch {
+			case t.Name == xml.Name{Space: nsRDF, Local: "Description"}:
+				for _, a := range t.Attr {
+					if _, ok := xmpFields[a.Name]; ok {
+						values[a.Name] = []string{strings.TrimSpace(a.Value)}
+					}
+				}
+			case parent() == xml.Name{Space: nsRDF, Local: "Description"}:
+				name := t.Name
+				prop, lis = &name, nil
+				text.Reset()
+			case prop != nil && t.Name == xml.Name{Space: nsRDF, Local: "li"}:
+				text.Reset()
+				isXDeflt = false
+				for _, a := range t.Attr {
+					if a.Name == (xml.Name{Space: nsXML, Local: "lang"}) && a.Value == "x-default" {
+						isXDeflt = true
+					}
+				}
+			}
+		case xml.CharData:
+			if prop != nil {
+				text.Write(t)
+			}
+		case xml.EndElement:
+			switch {
+			case prop != nil && t.Name == xml.Name{Space: nsRDF, Local: "li"}:
+				v := strings.TrimSpace(text.String())
+				text.Reset()
+				if v == "" {
+					break
+				}
+				if isXDeflt {
+					lis = append([]string{v}, lis...)
+				} else {
+					lis = append(lis, v)
+				}
+			case prop != nil && t.Name == *prop:
+				if _, ok := xmpFields[*prop]; ok {
+					if len(lis) > 0 {
+						values[*prop] = lis
+					} else if v := strings.TrimSpace(text.String()); v != "" {
+						values[*prop] = []string{v}
+					}
+				}
+				prop, lis = nil, nil
+			}
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+
+	out := &exif.IPTC{}
+	for name, v := range values {
+		if len(v) > 0 {
+			xmpFields[name](out, v)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,185 @@
+// Package spec checks media against the delivery requirements of a media customer,
+// like a minimum resolution, a maximum age or a set of accepted codecs.
+package spec
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/blixenkrone/gopro/pkg/exif"
+)
+
+// Rule names reported in a Result
+const (
+	RuleResolution  = "resolution"
+	RuleMaxAge      = "maxAge"
+	RuleGPS         = "gps"
+	RuleOrientation = "orientation"
+	RuleCodec       = "codec"
+)
+
+const (
+	Landscape = "landscape"
+	Portrait  = "portrait"
+)
+
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// Spec is a named set of rules owned by a media customer
+type Spec struct {
+	Name      string     `json:"name"`
+	MediaUID  string     `json:"mediaUID"`
+	Rules     Rules      `json:"rules"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+}
+
+// Rules are the requirements of a spec. Zero values are not checked.
+type Rules struct {
+	// MinWidth and MinHeight are compared to the long and short side, so they hold for either orientation
+	MinWidth  int `json:"minWidth,omitempty"`
+	MinHeight int `json:"minHeight,omitempty"`
+	// MaxAgeHours is how long before validation the media may have been captured
+	MaxAgeHours int  `json:"maxAgeHours,omitempty"`
+	RequireGPS  bool `json:"requireGPS,omitempty"`
+	// Orientation is landscape or portrait
+	Orientation string `json:"orientation,omitempty"`
+	// Codecs are the accepted video codecs, e.g. h264 and hevc. Images aren't checked, videos of an
+	// unknown codec fail.
+	Codecs []string `json:"codecs,omitempty"`
+}
+
+// Result is the outcome of a single rule
+type Result struct {
+	Rule   string `json:"rule"`
+	Passed bool   `json:"passed"`
+	Reason string `json:"reason"`
+}
+
+// Report is the outcome of validating media against a spec
+type Report struct {
+	Spec    string   `json:"spec"`
+	Passed  bool     `json:"passed"`
+	Results []Result `json:"results"`
+	// MissingExif is passed on from the validated output
+	MissingExif map[string]string `json:"missingExif,omitempty"`
+}
+
+// Validate checks the name and rules of a spec
+func (s *Spec) Validate() error {
+	if !validName.MatchString(s.Name) {
+		return errors.Errorf("spec name %q must be lowercase letters, digits, - and _", s.Name)
+	}
+	r := s.Rules
+	if r.MinWidth < 0 || r.MinHeight < 0 || r.MaxAgeHours < 0 {
+		return errors.New("rules can't be negative")
+	}
+	if r.Orientation != "" && r.Orientation != Landscape && r.Orientation != Portrait {
+		return errors.Errorf("orientation must be %s or %s", Landscape, Portrait)
+	}
+	return nil
+}
+
+// Check validates the output against the rules of the spec at time now
+func (s *Spec) Check(o *exif.Output, now time.Time) *Report {
+	rep := &Report{Spec: s.Name, Passed: true, MissingExif: o.MissingExif}
+	add := func(rule string, passed bool, format string, args ...interface{}) {
+		rep.Results = append(rep.Results, Result{Rule: rule, Passed: passed, Reason: fmt.Sprintf(format, args...)})
+		rep.Passed = rep.Passed && passed
+	}
+	r := s.Rules
+	w, h := o.PixelXDimension, o.PixelYDimension
+	hasSize := w > 0 && h > 0
+
+	if r.MinWidth > 0 || r.MinHeight > 0 {
+		long, short := max(w, h), min(w, h)
+		minLong, minShort := max(r.MinWidth, r.MinHeight), min(r.MinWidth, r.MinHeight)
+		switch {
+		case !hasSize:
+			add(RuleResolution, false, "resolution is unknown, at least %dx%d is required", r.MinWidth, r.MinHeight)
+		case long < minLong || short < minShort:
+			add(RuleResolution, false, "%dx%d is below the required %dx%d", w, h, r.MinWidth, r.MinHeight)
+		default:
+			add(RuleResolution, true, "%dx%d meets the required %dx%d", w, h, r.MinWidth, r.MinHeight)
+		}
+	}
+
+	if r.MaxAgeHours > 0 {
+		maxAge := time.Duration(r.MaxAgeHours) * time.Hour
+		captured := o.CaptureTime()
+		switch age := now.Sub(captured); {
+		case captured.IsZero():
+			add(RuleMaxAge, false, "capture time is unknown, media must be at most %d hours old", r.MaxAgeHours)
+		case age > maxAge:
+			add(RuleMaxAge, false, "captured %s, %s ago, the limit is %d hours", captured.UTC().Format(time.RFC3339), age.Round(time.Minute), r.MaxAgeHours)
+		default:
+			add(RuleMaxAge, true, "captured %s, within %d hours", captured.UTC().Format(time.RFC3339), r.MaxAgeHours)
+		}
+	}
+
+	if r.RequireGPS {
+		if o.HasLocation() {
+			add(RuleGPS, true, "captured at %.5f,%.5f", o.Lat, o.Lng)
+		} else {
+			add(RuleGPS, false, "no GPS location in the media")
+		}
+	}
+
+	if r.Orientation != "" {
+		orientation := Landscape
+		if h > w {
+			orientation = Portrait
+		}
+		switch {
+		case !hasSize:
+			add(RuleOrientation, false, "orientation is unknown, %s is required", r.Orientation)
+		case w == h:
+			add(RuleOrientation, false, "%dx%d is square, %s is required", w, h, r.Orientation)
+		case orientation != r.Orientation:
+			add(RuleOrientation, false, "%dx%d is %s, %s is required", w, h, orientation, r.Orientation)
+		default:
+			add(RuleOrientation, true, "%dx%d is %s", w, h, orientation)
+		}
+	}
+
+	if len(r.Codecs) > 0 {
+		accepted := strings.Join(r.Codecs, ", ")
+		switch {
+		case !o.Video:
+			add(RuleCodec, true, "not a video")
+		case o.Codec == "":
+			add(RuleCodec, false, "codec is unknown, one of %s is required", accepted)
+		case !contains(r.Codecs, o.Codec):
+			add(RuleCodec, false, "%s is not one of %s", o.Codec, accepted)
+		default:
+			add(RuleCodec, true, "%s is accepted", o.Codec)
+		}
+	}
+	return rep
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,108 @@
+package spec
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blixenkrone/gopro/pkg/exif"
+)
+
+var now = time.Date(2020, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+var broadcast = &Spec{
+	Name: "broadcast",
+	Rules: Rules{
+		MinWidth:    1920,
+		MinHeight:   1080,
+		MaxAgeHours: 24,
+		RequireGPS:  true,
+		Orientation: Landscape,
+		Codecs:      []string{"h264", "hevc"},
+	},
+}
+
+func results(rep *Report) map[string]Result {
+	res := make(map[string]Result)
+	for _, r := range rep.Results {
+		res[r.Rule] = r
+	}
+	return res
+}
+
+func TestCheckPass(t *testing.T) {
+	rep := broadcast.Check(&exif.Output{
+		Date:            now.Add(-2*time.Hour).Unix() * 1000,
+		Lat:             55.6761,
+		Lng:             12.5683,
+		PixelXDimension: 3840,
+		PixelYDimension: 2160,
+		Video:           true,
+		Codec:           "hevc",
+	}, now)
+	if !rep.Passed || len(rep.Results) != 5 {
+		t.Errorf("Expected all 5 rules to pass %+v", rep)
+	}
+}
+
+func TestCheckFail(t *testing.T) {
+	missing := map[string]string{"lat": "error parsing from type lat"}
+	rep := broadcast.Check(&exif.Output{
+		Date:            now.Add(-48*time.Hour).Unix() * 1000,
+		PixelXDimension: 1080,
+		PixelYDimension: 1920,
+		Video:           true,
+		Codec:           "prores",
+		MissingExif:     missing,
+	}, now)
+	if rep.Passed {
+		t.Fatal("Expected the report to fail")
+	}
+	res := results(rep)
+	if !res[RuleResolution].Passed {
+		t.Errorf("Expected a portrait 1080x1920 to meet 1920x1080 %+v", res[RuleResolution])
+	}
+	for _, rule := range []string{RuleMaxAge, RuleGPS, RuleOrientation, RuleCodec} {
+		if res[rule].Passed || res[rule].Reason == "" {
+			t.Errorf("Expected %s to fail with a reason %+v", rule, res[rule])
+		}
+	}
+	if !strings.Contains(res[RuleOrientation].Reason, "portrait") {
+		t.Errorf("Unexpected reason %s", res[RuleOrientation].Reason)
+	}
+	if rep.MissingExif["lat"] == "" {
+		t.Error("Expected missing exif to be passed on")
+	}
+}
+
+func TestCheckUnknown(t *testing.T) {
+	res := results(broadcast.Check(&exif.Output{}, now))
+	for _, rule := range []string{RuleResolution, RuleMaxAge, RuleOrientation} {
+		if res[rule].Passed {
+			t.Errorf("Expected %s to fail for unknown values", rule)
+		}
+	}
+	if !res[RuleCodec].Passed {
+		t.Error("Expected the codec rule to pass for images")
+	}
+	res = results(broadcast.Check(&exif.Output{Video: true}, now))
+	if res[RuleCodec].Passed || !strings.Contains(res[RuleCodec].Reason, "unknown") {
+		t.Errorf("Expected the codec rule to fail for a video of an unknown codec %+v", res[RuleCodec])
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := broadcast.Validate(); err != nil {
+		t.Error(err)
+	}
+	for _, s := range []*Spec{
+		{Name: "Broadcast"},
+		{Name: ""},
+		{Name: "ok", Rules: Rules{Orientation: "square"}},
+		{Name: "ok", Rules: Rules{MinWidth: -1}},
+	} {
+		if err := s.Validate(); err == nil {
+			t.Errorf("Expected error for %+v", s)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package video
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"sync"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+// FakeFrameExtractor makes frames without decoding the video, for tests. Each frame is plain with the
+// whole seconds of its time as red and ten times the tenths as green, so frames can be told apart.
+type FakeFrameExtractor struct {
+	Width, Height int
+
+	mu sync.Mutex
+	// Times are the times of the extracted frames
+	Times []time.Duration
+}
+
+func (f *FakeFrameExtractor) Frame(ctx context.Context, path string, at time.Duration) (image.Image, error) {
+	f.mu.Lock()
+	f.Times = append(f.Times, at)
+	f.mu.Unlock()
+	tenths := int(at / (100 * time.Millisecond))
+	return imaging.New(f.Width, f.Height, color.NRGBA{uint8(tenths / 10), uint8(tenths % 10 * 10), 0, 255}), nil
+}
+
+func (f *FakeFrameExtractor) Frames(ctx context.Context, path string, times []time.Duration) ([]image.Image, error) {
+	frames := make([]image.Image, len(times))
+	for i, at := range times {
+		frames[i], _ = f.Frame(ctx, path, at)
+	}
+	return frames, nil
+}
@@ -0,0 +1,220 @@
+package video
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/pkg/errors"
+)
+
+// ErrNoFrameExtractor is returned when frames are extracted before a FrameExtractor is registered
+var ErrNoFrameExtractor = errors.New("no frame extractor registered")
+
+const (
+	extractTimeout = 30 * time.Second
+	// stripTimeout bounds decoding all frames of a strip, which reads the video up to the last of them
+	stripTimeout = 2 * time.Minute
+)
+
+// FrameExtractor decodes frames of a video file, rotated for display. Frames returns the frames at
+// increasing times in one pass over the video.
+type FrameExtractor interface {
+	Frame(ctx context.Context, path string, at time.Duration) (image.Image, error)
+	Frames(ctx context.Context, path string, times []time.Duration) ([]image.Image, error)
+}
+
+var (
+	mu        sync.RWMutex
+	extractor FrameExtractor
+)
+
+// RegisterFrameExtractor sets the extractor used for poster frames and strips
+func RegisterFrameExtractor(e FrameExtractor) {
+	mu.Lock()
+	defer mu.Unlock()
+	extractor = e
+}
+
+// CanExtractFrames reports whether a FrameExtractor is registered, so spooling a video for frames is worth it
+func CanExtractFrames() bool {
+	_, err := frameExtractor()
+	return err == nil
+}
+
+func frameExtractor() (FrameExtractor, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	if extractor == nil {
+		return nil, ErrNoFrameExtractor
+	}
+	return extractor, nil
+}
+
+// FFmpeg extracts frames with the ffmpeg command at Path
+type FFmpeg struct {
+	Path string
+}
+
+func (f FFmpeg) Frame(ctx context.Context, path string, at time.Duration) (image.Image, error) {
+	ctx, cancel := context.WithTimeout(ctx, extractTimeout)
+	defer cancel()
+	// -ss before -i seeks to the keyframe before the time and decodes from there, which is fast on long videos
+	cmd := exec.CommandContext(ctx, f.Path, "-v", "error", "-ss", strconv.FormatFloat(at.Seconds(), 'f', 3, 64),
+		"-i", path, "-frames:v", "1", "-f", "image2pipe", "-c:v", "png", "-")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrapf(err, "%s: %s", filepath.Base(f.Path), bytes.TrimSpace(stderr.Bytes()))
+	}
+	if stdout.Len() == 0 {
+		return nil, errors.Errorf("no frame at %s", at)
+	}
+	img, err := png.Decode(&stdout)
+	return img, errors.Wrap(err, "decoding frame")
+}
+
+// Frames runs ffmpeg once, selecting the first frame at or after each time
+func (f FFmpeg) Frames(ctx context.Context, path string, times []time.Duration) ([]image.Image, error) {
+	ctx, cancel := context.WithTimeout(ctx, stripTimeout)
+	defer cancel()
+	terms := make([]string, len(times))
+	for i, at := range times {
+		s := strconv.FormatFloat(at.Seconds(), 'f', 3, 64)
+		terms[i] = "gte(t," + s + ")*(isnan(prev_t)+lt(prev_t," + s + "))"
+	}
+	cmd := exec.CommandContext(ctx, f.Path, "-v", "error", "-i", path,
+		"-vf", "select='"+strings.Join(terms, "+")+"'", "-vsync", "vfr", "-frames:v", strconv.Itoa(len(times)),
+		"-f", "image2pipe", "-c:v", "png", "-")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, errors.Wrap(err, filepath.Base(f.Path))
+	}
+	frames, decodeErr := decodeFrames(bufio.NewReader(stdout), len(times))
+	// lets ffmpeg finish writing if decoding stopped early
+	io.Copy(ioutil.Discard, stdout)
+	if err := cmd.Wait(); err != nil {
+		return nil, errors.Wrapf(err, "%s: %s", filepath.Base(f.Path), bytes.TrimSpace(stderr.Bytes()))
+	}
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
+	if len(frames) != len(times) {
+		return nil, errors.Errorf("got %d of %d frames", len(frames), len(times))
+	}
+	return frames, nil
+}
+
+// decodeFrames decodes up to n PNGs written one after the other
+func decodeFrames(r *bufio.Reader, n int) ([]image.Image, error) {
+	var frames []image.Image
+	for len(frames) < n {
+		if _, err := r.Peek(1); err == io.EOF {
+			break
+		}
+		img, err := png.Decode(r)
+		if err != nil {
+			return nil, errors.Wrapf(err, "decoding frame %d", len(frames))
+		}
+		frames = append(frames, img)
+	}
+	return frames, nil
+}
+
+// Poster returns the frame at the time from the start, or the last frame if the video is shorter,
+// and the time of the frame it returned
+func (v *videoExifData) Poster(ctx context.Context, at time.Duration) (image.Image, time.Duration, error) {
+	e, err := v.extractor()
+	if err != nil {
+		return nil, 0, err
+	}
+	if d := v.Meta.Duration; d > 0 && at >= d {
+		// the frame just before the end, a seek to the very end finds none
+		at = d - d/100
+	}
+	if at < 0 {
+		at = 0
+	}
+	frame, err := e.Frame(ctx, v.file.Name(), at)
+	return frame, at, err
+}
+
+// Frames returns n frames spread evenly over the video, each from the middle of its part of it, decoded in
+// one pass bounded by ctx
+func (v *videoExifData) Frames(ctx context.Context, n int) ([]image.Image, error) {
+	e, err := v.extractor()
+	if err != nil {
+		return nil, err
+	}
+	if n < 1 {
+		return nil, errors.Errorf("cannot extract %d frames", n)
+	}
+	if v.Meta.Duration <= 0 {
+		return nil, errors.New("video has no duration to spread frames over")
+	}
+	times := make([]time.Duration, n)
+	for i := range times {
+		times[i] = v.Meta.Duration * time.Duration(2*i+1) / time.Duration(2*n)
+	}
+	return e.Frames(ctx, v.file.Name(), times)
+}
+
+func (v *videoExifData) extractor() (FrameExtractor, error) {
+	e, err := frameExtractor()
+	if err != nil {
+		return nil, err
+	}
+	if v.file == nil {
+		return nil, errors.New("frames are only extracted from videos read with SpoolVideo")
+	}
+	return e, nil
+}
+
+// ContactSheet lays out frames in rows of columns, each scaled to width with the aspect ratio of the first
+// that isn't empty. Empty frames leave their place blank. A single row is a sprite strip players show
+// while scrubbing.
+func ContactSheet(frames []image.Image, columns, width int) *image.NRGBA {
+	var b image.Rectangle
+	for _, f := range frames {
+		if b = f.Bounds(); !b.Empty() {
+			break
+		}
+	}
+	if b.Empty() || columns < 1 || width < 1 {
+		return image.NewNRGBA(image.Rectangle{})
+	}
+	if columns > len(frames) {
+		columns = len(frames)
+	}
+	height := width * b.Dy() / b.Dx()
+	if height < 1 {
+		height = 1
+	}
+	rows := (len(frames) + columns - 1) / columns
+	sheet := image.NewNRGBA(image.Rect(0, 0, columns*width, rows*height))
+	for i, f := range frames {
+		if f.Bounds().Empty() {
+			continue
+		}
+		at := image.Pt(i%columns*width, i/columns*height)
+		draw.Draw(sheet, image.Rectangle{at, at.Add(image.Pt(width, height))}, imaging.Fill(f, width, height, imaging.Center, imaging.Linear), image.Point{}, draw.Src)
+	}
+	return sheet
+}
@@ -0,0 +1,146 @@
+package video
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestFrames(t *testing.T) {
+	v, err := SpoolVideo(bytes.NewReader(testMovie(false)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	if _, _, err := v.Poster(context.Background(), time.Second); err != ErrNoFrameExtractor {
+		t.Errorf("got %v without an extractor", err)
+	}
+
+	fake := &FakeFrameExtractor{Width: 160, Height: 90}
+	RegisterFrameExtractor(fake)
+	defer RegisterFrameExtractor(nil)
+
+	poster, at, err := v.Poster(context.Background(), 2*time.Second)
+	if err != nil || at != 2*time.Second {
+		t.Fatal(at, err)
+	}
+	if c := poster.(*image.NRGBA).NRGBAAt(0, 0); c.R != 2 || c.G != 0 {
+		t.Errorf("poster of %v", c)
+	}
+	// past the end of the 12.5s movie
+	if _, at, err := v.Poster(context.Background(), time.Minute); err != nil || at != 12375*time.Millisecond {
+		t.Fatal(at, err)
+	}
+	frames, err := v.Frames(context.Background(), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []time.Duration{2 * time.Second, 12375 * time.Millisecond,
+		1250 * time.Millisecond, 3750 * time.Millisecond, 6250 * time.Millisecond, 8750 * time.Millisecond, 11250 * time.Millisecond}
+	if len(frames) != 5 || !reflect.DeepEqual(fake.Times, want) {
+		t.Errorf("extracted %d frames at %v", len(frames), fake.Times)
+	}
+
+	data := testMovie(false)
+	stream, err := NewVideo(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Frames(context.Background(), 5); err == nil {
+		t.Error("frames extracted without a file")
+	}
+}
+
+func TestContactSheet(t *testing.T) {
+	var frames []image.Image
+	for i := 0; i < 5; i++ {
+		frames = append(frames, imaging.New(160, 90, color.NRGBA{uint8(i * 50), 0, 0, 255}))
+	}
+	sheet := ContactSheet(frames, 3, 80)
+	if sheet.Bounds() != image.Rect(0, 0, 240, 90) {
+		t.Fatalf("sheet of %v", sheet.Bounds())
+	}
+	// the fourth frame starts the second row
+	if c := sheet.NRGBAAt(10, 60); c.R != 150 {
+		t.Errorf("second row starts with %v", c)
+	}
+	if strip := ContactSheet(frames, 10, 80); strip.Bounds() != image.Rect(0, 0, 400, 45) {
+		t.Errorf("strip of %v", strip.Bounds())
+	}
+	empty := image.NewNRGBA(image.Rectangle{})
+	if strip := ContactSheet([]image.Image{empty, frames[1]}, 2, 80); strip.Bounds() != image.Rect(0, 0, 160, 45) {
+		t.Errorf("strip with an empty frame of %v", strip.Bounds())
+	}
+	if sheet := ContactSheet([]image.Image{empty}, 1, 80); !sheet.Bounds().Empty() {
+		t.Errorf("sheet of empty frames of %v", sheet.Bounds())
+	}
+}
+
+func TestFFmpeg(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ffmpeg-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var frame bytes.Buffer
+	if err := png.Encode(&frame, imaging.New(32, 18, color.White)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "frame.png"), frame.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// a stand in for ffmpeg that records its arguments and writes the frame
+	script := "#!/bin/sh\necho \"$@\" > " + filepath.Join(dir, "args") + "\ncat " + filepath.Join(dir, "frame.png") + "\n"
+	path := filepath.Join(dir, "ffmpeg")
+	if err := ioutil.WriteFile(path, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := FFmpeg{Path: path}.Frame(context.Background(), "/videos/in.mp4", 2500*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 32 {
+		t.Errorf("frame of %v", img.Bounds())
+	}
+	args, err := ioutil.ReadFile(filepath.Join(dir, "args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(args), "-ss 2.500 -i /videos/in.mp4") {
+		t.Errorf("called with %s", args)
+	}
+
+	if _, err := (FFmpeg{Path: filepath.Join(dir, "missing")}).Frame(context.Background(), "in.mp4", 0); err == nil {
+		t.Error("missing command succeeded")
+	}
+
+	// a strip is decoded from the frames of a single run
+	script = "#!/bin/sh\necho \"$@\" >> " + filepath.Join(dir, "strip") + "\ncat " + strings.Repeat(filepath.Join(dir, "frame.png")+" ", 3) + "\n"
+	if err := ioutil.WriteFile(path, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	frames, err := FFmpeg{Path: path}.Frames(context.Background(), "/videos/in.mp4", []time.Duration{time.Second, 2 * time.Second, 3 * time.Second})
+	if err != nil || len(frames) != 3 {
+		t.Fatalf("got %d frames, %v", len(frames), err)
+	}
+	if args, _ := ioutil.ReadFile(filepath.Join(dir, "strip")); strings.Count(string(args), "\n") != 1 ||
+		!strings.Contains(string(args), "gte(t,2.000)*(isnan(prev_t)+lt(prev_t,2.000))") {
+		t.Errorf("called with %s", args)
+	}
+	if _, err := (FFmpeg{Path: path}).Frames(context.Background(), "/videos/in.mp4", make([]time.Duration, 4)); err == nil {
+		t.Error("missing frames succeeded")
+	}
+}
@@ -0,0 +1,616 @@
+package video
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ISO base media (MP4) and QuickTime files are trees of boxes ("atoms"): a 32 bit size and a four character type,
+// followed by the payload or child boxes. Only the boxes holding metadata are read, so a file is never buffered.
+// See ISO/IEC 14496-12 and https://developer.apple.com/library/archive/documentation/QuickTime/QTFF/
+
+const (
+	boxHeaderSize = 8
+	// maxBoxPayload bounds the payload read into memory for a single metadata box
+	maxBoxPayload = 1 << 20
+	// maxMovieSize bounds the moov box kept in memory when a video is read as a stream
+	maxMovieSize = 64 << 20
+)
+
+// decodedBoxes are the top level boxes Decode reads, the others are skipped when reading a stream
+var decodedBoxes = map[string]bool{"ftyp": true, "moov": true}
+
+// epoch1904 is the start of the mp4 clock
+var epoch1904 = time.Date(1904, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+var (
+	// ErrNotMP4 is returned for files that don't start with a box
+	ErrNotMP4 = errors.New("not an mp4 or quicktime file")
+	// ErrNoMovie is returned for files without a moov box, e.g. truncated uploads
+	ErrNoMovie = errors.New("no moov box in file")
+)
+
+// Metadata is the movie metadata read from the moov box
+type Metadata struct {
+	// Brand is the major brand of the ftyp box, e.g. "isom", "mp42" or "qt  "
+	Brand        string        `json:"brand,omitempty"`
+	CreationTime time.Time     `json:"creationTime,omitempty"`
+	Duration     time.Duration `json:"duration,omitempty"`
+	Location     *Location     `json:"location,omitempty"`
+	Tracks       []*Track      `json:"tracks,omitempty"`
+}
+
+// Track is a single video, audio or other track of a movie
+type Track struct {
+	ID uint32 `json:"id"`
+	// Handler is the track type: "vide", "soun", "meta", "text" etc
+	Handler string `json:"handler,omitempty"`
+	// Codec is the fourcc of the first sample description, e.g. "avc1", "hvc1" or "mp4a"
+	Codec        string        `json:"codec,omitempty"`
+	CreationTime time.Time     `json:"creationTime,omitempty"`
+	Duration     time.Duration `json:"duration,omitempty"`
+	// Width and Height are the presentation size before rotation
+	Width  float64 `json:"width,omitempty"`
+	Height float64 `json:"height,omitempty"`
+	// Rotation in degrees clockwise, taken from the track matrix
+	Rotation int `json:"rotation,omitempty"`
+	// Timescale is the number of media time units per second
+	Timescale uint32 `json:"timescale,omitempty"`
+
+	// Samples is the number of frames of a video track or packets of an audio track
+	Samples uint64 `json:"samples,omitempty"`
+	// SampleBytes is the size of all samples, the stream size
+	SampleBytes uint64 `json:"sampleBytes,omitempty"`
+	// SampleDuration is the duration of all samples in Timescale units
+	SampleDuration uint64 `json:"sampleDuration,omitempty"`
+
+	// CodedWidth and CodedHeight are the size of the encoded video frames
+	CodedWidth  int `json:"codedWidth,omitempty"`
+	CodedHeight int `json:"codedHeight,omitempty"`
+
+	SampleRate float64 `json:"sampleRate,omitempty"`
+	Channels   int     `json:"channels,omitempty"`
+}
+
+// IsVideo reports whether the track holds video
+func (t *Track) IsVideo() bool {
+	return t.Handler == "vide"
+}
+
+// IsAudio reports whether the track holds audio
+func (t *Track) IsAudio() bool {
+	return t.Handler == "soun"
+}
+
+// VideoTrack returns the first video track, or nil
+func (m *Metadata) VideoTrack() *Track {
+	for _, t := range m.Tracks {
+		if t.IsVideo() {
+			return t
+		}
+	}
+	return nil
+}
+
+// Location is an ISO 6709 position
+type Location struct {
+	Lat      float64  `json:"lat"`
+	Lng      float64  `json:"lng"`
+	Altitude *float64 `json:"altitude,omitempty"`
+}
+
+type box struct {
+	typ string
+	// offset of the payload and end of the box in the file
+	start, end int64
+}
+
+// boxes reads the boxes between start and end
+func boxes(r io.ReaderAt, start, end int64) ([]box, error) {
+	var res []box
+	var hdr [16]byte
+	for offset := start; offset+boxHeaderSize <= end; {
+		if _, err := r.ReadAt(hdr[:boxHeaderSize], offset); err != nil {
+			return nil, errors.Wrapf(err, "reading box header at %d", offset)
+		}
+		size := int64(binary.BigEndian.Uint32(hdr[:4]))
+		b := box{typ: string(hdr[4:8]), start: offset + boxHeaderSize}
+		switch size {
+		case 0:
+			// The box extends to the end of the file
+			size = end - offset
+		case 1:
+			if _, err := r.ReadAt(hdr[8:16], offset+boxHeaderSize); err != nil {
+				return nil, errors.Wrapf(err, "reading large box size at %d", offset)
+			}
+			size = int64(binary.BigEndian.Uint64(hdr[8:16]))
+			b.start += 8
+		}
+		if size < b.start-offset || offset+size > end {
+			return nil, errors.Errorf("invalid size %d of box %q at %d", size, b.typ, offset)
+		}
+		b.end = offset + size
+		res = append(res, b)
+		offset = b.end
+	}
+	return res, nil
+}
+
+// streamBoxes reads the top level boxes of a stream, keeping those Decode reads and discarding the others
+// like the media data. It returns the kept boxes as a file for Decode and the size of the whole stream.
+func streamBoxes(r io.Reader) ([]byte, int64, error) {
+	var kept bytes.Buffer
+	var size int64
+	var hdr [16]byte
+	for {
+		n, err := io.ReadFull(r, hdr[:boxHeaderSize])
+		size += int64(n)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// like Decode, bytes too few for a box after the last one are ignored
+			break
+		}
+		if err != nil {
+			return nil, size, errors.Wrap(err, "reading box header")
+		}
+		headerSize := int64(boxHeaderSize)
+		boxSize := int64(binary.BigEndian.Uint32(hdr[:4]))
+		switch boxSize {
+		case 0:
+			// The box extends to the end of the stream
+			boxSize = -1
+		case 1:
+			n, err := io.ReadFull(r, hdr[8:16])
+			size += int64(n)
+			if err != nil {
+				return nil, size, ErrNotMP4
+			}
+			headerSize += 8
+			boxSize = int64(binary.BigEndian.Uint64(hdr[8:16]))
+		}
+		if boxSize >= 0 && boxSize < headerSize {
+			return nil, size, ErrNotMP4
+		}
+
+		var dst io.Writer = ioutil.Discard
+		if decodedBoxes[string(hdr[4:8])] {
+			if boxSize > maxMovieSize {
+				return nil, size, errors.Errorf("box %q of %d bytes is too large", hdr[4:8], boxSize)
+			}
+			kept.Write(hdr[:headerSize])
+			dst = &limitedWriter{w: &kept, n: maxMovieSize}
+		}
+		if boxSize < 0 {
+			n, err := io.Copy(dst, r)
+			size += n
+			return kept.Bytes(), size, errors.Wrap(err, "reading last box")
+		}
+		n64, err := io.CopyN(dst, r, boxSize-headerSize)
+		size += n64
+		if err == io.EOF {
+			// the box is larger than the stream, it was cut off
+			return nil, size, ErrNotMP4
+		}
+		if err != nil {
+			return nil, size, errors.Wrapf(err, "reading box %q", hdr[4:8])
+		}
+	}
+	return kept.Bytes(), size, nil
+}
+
+// limitedWriter fails writes past n bytes
+type limitedWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > l.n {
+		return 0, errors.New("box is too large")
+	}
+	l.n -= int64(len(p))
+	return l.w.Write(p)
+}
+
+func find(bs []box, typ string) (box, bool) {
+	for _, b := range bs {
+		if b.typ == typ {
+			return b, true
+		}
+	}
+	return box{}, false
+}
+
+func payload(r io.ReaderAt, b box) ([]byte, error) {
+	n := b.end - b.start
+	if n > maxBoxPayload {
+		return nil, errors.Errorf("box %q of %d bytes is too large", b.typ, n)
+	}
+	buf := make([]byte, n)
+	if _, err := r.ReadAt(buf, b.start); err != nil {
+		return nil, errors.Wrapf(err, "reading box %q", b.typ)
+	}
+	return buf, nil
+}
+
+// reader reads big endian values from a box payload, remembering the first short read.
+// After a short read values are zero and next returns nil, the payload length bounds every read.
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err != nil || n < 0 || len(r.b) < n {
+		r.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+// fixed reads n bytes, or n zero bytes after a short read
+func (r *reader) fixed(n int) []byte {
+	if v := r.next(n); v != nil {
+		return v
+	}
+	return make([]byte, n)
+}
+
+func (r *reader) u8() uint8        { return r.fixed(1)[0] }
+func (r *reader) u16() uint16      { return binary.BigEndian.Uint16(r.fixed(2)) }
+func (r *reader) u32() uint32      { return binary.BigEndian.Uint32(r.fixed(4)) }
+func (r *reader) u64() uint64      { return binary.BigEndian.Uint64(r.fixed(8)) }
+func (r *reader) skip(n int)       { r.next(n) }
+func (r *reader) fourcc() string   { return string(r.next(4)) }
+func (r *reader) fixed16() float64 { return float64(r.u32()) / 65536 }
+
+// versioned reads a 32 or 64 bit value depending on the full box version
+func (r *reader) versioned(version uint8) uint64 {
+	if version == 1 {
+		return r.u64()
+	}
+	return uint64(r.u32())
+}
+
+func mp4Time(seconds uint64) time.Time {
+	if seconds == 0 {
+		return time.Time{}
+	}
+	return epoch1904.Add(time.Duration(seconds) * time.Second)
+}
+
+func mp4Duration(units uint64, timescale uint32) time.Duration {
+	// All ones means the duration is unknown
+	if timescale == 0 || units == math.MaxUint32 || units == math.MaxUint64 {
+		return 0
+	}
+	return time.Duration(float64(units) / float64(timescale) * float64(time.Second))
+}
+
+// Decode reads the movie metadata of an mp4 or quicktime file of size bytes
+func Decode(r io.ReaderAt, size int64) (*Metadata, error) {
+	top, err := boxes(r, 0, size)
+	if err != nil || len(top) == 0 {
+		return nil, ErrNotMP4
+	}
+
+	var m Metadata
+	if ftyp, ok := find(top, "ftyp"); ok {
+		b, err := payload(r, ftyp)
+		if err != nil {
+			return nil, err
+		}
+		m.Brand = (&reader{b: b}).fourcc()
+	}
+
+	moov, ok := find(top, "moov")
+	if !ok {
+		return nil, ErrNoMovie
+	}
+	children, err := boxes(r, moov.start, moov.end)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range children {
+		switch b.typ {
+		case "mvhd":
+			if err := m.decodeMvhd(r, b); err != nil {
+				return nil, err
+			}
+		case "trak":
+			t, err := decodeTrak(r, b)
+			if err != nil {
+				return nil, err
+			}
+			m.Tracks = append(m.Tracks, t)
+		case "udta":
+			if err := m.decodeUdta(r, b); err != nil {
+				return nil, err
+			}
+		case "meta":
+			if err := m.decodeMeta(r, b); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &m, nil
+}
+
+func (m *Metadata) decodeMvhd(r io.ReaderAt, b box) error {
+	p, err := payload(r, b)
+	if err != nil {
+		return err
+	}
+	rd := &reader{b: p}
+	version := rd.u8()
+	rd.skip(3)
+	m.CreationTime = mp4Time(rd.versioned(version))
+	rd.versioned(version) // modification time
+	timescale := rd.u32()
+	m.Duration = mp4Duration(rd.versioned(version), timescale)
+	return errors.Wrap(rd.err, "reading mvhd")
+}
+
+func decodeTrak(r io.ReaderAt, trak box) (*Track, error) {
+	var t Track
+	children, err := boxes(r, trak.start, trak.end)
+	if err != nil {
+		return nil, err
+	}
+	if tkhd, ok := find(children, "tkhd"); ok {
+		if err := t.decodeTkhd(r, tkhd); err != nil {
+			return nil, err
+		}
+	}
+	mdia, ok := find(children, "mdia")
+	if !ok {
+		return &t, nil
+	}
+	if err := t.decodeMdia(r, mdia); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (t *Track) decodeTkhd(r io.ReaderAt, b box) error {
+	p, err := payload(r, b)
+	if err != nil {
+		return err
+	}
+	rd := &reader{b: p}
+	version := rd.u8()
+	rd.skip(3)
+	t.CreationTime = mp4Time(rd.versioned(version))
+	rd.versioned(version) // modification time
+	t.ID = rd.u32()
+	rd.skip(4)
+	rd.versioned(version) // duration in movie timescale, the media header has it in seconds
+	rd.skip(8 + 2 + 2 + 2 + 2)
+
+	var matrix [9]int32
+	for i := range matrix {
+		matrix[i] = int32(rd.u32())
+	}
+	t.Rotation = rotation(matrix)
+	t.Width = rd.fixed16()
+	t.Height = rd.fixed16()
+	return errors.Wrap(rd.err, "reading tkhd")
+}
+
+// rotation returns the clockwise rotation of a track matrix, rounded to whole degrees.
+// The matrix is {a b u, c d v, x y w} with a, b, c and d as 16.16 fixed point.
+func rotation(matrix [9]int32) int {
+	a, b := float64(matrix[0])/65536, float64(matrix[1])/65536
+	deg := int(math.Round(math.Atan2(b, a) * 180 / math.Pi))
+	return (deg + 360) % 360
+}
+
+func (t *Track) decodeMdia(r io.ReaderAt, mdia box) error {
+	children, err := boxes(r, mdia.start, mdia.end)
+	if err != nil {
+		return err
+	}
+	if mdhd, ok := find(children, "mdhd"); ok {
+		p, err := payload(r, mdhd)
+		if err != nil {
+			return err
+		}
+		rd := &reader{b: p}
+		version := rd.u8()
+		rd.skip(3)
+		rd.versioned(version) // creation time
+		rd.versioned(version) // modification time
+		t.Timescale = rd.u32()
+		t.Duration = mp4Duration(rd.versioned(version), t.Timescale)
+		if rd.err != nil {
+			return errors.Wrap(rd.err, "reading mdhd")
+		}
+	}
+	if hdlr, ok := find(children, "hdlr"); ok {
+		p, err := payload(r, hdlr)
+		if err != nil {
+			return err
+		}
+		rd := &reader{b: p}
+		rd.skip(8)
+		t.Handler = rd.fourcc()
+		if rd.err != nil {
+			return errors.Wrap(rd.err, "reading hdlr")
+		}
+	}
+
+	minf, ok := find(children, "minf")
+	if !ok {
+		return nil
+	}
+	if children, err = boxes(r, minf.start, minf.end); err != nil {
+		return err
+	}
+	stbl, ok := find(children, "stbl")
+	if !ok {
+		return nil
+	}
+	if children, err = boxes(r, stbl.start, stbl.end); err != nil {
+		return err
+	}
+	return t.decodeStbl(r, children)
+}
+
+// decodeUdta reads the quicktime user data location, the ©xyz box
+func (m *Metadata) decodeUdta(r io.ReaderAt, udta box) error {
+	children, err := boxes(r, udta.start, udta.end)
+	if err != nil {
+		// Some writers put non box data in udta, it is only metadata
+		return nil
+	}
+	for _, b := range children {
+		switch b.typ {
+		case "\xa9xyz":
+			p, err := payload(r, b)
+			if err != nil {
+				return err
+			}
+			// A 16 bit string length and language code, then the string
+			rd := &reader{b: p}
+			n := rd.u16()
+			rd.skip(2)
+			s := rd.next(int(n))
+			if rd.err != nil {
+				continue
+			}
+			if loc, err := ParseISO6709(string(s)); err == nil {
+				m.Location = loc
+			}
+		case "meta":
+			if err := m.decodeMeta(r, b); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// decodeMeta reads the location and creation date of the keyed metadata written by phones,
+// com.apple.quicktime.location.ISO6709 and com.apple.quicktime.creationdate
+func (m *Metadata) decodeMeta(r io.ReaderAt, meta box) error {
+	// An mp4 meta is a full box with 4 bytes of version and flags, a quicktime meta is not
+	var vf [4]byte
+	if meta.end-meta.start < 4 {
+		return nil
+	}
+	if _, err := r.ReadAt(vf[:], meta.start); err != nil {
+		return err
+	}
+	if vf == [4]byte{} {
+		meta.start += 4
+	}
+	children, err := boxes(r, meta.start, meta.end)
+	if err != nil {
+		return nil
+	}
+
+	keysBox, ok := find(children, "keys")
+	if !ok {
+		return nil
+	}
+	ilst, ok := find(children, "ilst")
+	if !ok {
+		return nil
+	}
+	p, err := payload(r, keysBox)
+	if err != nil {
+		return err
+	}
+	rd := &reader{b: p}
+	rd.skip(4)
+	count := rd.u32()
+	// every key takes at least its size and namespace, so a count the payload can't hold is invalid
+	if rd.err != nil || uint64(count)*8 > uint64(len(rd.b)) {
+		return nil
+	}
+	keys := make([]string, count)
+	for i := range keys {
+		size := rd.u32()
+		if size < 8 || uint64(size)-4 > uint64(len(rd.b)) || rd.err != nil {
+			break
+		}
+		rd.skip(4) // namespace, usually mdta
+		keys[i] = string(rd.next(int(size - 8)))
+	}
+
+	items, err := boxes(r, ilst.start, ilst.end)
+	if err != nil {
+		return nil
+	}
+	for _, item := range items {
+		index := int(binary.BigEndian.Uint32([]byte(item.typ)))
+		if index < 1 || index > len(keys) {
+			continue
+		}
+		data, err := boxes(r, item.start, item.end)
+		if err != nil {
+			continue
+		}
+		db, ok := find(data, "data")
+		if !ok {
+			continue
+		}
+		p, err := payload(r, db)
+		if err != nil || len(p) < 8 {
+			continue
+		}
+		// type indicator and locale precede the value
+		value := string(p[8:])
+		switch keys[index-1] {
+		case "com.apple.quicktime.location.ISO6709":
+			if loc, err := ParseISO6709(value); err == nil {
+				m.Location = loc
+			}
+		case "com.apple.quicktime.creationdate":
+			if t, err := time.Parse("2006-01-02T15:04:05-0700", value); err == nil {
+				m.CreationTime = t.UTC()
+			}
+		}
+	}
+	return nil
+}
+
+var iso6709 = regexp.MustCompile(`^([+-]\d+(?:\.\d+)?)([+-]\d+(?:\.\d+)?)([+-]\d+(?:\.\d+)?)?(?:CRS[^/]*)?/?$`)
+
+// ParseISO6709 parses a location in decimal degrees like +55.6761+012.5683+010.000/
+func ParseISO6709(s string) (*Location, error) {
+	match := iso6709.FindStringSubmatch(strings.TrimSpace(strings.TrimRight(s, "\x00")))
+	if match == nil {
+		return nil, errors.Errorf("invalid ISO 6709 location %q", s)
+	}
+	lat, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return nil, err
+	}
+	lng, err := strconv.ParseFloat(match[2], 64)
+	if err != nil {
+		return nil, err
+	}
+	if lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+		return nil, errors.Errorf("location %q out of range", s)
+	}
+	loc := &Location{Lat: lat, Lng: lng}
+	if match[3] != "" {
+		alt, err := strconv.ParseFloat(match[3], 64)
+		if err != nil {
+			return nil, err
+		}
+		loc.Altitude = &alt
+	}
+	return loc, nil
+}
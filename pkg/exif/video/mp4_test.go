@@ -0,0 +1,254 @@
+package video
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blixenkrone/gopro/internal/mediatest"
+)
+
+var (
+	mkbox = mediatest.Box
+	u16   = mediatest.U16
+	u32   = mediatest.U32
+)
+
+// matrix returns a track matrix rotated clockwise by 0, 90, 180 or 270 degrees
+func matrix(deg int) []byte {
+	one, minus := uint32(0x10000), uint32(0xffff0000)
+	a, b, c, d := one, uint32(0), uint32(0), one
+	switch deg {
+	case 90:
+		a, b, c, d = 0, one, minus, 0
+	case 180:
+		a, b, c, d = minus, 0, 0, minus
+	case 270:
+		a, b, c, d = 0, minus, one, 0
+	}
+	return u32(a, b, 0, c, d, 0, 0, 0, 0x40000000)
+}
+
+var created = time.Date(2019, time.November, 5, 12, 30, 0, 0, time.UTC)
+
+func mp4Seconds(t time.Time) uint32 {
+	return uint32(t.Sub(epoch1904) / time.Second)
+}
+
+func trak(id uint32, handler, codec string, width, height uint32, rot int, timescale, duration uint32) []byte {
+	tkhd := mkbox("tkhd", u32(0), u32(mp4Seconds(created)), u32(0), u32(id), u32(0), u32(0),
+		make([]byte, 8), u16(0), u16(0), u16(0), u16(0), matrix(rot), u32(width<<16), u32(height<<16))
+	mdhd := mkbox("mdhd", u32(0), u32(0), u32(0), u32(timescale), u32(duration), u16(0), u16(0))
+	hdlr := mkbox("hdlr", u32(0), u32(0), []byte(handler), make([]byte, 12), []byte("handler\x00"))
+	var entry []byte
+	switch handler {
+	case "vide":
+		entry = bytes.Join([][]byte{make([]byte, 8), make([]byte, 16), u16(uint16(width)), u16(uint16(height)), make([]byte, 50)}, nil)
+	case "soun":
+		entry = bytes.Join([][]byte{make([]byte, 8), u16(0), make([]byte, 6), u16(2), make([]byte, 6), u32(48000 << 16)}, nil)
+	}
+	stsd := mkbox("stsd", u32(0), u32(1), mkbox(codec, entry))
+	// Samples of a fixed duration, each of 1000 bytes
+	delta := uint32(1000)
+	if handler == "vide" {
+		delta = 1001
+	}
+	stts := mkbox("stts", u32(0), u32(1), u32(duration/delta), u32(delta))
+	sizes := [][]byte{u32(0), u32(0), u32(duration / delta)}
+	for i := uint32(0); i < duration/delta; i++ {
+		sizes = append(sizes, u32(1000))
+	}
+	stsz := mkbox("stsz", sizes...)
+	return mkbox("trak", tkhd, mkbox("mdia", mdhd, hdlr, mkbox("minf", mkbox("stbl", stsd, stts, stsz))))
+}
+
+func testMovie(moovLast bool, extra ...[]byte) []byte {
+	mvhd := mkbox("mvhd", u32(0), u32(mp4Seconds(created)), u32(0), u32(1000), u32(12500), make([]byte, 80))
+	xyz := "+55.6761+012.5683+010.000/"
+	udta := mkbox("udta", mkbox("\xa9xyz", u16(uint16(len(xyz))), u16(0x15c7), []byte(xyz)))
+	moov := mkbox("moov", append([][]byte{mvhd,
+		trak(1, "soun", "mp4a", 0, 0, 0, 48000, 600000),
+		trak(2, "vide", "hvc1", 1920, 1080, 90, 30000, 375375),
+		udta}, extra...)...)
+	ftyp := mkbox("ftyp", []byte("qt  "), u32(0), []byte("qt  "))
+	mdat := mkbox("mdat", make([]byte, 1000))
+	if moovLast {
+		return bytes.Join([][]byte{ftyp, mdat, moov}, nil)
+	}
+	return bytes.Join([][]byte{ftyp, moov, mdat}, nil)
+}
+
+func TestDecode(t *testing.T) {
+	for _, moovLast := range []bool{false, true} {
+		data := testMovie(moovLast)
+		m, err := Decode(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Brand != "qt  " || !m.CreationTime.Equal(created) || m.Duration != 12500*time.Millisecond {
+			t.Errorf("Unexpected movie header %+v", m)
+		}
+		if m.Location == nil || m.Location.Lat != 55.6761 || m.Location.Lng != 12.5683 || *m.Location.Altitude != 10 {
+			t.Errorf("Unexpected location %+v", m.Location)
+		}
+		if len(m.Tracks) != 2 {
+			t.Fatalf("Expected 2 tracks got %d", len(m.Tracks))
+		}
+		audio, video := m.Tracks[0], m.Tracks[1]
+		if !audio.IsAudio() || audio.Codec != "mp4a" || audio.Duration != 12500*time.Millisecond {
+			t.Errorf("Unexpected audio track %+v", audio)
+		}
+		if m.VideoTrack() != video || video.Codec != "hvc1" || video.Width != 1920 || video.Height != 1080 ||
+			video.Rotation != 90 || video.ID != 2 || video.Timescale != 30000 {
+			t.Errorf("Unexpected video track %+v", video)
+		}
+	}
+}
+
+func TestDecodeAppleMetadata(t *testing.T) {
+	// A keys entry has the layout of a box with the namespace as its type
+	keys := mkbox("keys", u32(0), u32(2),
+		mkbox("mdta", []byte("com.apple.quicktime.creationdate")),
+		mkbox("mdta", []byte("com.apple.quicktime.location.ISO6709")))
+	value := func(s string) []byte { return mkbox("data", u32(1), u32(0), []byte(s)) }
+	ilst := mkbox("ilst",
+		mkbox(string(u32(1)), value("2020-06-01T10:00:00+0200")),
+		mkbox(string(u32(2)), value("-33.8688+151.2093/")))
+	meta := mkbox("meta", mkbox("hdlr", u32(0), u32(0), []byte("mdta"), make([]byte, 12)), keys, ilst)
+
+	data := testMovie(false, meta)
+	m, err := Decode(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.CreationTime.Equal(time.Date(2020, time.June, 1, 8, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected creation time %s", m.CreationTime)
+	}
+	if m.Location.Lat != -33.8688 || m.Location.Lng != 151.2093 || m.Location.Altitude != nil {
+		t.Errorf("Unexpected location %+v", m.Location)
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"empty":     nil,
+		"jpeg":      {0xff, 0xd8, 0xff, 0xe0, 0, 0x10, 'J', 'F', 'I', 'F', 0, 1},
+		"truncated": testMovie(false)[:100],
+	} {
+		if _, err := Decode(bytes.NewReader(data), int64(len(data))); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+	noMoov := mkbox("ftyp", []byte("isom"), u32(0))
+	if _, err := Decode(bytes.NewReader(noMoov), int64(len(noMoov))); err != ErrNoMovie {
+		t.Errorf("Expected ErrNoMovie got %v", err)
+	}
+}
+
+func TestDecodeMalformedKeys(t *testing.T) {
+	ilst := mkbox("ilst", mkbox(string(u32(1)), mkbox("data", u32(1), u32(0), []byte("+1.0+2.0/"))))
+	for name, keys := range map[string][]byte{
+		// a key larger than the file must not be allocated
+		"key size": mkbox("keys", u32(0), u32(1), u32(0xf0000000), []byte("mdta")),
+		"count":    mkbox("keys", u32(0), u32(0xffffffff), u32(12), []byte("mdta")),
+		"short":    mkbox("keys", u32(0), u32(1), u32(40), []byte("mdta"), []byte("key")),
+	} {
+		data := testMovie(false, mkbox("meta", keys, ilst))
+		m, err := Decode(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if m.Location.Lat != 55.6761 {
+			t.Errorf("%s: location %+v", name, m.Location)
+		}
+	}
+}
+
+func TestRotation(t *testing.T) {
+	for _, deg := range []int{0, 90, 180, 270} {
+		var m [9]int32
+		b := matrix(deg)
+		for i := range m {
+			m[i] = int32(binary.BigEndian.Uint32(b[i*4:]))
+		}
+		if r := rotation(m); r != deg {
+			t.Errorf("Expected %d got %d", deg, r)
+		}
+	}
+}
+
+func TestParseISO6709(t *testing.T) {
+	loc, err := ParseISO6709("+55.6761+012.5683/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc.Lat != 55.6761 || loc.Lng != 12.5683 || loc.Altitude != nil {
+		t.Errorf("Unexpected location %+v", loc)
+	}
+	for _, s := range []string{"", "55.6761,12.5683", "+95.0+010.0/", strings.Repeat("+", 3)} {
+		if _, err := ParseISO6709(s); err == nil {
+			t.Errorf("Expected error for %q", s)
+		}
+	}
+}
+
+func TestReadVideo(t *testing.T) {
+	data := testMovie(true)
+	v, err := ReadVideo(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := v.CreateVideoExifOutput()
+	if out.Lat != 55.6761 || out.PixelXDimension != 1080 || out.Date != created.Unix()*1000 || out.Codec != "hevc" || !out.Video || len(out.MissingExif) != 0 {
+		t.Errorf("Unexpected output %+v", out)
+	}
+	if v.VideoOutput().Size != int64(len(data)) {
+		t.Errorf("Unexpected size %d", v.VideoOutput().Size)
+	}
+
+	// the media data is read past, not kept
+	movie, size, err := streamBoxes(bytes.NewReader(data))
+	if err != nil || size != int64(len(data)) || bytes.Contains(movie, []byte("mdat")) {
+		t.Errorf("kept %d of %d bytes, %v", len(movie), size, err)
+	}
+	for name, data := range map[string][]byte{
+		"truncated": data[:len(data)-10],
+		"jpeg":      {0xff, 0xd8, 0xff, 0xe0, 0, 0x10, 'J', 'F', 'I', 'F', 0, 1},
+		"huge moov": append(u32(maxMovieSize+9), "moov"...),
+	} {
+		if _, err := ReadVideo(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestVideoOutput(t *testing.T) {
+	data := testMovie(false)
+	v, err := NewVideo(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := v.VideoOutput()
+	if out.Format != "mov" || out.Duration != 12.5 || out.VideoCodec != "hevc" || out.AudioCodec != "aac" {
+		t.Errorf("Unexpected output %+v", out)
+	}
+	// Rotated by 90 degrees, so the display is portrait
+	if out.Width != 1080 || out.Height != 1920 || out.Rotation != 90 || out.FrameRate != 29.97 {
+		t.Errorf("Unexpected video %dx%d rotated %d at %v fps", out.Width, out.Height, out.Rotation, out.FrameRate)
+	}
+	if out.Bitrate != int64(len(data))*8*1000/12500 {
+		t.Errorf("Unexpected bitrate %d", out.Bitrate)
+	}
+	if len(out.Streams) != 2 {
+		t.Fatalf("Expected 2 streams got %d", len(out.Streams))
+	}
+	audio, video := out.Streams[0], out.Streams[1]
+	if audio.Type != "audio" || audio.SampleRate != 48000 || audio.Channels != 2 || audio.Samples != 600 || audio.Bitrate != 600*1000*8*1000/12500 {
+		t.Errorf("Unexpected audio stream %+v", audio)
+	}
+	if video.Type != "video" || video.Width != 1920 || video.Height != 1080 || video.CodecTag != "hvc1" || video.Samples != 375 {
+		t.Errorf("Unexpected video stream %+v", video)
+	}
+}
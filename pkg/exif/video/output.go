@@ -0,0 +1,159 @@
+package video
+
+import (
+	"math"
+	"strings"
+	"time"
+)
+
+// VideoOutput is the technical description of a video, to check footage against delivery specs
+type VideoOutput struct {
+	// Format is the container: "mov", "mp4", "3gp" or "m4v"
+	Format string `json:"format"`
+	Brand  string `json:"brand,omitempty"`
+	Size   int64  `json:"size"`
+	// Duration in seconds
+	Duration     float64    `json:"duration"`
+	Bitrate      int64      `json:"bitrate"`
+	CreationTime *time.Time `json:"creationTime,omitempty"`
+	Location     *Location  `json:"location,omitempty"`
+
+	// Width and Height are the display size of the first video stream, after rotation
+	Width      int     `json:"width,omitempty"`
+	Height     int     `json:"height,omitempty"`
+	Rotation   int     `json:"rotation,omitempty"`
+	FrameRate  float64 `json:"frameRate,omitempty"`
+	VideoCodec string  `json:"videoCodec,omitempty"`
+	AudioCodec string  `json:"audioCodec,omitempty"`
+
+	Streams []*StreamInfo `json:"streams"`
+}
+
+// StreamInfo describes a single track
+type StreamInfo struct {
+	Index int    `json:"index"`
+	ID    uint32 `json:"id"`
+	// Type is "video", "audio" or the handler type of other tracks
+	Type string `json:"type"`
+	// Codec is the common codec name and CodecTag the fourcc it is stored as
+	Codec    string  `json:"codec,omitempty"`
+	CodecTag string  `json:"codecTag,omitempty"`
+	Duration float64 `json:"duration"`
+	Bitrate  int64   `json:"bitrate,omitempty"`
+	Samples  uint64  `json:"samples,omitempty"`
+
+	Width         int     `json:"width,omitempty"`
+	Height        int     `json:"height,omitempty"`
+	DisplayWidth  int     `json:"displayWidth,omitempty"`
+	DisplayHeight int     `json:"displayHeight,omitempty"`
+	Rotation      int     `json:"rotation,omitempty"`
+	FrameRate     float64 `json:"frameRate,omitempty"`
+
+	SampleRate int `json:"sampleRate,omitempty"`
+	Channels   int `json:"channels,omitempty"`
+}
+
+// codecs maps sample description fourccs to common codec names
+var codecs = map[string]string{
+	"avc1": "h264", "avc3": "h264",
+	"hvc1": "hevc", "hev1": "hevc",
+	"av01": "av1",
+	"vp09": "vp9",
+	"mp4v": "mpeg4",
+	"jpeg": "mjpeg", "mjpa": "mjpeg",
+	"apch": "prores", "apcn": "prores", "apcs": "prores", "apco": "prores", "ap4h": "prores", "ap4x": "prores",
+	"dvh1": "dolby vision", "dvhe": "dolby vision",
+	"mp4a": "aac",
+	"ac-3": "ac3", "ec-3": "eac3",
+	"Opus": "opus",
+	"fLaC": "flac",
+	"alac": "alac",
+	"sowt": "pcm", "twos": "pcm", "lpcm": "pcm", "in24": "pcm", "in32": "pcm", "fl32": "pcm",
+	"samr": "amr", "sawb": "amr",
+}
+
+// CodecName returns the common name of a codec fourcc, or the fourcc itself
+func CodecName(fourcc string) string {
+	if name, ok := codecs[fourcc]; ok {
+		return name
+	}
+	return strings.TrimSpace(fourcc)
+}
+
+// containerFormat derives the container from the major brand
+func containerFormat(brand string) string {
+	switch {
+	case brand == "qt  ":
+		return "mov"
+	case strings.HasPrefix(brand, "3g"):
+		return "3gp"
+	case brand == "M4V " || brand == "M4VH" || brand == "M4VP":
+		return "m4v"
+	default:
+		return "mp4"
+	}
+}
+
+func round(f float64, decimals int) float64 {
+	p := math.Pow(10, float64(decimals))
+	return math.Round(f*p) / p
+}
+
+// VideoOutput describes the video and its streams
+func (v *videoExifData) VideoOutput() *VideoOutput {
+	m := v.Meta
+	out := &VideoOutput{
+		Format:   containerFormat(m.Brand),
+		Brand:    strings.TrimSpace(m.Brand),
+		Size:     v.size,
+		Duration: round(m.Duration.Seconds(), 3),
+		Location: m.Location,
+		Streams:  make([]*StreamInfo, 0, len(m.Tracks)),
+	}
+	if m.Duration > 0 {
+		out.Bitrate = int64(float64(v.size*8) / m.Duration.Seconds())
+	}
+	if !m.CreationTime.IsZero() {
+		created := m.CreationTime
+		out.CreationTime = &created
+	}
+
+	for i, t := range m.Tracks {
+		s := &StreamInfo{
+			Index:    i,
+			ID:       t.ID,
+			Type:     t.Handler,
+			Codec:    CodecName(t.Codec),
+			CodecTag: t.Codec,
+			Duration: round(t.Duration.Seconds(), 3),
+			Bitrate:  t.Bitrate(),
+			Samples:  t.Samples,
+		}
+		switch {
+		case t.IsVideo():
+			s.Type = "video"
+			s.Width, s.Height = t.CodedWidth, t.CodedHeight
+			s.DisplayWidth, s.DisplayHeight = int(math.Round(t.Width)), int(math.Round(t.Height))
+			if t.Rotation == 90 || t.Rotation == 270 {
+				s.DisplayWidth, s.DisplayHeight = s.DisplayHeight, s.DisplayWidth
+			}
+			s.Rotation = t.Rotation
+			s.FrameRate = round(t.FrameRate(), 3)
+			if out.VideoCodec == "" {
+				out.VideoCodec = s.Codec
+				out.Width, out.Height = s.DisplayWidth, s.DisplayHeight
+				out.Rotation = s.Rotation
+				out.FrameRate = s.FrameRate
+			}
+		case t.IsAudio():
+			s.Type = "audio"
+			s.SampleRate = int(t.SampleRate)
+			s.Channels = t.Channels
+			if out.AudioCodec == "" {
+				out.AudioCodec = s.Codec
+			}
+		}
+		out.Streams = append(out.Streams, s)
+	}
+	return out
+}
@@ -0,0 +1,145 @@
+package video
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// tableBlock is the number of bytes of a sample table read at once
+const tableBlock = 64 << 10
+
+// decodeStbl reads the codec of the first sample description and the sample counts, sizes and durations
+func (t *Track) decodeStbl(r io.ReaderAt, children []box) error {
+	if stsd, ok := find(children, "stsd"); ok {
+		if err := t.decodeStsd(r, stsd); err != nil {
+			return err
+		}
+	}
+	if stts, ok := find(children, "stts"); ok {
+		err := eachEntry(r, stts, 8, func(e []byte) {
+			count := uint64(binary.BigEndian.Uint32(e[:4]))
+			t.Samples += count
+			t.SampleDuration += count * uint64(binary.BigEndian.Uint32(e[4:8]))
+		})
+		if err != nil {
+			return errors.Wrap(err, "reading stts")
+		}
+	}
+	if stsz, ok := find(children, "stsz"); ok {
+		var hdr [12]byte
+		if _, err := r.ReadAt(hdr[:], stsz.start); err != nil {
+			return errors.Wrap(err, "reading stsz")
+		}
+		// With a fixed sample size there is no table
+		if size := uint64(binary.BigEndian.Uint32(hdr[4:8])); size > 0 {
+			t.SampleBytes = size * uint64(binary.BigEndian.Uint32(hdr[8:12]))
+		} else {
+			err := eachEntry(r, box{typ: stsz.typ, start: stsz.start + 4, end: stsz.end}, 4, func(e []byte) {
+				t.SampleBytes += uint64(binary.BigEndian.Uint32(e))
+			})
+			if err != nil {
+				return errors.Wrap(err, "reading stsz")
+			}
+		}
+	}
+	return nil
+}
+
+// eachEntry calls fn with every entry of a table box: a full box header, a 32 bit entry count and the entries
+func eachEntry(r io.ReaderAt, b box, size int, fn func(entry []byte)) error {
+	var hdr [8]byte
+	if _, err := r.ReadAt(hdr[:], b.start); err != nil {
+		return err
+	}
+	count := int64(binary.BigEndian.Uint32(hdr[4:8]))
+	offset := b.start + 8
+	if offset+count*int64(size) > b.end {
+		return errors.Errorf("%d entries don't fit in %s", count, b.typ)
+	}
+
+	buf := make([]byte, (tableBlock/size)*size)
+	for count > 0 {
+		n := int64(len(buf) / size)
+		if n > count {
+			n = count
+		}
+		block := buf[:n*int64(size)]
+		if _, err := r.ReadAt(block, offset); err != nil {
+			return err
+		}
+		for i := 0; i < len(block); i += size {
+			fn(block[i : i+size])
+		}
+		offset += int64(len(block))
+		count -= n
+	}
+	return nil
+}
+
+// decodeStsd reads the first sample description: its format and the frame size or audio format
+func (t *Track) decodeStsd(r io.ReaderAt, stsd box) error {
+	// version and flags, entry count, then the entry size and format
+	n := stsd.end - stsd.start
+	if n < 16 {
+		return nil
+	}
+	if n > 128 {
+		n = 128
+	}
+	p := make([]byte, n)
+	if _, err := r.ReadAt(p, stsd.start); err != nil {
+		return errors.Wrap(err, "reading stsd")
+	}
+	rd := &reader{b: p}
+	rd.skip(4)
+	if rd.u32() == 0 {
+		return nil
+	}
+	rd.skip(4)
+	t.Codec = rd.fourcc()
+	// reserved and data reference index
+	rd.skip(8)
+
+	switch t.Handler {
+	case "vide":
+		rd.skip(16)
+		t.CodedWidth = int(rd.u16())
+		t.CodedHeight = int(rd.u16())
+	case "soun":
+		version := rd.u16()
+		rd.skip(6)
+		channels := rd.u16()
+		rd.skip(6)
+		rate := rd.fixed16()
+		if version == 2 {
+			// Quicktime sound description v2 keeps the real values further on
+			rd.skip(4)
+			rate = math.Float64frombits(rd.u64())
+			channels = uint16(rd.u32())
+		}
+		if rd.err == nil {
+			t.Channels = int(channels)
+			t.SampleRate = rate
+		}
+	}
+	return nil
+}
+
+// FrameRate is the average number of samples per second, the frame rate of a video track
+func (t *Track) FrameRate() float64 {
+	if t.SampleDuration == 0 || t.Timescale == 0 {
+		return 0
+	}
+	return float64(t.Samples) * float64(t.Timescale) / float64(t.SampleDuration)
+}
+
+// Bitrate is the average number of bits per second of the track
+func (t *Track) Bitrate() int64 {
+	if t.Duration <= 0 {
+		return 0
+	}
+	return int64(float64(t.SampleBytes*8) / t.Duration.Seconds())
+}
@@ -1,203 +1,105 @@
 package video
 
 import (
-	"encoding/json"
+	"bytes"
 	"io"
-	"os/exec"
-	"regexp"
-	"strconv"
-	"sync"
-	"time"
+	"io/ioutil"
+	"os"
 
-	"github.com/davecgh/go-spew/spew"
 	"github.com/pkg/errors"
 
+	"github.com/blixenkrone/gopro/pkg/conversion"
 	exif "github.com/blixenkrone/gopro/pkg/exif"
-	"github.com/blixenkrone/gopro/pkg/file"
 	"github.com/blixenkrone/gopro/pkg/logger"
 )
 
-var (
-	log = logger.NewLogger()
-	mut sync.RWMutex
-)
-
-const (
-	width  = "width"
-	height = "height"
-	lat    = "lat"
-	lng    = "lng"
-)
+var log = logger.NewLogger()
 
 type videoExifData struct {
-	File *file.File
+	Meta *Metadata
+	size int64
+	// file is the spooled upload of SpoolVideo
+	file *os.File
 }
 
+// ReadVideo decodes the metadata of a video stream like an upload. Only the boxes Decode reads are kept
+// in memory, the media data is read past, so the video is never buffered.
 func ReadVideo(r io.Reader) (*videoExifData, error) {
-	f, err := file.NewFile(r)
+	movie, size, err := streamBoxes(r)
 	if err != nil {
 		return nil, err
 	}
-
-	return &videoExifData{
-		File: f,
-	}, nil
-}
-
-func (v *videoExifData) CreateVideoExifOutput() *exif.Output {
-	xErr := &exif.Output{MissingExif: make(map[string]string)}
-	// TODO:
-	// thumbnail, err := f.makeThumbnail()
-	// if err != nil {
-	// 	return nil, err
-	// }
-	size, err := v.File.FileSize()
-
+	meta, err := Decode(bytes.NewReader(movie), int64(len(movie)))
 	if err != nil {
-		err = errors.Cause(err)
-		xErr.AddMissingExif("filesize", err)
+		return nil, err
 	}
+	return &videoExifData{Meta: meta, size: size}, nil
+}
 
-	meta, err := v.ffprobeVideoMeta()
+// SpoolVideo copies r to a temp file only readable by this process and decodes its metadata, for
+// extracting frames, which needs the whole video. Close removes the file.
+func SpoolVideo(r io.Reader) (*videoExifData, error) {
+	f, err := ioutil.TempFile("", "video-*")
 	if err != nil {
-		xErr.AddMissingExif("metacmd", err)
+		return nil, errors.Wrap(err, "error creating tmp file")
 	}
-	spew.Dump(meta)
-
-	geo, err := v.parseLocation(meta.Format.Tags.Location)
-	if err != nil {
-		xErr.AddMissingExif("geo", err)
-		log.Errorf("cause: %s", errors.Cause(err))
+	v := &videoExifData{file: f}
+	if v.size, err = io.Copy(f, r); err != nil {
+		v.Close()
+		return nil, errors.Wrap(err, "error writing to tmp file")
 	}
-
-	return &exif.Output{
-		MediaSize:       size,
-		Date:            meta.Format.Tags.CreationTime.UnixNano(),
-		Lat:             geo[lat],
-		Lng:             geo[lng],
-		PixelXDimension: meta.Streams[0].Width,
-		PixelYDimension: meta.Streams[0].Height,
-		MissingExif:     xErr.MissingExif,
+	if v.Meta, err = Decode(f, v.size); err != nil {
+		v.Close()
+		return nil, err
 	}
+	return v, nil
 }
 
-type ffprobeOutput struct {
-	Format  *ffprobeFormat   `json:"format,omitempty"`
-	Streams []*ffprobeStream `json:"streams,omitempty"`
-}
-
-type ffprobeStream struct {
-	Width    int    `json:"width,omitempty"`
-	Height   int    `json:"height,omitempty"`
-	Duration string `json:"duration,omitempty"`
-}
-
-type ffprobeFormat struct {
-	Filename string       `json:"filename,omitempty"`
-	Duration string       `json:"duration,omitempty"`
-	Size     string       `json:"size,omitempty"`
-	Tags     *ffprobeTags `json:"tags,omitempty"`
-}
-
-type ffprobeTags struct {
-	CreationTime time.Time `json:"creation_time,omitempty"`
-	Location     string    `json:"location,omitempty"`
-}
-
-// func (v *videoExifData) ffprobeVideoMeta() ([]byte, error) {
-// 	ffmpeg, _ := exec.LookPath("ffmpeg")
-
-// 	cmd := exec.Command(ffmpeg, "-i", v.File.FileName(), "-c:v", "copy", "-movflags", "faststart", "-strict", "-2", "-moov_size", "bytes", "-hide_banner")
-// 	log.Info(cmd.String())
-// 	out, err := cmd.CombinedOutput()
-// 	if err != nil {
-// 		return nil, errors.Wrapf(err, "error cmd stdout: %s", out)
-// 	}
-// 	return out, nil
-// }
-
-func (v *videoExifData) ffprobeVideoMeta() (*ffprobeOutput, error) {
-	ffprobe, err := exec.LookPath("ffprobe")
-	if err != nil {
-		return nil, errors.New("error finding exec path for ffprobe")
-	}
-
-	cmd := exec.Command(ffprobe, "-v", "error", "-print_format", "json", "-show_format", "-show_streams", "-hide_banner", v.File.FileName())
-	log.Info(cmd.String())
-	out, err := cmd.CombinedOutput()
+// NewVideo decodes the metadata of a video of size bytes without copying it
+func NewVideo(r io.ReaderAt, size int64) (*videoExifData, error) {
+	meta, err := Decode(r, size)
 	if err != nil {
-		return nil, errors.Wrapf(err, "error cmd stdout: %s", out)
-	}
-
-	var ffprobeOut ffprobeOutput
-	if err := json.Unmarshal(out, &ffprobeOut); err != nil {
-		return nil, errors.Cause(err)
+		return nil, err
 	}
-	return &ffprobeOut, nil
+	return &videoExifData{Meta: meta, size: size}, nil
 }
 
-// Parse geolocation from single video file - no array pls
-func (v *videoExifData) parseLocation(input string) (geo map[string]float64, err error) {
-	if len(input) <= 0 {
-		return nil, errors.New("error: no location data provided in file")
+// Close removes the temp file of SpoolVideo
+func (v *videoExifData) Close() error {
+	if v.file == nil {
+		return nil
 	}
-	geo = make(map[string]float64, 2)
-	ISOCoordinate, match := matchRegExp(`((\+|-)\d+\.?\d*)`, []byte(input))
-	if !match {
-		return nil, errors.New("regex failed to match")
+	if err := v.file.Close(); err != nil {
+		log.Errorln(err)
 	}
-	coordinates := []string{lat, lng}
-	result := ISOCoordinate.FindAllString(input, 2)
-	for i := range result {
-		f, err := strconv.ParseFloat(result[i], 64)
-		if err != nil {
-			return nil, errors.Errorf("error parsing %s as float", coordinates[i])
-		}
-		geo[coordinates[i]] = f
-	}
-	return geo, nil
+	return os.Remove(v.file.Name())
 }
 
-// map represents height and width as string values i.e: hw["width"].
-func (v *videoExifData) matchWHDimension(input []byte) (wh map[string]int, err error) {
-	exp, match := matchRegExp(`[0-9]*`, input)
-	if !match {
-		return nil, errors.New("regex failed to match")
+func (v *videoExifData) CreateVideoExifOutput() *exif.Output {
+	out := &exif.Output{
+		MediaSize:   conversion.FileSizeBytesToFloat(int(v.size)),
+		MissingExif: make(map[string]string),
+		Video:       true,
 	}
-
-	log.Infof("matched %s", v.File.FileName())
-	wh = make(map[string]int)
-	// width always comes first
-	dimensions := exp.FindAllString(string(input), -1)
-	log.Infof("%s", dimensions)
-	arr := []string{width, height}
-	for i := range arr {
-		if err != nil {
-			err = errors.Wrapf(err, "str conv in wh loop failed at pos %v with video val %s", i, dimensions[i])
-		}
-		dimension, err := strconv.Atoi(dimensions[i])
-		if err != nil {
-			log.Error(errors.Wrap(err, "dimension strconv failed"))
-		}
-		wh[arr[i]] = dimension
+	if v.Meta.CreationTime.IsZero() {
+		out.AddMissingExif("date", errors.New("no creation time"))
+	} else {
+		out.Date = conversion.UnixNanoToMillis(v.Meta.CreationTime)
 	}
-	return wh, err
-}
-
-// TODO: For later use maybe?
-func execCmd(path string, arg ...string) (*exec.Cmd, error) {
-	pathcmd, err := exec.LookPath(path)
-	if err != nil {
-		return nil, errors.Errorf("error finding exec path for %s", path)
+	if loc := v.Meta.Location; loc != nil {
+		out.Lat, out.Lng = loc.Lat, loc.Lng
+	} else {
+		out.AddMissingExif("geo", errors.New("no location data provided in file"))
 	}
-	return exec.Command(pathcmd, arg...), nil
-}
-
-func matchRegExp(str string, bval []byte) (*regexp.Regexp, bool) {
-	exp := regexp.MustCompile(str)
-	if matched := exp.Match(bval); !matched {
-		return nil, false
+	if t := v.Meta.VideoTrack(); t != nil {
+		out.PixelXDimension = int(t.Width)
+		out.PixelYDimension = int(t.Height)
+		if t.Rotation == 90 || t.Rotation == 270 {
+			out.PixelXDimension, out.PixelYDimension = out.PixelYDimension, out.PixelXDimension
+		}
+		out.Codec = CodecName(t.Codec)
 	} else {
-		return exp, true
+		out.AddMissingExif("dimensions", errors.New("no video track"))
 	}
+	return out
 }
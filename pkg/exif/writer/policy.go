@@ -0,0 +1,166 @@
+package writer
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Policy decides the metadata kept when a JPEG is rewritten. Date, camera and exposure tags are always kept,
+// the others only with the consent of the photographer.
+type Policy struct {
+	// KeepGPS keeps the GPS IFD with the position the picture was taken at
+	KeepGPS bool
+	// KeepSerialNumbers keeps the serial numbers of the body and lens and the unique image id
+	KeepSerialNumbers bool
+	// KeepNames keeps the artist, owner and copyright
+	KeepNames bool
+	// KeepThumbnail keeps the thumbnail of IFD1, it is dropped anyway if the Exif gets too large for APP1
+	KeepThumbnail bool
+}
+
+// DefaultPolicy keeps the thumbnail but no GPS, serial numbers or names
+var DefaultPolicy = Policy{KeepThumbnail: true}
+
+// Consents of ParsePolicy
+const (
+	ConsentGPS          = "gps"
+	ConsentSerialNumber = "serial"
+	ConsentNames        = "names"
+)
+
+// ParsePolicy returns the DefaultPolicy extended with a comma separated list of consents
+func ParsePolicy(consents string) (Policy, error) {
+	p := DefaultPolicy
+	for _, c := range strings.Split(consents, ",") {
+		switch strings.TrimSpace(strings.ToLower(c)) {
+		case "":
+		case ConsentGPS:
+			p.KeepGPS = true
+		case ConsentSerialNumber:
+			p.KeepSerialNumbers = true
+		case ConsentNames:
+			p.KeepNames = true
+		default:
+			return p, errors.Errorf("unknown consent %q, expected %s, %s or %s", c, ConsentGPS, ConsentSerialNumber, ConsentNames)
+		}
+	}
+	return p, nil
+}
+
+// String names the consents of the policy, sorted, "none" without any
+func (p Policy) String() string {
+	var consents []string
+	if p.KeepGPS {
+		consents = append(consents, ConsentGPS)
+	}
+	if p.KeepSerialNumbers {
+		consents = append(consents, ConsentSerialNumber)
+	}
+	if p.KeepNames {
+		consents = append(consents, ConsentNames)
+	}
+	if len(consents) == 0 {
+		return "none"
+	}
+	sort.Strings(consents)
+	return strings.Join(consents, ",")
+}
+
+// tag groups of the whitelist
+const (
+	tagKeep = iota + 1
+	tagSerialNumber
+	tagName
+)
+
+// ifd0Tags are the tags of IFD0 that may be kept, the Exif and GPS pointers are handled separately
+var ifd0Tags = map[uint16]int{
+	0x010F: tagKeep, // Make
+	0x0110: tagKeep, // Model
+	0x0112: tagKeep, // Orientation
+	0x011A: tagKeep, // XResolution
+	0x011B: tagKeep, // YResolution
+	0x0128: tagKeep, // ResolutionUnit
+	0x0131: tagKeep, // Software
+	0x0132: tagKeep, // DateTime
+	0x0213: tagKeep, // YCbCrPositioning
+	0x013B: tagName, // Artist
+	0x8298: tagName, // Copyright
+	0x9C9D: tagName, // XPAuthor
+}
+
+// exifTags are the tags of the Exif IFD that may be kept. The maker note is never kept: it holds
+// serial numbers and its offsets break when it moves.
+var exifTags = map[uint16]int{
+	0x829A: tagKeep,         // ExposureTime
+	0x829D: tagKeep,         // FNumber
+	0x8822: tagKeep,         // ExposureProgram
+	0x8827: tagKeep,         // ISOSpeedRatings
+	0x8830: tagKeep,         // SensitivityType
+	0x8832: tagKeep,         // RecommendedExposureIndex
+	0x9000: tagKeep,         // ExifVersion
+	0x9003: tagKeep,         // DateTimeOriginal
+	0x9004: tagKeep,         // DateTimeDigitized
+	0x9010: tagKeep,         // OffsetTime
+	0x9011: tagKeep,         // OffsetTimeOriginal
+	0x9012: tagKeep,         // OffsetTimeDigitized
+	0x9101: tagKeep,         // ComponentsConfiguration
+	0x9201: tagKeep,         // ShutterSpeedValue
+	0x9202: tagKeep,         // ApertureValue
+	0x9203: tagKeep,         // BrightnessValue
+	0x9204: tagKeep,         // ExposureBiasValue
+	0x9205: tagKeep,         // MaxApertureValue
+	0x9207: tagKeep,         // MeteringMode
+	0x9208: tagKeep,         // LightSource
+	0x9209: tagKeep,         // Flash
+	0x920A: tagKeep,         // FocalLength
+	0x9290: tagKeep,         // SubSecTime
+	0x9291: tagKeep,         // SubSecTimeOriginal
+	0x9292: tagKeep,         // SubSecTimeDigitized
+	0xA000: tagKeep,         // FlashpixVersion
+	0xA001: tagKeep,         // ColorSpace
+	0xA002: tagKeep,         // PixelXDimension
+	0xA003: tagKeep,         // PixelYDimension
+	0xA217: tagKeep,         // SensingMethod
+	0xA300: tagKeep,         // FileSource
+	0xA301: tagKeep,         // SceneType
+	0xA401: tagKeep,         // CustomRendered
+	0xA402: tagKeep,         // ExposureMode
+	0xA403: tagKeep,         // WhiteBalance
+	0xA404: tagKeep,         // DigitalZoomRatio
+	0xA405: tagKeep,         // FocalLengthIn35mmFilm
+	0xA406: tagKeep,         // SceneCaptureType
+	0xA408: tagKeep,         // Contrast
+	0xA409: tagKeep,         // Saturation
+	0xA40A: tagKeep,         // Sharpness
+	0xA432: tagKeep,         // LensSpecification
+	0xA433: tagKeep,         // LensMake
+	0xA434: tagKeep,         // LensModel
+	0xA420: tagSerialNumber, // ImageUniqueID
+	0xA431: tagSerialNumber, // BodySerialNumber
+	0xA435: tagSerialNumber, // LensSerialNumber
+	0xA430: tagName,         // CameraOwnerName
+}
+
+// thumbnailTags are the tags of IFD1 describing the thumbnail
+var thumbnailTags = map[uint16]int{
+	0x0103: tagKeep, // Compression
+	0x011A: tagKeep, // XResolution
+	0x011B: tagKeep, // YResolution
+	0x0128: tagKeep, // ResolutionUnit
+	0x0202: tagKeep, // JPEGInterchangeFormatLength
+}
+
+func (p Policy) keep(tags map[uint16]int, tag uint16) bool {
+	switch tags[tag] {
+	case tagKeep:
+		return true
+	case tagSerialNumber:
+		return p.KeepSerialNumbers
+	case tagName:
+		return p.KeepNames
+	}
+	return false
+}
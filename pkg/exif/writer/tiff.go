@@ -0,0 +1,263 @@
+package writer
+
+import (
+	"encoding/binary"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// pointer tags to other IFDs and the thumbnail
+const (
+	tagExifIFD       = 0x8769
+	tagGPSIFD        = 0x8825
+	tagInteropIFD    = 0xA005
+	tagThumbnail     = 0x0201
+	tagThumbnailSize = 0x0202
+)
+
+// typeSizes are the byte sizes of the TIFF field types, 13 is an IFD offset
+var typeSizes = map[uint16]uint32{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8, 13: 4}
+
+type entry struct {
+	tag, typ uint16
+	count    uint32
+	// value is in the byte order of the file
+	value []byte
+}
+
+type ifd struct {
+	entries []entry
+	// sub are the IFDs pointed to by tag
+	sub map[uint16]*ifd
+}
+
+// tiffReader reads the IFDs of a TIFF structure, refusing to read one twice
+type tiffReader struct {
+	b     []byte
+	order binary.ByteOrder
+	seen  map[uint32]bool
+}
+
+func newTIFFReader(b []byte) (*tiffReader, error) {
+	r := &tiffReader{b: b, seen: make(map[uint32]bool)}
+	switch {
+	case len(b) < 8:
+		return nil, errors.New("exif: short tiff header")
+	case string(b[:4]) == "II*\x00":
+		r.order = binary.LittleEndian
+	case string(b[:4]) == "MM\x00*":
+		r.order = binary.BigEndian
+	default:
+		return nil, errors.New("exif: invalid tiff header")
+	}
+	return r, nil
+}
+
+// readIFD reads the entries of the IFD at offset and the offset of the next IFD
+func (r *tiffReader) readIFD(offset uint32) (*ifd, uint32, error) {
+	if r.seen[offset] {
+		return nil, 0, errors.Errorf("exif: ifd at %d is referenced twice", offset)
+	}
+	r.seen[offset] = true
+	if uint64(offset)+2 > uint64(len(r.b)) {
+		return nil, 0, errors.Errorf("exif: ifd at %d is out of range", offset)
+	}
+	n := uint32(r.order.Uint16(r.b[offset:]))
+	end := uint64(offset) + 2 + 12*uint64(n)
+	if end+4 > uint64(len(r.b)) {
+		return nil, 0, errors.Errorf("exif: ifd at %d overflows the tiff", offset)
+	}
+	d := &ifd{sub: make(map[uint16]*ifd)}
+	for i := uint32(0); i < n; i++ {
+		p := offset + 2 + 12*i
+		e := entry{tag: r.order.Uint16(r.b[p:]), typ: r.order.Uint16(r.b[p+2:]), count: r.order.Uint32(r.b[p+4:])}
+		size, ok := typeSizes[e.typ]
+		if !ok {
+			// the size of unknown types isn't known, so neither is their value
+			continue
+		}
+		length := uint64(size) * uint64(e.count)
+		at := uint64(p + 8)
+		if length > 4 {
+			at = uint64(r.order.Uint32(r.b[p+8:]))
+		}
+		if at+length > uint64(len(r.b)) {
+			return nil, 0, errors.Errorf("exif: value of tag %#04x is out of range", e.tag)
+		}
+		e.value = r.b[at : at+length]
+		d.entries = append(d.entries, e)
+	}
+	return d, r.order.Uint32(r.b[end:]), nil
+}
+
+// pointer returns the offset value of tag, which must be a single LONG or IFD
+func (d *ifd) pointer(order binary.ByteOrder, tag uint16) (uint32, bool) {
+	for _, e := range d.entries {
+		if e.tag == tag && (e.typ == 4 || e.typ == 13) && e.count == 1 {
+			return order.Uint32(e.value), true
+		}
+	}
+	return 0, false
+}
+
+// filter keeps the entries of the tags keep reports
+func (d *ifd) filter(keep func(tag uint16) bool) {
+	var kept []entry
+	for _, e := range d.entries {
+		if keep(e.tag) {
+			kept = append(kept, e)
+		}
+	}
+	d.entries = kept
+}
+
+// tiffWriter lays out IFDs one after the other, each followed by the values that don't fit in its entries
+type tiffWriter struct {
+	b     []byte
+	order binary.ByteOrder
+}
+
+func newTIFFWriter(order binary.ByteOrder) *tiffWriter {
+	w := &tiffWriter{order: order}
+	if order == binary.LittleEndian {
+		w.b = append(w.b, "II*\x00\x08\x00\x00\x00"...)
+	} else {
+		w.b = append(w.b, "MM\x00*\x00\x00\x00\x08"...)
+	}
+	return w
+}
+
+func (w *tiffWriter) align() {
+	if len(w.b)%2 == 1 {
+		w.b = append(w.b, 0)
+	}
+}
+
+// writeIFD writes d with its sub IFDs and returns its offset and the position of its next IFD offset.
+// The sub IFDs get a LONG entry pointing at them.
+func (w *tiffWriter) writeIFD(d *ifd) (offset uint32, next int) {
+	entries := append([]entry(nil), d.entries...)
+	for tag := range d.sub {
+		entries = append(entries, entry{tag: tag, typ: 4, count: 1, value: make([]byte, 4)})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+
+	w.align()
+	start := len(w.b)
+	w.b = append(w.b, make([]byte, 2+12*len(entries)+4)...)
+	w.order.PutUint16(w.b[start:], uint16(len(entries)))
+	pointers := make(map[uint16]int)
+	for i, e := range entries {
+		p := start + 2 + 12*i
+		w.order.PutUint16(w.b[p:], e.tag)
+		w.order.PutUint16(w.b[p+2:], e.typ)
+		w.order.PutUint32(w.b[p+4:], e.count)
+		if _, ok := d.sub[e.tag]; ok {
+			pointers[e.tag] = p + 8
+			continue
+		}
+		if len(e.value) <= 4 {
+			copy(w.b[p+8:], e.value)
+			continue
+		}
+		w.align()
+		w.order.PutUint32(w.b[p+8:], uint32(len(w.b)))
+		w.b = append(w.b, e.value...)
+	}
+	next = start + 2 + 12*len(entries)
+
+	tags := make([]int, 0, len(d.sub))
+	for tag := range d.sub {
+		tags = append(tags, int(tag))
+	}
+	sort.Ints(tags)
+	for _, tag := range tags {
+		subOffset, _ := w.writeIFD(d.sub[uint16(tag)])
+		w.order.PutUint32(w.b[pointers[uint16(tag)]:], subOffset)
+	}
+	return uint32(start), next
+}
+
+// rewriteTIFF keeps the tags of the TIFF structure of an Exif segment the policy allows
+func rewriteTIFF(b []byte, p Policy) ([]byte, error) {
+	r, err := newTIFFReader(b)
+	if err != nil {
+		return nil, err
+	}
+	ifd0, nextOffset, err := r.readIFD(r.order.Uint32(b[4:]))
+	if err != nil {
+		return nil, err
+	}
+	if offset, ok := ifd0.pointer(r.order, tagExifIFD); ok {
+		exifIFD, _, err := r.readIFD(offset)
+		if err != nil {
+			return nil, err
+		}
+		if offset, ok := exifIFD.pointer(r.order, tagInteropIFD); ok {
+			// the interoperability IFD only holds the compatibility of the file
+			if interop, _, err := r.readIFD(offset); err == nil {
+				exifIFD.sub[tagInteropIFD] = interop
+			}
+		}
+		exifIFD.filter(func(tag uint16) bool { return p.keep(exifTags, tag) })
+		ifd0.sub[tagExifIFD] = exifIFD
+	}
+	if offset, ok := ifd0.pointer(r.order, tagGPSIFD); ok && p.KeepGPS {
+		gps, _, err := r.readIFD(offset)
+		if err != nil {
+			return nil, err
+		}
+		ifd0.sub[tagGPSIFD] = gps
+	}
+	ifd0.filter(func(tag uint16) bool { return p.keep(ifd0Tags, tag) })
+
+	var ifd1 *ifd
+	var thumb []byte
+	if nextOffset != 0 && p.KeepThumbnail {
+		ifd1, thumb = r.thumbnail(nextOffset)
+	}
+	return writeTIFF(r.order, ifd0, ifd1, thumb), nil
+}
+
+// thumbnail reads IFD1 and its JPEG thumbnail, it returns nil if there is none
+func (r *tiffReader) thumbnail(offset uint32) (*ifd, []byte) {
+	ifd1, _, err := r.readIFD(offset)
+	if err != nil {
+		return nil, nil
+	}
+	thumbOffset, ok := ifd1.pointer(r.order, tagThumbnail)
+	size, hasSize := ifd1.pointer(r.order, tagThumbnailSize)
+	if !ok || !hasSize || uint64(thumbOffset)+uint64(size) > uint64(len(r.b)) {
+		return nil, nil
+	}
+	ifd1.filter(func(tag uint16) bool { return thumbnailTags[tag] == tagKeep })
+	return ifd1, r.b[thumbOffset : thumbOffset+size]
+}
+
+func writeTIFF(order binary.ByteOrder, ifd0, ifd1 *ifd, thumb []byte) []byte {
+	w := newTIFFWriter(order)
+	_, next := w.writeIFD(ifd0)
+	if ifd1 == nil {
+		return w.b
+	}
+	ifd1.entries = append(ifd1.entries, entry{tag: tagThumbnail, typ: 4, count: 1, value: make([]byte, 4)})
+	offset, _ := w.writeIFD(ifd1)
+	order.PutUint32(w.b[next:], offset)
+	w.align()
+	thumbAt := len(w.b)
+	w.b = append(w.b, thumb...)
+	w.patchThumbnail(offset, uint32(thumbAt))
+	return w.b
+}
+
+// patchThumbnail sets the thumbnail offset in the IFD1 at offset
+func (w *tiffWriter) patchThumbnail(offset, thumbAt uint32) {
+	n := int(w.order.Uint16(w.b[offset:]))
+	for i := 0; i < n; i++ {
+		p := int(offset) + 2 + 12*i
+		if w.order.Uint16(w.b[p:]) == tagThumbnail {
+			w.order.PutUint32(w.b[p+8:], thumbAt)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// Package writer rewrites the metadata of JPEGs for publishing. Exif is filtered through a whitelist Policy,
+// and XMP, IPTC, comments and other application segments that may hold names or places are dropped.
+// The coded image is copied byte for byte, so the picture doesn't lose quality.
+package writer
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrNotJPEG = errors.New("not a jpeg")
+
+	exifHeader = []byte("Exif\x00\x00")
+	iccHeader  = []byte("ICC_PROFILE\x00")
+	adobe      = []byte("Adobe")
+)
+
+const (
+	markerSOI   = 0xD8
+	markerEOI   = 0xD9
+	markerSOS   = 0xDA
+	markerAPP0  = 0xE0
+	markerAPP1  = 0xE1
+	markerAPP2  = 0xE2
+	markerAPP14 = 0xEE
+	markerAPP15 = 0xEF
+	markerCOM   = 0xFE
+
+	// maxSegment is the largest payload of a segment after its length
+	maxSegment = 0xFFFF - 2
+)
+
+// Strip rewrites the metadata of a JPEG by the policy. Kept are the JFIF header, the ICC profile, the Adobe
+// color transform and the Exif tags the policy allows. Data after the end of the image, like the extra
+// images of multi picture files, is dropped.
+func Strip(data []byte, p Policy) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != markerSOI {
+		return nil, ErrNotJPEG
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xFF, markerSOI)
+	wroteExif := false
+	for pos := 2; ; {
+		if pos+4 > len(data) || data[pos] != 0xFF {
+			return nil, errors.Errorf("jpeg: no marker at %d", pos)
+		}
+		marker := data[pos+1]
+		if marker == 0xFF {
+			// fill byte
+			pos++
+			continue
+		}
+		if marker == markerSOS {
+			end, err := imageEnd(data, pos)
+			if err != nil {
+				return nil, err
+			}
+			return append(out, data[pos:end]...), nil
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			return nil, errors.Errorf("jpeg: segment %#x overflows the file", marker)
+		}
+		segment, payload := data[pos:pos+2+length], data[pos+4:pos+2+length]
+		pos += 2 + length
+
+		switch {
+		case marker == markerAPP1 && bytes.HasPrefix(payload, exifHeader):
+			if wroteExif {
+				continue
+			}
+			wroteExif = true
+			exif, err := rewriteExif(payload[len(exifHeader):], p)
+			if err != nil {
+				return nil, err
+			}
+			out = appendSegment(out, markerAPP1, append(append([]byte{}, exifHeader...), exif...))
+		case keepSegment(marker, payload):
+			out = append(out, segment...)
+		}
+	}
+}
+
+// keepSegment reports whether a segment other than Exif is kept
+func keepSegment(marker byte, payload []byte) bool {
+	switch {
+	case marker == markerAPP0:
+		return true
+	case marker == markerAPP2:
+		// but not the multi picture index, the pictures it points at are dropped
+		return bytes.HasPrefix(payload, iccHeader)
+	case marker == markerAPP14:
+		return bytes.HasPrefix(payload, adobe)
+	case marker >= markerAPP0 && marker <= markerAPP15, marker == markerCOM:
+		return false
+	}
+	return true
+}
+
+// rewriteExif filters the TIFF structure of an Exif segment, dropping the thumbnail if it doesn't fit
+func rewriteExif(tiff []byte, p Policy) ([]byte, error) {
+	exif, err := rewriteTIFF(tiff, p)
+	if err != nil {
+		return nil, err
+	}
+	if len(exifHeader)+len(exif) > maxSegment && p.KeepThumbnail {
+		p.KeepThumbnail = false
+		return rewriteExif(tiff, p)
+	}
+	if len(exifHeader)+len(exif) > maxSegment {
+		return nil, errors.New("exif: too large for a segment")
+	}
+	return exif, nil
+}
+
+func appendSegment(out []byte, marker byte, payload []byte) []byte {
+	out = append(out, 0xFF, marker, 0, 0)
+	binary.BigEndian.PutUint16(out[len(out)-2:], uint16(2+len(payload)))
+	return append(out, payload...)
+}
+
+// imageEnd returns the position after the EOI marker, reading from the first SOS at pos. Progressive
+// JPEGs have several scans with tables between them.
+func imageEnd(data []byte, pos int) (int, error) {
+	for pos+2 <= len(data) {
+		if data[pos] != 0xFF {
+			return 0, errors.Errorf("jpeg: no marker at %d", pos)
+		}
+		marker := data[pos+1]
+		switch {
+		case marker == markerEOI:
+			return pos + 2, nil
+		case marker == 0xFF:
+			pos++
+			continue
+		case marker >= 0xD0 && marker <= 0xD7:
+			pos += 2
+			continue
+		}
+		if pos+4 > len(data) {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			return 0, errors.Errorf("jpeg: segment %#x overflows the file", marker)
+		}
+		pos += 2 + length
+		if marker != markerSOS {
+			continue
+		}
+		// the entropy coded data ends at the first marker that isn't a stuffed 0xFF or a restart
+		for pos+1 < len(data) && !(data[pos] == 0xFF && data[pos+1] != 0 && (data[pos+1] < 0xD0 || data[pos+1] > 0xD7)) {
+			pos++
+		}
+	}
+	// decoders show truncated images, so they are kept as they are
+	return len(data), nil
+}
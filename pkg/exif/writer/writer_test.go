@@ -0,0 +1,137 @@
+package writer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/jpeg"
+	"testing"
+
+	goexif "github.com/rwcarlsen/goexif/exif"
+
+	"github.com/blixenkrone/gopro/internal/mediatest"
+)
+
+// testJPEG has private metadata in every place a camera or editor may put it, and a second image after the first
+func testJPEG(t *testing.T, order binary.ByteOrder) (data, scan []byte) {
+	ifd0 := []mediatest.Entry{
+		mediatest.ASCII(0x10F, "Canon"), mediatest.ASCII(0x110, "Canon EOS R5"), mediatest.ASCII(0x13B, "Jane Doe"),
+	}
+	exifIFD := []mediatest.Entry{
+		mediatest.ASCII(0x9003, "2021:06:01 18:30:15"),
+		mediatest.Rational(order, 0x829D, 28, 10),
+		mediatest.Undefined(0x927C, []byte("Canon\x00\x00\x00")),
+		mediatest.ASCII(0xA430, "Jane Doe"),
+		mediatest.ASCII(0xA431, "012345678901"),
+	}
+	gps := []mediatest.Entry{
+		mediatest.ASCII(0x1, "N"), mediatest.Rational(order, 0x2, 56, 1, 9, 1, 0, 1),
+		mediatest.ASCII(0x3, "E"), mediatest.Rational(order, 0x4, 10, 1, 12, 1, 0, 1),
+	}
+	thumb := mediatest.JPEG(t, mediatest.Scene(16, 12), 0)
+	img := mediatest.JPEG(t, mediatest.Scene(64, 48), 0)
+	tiff := mediatest.TIFF{
+		Order:     order,
+		IFD0:      ifd0,
+		SubIFDs:   map[uint16][]mediatest.Entry{0x8769: exifIFD, 0x8825: gps},
+		Thumbnail: thumb,
+	}.Bytes()
+
+	data = bytes.Join([][]byte{
+		{0xFF, 0xD8},
+		mediatest.Segment(0xE1, exifHeader, tiff),
+		mediatest.Segment(0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta>Jane Doe</x:xmpmeta>")),
+		mediatest.Segment(0xE2, iccHeader, []byte{1, 1, 0, 0}),
+		mediatest.Segment(0xE2, []byte("MPF\x00index")),
+		mediatest.Segment(0xED, []byte("Photoshop 3.0\x008BIM")),
+		mediatest.Segment(0xFE, []byte("Jane's holiday")),
+		img[2:],
+		img,
+	}, nil)
+	return data, img[2:]
+}
+
+func TestStrip(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		data, scan := testJPEG(t, order)
+		out, err := Strip(data, DefaultPolicy)
+		if err != nil {
+			t.Fatalf("%s: %s", order, err)
+		}
+		if !bytes.HasSuffix(out, scan) {
+			t.Errorf("%s: image data changed", order)
+		}
+		for _, private := range []string{"Jane", "MPF", "8BIM", "0123456789"} {
+			if bytes.Contains(out, []byte(private)) {
+				t.Errorf("%s: %q is still in the stripped jpeg", order, private)
+			}
+		}
+		if !bytes.Contains(out, iccHeader) {
+			t.Errorf("%s: icc profile dropped", order)
+		}
+		if _, err := jpeg.Decode(bytes.NewReader(out)); err != nil {
+			t.Errorf("%s: stripped jpeg doesn't decode: %s", order, err)
+		}
+
+		x, err := goexif.Decode(bytes.NewReader(out))
+		if err != nil {
+			t.Fatalf("%s: %s", order, err)
+		}
+		if tag, err := x.Get(goexif.Model); err != nil || tag.String() != `"Canon EOS R5"` {
+			t.Errorf("%s: model %v, %v", order, tag, err)
+		}
+		if tag, err := x.Get(goexif.FNumber); err != nil || tag.String() != `"28/10"` {
+			t.Errorf("%s: f-number %v, %v", order, tag, err)
+		}
+		if _, err := x.Get(goexif.DateTimeOriginal); err != nil {
+			t.Errorf("%s: %s", order, err)
+		}
+		if _, _, err := x.LatLong(); err == nil {
+			t.Errorf("%s: gps kept without consent", order)
+		}
+		if _, err := x.Get(goexif.MakerNote); err == nil {
+			t.Errorf("%s: maker note kept", order)
+		}
+		if thumb, err := x.JpegThumbnail(); err != nil || !bytes.Equal(thumb, mediatest.JPEG(t, mediatest.Scene(16, 12), 0)) {
+			t.Errorf("%s: thumbnail not kept: %v", order, err)
+		}
+	}
+}
+
+func TestStripConsent(t *testing.T) {
+	data, _ := testJPEG(t, binary.LittleEndian)
+	p, err := ParsePolicy("gps, names")
+	if err != nil || p.String() != "gps,names" {
+		t.Fatalf("policy %s, %v", p, err)
+	}
+	out, err := Strip(data, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, err := goexif.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lat, lng, err := x.LatLong(); err != nil || lat < 56.14 || lat > 56.16 || lng < 10.19 || lng > 10.21 {
+		t.Errorf("gps %f,%f, %v", lat, lng, err)
+	}
+	if _, err := x.Get(goexif.Artist); err != nil {
+		t.Error("artist dropped with consent")
+	}
+	if bytes.Contains(out, []byte("0123456789")) {
+		t.Error("serial number kept without consent")
+	}
+	if _, err := ParsePolicy("everything"); err == nil {
+		t.Error("unknown consent accepted")
+	}
+}
+
+func TestStripInvalid(t *testing.T) {
+	if _, err := Strip([]byte("\x89PNG\r\n\x1a\n"), DefaultPolicy); err != ErrNotJPEG {
+		t.Errorf("got %v for a png", err)
+	}
+	data, _ := testJPEG(t, binary.BigEndian)
+	for n := 0; n < len(data); n += 5 {
+		// must not panic
+		Strip(data[:n], DefaultPolicy)
+	}
+}
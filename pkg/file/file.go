@@ -9,6 +9,7 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/blixenkrone/gopro/pkg/conversion"
+	exifwriter "github.com/blixenkrone/gopro/pkg/exif/writer"
 )
 
 type File struct {
@@ -103,9 +104,22 @@ func (f *File) FileSize() (size float64, err error) {
 	return size, err
 }
 
-func (f *File) EncodeExif(metaTag, value string) error {
-	// Handle file types
-
-	return nil
-
+// EncodeExif rewrites the metadata of a JPEG file by the policy, see exifwriter.Strip
+func (f *File) EncodeExif(p exifwriter.Policy) error {
+	if _, err := f.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	b, err := ioutil.ReadAll(f.file)
+	if err != nil {
+		return err
+	}
+	stripped, err := exifwriter.Strip(b, p)
+	if err != nil {
+		return errors.Wrap(err, "stripping exif")
+	}
+	if err := f.file.Truncate(0); err != nil {
+		return err
+	}
+	_, err = f.file.WriteAt(stripped, 0)
+	return err
 }
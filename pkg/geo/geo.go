@@ -0,0 +1,150 @@
+package geo
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// mean earth radius (IUGG)
+const earthRadiusKm = 6371.0088
+
+// Point is a WGS84 position in decimal degrees
+type Point struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+// ParsePoint parses "lat,lng", i.e. "55.6761,12.5683"
+func ParsePoint(s string) (Point, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return Point{}, errors.Errorf("expected lat,lng got %q", s)
+	}
+	lat, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil {
+		return Point{}, errors.Wrap(err, "parsing latitude")
+	}
+	lng, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil {
+		return Point{}, errors.Wrap(err, "parsing longitude")
+	}
+	p := Point{lat, lng}
+	if !p.Valid() {
+		return Point{}, errors.Errorf("coordinates out of range: %s", s)
+	}
+	return p, nil
+}
+
+// Valid reports whether the point is within the latitude and longitude ranges
+func (p Point) Valid() bool {
+	return p.Lat >= -90 && p.Lat <= 90 && p.Lng >= -180 && p.Lng <= 180
+}
+
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+func degrees(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
+
+// Distance returns the great-circle distance in km between a and b using the haversine formula
+func Distance(a, b Point) float64 {
+	dLat := radians(b.Lat - a.Lat)
+	dLng := radians(b.Lng - a.Lng)
+	h := math.Pow(math.Sin(dLat/2), 2) +
+		math.Cos(radians(a.Lat))*math.Cos(radians(b.Lat))*math.Pow(math.Sin(dLng/2), 2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// BoundingBox is a lat/lng rectangle containing every point within a radius.
+// It is meant as a cheap index friendly prefilter before the exact Distance check.
+type BoundingBox struct {
+	MinLat, MaxLat float64
+	MinLng, MaxLng float64
+	// AllLng is set when the box wraps the antimeridian or a pole, and longitude can't be used to filter
+	AllLng bool
+}
+
+// BoundingBox returns the box around p containing all points within radiusKm
+func (p Point) BoundingBox(radiusKm float64) BoundingBox {
+	dLat := degrees(radiusKm / earthRadiusKm)
+	box := BoundingBox{
+		MinLat: math.Max(p.Lat-dLat, -90),
+		MaxLat: math.Min(p.Lat+dLat, 90),
+	}
+	if box.MinLat == -90 || box.MaxLat == 90 {
+		box.AllLng = true
+		return box
+	}
+	dLng := degrees(math.Asin(math.Min(1, math.Sin(radians(dLat))/math.Cos(radians(p.Lat)))))
+	box.MinLng = p.Lng - dLng
+	box.MaxLng = p.Lng + dLng
+	if box.MinLng < -180 || box.MaxLng > 180 {
+		box.AllLng = true
+	}
+	return box
+}
+
+// Coordinate is a latitude or longitude in decimal degrees.
+// It accepts JSON numbers as well as the numeric strings older clients send,
+// and scans NULL, numeric and text database columns.
+type Coordinate float64
+
+// UnmarshalJSON implements json.Unmarshaler
+func (c *Coordinate) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case nil:
+		*c = 0
+	case float64:
+		*c = Coordinate(v)
+	case string:
+		if v == "" {
+			*c = 0
+			return nil
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return errors.Wrapf(err, "parsing coordinate %q", v)
+		}
+		*c = Coordinate(f)
+	default:
+		return errors.Errorf("coordinate must be a number got %s", b)
+	}
+	return nil
+}
+
+// Scan implements sql.Scanner
+func (c *Coordinate) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*c = 0
+	case float64:
+		*c = Coordinate(v)
+	case int64:
+		*c = Coordinate(v)
+	case []byte:
+		return c.Scan(string(v))
+	case string:
+		if v == "" {
+			*c = 0
+			return nil
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return errors.Wrapf(err, "scanning coordinate %q", v)
+		}
+		*c = Coordinate(f)
+	default:
+		return errors.Errorf("cannot scan %T into coordinate", src)
+	}
+	return nil
+}
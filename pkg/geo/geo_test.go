@@ -0,0 +1,70 @@
+package geo
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+var (
+	copenhagen = Point{55.6761, 12.5683}
+	aarhus     = Point{56.1629, 10.2039}
+)
+
+func TestDistance(t *testing.T) {
+	d := Distance(copenhagen, aarhus)
+	if math.Abs(d-157) > 2 {
+		t.Errorf("Expected ~157km got %v", d)
+	}
+	if d := Distance(copenhagen, copenhagen); d != 0 {
+		t.Errorf("Expected 0 got %v", d)
+	}
+}
+
+func TestBoundingBox(t *testing.T) {
+	box := copenhagen.BoundingBox(200)
+	if box.AllLng {
+		t.Fatal("box around copenhagen must not wrap")
+	}
+	if aarhus.Lat < box.MinLat || aarhus.Lat > box.MaxLat || aarhus.Lng < box.MinLng || aarhus.Lng > box.MaxLng {
+		t.Errorf("Expected aarhus within %+v", box)
+	}
+
+	if box := (Point{0, 179.9}).BoundingBox(50); !box.AllLng {
+		t.Errorf("Expected box crossing the antimeridian to use all longitudes %+v", box)
+	}
+	if box := (Point{89.9, 0}).BoundingBox(50); !box.AllLng || box.MaxLat != 90 {
+		t.Errorf("Expected box over the pole to use all longitudes %+v", box)
+	}
+}
+
+func TestParsePoint(t *testing.T) {
+	p, err := ParsePoint("55.6761, 12.5683")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p != copenhagen {
+		t.Errorf("Expected %v got %v", copenhagen, p)
+	}
+	for _, s := range []string{"", "55.6", "a,b", "91,0", "0,181"} {
+		if _, err := ParsePoint(s); err == nil {
+			t.Errorf("Expected error for %q", s)
+		}
+	}
+}
+
+func TestCoordinateUnmarshal(t *testing.T) {
+	var v struct {
+		Lat Coordinate `json:"lat"`
+		Lng Coordinate `json:"lng"`
+	}
+	if err := json.Unmarshal([]byte(`{"lat": "55.6761", "lng": 12.5683}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Lat != 55.6761 || v.Lng != 12.5683 {
+		t.Errorf("Expected 55.6761,12.5683 got %v,%v", v.Lat, v.Lng)
+	}
+	if err := json.Unmarshal([]byte(`{"lat": true}`), &v); err == nil {
+		t.Error("Expected error for bool coordinate")
+	}
+}
@@ -0,0 +1,131 @@
+package ical
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Minimal RFC 5545 writer for publishing calendars that apps like Google Calendar and iOS can subscribe to.
+
+const (
+	dateTimeFormat = "20060102T150405Z"
+	// lines longer than this are folded (RFC 5545 section 3.1)
+	maxLineOctets = 75
+)
+
+// Event statuses (RFC 5545 section 3.8.1.11)
+const (
+	StatusTentative = "TENTATIVE"
+	StatusConfirmed = "CONFIRMED"
+	StatusCancelled = "CANCELLED"
+)
+
+// Geo is a latitude/longitude pair
+type Geo struct {
+	Lat float64
+	Lng float64
+}
+
+// Event is a single VEVENT
+type Event struct {
+	UID         string
+	Stamp       time.Time
+	Start       time.Time
+	End         time.Time
+	Summary     string
+	Description string
+	Status      string
+	Geo         *Geo
+}
+
+// Calendar is a VCALENDAR with its events
+type Calendar struct {
+	ProdID string
+	Name   string
+	Events []*Event
+}
+
+// Encode writes the calendar as an iCalendar stream
+func (c *Calendar) Encode(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	e := &encoder{w: bw}
+
+	e.line("BEGIN", "VCALENDAR")
+	e.line("VERSION", "2.0")
+	e.line("PRODID", c.ProdID)
+	e.line("CALSCALE", "GREGORIAN")
+	e.line("METHOD", "PUBLISH")
+	if c.Name != "" {
+		e.line("X-WR-CALNAME", escapeText(c.Name))
+	}
+	for _, ev := range c.Events {
+		e.event(ev)
+	}
+	e.line("END", "VCALENDAR")
+
+	if e.err != nil {
+		return e.err
+	}
+	return bw.Flush()
+}
+
+type encoder struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (e *encoder) event(ev *Event) {
+	e.line("BEGIN", "VEVENT")
+	e.line("UID", ev.UID)
+	e.line("DTSTAMP", formatTime(ev.Stamp))
+	e.line("DTSTART", formatTime(ev.Start))
+	e.line("DTEND", formatTime(ev.End))
+	if ev.Summary != "" {
+		e.line("SUMMARY", escapeText(ev.Summary))
+	}
+	if ev.Description != "" {
+		e.line("DESCRIPTION", escapeText(ev.Description))
+	}
+	if ev.Status != "" {
+		e.line("STATUS", ev.Status)
+	}
+	if ev.Geo != nil {
+		e.line("GEO", fmt.Sprintf("%f;%f", ev.Geo.Lat, ev.Geo.Lng))
+	}
+	e.line("END", "VEVENT")
+}
+
+// line writes a content line folded to 75 octets and terminated by CRLF
+func (e *encoder) line(name, value string) {
+	if e.err != nil {
+		return
+	}
+	l := name + ":" + value
+	// continuation lines start with a space that counts towards the limit
+	for limit := maxLineOctets; len(l) > limit; limit = maxLineOctets - 1 {
+		cut := limit
+		// don't split multi byte utf-8 characters
+		for cut > 0 && l[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		if _, e.err = e.w.WriteString(l[:cut] + "\r\n "); e.err != nil {
+			return
+		}
+		l = l[cut:]
+	}
+	_, e.err = e.w.WriteString(l + "\r\n")
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(dateTimeFormat)
+}
+
+var textEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+// escapeText escapes a TEXT value (RFC 5545 section 3.3.11)
+func escapeText(s string) string {
+	return textEscaper.Replace(s)
+}
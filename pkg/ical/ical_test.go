@@ -0,0 +1,73 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncode(t *testing.T) {
+	start := time.Date(2020, 1, 6, 9, 0, 0, 0, time.FixedZone("CET", 3600))
+	cal := &Calendar{
+		ProdID: "-//Byrd//Pro//EN",
+		Name:   "Byrd bookings",
+		Events: []*Event{{
+			UID:     "booking-42@pro.byrd.news",
+			Stamp:   start,
+			Start:   start,
+			End:     start.Add(2 * time.Hour),
+			Summary: "Photos of the press conference; bring flash, tripod",
+			Status:  StatusConfirmed,
+			Geo:     &Geo{Lat: 55.676098, Lng: 12.568337},
+		}},
+	}
+
+	var buf bytes.Buffer
+	if err := cal.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	expected := []string{
+		"BEGIN:VCALENDAR\r\n",
+		"UID:booking-42@pro.byrd.news\r\n",
+		"DTSTART:20200106T080000Z\r\n",
+		"DTEND:20200106T100000Z\r\n",
+		`SUMMARY:Photos of the press conference\; bring flash\, tripod` + "\r\n",
+		"GEO:55.676098;12.568337\r\n",
+		"STATUS:CONFIRMED\r\n",
+		"END:VCALENDAR\r\n",
+	}
+	for _, e := range expected {
+		if !strings.Contains(out, e) {
+			t.Errorf("Expected output to contain %q got:\n%s", e, out)
+		}
+	}
+}
+
+func TestLineFolding(t *testing.T) {
+	var buf bytes.Buffer
+	cal := &Calendar{
+		ProdID: "-//Byrd//Pro//EN",
+		Events: []*Event{{Description: strings.Repeat("ø", 100)}},
+	}
+	if err := cal.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var unfolded strings.Builder
+	for i, l := range strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n") {
+		if len(l) > maxLineOctets {
+			t.Errorf("line %d is %d octets: %q", i, len(l), l)
+		}
+		if strings.HasPrefix(l, " ") {
+			unfolded.WriteString(l[1:])
+			continue
+		}
+		unfolded.WriteString("\n" + l)
+	}
+	if !strings.Contains(unfolded.String(), "DESCRIPTION:"+strings.Repeat("ø", 100)) {
+		t.Errorf("unfolding did not restore the description: %s", unfolded.String())
+	}
+}
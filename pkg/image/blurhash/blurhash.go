@@ -0,0 +1,116 @@
+// Package blurhash encodes images as BlurHash strings, see https://blurha.sh. A BlurHash is a few
+// dozen characters which clients decode to a blurred placeholder while the image loads.
+package blurhash
+
+import (
+	"image"
+	"math"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/pkg/errors"
+)
+
+const (
+	characters = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+	// sampleSize is the longest side the image is scaled down to, a blurhash has no more detail than that
+	sampleSize = 64
+)
+
+// Encode computes the BlurHash of img with xComponents by yComponents cosine components, each from 1 to 9.
+// More components keep more detail, 4 by 3 suits most photos.
+func Encode(img image.Image, xComponents, yComponents int) (string, error) {
+	if xComponents < 1 || xComponents > 9 || yComponents < 1 || yComponents > 9 {
+		return "", errors.Errorf("blurhash components %dx%d must be from 1 to 9", xComponents, yComponents)
+	}
+	if img.Bounds().Empty() {
+		return "", errors.New("blurhash of an empty image")
+	}
+	small := imaging.Fit(img, sampleSize, sampleSize, imaging.Box)
+	w, h := small.Bounds().Dx(), small.Bounds().Dy()
+
+	// the linear color of every pixel, so each component doesn't convert them again
+	linear := make([][3]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := small.NRGBAAt(x, y)
+			linear[y*w+x] = [3]float64{toLinear(c.R), toLinear(c.G), toLinear(c.B)}
+		}
+	}
+
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	for j := 0; j < yComponents; j++ {
+		for i := 0; i < xComponents; i++ {
+			var f [3]float64
+			for y := 0; y < h; y++ {
+				cy := math.Cos(math.Pi * float64(j) * float64(y) / float64(h))
+				for x := 0; x < w; x++ {
+					basis := math.Cos(math.Pi*float64(i)*float64(x)/float64(w)) * cy
+					p := linear[y*w+x]
+					f[0] += basis * p[0]
+					f[1] += basis * p[1]
+					f[2] += basis * p[2]
+				}
+			}
+			scale := 2 / float64(w*h)
+			if i == 0 && j == 0 {
+				scale = 1 / float64(w*h)
+			}
+			factors = append(factors, [3]float64{f[0] * scale, f[1] * scale, f[2] * scale})
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(encode83((xComponents-1)+(yComponents-1)*9, 1))
+	dc, ac := factors[0], factors[1:]
+	maxValue := 1.0
+	if len(ac) > 0 {
+		var actualMax float64
+		for _, f := range ac {
+			actualMax = math.Max(actualMax, math.Max(math.Abs(f[0]), math.Max(math.Abs(f[1]), math.Abs(f[2]))))
+		}
+		quantisedMax := int(math.Max(0, math.Min(82, math.Floor(actualMax*166-0.5))))
+		maxValue = float64(quantisedMax+1) / 166
+		b.WriteString(encode83(quantisedMax, 1))
+	} else {
+		b.WriteString(encode83(0, 1))
+	}
+	b.WriteString(encode83(toSRGB(dc[0])<<16+toSRGB(dc[1])<<8+toSRGB(dc[2]), 4))
+	for _, f := range ac {
+		b.WriteString(encode83(quantiseAC(f[0], maxValue)*19*19+quantiseAC(f[1], maxValue)*19+quantiseAC(f[2], maxValue), 2))
+	}
+	return b.String(), nil
+}
+
+func quantiseAC(v, maxValue float64) int {
+	return int(math.Max(0, math.Min(18, math.Floor(signPow(v/maxValue, 0.5)*9+9.5))))
+}
+
+func encode83(value, length int) string {
+	b := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		b[i] = characters[value%83]
+		value /= 83
+	}
+	return string(b)
+}
+
+func toLinear(v uint8) float64 {
+	c := float64(v) / 255
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+func toSRGB(v float64) int {
+	c := math.Max(0, math.Min(1, v))
+	if c <= 0.0031308 {
+		return int(c*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(c, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
@@ -0,0 +1,47 @@
+package blurhash
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestEncode(t *testing.T) {
+	// 4x3 components is size flag L, and the average color is exactly red
+	hash, err := Encode(imaging.New(300, 200, color.NRGBA{255, 0, 0, 255}), 4, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hash) != 28 || hash[:1] != "L" || hash[2:6] != "TI:j" {
+		t.Errorf("got %s", hash)
+	}
+
+	// left to right gradient, the first horizontal component carries it
+	img := image.NewNRGBA(image.Rect(0, 0, 256, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 256; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x), uint8(x), uint8(x), 255})
+		}
+	}
+	hash, err = Encode(img, 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hash) != 8 || hash[:1] != "1" || hash[6:] == "fQ" {
+		t.Errorf("got %s", hash)
+	}
+}
+
+func TestEncodeInvalid(t *testing.T) {
+	if _, err := Encode(imaging.New(10, 10, color.White), 0, 3); err == nil {
+		t.Error("0 components accepted")
+	}
+	if _, err := Encode(imaging.New(10, 10, color.White), 4, 10); err == nil {
+		t.Error("10 components accepted")
+	}
+	if _, err := Encode(image.NewNRGBA(image.Rectangle{}), 4, 3); err == nil {
+		t.Error("empty image accepted")
+	}
+}
@@ -0,0 +1,234 @@
+// Package derivative makes the renditions of an image the platform serves, e.g. thumbnails, previews
+// and web sizes, from a single decode. How they are scaled and encoded is configured by a list of
+// Renditions.
+package derivative
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"regexp"
+
+	"github.com/disintegration/imaging"
+	"github.com/pkg/errors"
+
+	"github.com/blixenkrone/gopro/pkg/image/smartcrop"
+	"github.com/blixenkrone/gopro/pkg/image/watermark"
+)
+
+// Mode is how an image is brought to the size of a Rendition
+type Mode string
+
+const (
+	// Fit scales the image down to fit within the size, keeping all of it
+	Fit Mode = "fit"
+	// Fill scales the image to cover the size and crops what sticks out
+	Fill Mode = "fill"
+	// Crop cuts the size out of the center of the image without scaling
+	Crop Mode = "crop"
+	// Smart scales the image like Fill, but keeps the part with the most detail, faces and color
+	// instead of the center
+	Smart Mode = "smart"
+)
+
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Rendition describes one derivative. Height may be 0 with Fit to only bound the width.
+// Images are never scaled up, so a derivative can be smaller than its rendition.
+type Rendition struct {
+	Name   string `json:"name"`
+	Width  int    `json:"width"`
+	Height int    `json:"height,omitempty"`
+	Mode   Mode   `json:"mode"`
+	Format string `json:"format"`
+	// Quality is from 1 to 100 for lossy formats, 0 is the default of the encoder
+	Quality int `json:"quality,omitempty"`
+	// Watermark is set for renditions large enough to be used without buying the image
+	Watermark bool `json:"watermark,omitempty"`
+}
+
+// DefaultRenditions are the sizes the platform shows deliverables in
+var DefaultRenditions = []Rendition{
+	{Name: "thumb", Width: 320, Height: 320, Mode: Fill, Format: JPEG, Quality: 80},
+	{Name: "preview", Width: 1280, Height: 1280, Mode: Fit, Format: JPEG, Quality: 85, Watermark: true},
+	{Name: "web", Width: 2048, Height: 2048, Mode: Fit, Format: JPEG, Quality: 90, Watermark: true},
+}
+
+// WithCrop returns the renditions with a fixed aspect ratio set to crop the center with Fill, or the
+// most interesting part with Smart
+func WithCrop(renditions []Rendition, mode Mode) ([]Rendition, error) {
+	if mode != Fill && mode != Smart {
+		return nil, errors.Errorf("unknown crop %q", mode)
+	}
+	out := make([]Rendition, len(renditions))
+	for i, r := range renditions {
+		if r.Mode == Fill || r.Mode == Smart {
+			r.Mode = mode
+		}
+		out[i] = r
+	}
+	return out, nil
+}
+
+// Validate checks the rendition can be made with the registered encoders
+func (r Rendition) Validate() error {
+	if !validName.MatchString(r.Name) {
+		return errors.Errorf("rendition name %q must be lower case letters, digits, - and _", r.Name)
+	}
+	if r.Width <= 0 || r.Height < 0 || (r.Height == 0 && r.Mode != Fit) {
+		return errors.Errorf("rendition %s: invalid size %dx%d for %s", r.Name, r.Width, r.Height, r.Mode)
+	}
+	switch r.Mode {
+	case Fit, Fill, Crop, Smart:
+	default:
+		return errors.Errorf("rendition %s: unknown mode %q", r.Name, r.Mode)
+	}
+	if r.Quality < 0 || r.Quality > 100 {
+		return errors.Errorf("rendition %s: quality must be from 1 to 100", r.Name)
+	}
+	if _, err := encoderFor(r.Format); err != nil {
+		return errors.Wrapf(err, "rendition %s", r.Name)
+	}
+	return nil
+}
+
+// ParseRenditions reads a JSON list of renditions and validates them
+func ParseRenditions(b []byte) ([]Rendition, error) {
+	var renditions []Rendition
+	if err := json.Unmarshal(b, &renditions); err != nil {
+		return nil, errors.Wrap(err, "decoding renditions")
+	}
+	if err := validate(renditions); err != nil {
+		return nil, err
+	}
+	return renditions, nil
+}
+
+func validate(renditions []Rendition) error {
+	names := make(map[string]bool)
+	for _, r := range renditions {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+		if names[r.Name] {
+			return errors.Errorf("rendition %s is listed twice", r.Name)
+		}
+		names[r.Name] = true
+	}
+	return nil
+}
+
+// Derivative is an encoded rendition of an image
+type Derivative struct {
+	Rendition   Rendition
+	Width       int
+	Height      int
+	ContentType string
+	Data        []byte
+}
+
+// Extension is the file extension of the derivative, with the dot
+func (d *Derivative) Extension() string {
+	return extensions[d.Rendition.Format]
+}
+
+// Generate makes the renditions of img with wm on those with Watermark set, it stops at the first that fails
+func Generate(img image.Image, renditions []Rendition, wm *watermark.Watermark) ([]*Derivative, error) {
+	if err := validate(renditions); err != nil {
+		return nil, err
+	}
+	derivatives := make([]*Derivative, 0, len(renditions))
+	for _, r := range renditions {
+		d, err := generate(img, r, wm)
+		if err != nil {
+			return nil, errors.Wrapf(err, "rendition %s", r.Name)
+		}
+		derivatives = append(derivatives, d)
+	}
+	return derivatives, nil
+}
+
+func generate(img image.Image, r Rendition, wm *watermark.Watermark) (*Derivative, error) {
+	scaled := Resize(img, r)
+	if r.Watermark {
+		if wm == nil {
+			return nil, errors.New("no watermark for a watermarked rendition")
+		}
+		marked, err := wm.Apply(scaled)
+		if err != nil {
+			return nil, err
+		}
+		scaled = marked
+	}
+	e, err := encoderFor(r.Format)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := e.Encode(&buf, scaled, r.Quality); err != nil {
+		return nil, err
+	}
+	b := scaled.Bounds()
+	return &Derivative{
+		Rendition:   r,
+		Width:       b.Dx(),
+		Height:      b.Dy(),
+		ContentType: contentTypes[r.Format],
+		Data:        buf.Bytes(),
+	}, nil
+}
+
+// Resize brings img to the size of the rendition by its mode
+func Resize(img image.Image, r Rendition) image.Image {
+	b := img.Bounds()
+	switch r.Mode {
+	case Fill:
+		w, h := r.Width, r.Height
+		if w > b.Dx() || h > b.Dy() {
+			// the largest part of the image with the aspect ratio of the rendition, not scaled up
+			scale := minFloat(float64(b.Dx())/float64(w), float64(b.Dy())/float64(h))
+			w, h = maxInt(1, int(float64(w)*scale)), maxInt(1, int(float64(h)*scale))
+			return imaging.CropCenter(img, w, h)
+		}
+		return imaging.Fill(img, w, h, imaging.Center, imaging.Lanczos)
+	case Smart:
+		// smartcrop keeps the aspect ratio of the rendition, only the size is left
+		cropped := imaging.Crop(img, smartcrop.Crop(img, r.Width, r.Height))
+		if cb := cropped.Bounds(); r.Width >= cb.Dx() || r.Height >= cb.Dy() {
+			return cropped
+		}
+		return imaging.Resize(cropped, r.Width, r.Height, imaging.Lanczos)
+	case Crop:
+		return imaging.CropCenter(img, minInt(r.Width, b.Dx()), minInt(r.Height, b.Dy()))
+	default:
+		h := r.Height
+		if h == 0 {
+			h = b.Dy()
+		}
+		if b.Dx() <= r.Width && b.Dy() <= h {
+			return img
+		}
+		return imaging.Fit(img, r.Width, h, imaging.Lanczos)
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minFloat(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
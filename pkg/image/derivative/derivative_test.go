@@ -0,0 +1,146 @@
+package derivative
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os/exec"
+	"testing"
+
+	"github.com/blixenkrone/gopro/pkg/image/watermark"
+)
+
+func testImage(w, h int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	return img
+}
+
+func TestResize(t *testing.T) {
+	img := testImage(4000, 3000)
+	small := testImage(200, 100)
+	tests := []struct {
+		name string
+		img  image.Image
+		r    Rendition
+		w, h int
+	}{
+		{"fit", img, Rendition{Width: 1280, Height: 1280, Mode: Fit}, 1280, 960},
+		{"fit width", img, Rendition{Width: 800, Mode: Fit}, 800, 600},
+		{"fit small", small, Rendition{Width: 1280, Height: 1280, Mode: Fit}, 200, 100},
+		{"fill", img, Rendition{Width: 320, Height: 320, Mode: Fill}, 320, 320},
+		{"fill small", small, Rendition{Width: 320, Height: 320, Mode: Fill}, 100, 100},
+		{"smart", img, Rendition{Width: 320, Height: 240, Mode: Smart}, 320, 240},
+		{"smart small", small, Rendition{Width: 320, Height: 320, Mode: Smart}, 100, 100},
+		{"crop", img, Rendition{Width: 500, Height: 200, Mode: Crop}, 500, 200},
+		{"crop small", small, Rendition{Width: 500, Height: 50, Mode: Crop}, 200, 50},
+	}
+	for _, tt := range tests {
+		b := Resize(tt.img, tt.r).Bounds()
+		if b.Dx() != tt.w || b.Dy() != tt.h {
+			t.Errorf("%s: got %dx%d, want %dx%d", tt.name, b.Dx(), b.Dy(), tt.w, tt.h)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	renditions := append(DefaultRenditions, Rendition{Name: "lossless", Width: 100, Height: 100, Mode: Crop, Format: PNG})
+	derivatives, err := Generate(testImage(1600, 900), renditions, &watermark.Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][2]int{"thumb": {320, 320}, "preview": {1280, 720}, "web": {1600, 900}, "lossless": {100, 100}}
+	for _, d := range derivatives {
+		var cfg image.Config
+		var err error
+		switch d.ContentType {
+		case "image/jpeg":
+			cfg, err = jpeg.DecodeConfig(bytes.NewReader(d.Data))
+		case "image/png":
+			cfg, err = png.DecodeConfig(bytes.NewReader(d.Data))
+		}
+		size := want[d.Rendition.Name]
+		if err != nil || cfg.Width != size[0] || cfg.Height != size[1] || d.Width != size[0] || d.Height != size[1] {
+			t.Errorf("%s: %dx%d encoded as %dx%d %v, want %v", d.Rendition.Name, d.Width, d.Height, cfg.Width, cfg.Height, err, size)
+		}
+	}
+	if len(derivatives) != len(want) || derivatives[3].Extension() != ".png" {
+		t.Errorf("got %d derivatives", len(derivatives))
+	}
+
+	// the preview is watermarked, it is never made without one
+	preview := DefaultRenditions[1]
+	if _, err := Generate(testImage(1600, 900), []Rendition{preview}, nil); err == nil {
+		t.Error("preview made without a watermark")
+	}
+	preview.Watermark = false
+	plain, err := Generate(testImage(1600, 900), []Rendition{preview}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(plain[0].Data, derivatives[1].Data) {
+		t.Error("preview isn't watermarked")
+	}
+}
+
+func TestWithCrop(t *testing.T) {
+	renditions, err := WithCrop(DefaultRenditions, Smart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renditions[0].Mode != Smart || renditions[1].Mode != Fit || DefaultRenditions[0].Mode != Fill {
+		t.Errorf("got %+v", renditions)
+	}
+	if _, err := WithCrop(DefaultRenditions, Fit); err == nil {
+		t.Error("fit accepted as crop")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	invalid := []Rendition{
+		{Name: "Thumb", Width: 320, Height: 320, Mode: Fill, Format: JPEG},
+		{Name: "thumb", Width: 320, Mode: Fill, Format: JPEG},
+		{Name: "thumb", Width: 320, Height: 320, Mode: "stretch", Format: JPEG},
+		{Name: "thumb", Width: 320, Height: 320, Mode: Fill, Format: JPEG, Quality: 101},
+		{Name: "thumb", Width: 320, Height: 320, Mode: Fill, Format: "gif"},
+		{Name: "thumb", Width: 320, Height: 320, Mode: Fill, Format: AVIF},
+	}
+	for _, r := range invalid {
+		if err := r.Validate(); err == nil {
+			t.Errorf("%+v is valid", r)
+		}
+	}
+	if _, err := ParseRenditions([]byte(`[{"name":"a","width":1,"mode":"fit","format":"png"},{"name":"a","width":2,"mode":"fit","format":"png"}]`)); err == nil {
+		t.Error("duplicate names accepted")
+	}
+}
+
+func TestCommandEncoder(t *testing.T) {
+	cp, err := exec.LookPath("cp")
+	if err != nil {
+		t.Skip("no cp")
+	}
+	// cp writes the png it gets, which shows the arguments are filled in
+	RegisterEncoder(WebP, CommandEncoder{Path: cp, Args: []string{"{in}", "{out}"}})
+	defer RegisterEncoder(WebP, nil)
+
+	derivatives, err := Generate(testImage(64, 64), []Rendition{{Name: "webp", Width: 32, Height: 32, Mode: Fill, Format: WebP}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := derivatives[0]
+	if cfg, err := png.DecodeConfig(bytes.NewReader(d.Data)); err != nil || cfg.Width != 32 || d.ContentType != "image/webp" {
+		t.Errorf("got %+v, %v as %s", cfg, err, d.ContentType)
+	}
+
+	if err := (CommandEncoder{Path: cp}).Encode(ioutil.Discard, testImage(1, 1), 0); err == nil {
+		t.Error("failing command succeeded")
+	}
+}
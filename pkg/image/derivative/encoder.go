@@ -0,0 +1,157 @@
+package derivative
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Formats of a Rendition. JPEG and PNG are built in, WebP and AVIF need a registered Encoder.
+const (
+	JPEG = "jpeg"
+	PNG  = "png"
+	WebP = "webp"
+	AVIF = "avif"
+)
+
+const encodeTimeout = time.Minute
+
+var (
+	contentTypes = map[string]string{JPEG: "image/jpeg", PNG: "image/png", WebP: "image/webp", AVIF: "image/avif"}
+	extensions   = map[string]string{JPEG: ".jpg", PNG: ".png", WebP: ".webp", AVIF: ".avif"}
+)
+
+// Encoder writes an image in a format. Quality is from 1 to 100, 0 for the default of the encoder.
+type Encoder interface {
+	Encode(w io.Writer, img image.Image, quality int) error
+}
+
+// EncoderFunc is an Encoder of a function
+type EncoderFunc func(w io.Writer, img image.Image, quality int) error
+
+func (f EncoderFunc) Encode(w io.Writer, img image.Image, quality int) error {
+	return f(w, img, quality)
+}
+
+var (
+	mu       sync.RWMutex
+	encoders = map[string]Encoder{
+		JPEG: EncoderFunc(func(w io.Writer, img image.Image, quality int) error {
+			if quality == 0 {
+				quality = jpeg.DefaultQuality
+			}
+			return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+		}),
+		PNG: EncoderFunc(func(w io.Writer, img image.Image, quality int) error {
+			return png.Encode(w, img)
+		}),
+	}
+)
+
+// RegisterEncoder sets the encoder of a format, nil removes it
+func RegisterEncoder(format string, e Encoder) {
+	mu.Lock()
+	defer mu.Unlock()
+	if e == nil {
+		delete(encoders, format)
+		return
+	}
+	encoders[format] = e
+}
+
+func encoderFor(format string) (Encoder, error) {
+	if _, ok := contentTypes[format]; !ok {
+		return nil, errors.Errorf("unknown format %q", format)
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	e, ok := encoders[format]
+	if !ok {
+		return nil, errors.Errorf("no %s encoder registered", format)
+	}
+	return e, nil
+}
+
+// CommandEncoder encodes with a command line encoder, reading a PNG and writing the format.
+// Args are the arguments with {in}, {out} and {quality} replaced.
+type CommandEncoder struct {
+	Path string
+	Args []string
+	// DefaultQuality is used when a rendition has none
+	DefaultQuality int
+}
+
+// CWebP encodes WebP with cwebp of libwebp
+func CWebP(path string) CommandEncoder {
+	return CommandEncoder{Path: path, Args: []string{"-quiet", "-q", "{quality}", "{in}", "-o", "{out}"}, DefaultQuality: 80}
+}
+
+// AVIFEnc encodes AVIF with avifenc of libavif
+func AVIFEnc(path string) CommandEncoder {
+	return CommandEncoder{Path: path, Args: []string{"-q", "{quality}", "{in}", "{out}"}, DefaultQuality: 60}
+}
+
+func (c CommandEncoder) Encode(w io.Writer, img image.Image, quality int) error {
+	dir, err := ioutil.TempDir("", "derivative-")
+	if err != nil {
+		return errors.Wrap(err, "creating tmp dir")
+	}
+	defer os.RemoveAll(dir)
+
+	in, out := filepath.Join(dir, "in.png"), filepath.Join(dir, "out")
+	f, err := os.Create(in)
+	if err != nil {
+		return errors.Wrap(err, "creating tmp file")
+	}
+	// fast compression, the file only lives until the command read it
+	err = (&png.Encoder{CompressionLevel: png.BestSpeed}).Encode(f, img)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrap(err, "writing tmp file")
+	}
+
+	if quality == 0 {
+		quality = c.DefaultQuality
+	}
+	args := make([]string, len(c.Args))
+	for i, a := range c.Args {
+		switch a {
+		case "{in}":
+			a = in
+		case "{out}":
+			a = out
+		case "{quality}":
+			a = strconv.Itoa(quality)
+		}
+		args[i] = a
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), encodeTimeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.Path, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return errors.Wrapf(err, "%s: %s", filepath.Base(c.Path), bytes.TrimSpace(stderr.Bytes()))
+	}
+	encoded, err := os.Open(out)
+	if err != nil {
+		return errors.Wrap(err, "opening encoded image")
+	}
+	defer encoded.Close()
+	_, err = io.Copy(w, encoded)
+	return err
+}
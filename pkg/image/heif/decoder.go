@@ -0,0 +1,82 @@
+package heif
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNoDecoder is returned by DecodeImage when no Decoder is registered
+var ErrNoDecoder = errors.New("no heif decoder registered")
+
+const decodeTimeout = 30 * time.Second
+
+// Decoder decodes the primary image of a HEIF file, rotated for display
+type Decoder interface {
+	Decode(data []byte) (image.Image, error)
+}
+
+var (
+	mu      sync.RWMutex
+	decoder Decoder
+)
+
+// RegisterDecoder sets the decoder used by DecodeImage
+func RegisterDecoder(d Decoder) {
+	mu.Lock()
+	defer mu.Unlock()
+	decoder = d
+}
+
+// DecodeImage decodes data with the registered Decoder
+func DecodeImage(data []byte) (image.Image, error) {
+	mu.RLock()
+	d := decoder
+	mu.RUnlock()
+	if d == nil {
+		return nil, ErrNoDecoder
+	}
+	return d.Decode(data)
+}
+
+// CommandDecoder decodes with heif-convert of libheif, or a command with the same arguments:
+// the input and output file, with the output format taken from its extension
+type CommandDecoder struct {
+	Path string
+}
+
+func (c CommandDecoder) Decode(data []byte) (image.Image, error) {
+	dir, err := ioutil.TempDir("", "heif-")
+	if err != nil {
+		return nil, errors.Wrap(err, "creating tmp dir")
+	}
+	defer os.RemoveAll(dir)
+
+	in, out := filepath.Join(dir, "in.heic"), filepath.Join(dir, "out.jpg")
+	if err := ioutil.WriteFile(in, data, 0600); err != nil {
+		return nil, errors.Wrap(err, "writing tmp file")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), decodeTimeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.Path, in, out)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrapf(err, "%s: %s", filepath.Base(c.Path), bytes.TrimSpace(stderr.Bytes()))
+	}
+	f, err := os.Open(out)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening decoded image")
+	}
+	defer f.Close()
+	return jpeg.Decode(f)
+}
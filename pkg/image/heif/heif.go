@@ -0,0 +1,467 @@
+// Package heif reads the item structure of HEIF files like the HEIC photos of iPhones: the primary
+// image, its size and rotation, and the Exif and XMP items. Decoding the HEVC coded image itself is
+// left to a Decoder.
+package heif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrNotHEIF = errors.New("not a heif file")
+	ErrNoExif  = errors.New("heif file has no exif")
+	ErrNoXMP   = errors.New("heif file has no xmp")
+
+	// brands are the ftyp brands of HEIF image files
+	brands = map[string]bool{
+		"heic": true, "heix": true, "heim": true, "heis": true,
+		"hevc": true, "hevx": true, "mif1": true, "msf1": true,
+	}
+)
+
+// Item is an item of the meta box, like a coded image or an Exif block
+type Item struct {
+	ID   uint32
+	Type string
+	// ContentType is set for mime items, application/rdf+xml for XMP
+	ContentType string
+	// Width and Height are of the coded image, before Rotation
+	Width, Height int
+	// Rotation is anti-clockwise in degrees
+	Rotation int
+
+	// inIdat is set for items stored in the idat box, not the file
+	inIdat  bool
+	extents []extent
+}
+
+type extent struct {
+	offset, length uint64
+}
+
+// File is a parsed HEIF file
+type File struct {
+	Brand   string
+	Primary uint32
+	Items   map[uint32]*Item
+
+	data []byte
+	idat []byte
+}
+
+// IsHEIF reports whether data starts with the ftyp box of a HEIF image
+func IsHEIF(data []byte) bool {
+	if len(data) < 16 || string(data[4:8]) != "ftyp" {
+		return false
+	}
+	size := int(binary.BigEndian.Uint32(data))
+	if size < 16 || size > len(data) {
+		return false
+	}
+	if brands[string(data[8:12])] {
+		return true
+	}
+	for p := 16; p+4 <= size; p += 4 {
+		if brands[string(data[p:p+4])] {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse reads the meta box of a HEIF file. The data is kept to read items from.
+func Parse(data []byte) (*File, error) {
+	if !IsHEIF(data) {
+		return nil, ErrNotHEIF
+	}
+	f := &File{Brand: string(data[8:12]), Items: make(map[uint32]*Item), data: data}
+	var meta []byte
+	err := boxes(data, func(typ string, payload []byte) error {
+		if typ == "meta" {
+			meta = payload
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(meta) < 4 {
+		return nil, errors.New("heif: no meta box")
+	}
+	if err := f.parseMeta(meta[4:]); err != nil {
+		return nil, err
+	}
+	if f.Items[f.Primary] == nil {
+		return nil, errors.Errorf("heif: primary item %d doesn't exist", f.Primary)
+	}
+	return f, nil
+}
+
+// PrimaryItem returns the main image of the file
+func (f *File) PrimaryItem() *Item {
+	return f.Items[f.Primary]
+}
+
+// Size returns the display size of the primary image, after rotation
+func (f *File) Size() (width, height int) {
+	p := f.PrimaryItem()
+	if p.Rotation == 90 || p.Rotation == 270 {
+		return p.Height, p.Width
+	}
+	return p.Width, p.Height
+}
+
+// Exif returns the TIFF structure of the Exif item, starting with the byte order
+func (f *File) Exif() ([]byte, error) {
+	for _, it := range f.Items {
+		if it.Type != "Exif" {
+			continue
+		}
+		b, err := f.ItemData(it)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) < 4 {
+			return nil, errors.New("heif: short exif item")
+		}
+		// the item starts with the offset of the TIFF header, past an optional "Exif\0\0"
+		offset := uint64(binary.BigEndian.Uint32(b)) + 4
+		if offset >= uint64(len(b)) {
+			return nil, errors.New("heif: exif header offset out of range")
+		}
+		return b[offset:], nil
+	}
+	return nil, ErrNoExif
+}
+
+// XMP returns the XMP packet of the file
+func (f *File) XMP() ([]byte, error) {
+	for _, it := range f.Items {
+		if it.Type == "mime" && it.ContentType == "application/rdf+xml" {
+			return f.ItemData(it)
+		}
+	}
+	return nil, ErrNoXMP
+}
+
+// ItemData returns the bytes of an item, joining its extents. Extents may not overlap, so an item is
+// never larger than the file it is in.
+func (f *File) ItemData(it *Item) ([]byte, error) {
+	src := f.data
+	if it.inIdat {
+		src = f.idat
+	}
+	var total uint64
+	for _, e := range it.extents {
+		if e.offset > uint64(len(src)) || e.length > uint64(len(src))-e.offset {
+			return nil, errors.Errorf("heif: item %d is out of range", it.ID)
+		}
+		total += e.length
+	}
+	if len(it.extents) == 1 {
+		e := it.extents[0]
+		return src[e.offset : e.offset+e.length], nil
+	}
+	sorted := append([]extent(nil), it.extents...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].offset < sorted[j].offset })
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].offset < sorted[i-1].offset+sorted[i-1].length {
+			return nil, errors.Errorf("heif: item %d has overlapping extents", it.ID)
+		}
+	}
+	b := make([]byte, 0, total)
+	for _, e := range it.extents {
+		b = append(b, src[e.offset:e.offset+e.length]...)
+	}
+	return b, nil
+}
+
+func (f *File) parseMeta(meta []byte) error {
+	var props [][]byte
+	var propTypes []string
+	var ipma []byte
+	err := boxes(meta, func(typ string, b []byte) error {
+		switch typ {
+		case "pitm":
+			r := &reader{b: b}
+			if version, _ := r.fullBox(); version == 0 {
+				f.Primary = uint32(r.u16())
+			} else {
+				f.Primary = r.u32()
+			}
+			return r.err
+		case "iinf":
+			return f.parseIinf(b)
+		case "iloc":
+			return f.parseIloc(b)
+		case "idat":
+			f.idat = b
+		case "iprp":
+			return boxes(b, func(typ string, b []byte) error {
+				switch typ {
+				case "ipco":
+					return boxes(b, func(typ string, b []byte) error {
+						props = append(props, b)
+						propTypes = append(propTypes, typ)
+						return nil
+					})
+				case "ipma":
+					ipma = b
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if ipma != nil {
+		return f.parseIpma(ipma, props, propTypes)
+	}
+	return nil
+}
+
+func (f *File) parseIinf(b []byte) error {
+	r := &reader{b: b}
+	if version, _ := r.fullBox(); version == 0 {
+		r.u16()
+	} else {
+		r.u32()
+	}
+	if r.err != nil {
+		return r.err
+	}
+	return boxes(b[r.p:], func(typ string, b []byte) error {
+		if typ != "infe" {
+			return nil
+		}
+		r := &reader{b: b}
+		version, _ := r.fullBox()
+		if version < 2 {
+			// infe before version 2 has no item type
+			return nil
+		}
+		it := &Item{}
+		if version == 2 {
+			it.ID = uint32(r.u16())
+		} else {
+			it.ID = r.u32()
+		}
+		r.u16() // protection index
+		it.Type = r.fourcc()
+		r.cstring() // name
+		if it.Type == "mime" {
+			it.ContentType = r.cstring()
+		}
+		if r.err != nil {
+			return errors.Wrap(r.err, "heif: infe")
+		}
+		if prev, ok := f.Items[it.ID]; ok {
+			// iloc came first
+			it.extents, it.inIdat = prev.extents, prev.inIdat
+		}
+		f.Items[it.ID] = it
+		return nil
+	})
+}
+
+func (f *File) parseIloc(b []byte) error {
+	r := &reader{b: b}
+	version, _ := r.fullBox()
+	sizes := r.u16()
+	offsetSize, lengthSize := int(sizes>>12), int(sizes>>8&0xF)
+	baseOffsetSize, indexSize := int(sizes>>4&0xF), int(sizes&0xF)
+	if version == 0 {
+		indexSize = 0
+	}
+	var count uint32
+	idSize := 2
+	if version < 2 {
+		count = uint32(r.u16())
+	} else {
+		count, idSize = r.u32(), 4
+	}
+	// counts are checked against the box before allocating, since extents of 0 byte fields
+	// would let a few bytes claim megabytes
+	header := idSize + 2 + baseOffsetSize + 2
+	if version > 0 {
+		header += 2
+	}
+	if r.err == nil && uint64(count)*uint64(header) > uint64(r.left()) {
+		return errors.Errorf("heif: iloc of %d items is larger than its box", count)
+	}
+	extentSize := offsetSize + lengthSize + indexSize
+	for i := uint32(0); i < count && r.err == nil; i++ {
+		var id uint32
+		if version < 2 {
+			id = uint32(r.u16())
+		} else {
+			id = r.u32()
+		}
+		method := 0
+		if version > 0 {
+			method = int(r.u16() & 0xF)
+		}
+		r.u16() // data reference index
+		base := r.uint(baseOffsetSize)
+		n := int(r.u16())
+		if lengthSize == 0 && n > 1 {
+			return errors.Errorf("heif: iloc item %d has %d extents without lengths", id, n)
+		}
+		if r.err == nil && n*extentSize > r.left() {
+			return errors.Errorf("heif: iloc item %d has %d extents, more than its box holds", id, n)
+		}
+		extents := make([]extent, n)
+		for j := range extents {
+			r.uint(indexSize)
+			extents[j].offset = base + r.uint(offsetSize)
+			extents[j].length = r.uint(lengthSize)
+		}
+		it, ok := f.Items[id]
+		if !ok {
+			it = &Item{ID: id}
+			f.Items[id] = it
+		}
+		it.extents, it.inIdat = extents, method == 1
+	}
+	return errors.Wrap(r.err, "heif: iloc")
+}
+
+func (f *File) parseIpma(b []byte, props [][]byte, propTypes []string) error {
+	r := &reader{b: b}
+	version, flags := r.fullBox()
+	count := r.u32()
+	for i := uint32(0); i < count && r.err == nil; i++ {
+		var id uint32
+		if version < 1 {
+			id = uint32(r.u16())
+		} else {
+			id = r.u32()
+		}
+		n := int(r.u8())
+		for j := 0; j < n; j++ {
+			var index int
+			if flags&1 != 0 {
+				index = int(r.u16() & 0x7FFF)
+			} else {
+				index = int(r.u8() & 0x7F)
+			}
+			// property indexes start at 1, 0 is no property
+			it := f.Items[id]
+			if it == nil || index == 0 || index > len(props) {
+				continue
+			}
+			p := props[index-1]
+			switch propTypes[index-1] {
+			case "ispe":
+				pr := &reader{b: p}
+				pr.fullBox()
+				it.Width, it.Height = int(pr.u32()), int(pr.u32())
+			case "irot":
+				if len(p) > 0 {
+					it.Rotation = int(p[0]&3) * 90
+				}
+			}
+		}
+	}
+	return errors.Wrap(r.err, "heif: ipma")
+}
+
+// boxes calls fn with the type and payload of each box in b
+func boxes(b []byte, fn func(typ string, payload []byte) error) error {
+	for len(b) > 0 {
+		if len(b) < 8 {
+			return errors.New("heif: truncated box header")
+		}
+		size := uint64(binary.BigEndian.Uint32(b))
+		typ := string(b[4:8])
+		header := uint64(8)
+		switch size {
+		case 0:
+			// the box extends to the end
+			size = uint64(len(b))
+		case 1:
+			if len(b) < 16 {
+				return errors.New("heif: truncated box header")
+			}
+			size, header = binary.BigEndian.Uint64(b[8:]), 16
+		}
+		if size < header || size > uint64(len(b)) {
+			return errors.Errorf("heif: box %q of %d bytes overflows its parent", typ, size)
+		}
+		if err := fn(typ, b[header:size]); err != nil {
+			return err
+		}
+		b = b[size:]
+	}
+	return nil
+}
+
+// reader reads big endian values, keeping the first error
+type reader struct {
+	b   []byte
+	p   int
+	err error
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err != nil || r.p+n > len(r.b) {
+		if r.err == nil {
+			r.err = errors.New("unexpected end of box")
+		}
+		return make([]byte, n)
+	}
+	b := r.b[r.p : r.p+n]
+	r.p += n
+	return b
+}
+
+// fullBox reads the version and flags of a full box
+// left is the number of bytes not read yet
+func (r *reader) left() int { return len(r.b) - r.p }
+
+func (r *reader) fullBox() (version byte, flags uint32) {
+	v := binary.BigEndian.Uint32(r.next(4))
+	return byte(v >> 24), v & 0xFFFFFF
+}
+
+func (r *reader) u8() byte       { return r.next(1)[0] }
+func (r *reader) u16() uint16    { return binary.BigEndian.Uint16(r.next(2)) }
+func (r *reader) u32() uint32    { return binary.BigEndian.Uint32(r.next(4)) }
+func (r *reader) fourcc() string { return string(r.next(4)) }
+
+// uint reads an unsigned integer of 0, 4 or 8 bytes as used by iloc
+func (r *reader) uint(size int) uint64 {
+	switch size {
+	case 0:
+		return 0
+	case 4:
+		return uint64(r.u32())
+	case 8:
+		return binary.BigEndian.Uint64(r.next(8))
+	default:
+		if r.err == nil {
+			r.err = errors.Errorf("invalid field size %d", size)
+		}
+		return 0
+	}
+}
+
+func (r *reader) cstring() string {
+	if r.err != nil {
+		return ""
+	}
+	i := bytes.IndexByte(r.b[r.p:], 0)
+	if i < 0 {
+		r.err = errors.New("unterminated string")
+		return ""
+	}
+	s := string(r.b[r.p : r.p+i])
+	r.p += i + 1
+	return s
+}
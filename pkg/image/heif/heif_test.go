@@ -0,0 +1,162 @@
+package heif
+
+import (
+	"bytes"
+	"image"
+	"testing"
+
+	"github.com/blixenkrone/gopro/internal/mediatest"
+)
+
+var (
+	box     = mediatest.Box
+	fullBox = mediatest.FullBox
+	u16     = mediatest.U16
+	u32     = mediatest.U32
+)
+
+func infe(id uint16, typ, contentType string) []byte {
+	b := [][]byte{u16(id), u16(0), []byte(typ), {0}}
+	if typ == "mime" {
+		b = append(b, append([]byte(contentType), 0))
+	}
+	return fullBox("infe", 2, 0, b...)
+}
+
+var (
+	tiff    = []byte("II*\x00\x08\x00\x00\x00\x00\x00\x00\x00\x00\x00")
+	exifRaw = append(append(u32(6), "Exif\x00\x00"...), tiff...)
+	coded   = []byte("hevc coded image")
+	xmp     = []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"/>`)
+)
+
+// testFile builds a HEIC with a rotated primary image and Exif in the file, and XMP in idat.
+// The extents of the items in mdat are patched in once the meta box size is known.
+func testFile() []byte {
+	ftyp := box("ftyp", []byte("heic"), u32(0), []byte("mif1heic"))
+	iloc := func(mdat uint32) []byte {
+		return fullBox("iloc", 1, 0,
+			// offset and length 4 bytes, base offset 0, index 0
+			u16(0x4400), u16(3),
+			u16(1), u16(0), u16(0), u16(1), u32(mdat), u32(uint32(len(coded))),
+			u16(2), u16(0), u16(0), u16(1), u32(mdat+uint32(len(coded))), u32(uint32(len(exifRaw))),
+			u16(3), u16(1), u16(0), u16(1), u32(0), u32(uint32(len(xmp))),
+		)
+	}
+	meta := func(mdat uint32) []byte {
+		return fullBox("meta", 0, 0,
+			fullBox("hdlr", 0, 0, u32(0), []byte("pict"), make([]byte, 13)),
+			fullBox("pitm", 0, 0, u16(1)),
+			fullBox("iinf", 0, 0, u16(3), infe(1, "hvc1", ""), infe(2, "Exif", ""), infe(3, "mime", "application/rdf+xml")),
+			iloc(mdat),
+			box("iprp",
+				box("ipco",
+					fullBox("ispe", 0, 0, u32(4032), u32(3024)),
+					box("irot", []byte{3}),
+				),
+				fullBox("ipma", 0, 0, u32(1), u16(1), []byte{2, 0x81, 2}),
+			),
+			box("idat", xmp),
+		)
+	}
+	head := len(ftyp) + len(meta(0)) + 8
+	return bytes.Join([][]byte{ftyp, meta(uint32(head)), box("mdat", coded, exifRaw)}, nil)
+}
+
+func TestParse(t *testing.T) {
+	data := testFile()
+	if !IsHEIF(data) {
+		t.Fatal("not detected as heif")
+	}
+	f, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := f.PrimaryItem()
+	if p.Type != "hvc1" || p.Width != 4032 || p.Height != 3024 || p.Rotation != 270 {
+		t.Errorf("primary %+v", p)
+	}
+	if w, h := f.Size(); w != 3024 || h != 4032 {
+		t.Errorf("size %dx%d, want 3024x4032", w, h)
+	}
+	b, err := f.ItemData(p)
+	if err != nil || !bytes.Equal(b, coded) {
+		t.Errorf("primary data %q, %v", b, err)
+	}
+	if b, err := f.Exif(); err != nil || !bytes.Equal(b, tiff) {
+		t.Errorf("exif %q, %v", b, err)
+	}
+	if b, err := f.XMP(); err != nil || !bytes.Equal(b, xmp) {
+		t.Errorf("xmp %q, %v", b, err)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	if IsHEIF([]byte("\xff\xd8\xff\xe0")) {
+		t.Error("jpeg detected as heif")
+	}
+	if _, err := Parse(box("ftyp", []byte("mp42"), u32(0), []byte("isom"))); err != ErrNotHEIF {
+		t.Errorf("got %v for an mp4", err)
+	}
+	data := testFile()
+	for n := 0; n < len(data); n += 7 {
+		// must not panic
+		Parse(data[:n])
+	}
+}
+
+func TestParseIlocCounts(t *testing.T) {
+	for name, b := range map[string][]byte{
+		// the counts must fit in the box, extents without lengths would otherwise cost no bytes
+		"extents":  bytes.Join([][]byte{u32(1 << 24), u16(0x0400), u16(1), u16(1), u16(0), u16(0), u16(0xFFFF)}, nil),
+		"items":    bytes.Join([][]byte{u32(1 << 24), u16(0x4400), u16(0xFFFF), u16(1), u16(0), u16(0), u16(0)}, nil),
+		"v2 items": bytes.Join([][]byte{u32(2 << 24), u16(0x4400), u32(0xFFFFFFFF), u32(1)}, nil),
+		"lengths":  bytes.Join([][]byte{u32(0), u16(0x4000), u16(1), u16(1), u16(0), u16(2), u32(0), u32(8)}, nil),
+	} {
+		f := &File{Items: make(map[uint32]*Item)}
+		if err := f.parseIloc(b); err == nil {
+			t.Errorf("%s: parsed %+v", name, f.Items[1])
+		}
+	}
+	f := &File{Items: make(map[uint32]*Item)}
+	one := bytes.Join([][]byte{u32(0), u16(0x4000), u16(1), u16(1), u16(0), u16(1), u32(8)}, nil)
+	if err := f.parseIloc(one); err != nil || len(f.Items[1].extents) != 1 {
+		t.Errorf("single extent without length: %v", err)
+	}
+}
+
+func TestItemDataExtents(t *testing.T) {
+	f := &File{data: []byte("0123456789")}
+	split := &Item{ID: 1, extents: []extent{{6, 4}, {0, 3}}}
+	if b, err := f.ItemData(split); err != nil || string(b) != "6789012" {
+		t.Errorf("got %q, %v", b, err)
+	}
+	// extents repeating the file would expand a small file into a huge item
+	overlap := &Item{ID: 2}
+	for i := 0; i < 1000; i++ {
+		overlap.extents = append(overlap.extents, extent{0, 10})
+	}
+	if b, err := f.ItemData(overlap); err == nil {
+		t.Errorf("got %d bytes of overlapping extents", len(b))
+	}
+	if _, err := f.ItemData(&Item{ID: 3, extents: []extent{{0, 4}, {3, 4}}}); err == nil {
+		t.Error("got overlapping extents")
+	}
+}
+
+type stubDecoder struct{ img image.Image }
+
+func (s stubDecoder) Decode(data []byte) (image.Image, error) { return s.img, nil }
+
+func TestDecodeImage(t *testing.T) {
+	RegisterDecoder(nil)
+	if _, err := DecodeImage(testFile()); err != ErrNoDecoder {
+		t.Errorf("got %v without a decoder", err)
+	}
+	want := image.NewGray(image.Rect(0, 0, 3, 4))
+	RegisterDecoder(stubDecoder{want})
+	defer RegisterDecoder(nil)
+	if img, err := DecodeImage(testFile()); err != nil || img != want {
+		t.Errorf("got %v, %v", img, err)
+	}
+}
@@ -0,0 +1,113 @@
+// Package palette finds the dominant colors of an image, which lists show as the background of an
+// image until it loads.
+package palette
+
+import (
+	"fmt"
+	"image"
+	"sort"
+
+	"github.com/disintegration/imaging"
+)
+
+// sampleSize is the longest side the image is scaled down to before counting colors
+const sampleSize = 64
+
+// Color is a dominant color of an image
+type Color struct {
+	// Hex is the color as #rrggbb
+	Hex string `json:"hex"`
+	// Share is the part of the image in the color, from 0 to 1
+	Share float64 `json:"share"`
+}
+
+type pixel [3]uint8
+
+// Dominant returns up to n colors of img, the most common first. It splits the pixels by median cut:
+// the group spanning the widest range of a channel is split at its median until there are n groups,
+// and each group is represented by its average.
+func Dominant(img image.Image, n int) []Color {
+	if n < 1 || img.Bounds().Empty() {
+		return nil
+	}
+	small := imaging.Fit(img, sampleSize, sampleSize, imaging.Box)
+	b := small.Bounds()
+	pixels := make([]pixel, 0, b.Dx()*b.Dy())
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			c := small.NRGBAAt(x, y)
+			if c.A < 128 {
+				// transparent parts show the background, not the image
+				continue
+			}
+			pixels = append(pixels, pixel{c.R, c.G, c.B})
+		}
+	}
+	if len(pixels) == 0 {
+		return nil
+	}
+
+	groups := [][]pixel{pixels}
+	for len(groups) < n {
+		widest, channel, span := -1, 0, 0
+		for i, g := range groups {
+			if c, s := widestChannel(g); s > span {
+				widest, channel, span = i, c, s
+			}
+		}
+		if widest < 0 {
+			// every group is a single color
+			break
+		}
+		g := groups[widest]
+		sort.Slice(g, func(i, j int) bool { return g[i][channel] < g[j][channel] })
+		// cut where the value changes next to the median, so no value ends up in both halves
+		median := g[len(g)/2][channel]
+		cut := sort.Search(len(g), func(i int) bool { return g[i][channel] >= median })
+		if cut == 0 {
+			cut = sort.Search(len(g), func(i int) bool { return g[i][channel] > median })
+		}
+		groups[widest] = g[:cut]
+		groups = append(groups, g[cut:])
+	}
+
+	colors := make([]Color, 0, len(groups))
+	for _, g := range groups {
+		var sum [3]int
+		for _, p := range g {
+			sum[0] += int(p[0])
+			sum[1] += int(p[1])
+			sum[2] += int(p[2])
+		}
+		colors = append(colors, Color{
+			Hex:   fmt.Sprintf("#%02x%02x%02x", sum[0]/len(g), sum[1]/len(g), sum[2]/len(g)),
+			Share: float64(len(g)) / float64(len(pixels)),
+		})
+	}
+	sort.SliceStable(colors, func(i, j int) bool { return colors[i].Share > colors[j].Share })
+	return colors
+}
+
+// widestChannel returns the channel with the largest range of values in the pixels, and the range
+func widestChannel(pixels []pixel) (channel, span int) {
+	if len(pixels) < 2 {
+		return 0, 0
+	}
+	lo, hi := pixels[0], pixels[0]
+	for _, p := range pixels[1:] {
+		for c := range p {
+			if p[c] < lo[c] {
+				lo[c] = p[c]
+			}
+			if p[c] > hi[c] {
+				hi[c] = p[c]
+			}
+		}
+	}
+	for c := range lo {
+		if s := int(hi[c]) - int(lo[c]); s > span {
+			channel, span = c, s
+		}
+	}
+	return channel, span
+}
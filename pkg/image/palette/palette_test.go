@@ -0,0 +1,43 @@
+package palette
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestDominant(t *testing.T) {
+	// three quarters blue, one quarter red
+	img := image.NewNRGBA(image.Rect(0, 0, 400, 400))
+	for y := 0; y < 400; y++ {
+		for x := 0; x < 400; x++ {
+			c := color.NRGBA{20, 40, 200, 255}
+			if x >= 300 {
+				c = color.NRGBA{220, 10, 10, 255}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	colors := Dominant(img, 5)
+	if len(colors) < 2 || colors[0].Hex != "#1428c8" || colors[0].Share < 0.7 || colors[0].Share > 0.75 {
+		t.Fatalf("got %+v", colors)
+	}
+	var red float64
+	for _, c := range colors {
+		if c.Hex == "#dc0a0a" {
+			red = c.Share
+		}
+	}
+	if red < 0.24 || red > 0.26 {
+		t.Errorf("red share %f of %+v", red, colors)
+	}
+
+	if colors := Dominant(imaging.New(50, 50, color.NRGBA{1, 2, 3, 255}), 5); len(colors) != 1 || colors[0] != (Color{"#010203", 1}) {
+		t.Errorf("plain image: %+v", colors)
+	}
+	if colors := Dominant(imaging.New(50, 50, color.Transparent), 5); colors != nil {
+		t.Errorf("transparent image: %+v", colors)
+	}
+}
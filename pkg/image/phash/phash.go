@@ -0,0 +1,103 @@
+// Package phash computes perceptual hashes of images, which stay close when an image is scaled,
+// recompressed or slightly color corrected, and differ when its content changes.
+package phash
+
+import (
+	"fmt"
+	"image"
+	"math/bits"
+	"strconv"
+
+	"github.com/disintegration/imaging"
+	"github.com/pkg/errors"
+)
+
+// Hash is a 64 bit difference hash
+type Hash uint64
+
+// NearDuplicate is the largest Distance between hashes of the same picture, allowing for scaling,
+// recompression and small edits
+const NearDuplicate = 8
+
+// DHash compares the brightness of neighbouring pixels of the image scaled to 9x8
+func DHash(img image.Image) Hash {
+	small := imaging.Resize(img, 9, 8, imaging.Box)
+	var h Hash
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if luma(small, x, y) < luma(small, x+1, y) {
+				h |= 1 << uint(y*8+x)
+			}
+		}
+	}
+	return h
+}
+
+// Distance is the number of differing bits, 0 for near identical and up to 64 for unrelated images
+func (h Hash) Distance(o Hash) int {
+	return bits.OnesCount64(uint64(h ^ o))
+}
+
+func (h Hash) String() string {
+	return fmt.Sprintf("%016x", uint64(h))
+}
+
+// MarshalText writes the hash as 16 hex digits
+func (h Hash) MarshalText() ([]byte, error) {
+	return []byte(h.String()), nil
+}
+
+// UnmarshalText reads a hash written by MarshalText
+func (h *Hash) UnmarshalText(b []byte) error {
+	v, err := Parse(string(b))
+	if err != nil {
+		return err
+	}
+	*h = v
+	return nil
+}
+
+// Parse reads a hash written by String
+func Parse(s string) (Hash, error) {
+	v, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid hash %q", s)
+	}
+	return Hash(v), nil
+}
+
+func luma(img *image.NRGBA, x, y int) uint32 {
+	c := img.NRGBAAt(x, y)
+	return (299*uint32(c.R) + 587*uint32(c.G) + 114*uint32(c.B)) / 1000
+}
+
+// TrimBorders removes the near black rows and columns around an image, like the letterbox of
+// EXIF thumbnails that have another aspect ratio than the image
+func TrimBorders(img image.Image) image.Image {
+	n := imaging.Clone(img)
+	b := n.Bounds()
+	dark := func(x0, y0, x1, y1 int) bool {
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				if luma(n, x, y) > 24 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	top, bottom, left, right := b.Min.Y, b.Max.Y, b.Min.X, b.Max.X
+	for top < bottom-1 && dark(left, top, right, top+1) {
+		top++
+	}
+	for bottom > top+1 && dark(left, bottom-1, right, bottom) {
+		bottom--
+	}
+	for left < right-1 && dark(left, top, left+1, bottom) {
+		left++
+	}
+	for right > left+1 && dark(right-1, top, right, bottom) {
+		right--
+	}
+	return imaging.Crop(n, image.Rect(left, top, right, bottom))
+}
@@ -0,0 +1,53 @@
+package phash
+
+import (
+	"encoding/json"
+	"image"
+	"image/draw"
+	"testing"
+
+	"github.com/disintegration/imaging"
+
+	"github.com/blixenkrone/gopro/internal/mediatest"
+)
+
+func TestDHash(t *testing.T) {
+	orig := mediatest.Scene(400, 300)
+	h := DHash(orig)
+	if d := h.Distance(DHash(imaging.Resize(orig, 160, 120, imaging.Lanczos))); d > 4 {
+		t.Errorf("scaled copy is %d bits apart", d)
+	}
+	if d := h.Distance(DHash(imaging.AdjustBrightness(orig, 10))); d > 4 {
+		t.Errorf("brightened copy is %d bits apart", d)
+	}
+	if d := h.Distance(DHash(imaging.Resize(imaging.Rotate90(orig), 400, 300, imaging.Lanczos))); d < 16 {
+		t.Errorf("rotated image is only %d bits apart", d)
+	}
+	if d := h.Distance(DHash(imaging.Invert(orig))); d < 40 {
+		t.Errorf("inverted image is only %d bits apart", d)
+	}
+}
+
+func TestParse(t *testing.T) {
+	h := DHash(mediatest.Scene(64, 48))
+	p, err := Parse(h.String())
+	if err != nil || p != h {
+		t.Errorf("got %v, %v from %s", p, err, h)
+	}
+	if _, err := Parse("xyz"); err == nil {
+		t.Error("parsed an invalid hash")
+	}
+	b, _ := json.Marshal(struct{ H *Hash }{&h})
+	var v struct{ H *Hash }
+	if err := json.Unmarshal(b, &v); err != nil || v.H == nil || *v.H != h {
+		t.Errorf("json %s read as %v, %v", b, v.H, err)
+	}
+}
+
+func TestTrimBorders(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 160, 120))
+	draw.Draw(img, image.Rect(0, 15, 160, 105), mediatest.Scene(160, 90), image.Point{}, draw.Src)
+	if b := TrimBorders(img).Bounds(); b.Dx() != 160 || b.Dy() != 90 {
+		t.Errorf("trimmed to %v, want 160x90", b)
+	}
+}
@@ -0,0 +1,40 @@
+// Package pixels guards decoding against images declaring more pixels than fit in memory. A few KB of
+// JPEG can declare 65535x65535 pixels, which decode into 16 GB.
+package pixels
+
+import (
+	"bytes"
+	"image"
+
+	"github.com/pkg/errors"
+
+	"github.com/blixenkrone/gopro/pkg/image/heif"
+)
+
+// Max is the most pixels of an image that is decoded, above the 100 megapixels of medium format cameras
+const Max = 120 * 1000 * 1000
+
+// ErrTooMany is returned for images larger than Max
+var ErrTooMany = errors.New("image has too many pixels")
+
+// Check reads the size an image declares in its header, the primary image of a HEIF, and returns
+// ErrTooMany when it is above Max. Other formats must be registered with package image.
+func Check(data []byte) (width, height int, err error) {
+	if heif.IsHEIF(data) {
+		f, err := heif.Parse(data)
+		if err != nil {
+			return 0, 0, err
+		}
+		width, height = f.Size()
+	} else {
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return 0, 0, errors.Wrap(err, "decoding image config")
+		}
+		width, height = cfg.Width, cfg.Height
+	}
+	if int64(width)*int64(height) > Max {
+		return width, height, errors.Wrapf(ErrTooMany, "%dx%d", width, height)
+	}
+	return width, height, nil
+}
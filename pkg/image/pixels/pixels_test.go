@@ -0,0 +1,33 @@
+package pixels
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestCheck(t *testing.T) {
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, image.NewGray(image.Rect(0, 0, 64, 48)), nil); err != nil {
+		t.Fatal(err)
+	}
+	if w, h, err := Check(b.Bytes()); err != nil || w != 64 || h != 48 {
+		t.Errorf("got %dx%d, %v", w, h, err)
+	}
+
+	// the same JPEG declaring 65535x65535 in its frame header
+	huge := append([]byte{}, b.Bytes()...)
+	sof := bytes.Index(huge, []byte{0xFF, 0xC0})
+	binary.BigEndian.PutUint16(huge[sof+5:], 0xFFFF)
+	binary.BigEndian.PutUint16(huge[sof+7:], 0xFFFF)
+	if _, _, err := Check(huge); errors.Cause(err) != ErrTooMany {
+		t.Errorf("got %v for 65535x65535", err)
+	}
+	if _, _, err := Check([]byte("not an image")); err == nil {
+		t.Error("expected an error")
+	}
+}
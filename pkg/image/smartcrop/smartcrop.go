@@ -0,0 +1,182 @@
+// Package smartcrop finds where to crop an image to an aspect ratio so it keeps what draws
+// attention: detail, skin tones and saturated colors, instead of always cutting out the center.
+package smartcrop
+
+import (
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	// analysisSize is the longest side the image is scaled down to before scoring it
+	analysisSize = 256
+
+	edgeWeight       = 1.0
+	skinWeight       = 1.5
+	saturationWeight = 0.3
+	// centerBias lowers the score of crops away from the center, so plain images are cut in the middle
+	centerBias = 0.05
+)
+
+// skin is the normalized color of skin tones
+var skin = [3]float64{0.78, 0.57, 0.44}
+
+// Crop returns the largest rectangle of img with the aspect ratio of width to height that holds the
+// most detail, skin and color. The image is returned whole when it already has the ratio.
+func Crop(img image.Image, width, height int) image.Rectangle {
+	b := img.Bounds()
+	if width <= 0 || height <= 0 || b.Empty() {
+		return b
+	}
+	cw, ch := b.Dx(), maxInt(1, b.Dx()*height/width)
+	if ch > b.Dy() {
+		cw, ch = maxInt(1, b.Dy()*width/height), b.Dy()
+	}
+	if cw == b.Dx() && ch == b.Dy() {
+		return b
+	}
+
+	small := imaging.Fit(img, analysisSize, analysisSize, imaging.Box)
+	sb := small.Bounds()
+	scaleX, scaleY := float64(b.Dx())/float64(sb.Dx()), float64(b.Dy())/float64(sb.Dy())
+	sums := summedArea(score(small))
+
+	// the crop slides along the side it doesn't fill
+	ww, wh := clamp(round(float64(cw)/scaleX), 1, sb.Dx()), clamp(round(float64(ch)/scaleY), 1, sb.Dy())
+	horizontal := cw < b.Dx()
+	steps := sb.Dy() - wh
+	if horizontal {
+		steps = sb.Dx() - ww
+	}
+	best, bestScore := steps/2, math.Inf(-1)
+	for pos := 0; pos <= steps; pos++ {
+		x, y := 0, pos
+		if horizontal {
+			x, y = pos, 0
+		}
+		s := sums.sum(x, y, x+ww, y+wh)
+		if steps > 0 {
+			s *= 1 - centerBias*math.Abs(float64(2*pos-steps))/float64(steps)
+		}
+		if s > bestScore || (s == bestScore && abs(2*pos-steps) < abs(2*best-steps)) {
+			best, bestScore = pos, s
+		}
+	}
+
+	x0, y0 := 0, clamp(round(float64(best)*scaleY), 0, b.Dy()-ch)
+	if horizontal {
+		x0, y0 = clamp(round(float64(best)*scaleX), 0, b.Dx()-cw), 0
+	}
+	return image.Rect(b.Min.X+x0, b.Min.Y+y0, b.Min.X+x0+cw, b.Min.Y+y0+ch)
+}
+
+// score rates every pixel of img by how much it draws attention
+func score(img *image.NRGBA) [][]float64 {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	lum := make([][]float64, h)
+	for y := range lum {
+		lum[y] = make([]float64, w)
+		for x := range lum[y] {
+			c := img.NRGBAAt(x, y)
+			lum[y][x] = (0.299*float64(c.R) + 0.587*float64(c.G) + 0.114*float64(c.B)) / 255
+		}
+	}
+	at := func(x, y int) float64 {
+		return lum[clamp(y, 0, h-1)][clamp(x, 0, w-1)]
+	}
+
+	scores := make([][]float64, h)
+	for y := range scores {
+		scores[y] = make([]float64, w)
+		for x := range scores[y] {
+			c := img.NRGBAAt(x, y)
+			r, g, b := float64(c.R)/255, float64(c.G)/255, float64(c.B)/255
+			l := lum[y][x]
+			edge := math.Min(1, math.Abs(4*l-at(x-1, y)-at(x+1, y)-at(x, y-1)-at(x, y+1)))
+			scores[y][x] = edgeWeight*edge + skinWeight*skinTone(r, g, b, l) + saturationWeight*saturation(r, g, b, l)
+		}
+	}
+	return scores
+}
+
+// skinTone is how close the color is to skin, for pixels neither too dark nor too bright
+func skinTone(r, g, b, l float64) float64 {
+	if l < 0.2 || l > 0.95 {
+		return 0
+	}
+	n := math.Sqrt(r*r + g*g + b*b)
+	if n == 0 {
+		return 0
+	}
+	d := math.Sqrt(sq(r/n-skin[0]) + sq(g/n-skin[1]) + sq(b/n-skin[2]))
+	if d > 0.2 {
+		return 0
+	}
+	return 1 - d/0.2
+}
+
+// saturation of the color, for pixels neither too dark nor too bright
+func saturation(r, g, b, l float64) float64 {
+	if l < 0.05 || l > 0.9 {
+		return 0
+	}
+	max, min := math.Max(r, math.Max(g, b)), math.Min(r, math.Min(g, b))
+	if max == 0 {
+		return 0
+	}
+	return (max - min) / max
+}
+
+// summedAreaTable holds the sum of all values above and left of each position
+type summedAreaTable [][]float64
+
+func summedArea(values [][]float64) summedAreaTable {
+	t := make(summedAreaTable, len(values)+1)
+	t[0] = make([]float64, len(values[0])+1)
+	for y, row := range values {
+		t[y+1] = make([]float64, len(row)+1)
+		for x, v := range row {
+			t[y+1][x+1] = v + t[y][x+1] + t[y+1][x] - t[y][x]
+		}
+	}
+	return t
+}
+
+// sum of the values from x0,y0 up to, not including, x1,y1
+func (t summedAreaTable) sum(x0, y0, x1, y1 int) float64 {
+	return t[y1][x1] - t[y0][x1] - t[y1][x0] + t[y0][x0]
+}
+
+func sq(v float64) float64 {
+	return v * v
+}
+
+func round(v float64) int {
+	return int(math.Round(v))
+}
+
+func clamp(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
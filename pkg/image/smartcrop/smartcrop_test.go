@@ -0,0 +1,31 @@
+package smartcrop
+
+import (
+	"image"
+	"testing"
+
+	"github.com/blixenkrone/gopro/internal/mediatest"
+)
+
+func TestCrop(t *testing.T) {
+	tests := []struct {
+		name          string
+		img           image.Image
+		width, height int
+		// keep must be inside the crop
+		keep image.Rectangle
+		size image.Point
+	}{
+		{"face right", mediatest.Portrait(800, 200, image.Rect(620, 40, 760, 160)), 1, 1, image.Rect(620, 40, 760, 160), image.Pt(200, 200)},
+		{"face top", mediatest.Portrait(200, 800, image.Rect(40, 20, 160, 140)), 1, 1, image.Rect(40, 20, 160, 140), image.Pt(200, 200)},
+		{"plain", mediatest.Portrait(800, 200, image.Rectangle{}), 1, 1, image.Rect(300, 0, 500, 200), image.Pt(200, 200)},
+		{"same ratio", mediatest.Portrait(800, 200, image.Rectangle{}), 4, 1, image.Rect(0, 0, 800, 200), image.Pt(800, 200)},
+		{"sub image", mediatest.Portrait(800, 200, image.Rect(420, 40, 560, 160)).SubImage(image.Rect(400, 0, 1000, 200)), 1, 1, image.Rect(420, 40, 560, 160), image.Pt(200, 200)},
+	}
+	for _, tt := range tests {
+		got := Crop(tt.img, tt.width, tt.height)
+		if got.Size() != tt.size || !got.In(tt.img.Bounds()) || !tt.keep.In(got) {
+			t.Errorf("%s: got %v, want %v of %v", tt.name, got, tt.size, tt.keep)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package thumbnail
+
+import (
+	"image"
+	"testing"
+
+	"github.com/blixenkrone/gopro/internal/mediatest"
+	"github.com/blixenkrone/gopro/pkg/image/heif"
+	"github.com/blixenkrone/gopro/pkg/image/phash"
+)
+
+type stubDecoder struct{ img image.Image }
+
+func (s stubDecoder) Decode(data []byte) (image.Image, error) { return s.img, nil }
+
+func TestNewHEIF(t *testing.T) {
+	// only the boxes describing the primary image are read, the stub does the decoding
+	box, fullBox, u16, u32 := mediatest.Box, mediatest.FullBox, mediatest.U16, mediatest.U32
+	data := append(box("ftyp", []byte("heic"), u32(0), []byte("mif1")), fullBox("meta", 0, 0,
+		fullBox("hdlr", 0, 0, u32(0), []byte("pict"), make([]byte, 13)),
+		fullBox("pitm", 0, 0, u16(1)),
+		fullBox("iinf", 0, 0, u16(1), fullBox("infe", 2, 0, u16(1, 0), []byte("hvc1\x00"))),
+		box("iprp",
+			box("ipco", fullBox("ispe", 0, 0, u32(3024, 4032))),
+			fullBox("ipma", 0, 0, u32(1), u16(1), []byte{1, 0x81}),
+		),
+	)...)
+	if _, err := New(data); err == nil {
+		t.Fatal("decoded a heic without a decoder")
+	}
+
+	heif.RegisterDecoder(stubDecoder{image.NewRGBA(image.Rect(0, 0, 3024, 4032))})
+	defer heif.RegisterDecoder(nil)
+	img, err := New(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Extension != heicExtension || img.Info.Width != 3024 || img.Info.Height != 4032 {
+		t.Errorf("got %s %dx%d", img.Extension, img.Info.Width, img.Info.Height)
+	}
+	if img.PHash != phash.DHash(img.Image) {
+		t.Errorf("phash %s not set", img.PHash)
+	}
+	thumb, err := img.EncodeThumbnail()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := thumb.ThumbnailImg.Bounds(); b.Dx() != defaultWidth || b.Dy() != defaultHeight {
+		t.Errorf("thumbnail %v", b)
+	}
+}
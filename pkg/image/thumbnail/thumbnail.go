@@ -9,6 +9,13 @@ import (
 	"github.com/disintegration/imaging"
 	"github.com/pkg/errors"
 
+	"github.com/blixenkrone/gopro/pkg/image/blurhash"
+	"github.com/blixenkrone/gopro/pkg/image/heif"
+	"github.com/blixenkrone/gopro/pkg/image/palette"
+	"github.com/blixenkrone/gopro/pkg/image/phash"
+	"github.com/blixenkrone/gopro/pkg/image/pixels"
+	"github.com/blixenkrone/gopro/pkg/image/smartcrop"
+	"github.com/blixenkrone/gopro/pkg/image/watermark"
 	"github.com/blixenkrone/gopro/pkg/logger"
 )
 
@@ -22,20 +29,44 @@ type Filter imaging.ResampleFilter
 const (
 	defaultWidth, defaultHeight                 = 640, 640
 	widthResizeThreshold, heightResizeThreshold = 720, 720
+	heicExtension                               = "heic"
+	blurHashX, blurHashY, paletteSize           = 4, 3, 5
 )
 
 type Image struct {
 	Extension    string
 	Info         image.Config
 	Image        image.Image
+	PHash        phash.Hash  // perceptual hash to find the same picture in other uploads
+	Placeholder  Placeholder // blurhash and colors shown while the image loads
 	buf          bytes.Buffer
 	parseOptions parseOptions
 }
 
+// Placeholder is shown by lists while the image loads
+type Placeholder struct {
+	BlurHash string          `json:"blurHash"`
+	Palette  []palette.Color `json:"palette"`
+}
+
+func newPlaceholder(img image.Image) (Placeholder, error) {
+	hash, err := blurhash.Encode(img, blurHashX, blurHashY)
+	if err != nil {
+		return Placeholder{}, errors.Wrap(err, "encoding blurhash")
+	}
+	return Placeholder{BlurHash: hash, Palette: palette.Dominant(img, paletteSize)}, nil
+}
+
 /**
 Constructor function to create new image processing. Filter is optional.
 */
 func New(b []byte, filter ...Filter) (*Image, error) {
+	if _, _, err := pixels.Check(b); err != nil {
+		return nil, err
+	}
+	if heif.IsHEIF(b) {
+		return newHEIF(b, filter...)
+	}
 	img, err := decodeImg(b)
 	if err != nil {
 		return nil, errors.Wrap(err, "decoding image from buffer")
@@ -45,6 +76,14 @@ func New(b []byte, filter ...Filter) (*Image, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "decoding image config")
 	}
+	// the size after the exif orientation was applied, portrait photos are stored sideways
+	bounds := img.Bounds()
+	cfg.Width, cfg.Height = bounds.Dx(), bounds.Dy()
+
+	placeholder, err := newPlaceholder(img)
+	if err != nil {
+		return nil, err
+	}
 
 	parseOpts := setDefaultParseOptions(filter...)
 	return &Image{
@@ -52,6 +91,29 @@ func New(b []byte, filter ...Filter) (*Image, error) {
 		Extension:    ext,
 		Info:         cfg,
 		Image:        img,
+		PHash:        phash.DHash(img),
+		Placeholder:  placeholder,
+	}, nil
+}
+
+// newHEIF decodes a HEIC with the decoder registered in the heif package
+func newHEIF(b []byte, filter ...Filter) (*Image, error) {
+	img, err := heif.DecodeImage(b)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding heif image")
+	}
+	placeholder, err := newPlaceholder(img)
+	if err != nil {
+		return nil, err
+	}
+	bounds := img.Bounds()
+	return &Image{
+		parseOptions: setDefaultParseOptions(filter...),
+		Extension:    heicExtension,
+		Info:         image.Config{ColorModel: img.ColorModel(), Width: bounds.Dx(), Height: bounds.Dy()},
+		Image:        img,
+		PHash:        phash.DHash(img),
+		Placeholder:  placeholder,
 	}, nil
 }
 
@@ -59,9 +121,10 @@ func byteReader(imageData []byte) *bytes.Reader {
 	return bytes.NewReader(imageData)
 }
 
+// decodeImg decodes the image and turns it upright by its exif orientation
 func decodeImg(data []byte) (img image.Image, err error) {
 	r := byteReader(data)
-	img, err = imaging.Decode(r)
+	img, err = imaging.Decode(r, imaging.AutoOrientation(true))
 	if err != nil {
 		return nil, errors.Wrap(err, "error decoding raw image")
 	}
@@ -98,6 +161,10 @@ func (img *Image) aboveThreshold(cfg image.Config) bool {
 // If the format is anything else than JPEG, convert it...
 func (img *Image) writeAsJPEG() (image.Image, error) {
 	var err error
+	if img.Extension == heicExtension {
+		// already decoded to pixels
+		return img.Image, nil
+	}
 	ext, err := imaging.FormatFromExtension(img.Extension)
 	if err != nil {
 		return nil, errors.Wrapf(err, "couldn't format from extension: %s", img.Extension)
@@ -124,6 +191,12 @@ func (img *Image) createThumbnail(opt parseOptions) *image.NRGBA {
 	return imaging.Thumbnail(img.Image, opt.width, opt.height, opt.filter)
 }
 
+// Create thumbnail of the part of the image with the most detail, faces and color
+func (img *Image) createSmartThumbnail(opt parseOptions) *image.NRGBA {
+	cropped := imaging.Crop(img.Image, smartcrop.Crop(img.Image, opt.width, opt.height))
+	return imaging.Resize(cropped, opt.width, opt.height, opt.filter)
+}
+
 // ParsedImage contains the thumbnail properties
 type ParsedImage struct {
 	ThumbnailImg *image.NRGBA
@@ -135,6 +208,16 @@ Resize image according to the default dimensions.
 Default value for filter is imaging.Lanczos
 */
 func (img *Image) EncodeThumbnail() (*ParsedImage, error) {
+	return img.encodeThumbnail(img.createThumbnail)
+}
+
+// EncodeSmartThumbnail is EncodeThumbnail cropped to the part of the image that draws attention
+// instead of the center, which keeps faces off center in the thumbnail
+func (img *Image) EncodeSmartThumbnail() (*ParsedImage, error) {
+	return img.encodeThumbnail(img.createSmartThumbnail)
+}
+
+func (img *Image) encodeThumbnail(create func(parseOptions) *image.NRGBA) (*ParsedImage, error) {
 	// is the img big enough to upload to byrd and therefore worth scaling?
 	if !img.aboveThreshold(img.Info) {
 		return nil, errors.New("image too small to upload to platform")
@@ -148,7 +231,7 @@ func (img *Image) EncodeThumbnail() (*ParsedImage, error) {
 		return nil, errors.Errorf("error writing file as jpeg: %s with ext: %s", err, img.Extension)
 	}
 	img.Image = parsedImg
-	thumbnail := img.createThumbnail(img.parseOptions)
+	thumbnail := create(img.parseOptions)
 
 	var buf bytes.Buffer
 	err = jpeg.Encode(&buf, thumbnail, nil)
@@ -169,3 +252,19 @@ type Reader interface {
 func (pImg *ParsedImage) Bytes() []byte {
 	return pImg.buf.Bytes()
 }
+
+// Watermark composites wm onto the thumbnail and encodes it again
+func (pImg *ParsedImage) Watermark(wm *watermark.Watermark) error {
+	marked, err := wm.Apply(pImg.ThumbnailImg)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, marked, nil); err != nil {
+		return errors.Wrap(err, "jpeg encoding err:")
+	}
+	pImg.ThumbnailImg = marked
+	pImg.buf = buf
+	return nil
+}
+
@@ -4,6 +4,9 @@ import (
 	"bytes"
 	"encoding/base64"
 	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
 	"io"
 	"strings"
 	"testing"
@@ -12,6 +15,7 @@ import (
 
 	"github.com/blixenkrone/gopro/internal/storage/aws"
 	"github.com/blixenkrone/gopro/pkg/file"
+	"github.com/blixenkrone/gopro/pkg/image/watermark"
 )
 
 func TestImageDecoding(t *testing.T) {
@@ -67,6 +71,80 @@ func TestNewDecodingFromString(t *testing.T) {
 
 }
 
+// orientedJPEG is a w x h jpeg with a red top left corner and the exif orientation tag
+func orientedJPEG(t *testing.T, w, h int, orientation byte) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.NRGBA{40, 40, 200, 255}
+			if x < 100 && y < 100 {
+				c = color.NRGBA{255, 0, 0, 255}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	// big endian tiff with an IFD0 of only the orientation
+	tiff := []byte("MM\x00*\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00")
+	tiff[19] = orientation
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+	segment := append([]byte{0xFF, 0xE1, 0, byte(len(app1) + 2)}, app1...)
+	b := buf.Bytes()
+	return append(append([]byte{0xFF, 0xD8}, segment...), b[2:]...)
+}
+
+func TestOrientation(t *testing.T) {
+	// 6 is rotated 90 degrees clockwise for display, the red corner ends up top right
+	img, err := New(orientedJPEG(t, 1000, 800, 6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := img.Image.Bounds()
+	if b.Dx() != 800 || b.Dy() != 1000 || img.Info.Width != 800 || img.Info.Height != 1000 {
+		t.Fatalf("got %v, info %dx%d", b, img.Info.Width, img.Info.Height)
+	}
+	if r, g, _, _ := img.Image.At(b.Max.X-10, 10).RGBA(); r>>8 < 200 || g>>8 > 60 {
+		t.Errorf("top right is %v, not red", img.Image.At(b.Max.X-10, 10))
+	}
+	if p := img.Placeholder; len(p.BlurHash) != 28 || len(p.Palette) == 0 || p.Palette[0].Share < 0.9 {
+		t.Errorf("placeholder %+v", p)
+	}
+
+	for _, encode := range []func() (*ParsedImage, error){img.EncodeThumbnail, img.EncodeSmartThumbnail} {
+		thumb, err := encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size := thumb.ThumbnailImg.Bounds().Size(); size != image.Pt(defaultWidth, defaultHeight) {
+			t.Errorf("thumbnail of %v", size)
+		}
+	}
+}
+
+func TestParsedImageWatermark(t *testing.T) {
+	img, err := New(orientedJPEG(t, 1000, 800, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	thumb, err := img.EncodeThumbnail()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := thumb.Bytes()
+	if err := thumb.Watermark(&watermark.Default); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(plain, thumb.Bytes()) {
+		t.Error("watermark not encoded")
+	}
+	if cfg, err := jpeg.DecodeConfig(bytes.NewReader(thumb.Bytes())); err != nil || cfg.Width != defaultWidth {
+		t.Errorf("got %+v, %v", cfg, err)
+	}
+}
+
 const data = `
 /9j/4AAQSkZJRgABAQIAHAAcAAD/2wBDABALDA4MChAODQ4SERATGCgaGBYWGDEjJR0oOjM9PDkzODdA
 SFxOQERXRTc4UG1RV19iZ2hnPk1xeXBkeFxlZ2P/2wBDARESEhgVGC8aGi9jQjhCY2NjY2NjY2NjY2Nj
@@ -0,0 +1,174 @@
+// Package watermark composites a logo or a text onto images, so previews can't be used without buying
+// the image.
+package watermark
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+
+	"github.com/disintegration/imaging"
+	"github.com/pkg/errors"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Position is where a watermark is placed on an image
+type Position string
+
+const (
+	Center      Position = "center"
+	TopLeft     Position = "top-left"
+	TopRight    Position = "top-right"
+	BottomLeft  Position = "bottom-left"
+	BottomRight Position = "bottom-right"
+)
+
+const (
+	maxLogoSize = 1 << 20
+	// maxLogoSide bounds the decoded logo, a small PNG can hold a huge image
+	maxLogoSide = 4096
+)
+
+// Watermark is a PNG logo or a text. Media customers have their own as watermark profiles.
+type Watermark struct {
+	// Logo is a PNG, drawn instead of Text when set
+	Logo     []byte   `json:"logo,omitempty"`
+	Text     string   `json:"text,omitempty"`
+	Position Position `json:"position,omitempty"`
+	// Opacity is from 0, invisible, to 1
+	Opacity float64 `json:"opacity"`
+	// Scale is the width of the watermark relative to the width of the image
+	Scale float64 `json:"scale"`
+	// Tile repeats the watermark over the whole image instead of placing it once
+	Tile bool `json:"tile,omitempty"`
+}
+
+// Default is put on previews of media without a watermark profile
+var Default = Watermark{Text: "PREVIEW", Opacity: 0.35, Scale: 0.3, Tile: true}
+
+// Validate checks the watermark can be drawn. The whole logo is decoded, a truncated PNG has a valid header.
+func (wm *Watermark) Validate() error {
+	if err := wm.checkSettings(); err != nil {
+		return err
+	}
+	_, err := wm.mark()
+	return err
+}
+
+func (wm *Watermark) checkSettings() error {
+	if len(wm.Logo) == 0 && wm.Text == "" {
+		return errors.New("watermark needs a logo or a text")
+	}
+	if len(wm.Logo) > maxLogoSize {
+		return errors.Errorf("watermark logo is larger than %d bytes", maxLogoSize)
+	}
+	if len(wm.Logo) > 0 {
+		cfg, err := png.DecodeConfig(bytes.NewReader(wm.Logo))
+		if err != nil {
+			return errors.Wrap(err, "watermark logo must be a png")
+		}
+		if cfg.Width > maxLogoSide || cfg.Height > maxLogoSide {
+			return errors.Errorf("watermark logo of %dx%d is larger than %dx%d", cfg.Width, cfg.Height, maxLogoSide, maxLogoSide)
+		}
+	}
+	if wm.Opacity <= 0 || wm.Opacity > 1 {
+		return errors.Errorf("watermark opacity %g must be above 0 and at most 1", wm.Opacity)
+	}
+	if wm.Scale <= 0 || wm.Scale > 1 {
+		return errors.Errorf("watermark scale %g must be above 0 and at most 1", wm.Scale)
+	}
+	switch wm.Position {
+	case "", Center, TopLeft, TopRight, BottomLeft, BottomRight:
+	default:
+		return errors.Errorf("unknown watermark position %q", wm.Position)
+	}
+	return nil
+}
+
+// Apply returns a copy of img with the watermark
+func (wm *Watermark) Apply(img image.Image) (*image.NRGBA, error) {
+	if err := wm.checkSettings(); err != nil {
+		return nil, err
+	}
+	mark, err := wm.mark()
+	if err != nil {
+		return nil, err
+	}
+	dst := imaging.Clone(img)
+	b := dst.Bounds()
+	mark = imaging.Resize(mark, maxInt(1, int(float64(b.Dx())*wm.Scale)), 0, imaging.Linear)
+	size := mark.Bounds().Size()
+	alpha := image.NewUniform(color.Alpha{uint8(wm.Opacity * 255)})
+	put := func(at image.Point) {
+		draw.DrawMask(dst, image.Rectangle{at, at.Add(size)}, mark, image.Point{}, alpha, image.Point{}, draw.Over)
+	}
+
+	if wm.Tile {
+		// rows are shifted by half a mark, so there is no clean column to crop out
+		stepX, stepY := size.X+size.X/2, size.Y*3
+		for row, y := 0, -size.Y/2; y < b.Dy(); row, y = row+1, y+stepY {
+			for x := -(row % 2) * stepX / 2; x < b.Dx(); x += stepX {
+				put(image.Pt(x, y))
+			}
+		}
+		return dst, nil
+	}
+	margin := minInt(b.Dx(), b.Dy()) / 50
+	x, y := (b.Dx()-size.X)/2, (b.Dy()-size.Y)/2
+	switch wm.Position {
+	case TopLeft:
+		x, y = margin, margin
+	case TopRight:
+		x, y = b.Dx()-size.X-margin, margin
+	case BottomLeft:
+		x, y = margin, b.Dy()-size.Y-margin
+	case BottomRight:
+		x, y = b.Dx()-size.X-margin, b.Dy()-size.Y-margin
+	}
+	put(image.Pt(x, y))
+	return dst, nil
+}
+
+// mark is the logo or the text in white with a dark outline, which shows on light and dark images
+func (wm *Watermark) mark() (image.Image, error) {
+	if len(wm.Logo) > 0 {
+		logo, err := png.Decode(bytes.NewReader(wm.Logo))
+		return logo, errors.Wrap(err, "decoding watermark logo")
+	}
+	face := basicfont.Face7x13
+	width := font.MeasureString(face, wm.Text).Ceil()
+	height := face.Metrics().Height.Ceil()
+	img := image.NewNRGBA(image.Rect(0, 0, width+2, height+2))
+	text := func(c color.Color, dx, dy int) {
+		d := font.Drawer{
+			Dst:  img,
+			Src:  image.NewUniform(c),
+			Face: face,
+			Dot:  fixed.P(1+dx, 1+dy+face.Metrics().Ascent.Ceil()),
+		}
+		d.DrawString(wm.Text)
+	}
+	for _, d := range []image.Point{{-1, 0}, {1, 0}, {0, -1}, {0, 1}} {
+		text(color.NRGBA{0, 0, 0, 160}, d.X, d.Y)
+	}
+	text(color.White, 0, 0)
+	return img, nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,97 @@
+package watermark
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func redLogo(t *testing.T) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, imaging.New(10, 10, color.NRGBA{255, 0, 0, 255})); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestWatermarkPosition(t *testing.T) {
+	gray := color.NRGBA{128, 128, 128, 255}
+	img := imaging.New(400, 300, gray)
+
+	wm := Watermark{Logo: redLogo(t), Position: BottomRight, Opacity: 1, Scale: 0.1}
+	out, err := wm.Apply(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the 40x40 logo is 6 pixels from the bottom right corner
+	if c := out.NRGBAAt(374, 274); c.R != 255 || c.G != 0 {
+		t.Errorf("logo pixel is %v", c)
+	}
+	if c := out.NRGBAAt(10, 10); c != gray {
+		t.Errorf("top left is %v", c)
+	}
+	if c := img.NRGBAAt(374, 274); c != gray {
+		t.Error("source image changed")
+	}
+
+	wm.Opacity, wm.Position = 0.5, TopLeft
+	out, err = wm.Apply(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := out.NRGBAAt(20, 20); c.R < 185 || c.R > 195 || c.G < 60 || c.G > 68 {
+		t.Errorf("half transparent logo pixel is %v", c)
+	}
+}
+
+func TestWatermarkTile(t *testing.T) {
+	img := imaging.New(640, 640, color.NRGBA{90, 120, 150, 255})
+	out, err := Default.Apply(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the text covers parts of the image all over it, not one spot
+	quarters := make(map[image.Point]bool)
+	changed := 0
+	for y := 0; y < 640; y++ {
+		for x := 0; x < 640; x++ {
+			if out.NRGBAAt(x, y) != img.NRGBAAt(x, y) {
+				changed++
+				quarters[image.Pt(x/320, y/320)] = true
+			}
+		}
+	}
+	if len(quarters) != 4 || changed < 640*640/50 || changed > 640*640/2 {
+		t.Errorf("%d pixels changed in %d quarters", changed, len(quarters))
+	}
+}
+
+func TestWatermarkValidate(t *testing.T) {
+	logo := redLogo(t)
+	var huge bytes.Buffer
+	if err := png.Encode(&huge, image.NewGray(image.Rect(0, 0, 5000, 10))); err != nil {
+		t.Fatal(err)
+	}
+	invalid := []Watermark{
+		// the header of a truncated logo decodes, the pixels don't
+		{Logo: logo[:len(logo)-20], Opacity: 0.5, Scale: 0.2},
+		{Logo: huge.Bytes(), Opacity: 0.5, Scale: 0.2},
+		{Opacity: 0.5, Scale: 0.2},
+		{Text: "x", Opacity: 0, Scale: 0.2},
+		{Text: "x", Opacity: 0.5, Scale: 2},
+		{Text: "x", Opacity: 0.5, Scale: 0.2, Position: "middle"},
+		{Logo: []byte("GIF89a"), Opacity: 0.5, Scale: 0.2},
+	}
+	for _, wm := range invalid {
+		if err := wm.Validate(); err == nil {
+			t.Errorf("%+v is valid", wm)
+		}
+		if _, err := wm.Apply(imaging.New(100, 100, color.White)); err == nil {
+			t.Errorf("%+v is applied", wm)
+		}
+	}
+}
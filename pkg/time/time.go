@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"sort"
 	"time"
 
 	"github.com/blixenkrone/gopro/pkg/logger"
@@ -57,3 +58,64 @@ func (t *TimeBuilder) IsZero() error {
 	}
 	return nil
 }
+
+// Period is a half-open time range [Start, End)
+type Period struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Overlaps reports whether the two periods share any instant
+func (p Period) Overlaps(o Period) bool {
+	return p.Start.Before(o.End) && o.Start.Before(p.End)
+}
+
+// Subtract returns the parts of the open periods that are not covered by any of the busy periods.
+// The result is sorted by start time.
+func Subtract(open, busy []Period) []Period {
+	busy = Merge(busy)
+	var free []Period
+	for _, o := range Merge(open) {
+		start := o.Start
+		for _, b := range busy {
+			if !b.End.After(start) {
+				continue
+			}
+			if !b.Start.Before(o.End) {
+				break
+			}
+			if b.Start.After(start) {
+				free = append(free, Period{start, b.Start})
+			}
+			start = b.End
+		}
+		if start.Before(o.End) {
+			free = append(free, Period{start, o.End})
+		}
+	}
+	return free
+}
+
+// Merge sorts the periods and joins the ones that overlap or touch
+func Merge(periods []Period) []Period {
+	sorted := make([]Period, 0, len(periods))
+	for _, p := range periods {
+		if p.End.After(p.Start) {
+			sorted = append(sorted, p)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start.Before(sorted[j].Start) })
+
+	var merged []Period
+	for _, p := range sorted {
+		last := len(merged) - 1
+		if last >= 0 && !p.Start.After(merged[last].End) {
+			if p.End.After(merged[last].End) {
+				merged[last].End = p.End
+			}
+			continue
+		}
+		merged = append(merged, p)
+	}
+	return merged
+}
@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func at(hour int) time.Time {
+	return time.Date(2020, 1, 6, hour, 0, 0, 0, time.UTC)
+}
+
+func TestSubtract(t *testing.T) {
+	tests := []struct {
+		name       string
+		open, busy []Period
+		free       []Period
+	}{
+		{
+			name: "no bookings",
+			open: []Period{{at(8), at(16)}},
+			free: []Period{{at(8), at(16)}},
+		},
+		{
+			name: "booking in the middle",
+			open: []Period{{at(8), at(16)}},
+			busy: []Period{{at(10), at(12)}},
+			free: []Period{{at(8), at(10)}, {at(12), at(16)}},
+		},
+		{
+			name: "overlapping bookings and blocked period",
+			open: []Period{{at(8), at(16)}},
+			busy: []Period{{at(11), at(13)}, {at(7), at(9)}, {at(12), at(14)}},
+			free: []Period{{at(9), at(11)}, {at(14), at(16)}},
+		},
+		{
+			name: "fully booked",
+			open: []Period{{at(8), at(16)}},
+			busy: []Period{{at(6), at(18)}},
+		},
+		{
+			name: "booking spanning two windows",
+			open: []Period{{at(8), at(12)}, {at(13), at(17)}},
+			busy: []Period{{at(11), at(14)}},
+			free: []Period{{at(8), at(11)}, {at(14), at(17)}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			free := Subtract(test.open, test.busy)
+			if len(free) != len(test.free) {
+				t.Fatalf("Expected %v got %v", test.free, free)
+			}
+			for i := range free {
+				if !free[i].Start.Equal(test.free[i].Start) || !free[i].End.Equal(test.free[i].End) {
+					t.Errorf("Expected %v got %v", test.free[i], free[i])
+				}
+			}
+		})
+	}
+}
+
+func TestOverlaps(t *testing.T) {
+	p := Period{at(10), at(12)}
+	if !p.Overlaps(Period{at(11), at(13)}) {
+		t.Error("expected overlap")
+	}
+	if p.Overlaps(Period{at(12), at(13)}) {
+		t.Error("adjacent periods must not overlap")
+	}
+}
@@ -1,97 +1,147 @@
 package main
 
+/**
+Imports every professional firebase profile into the postgres professional table.
+It is idempotent, so it can run nightly to keep both stores in sync.
+
+	go run scripts/postgres/migrate.go [-dry-run]
+*/
+
 import (
 	"context"
+	"flag"
 	"fmt"
-	"sync"
+	"time"
 
 	"github.com/blixenkrone/gopro/pkg/logger"
 
 	"github.com/joho/godotenv"
+	"github.com/pkg/errors"
 
 	"github.com/blixenkrone/gopro/internal/storage"
 	firebase "github.com/blixenkrone/gopro/internal/storage/firebase"
 	postgres "github.com/blixenkrone/gopro/internal/storage/postgres"
 )
 
-var log = logger.NewLogger()
+var (
+	dryRun  = flag.Bool("dry-run", false, "Report what would change without writing to postgres")
+	timeout = flag.Duration("timeout", 10*time.Minute, "Give up if the import takes longer than this")
+	log     = logger.NewLogger()
+)
 
 func main() {
+	flag.Parse()
 	fmt.Println("Starting migration")
 
 	if err := godotenv.Load(); err != nil {
 		log.Errorf("Error cfg: %s", err)
 	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
 	// Init SQL db
 	sqldb, err := postgres.NewPQ()
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer sqldb.Close()
 
 	// Get the FB profiles
-	profiles, err := getProfilesFromFB()
+	profiles, err := getProfilesFromFB(ctx)
 	if err != nil {
-		log.Errorf("Error getting profiles: %s", err)
+		log.Fatalf("Error getting profiles: %s", err)
 	}
 
-	err = insertProfilesSQL(sqldb, profiles)
+	existing, err := sqldb.GetProfessionals(ctx)
 	if err != nil {
-		log.Errorf("Error inserting profiles: %s", err)
+		log.Fatalf("Error getting professionals: %s", err)
 	}
 
+	plan := planImport(profiles, existing)
+	created, updated := len(plan.create), len(plan.update)
+	if !*dryRun {
+		if created, updated, err = plan.apply(ctx, sqldb); err != nil {
+			log.Fatalf("Error inserting profiles: %s", err)
+		}
+	}
+
+	log.Infof("created: %d updated: %d unchanged: %d skipped: %d (dry run: %v)",
+		created, updated, plan.unchanged, plan.skipped, *dryRun)
 }
 
 // ExportToPostgres -
-func getProfilesFromFB() ([]*storage.FirebaseProfile, error) {
+func getProfilesFromFB(ctx context.Context) ([]*storage.FirebaseProfile, error) {
 	fbdb, err := firebase.NewFB()
 	if err != nil {
 		return nil, err
 	}
-	prfs, err := fbdb.GetProfiles(context.Background())
+	prfs, err := fbdb.GetProfiles(ctx)
 	if err != nil {
 		return nil, err
 	}
 	return prfs, nil
 }
 
-func insertProfilesSQL(sqldb storage.PQService, profiles []*storage.FirebaseProfile) error {
-	var wg sync.WaitGroup
-	if err := sqldb.Ping(); err != nil {
-		log.Fatal(err)
+// importPlan is the difference between the firebase professionals and the professional table
+type importPlan struct {
+	create    []*storage.Professional
+	update    []*storage.Professional
+	unchanged int
+	// profiles that aren't professionals or have no uid
+	skipped int
+}
+
+func planImport(profiles []*storage.FirebaseProfile, existing []*storage.Professional) *importPlan {
+	byUID := make(map[string]*storage.Professional, len(existing))
+	for _, pro := range existing {
+		byUID[pro.UserUID] = pro
 	}
-	// ctx := context.Background()
+
+	var plan importPlan
+	seen := make(map[string]bool)
 	for _, p := range profiles {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			if p.IsProfessional {
-				log.Println(p)
-
-				// ! create profile with level and userUID
-				// pro := storage.Professional{
-				// 	ID: p.UserID,
-				// }
-
-				// _, err := sqldb(ctx, &pro)
-				// if err != nil {
-				// 	log.Errorf("Didnt create row: %s", err)
-				// 	return
-				// }
-			}
-		}()
-		wg.Wait()
+		if !p.IsProfessional || p.UserID == "" || seen[p.UserID] {
+			plan.skipped++
+			continue
+		}
+		seen[p.UserID] = true
+
+		pro := &storage.Professional{
+			UserUID:  p.UserID,
+			ProLevel: p.ProLevel,
+			Email:    p.Email,
+		}
+		current, ok := byUID[p.UserID]
+		switch {
+		case !ok:
+			plan.create = append(plan.create, pro)
+		case current.Email != pro.Email || (pro.ProLevel > 0 && current.ProLevel != pro.ProLevel):
+			plan.update = append(plan.update, pro)
+		default:
+			plan.unchanged++
+		}
 	}
-	return nil
+	return &plan
 }
 
-// TODO:
-
-// todo:
-// func importProfessionals(){}
-
-// todo:
-// func createMediatableDepartments(){}
-
-// todo:
-// func insertDepartmentSQL(){}
+// apply upserts the planned professionals. The counts are of the rows postgres reports created or updated,
+// which differ from the plan when professionals are added while it runs.
+func (plan *importPlan) apply(ctx context.Context, sqldb storage.PQService) (created, updated int, err error) {
+	if err := sqldb.Ping(); err != nil {
+		return 0, 0, err
+	}
+	for _, pro := range append(plan.create, plan.update...) {
+		isNew, err := sqldb.UpsertProfessional(ctx, pro)
+		if err != nil {
+			return created, updated, errors.Wrapf(err, "upserting %s", pro.UserUID)
+		}
+		if isNew {
+			created++
+		} else {
+			updated++
+		}
+		log.Debugf("Upserted professional %s, created: %v", pro.UserUID, isNew)
+	}
+	return created, updated, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/blixenkrone/gopro/internal/storage"
+)
+
+func TestPlanImport(t *testing.T) {
+	existing := []*storage.Professional{
+		{UserUID: "same", ProLevel: 2, Email: "same@byrd.news"},
+		{UserUID: "email", ProLevel: 2, Email: "old@byrd.news"},
+		{UserUID: "level", ProLevel: 1, Email: "level@byrd.news"},
+		{UserUID: "nolevel", ProLevel: 3, Email: "nolevel@byrd.news"},
+	}
+	tests := []struct {
+		name                               string
+		profile                            *storage.FirebaseProfile
+		create, update, unchanged, skipped int
+	}{
+		{"new", &storage.FirebaseProfile{UserID: "new", IsProfessional: true, Email: "new@byrd.news"}, 1, 0, 0, 0},
+		{"unchanged", &storage.FirebaseProfile{UserID: "same", IsProfessional: true, ProLevel: 2, Email: "same@byrd.news"}, 0, 0, 1, 0},
+		{"email changed", &storage.FirebaseProfile{UserID: "email", IsProfessional: true, ProLevel: 2, Email: "new@byrd.news"}, 0, 1, 0, 0},
+		{"level changed", &storage.FirebaseProfile{UserID: "level", IsProfessional: true, ProLevel: 3, Email: "level@byrd.news"}, 0, 1, 0, 0},
+		{"zero level kept", &storage.FirebaseProfile{UserID: "nolevel", IsProfessional: true, Email: "nolevel@byrd.news"}, 0, 0, 1, 0},
+		{"media", &storage.FirebaseProfile{UserID: "media", IsMedia: true}, 0, 0, 0, 1},
+		{"no uid", &storage.FirebaseProfile{IsProfessional: true}, 0, 0, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := planImport([]*storage.FirebaseProfile{tt.profile}, existing)
+			if len(plan.create) != tt.create || len(plan.update) != tt.update || plan.unchanged != tt.unchanged || plan.skipped != tt.skipped {
+				t.Errorf("got create %d update %d unchanged %d skipped %d", len(plan.create), len(plan.update), plan.unchanged, plan.skipped)
+			}
+		})
+	}
+
+	dup := &storage.FirebaseProfile{UserID: "new", IsProfessional: true}
+	if plan := planImport([]*storage.FirebaseProfile{dup, dup}, nil); len(plan.create) != 1 || plan.skipped != 1 {
+		t.Errorf("duplicate profile planned %+v", plan)
+	}
+}
+
+type fakeProfessionals struct {
+	storage.PQService
+	existing map[string]bool
+	fail     string
+}
+
+func (f *fakeProfessionals) Ping() error { return nil }
+
+func (f *fakeProfessionals) UpsertProfessional(ctx context.Context, pro *storage.Professional) (bool, error) {
+	if pro.UserUID == f.fail {
+		return false, errors.New("connection reset")
+	}
+	created := !f.existing[pro.UserUID]
+	f.existing[pro.UserUID] = true
+	return created, nil
+}
+
+func TestApplyCounts(t *testing.T) {
+	plan := &importPlan{
+		create: []*storage.Professional{{UserUID: "a"}, {UserUID: "b"}},
+		update: []*storage.Professional{{UserUID: "c"}},
+	}
+	// b was added after the plan was made
+	db := &fakeProfessionals{existing: map[string]bool{"b": true, "c": true}}
+	created, updated, err := plan.apply(context.Background(), db)
+	if err != nil || created != 1 || updated != 2 {
+		t.Errorf("created %d updated %d, %v", created, updated, err)
+	}
+
+	db = &fakeProfessionals{existing: map[string]bool{}, fail: "b"}
+	if created, _, err := plan.apply(context.Background(), db); err == nil || created != 1 {
+		t.Errorf("created %d before failing with %v", created, err)
+	}
+}